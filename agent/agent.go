@@ -0,0 +1,751 @@
+// Package agent is the client side of SafeBox backups. It walks local paths,
+// splits files into content-defined chunks, compresses and encrypts the
+// chunks the server does not have yet and commits the snapshot through the
+// agent API, so the data never reaches the server in the clear.
+package agent
+
+import (
+	"SafeBox/utils"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Estado de cada arquivo no resultado enviado ao servidor
+const (
+	statusOK      = "ok"
+	statusFailed  = "failed"
+	statusSkipped = "skipped"
+)
+
+// lookupBatch is how many chunk hashes are looked up per request
+const lookupBatch = 1000
+
+var errFileChanged = errors.New("file changed while it was read")
+
+// errRunTooLarge is returned when the files of a run exceed the size the plan allows
+var errRunTooLarge = errors.New("files exceed the size limit of the plan")
+
+// Agent backs up the profiles of a configuration
+type Agent struct {
+	config *Config
+	client *Client
+}
+
+func New(config *Config) *Agent {
+	return &Agent{
+		config: config,
+		client: NewClient(config.Server, config.Token),
+	}
+}
+
+// source is a local path and its directory in the snapshot
+type source struct {
+	Root string
+	Name string // diretório no snapshot; vazio quando o perfil tem um único caminho
+	// Origin replaces Root in the results of the copies made by a Source
+	Origin string
+	Walk   utils.WalkOptions
+}
+
+// sourcePath is the local path results report for rel
+func (s source) sourcePath(rel string) string {
+	if s.Origin != "" {
+		return s.Origin
+	}
+	return path.Join(filepath.ToSlash(s.Root), rel)
+}
+
+// localFile is a file selected by the walk
+type localFile struct {
+	FilePath   string
+	Path       string // caminho no snapshot
+	SourcePath string
+	Info       os.FileInfo
+}
+
+// run holds the state of one backup run of a profile
+type run struct {
+	agent      *Agent
+	profile    *Profile
+	chunkOpts  utils.ChunkOptions
+	previous   map[string]Entry
+	checkpoint *checkpoint
+
+	sources []source
+	id      string // emitido pelo servidor ao começar a execução
+	maxSize int64  // zero quando o servidor não informa o limite
+	size    int64
+	// gone holds, in incremental runs, the entries below the changed paths
+	// that were not found again yet; new files may be moves of them
+	gone map[string]Entry
+
+	mu      sync.Mutex
+	files   map[string]localFile
+	seen    map[string]bool
+	entries map[string]Entry
+	results []FileResult
+	hooks   []HookResult
+	started time.Time
+}
+
+// Run backs up a profile and commits its snapshot. Files that fail after
+// their retries keep their previous version and are reported in the result;
+// an interrupted run keeps a checkpoint and resumes from it the next time.
+func (a *Agent) Run(ctx context.Context, profile *Profile) (*SnapshotResult, error) {
+	_, result, err := a.fullRun(ctx, profile, "")
+	return result, err
+}
+
+// fullRun walks every path of the profile and returns the committed run
+func (a *Agent) fullRun(ctx context.Context, profile *Profile, mode string) (*run, *SnapshotResult, error) {
+	started := time.Now()
+	start, err := a.client.StartRun(ctx, profile.Name, mode)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to start run: %w", err)
+	}
+
+	previous := make(map[string]Entry, len(start.Entries))
+	for _, entry := range start.Entries {
+		previous[entry.Path] = entry
+	}
+	chunkOpts := utils.ChunkOptions{
+		MinSize: start.MinChunkSize,
+		AvgSize: start.AvgChunkSize,
+		MaxSize: start.MaxChunkSize,
+	}
+	r, err := a.newRun(profile, chunkOpts, previous)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.id = start.ID
+	r.maxSize = start.MaxRunSize
+	if len(r.checkpoint.Entries) > 0 {
+		logrus.WithFields(logrus.Fields{"profile": profile.Name, "files": len(r.checkpoint.Entries)}).Info("Resuming from checkpoint")
+	}
+
+	// Os hooks "pre" preparam os caminhos; se um deles abortar, nada é lido
+	env := hookEnv(profile, mode)
+	err = r.runHooks(ctx, hookPre, profile.PreHooks, env)
+	status := "aborted"
+	if err == nil {
+		// As origens que não são diretórios gravam suas cópias em um diretório temporário
+		var staging string
+		staging, err = r.openSources(ctx, env)
+		if staging != "" {
+			defer os.RemoveAll(staging)
+		}
+	}
+	if err == nil {
+		err = r.process(ctx, func(visit func(localFile) error) error {
+			for _, src := range r.sources {
+				if err := utils.WalkSource(src.Root, src.Walk, r.walkFunc(src, visit), r.skipFunc(src)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		status = "success"
+		if err != nil {
+			status = "failed"
+		}
+	}
+	// Os hooks "post" rodam antes do commit para que a saída deles vá com o snapshot
+	hookErr := r.runHooks(context.WithoutCancel(ctx), hookPost, profile.PostHooks, append(env, "SAFEBOX_STATUS="+status))
+	if err != nil {
+		r.saveCheckpoint()
+		return nil, nil, err
+	}
+
+	result, err := r.commit(ctx, start.ParentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"profile":   profile.Name,
+		"snapshot":  result.ManifestID,
+		"uploaded":  result.UploadedCount,
+		"unchanged": result.UnchangedCount,
+		"failed":    result.FailedCount,
+		"skipped":   result.SkippedCount,
+		"duration":  time.Since(started).Round(time.Second),
+	}).Info("Backup finished")
+	// O snapshot fica, mas um hook "post" que falhou faz a execução falhar
+	return r, result, hookErr
+}
+
+func (a *Agent) newRun(profile *Profile, chunkOpts utils.ChunkOptions, previous map[string]Entry) (*run, error) {
+	cp, err := openCheckpoint(a.config.StateDir, profile.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	return &run{
+		agent:      a,
+		profile:    profile,
+		chunkOpts:  chunkOpts,
+		previous:   previous,
+		checkpoint: cp,
+		sources:    sources(profile),
+		files:      map[string]localFile{},
+		seen:       map[string]bool{},
+		entries:    map[string]Entry{},
+		started:    time.Now(),
+	}, nil
+}
+
+// process stores the files visited by walk with a pool of workers
+func (r *run) process(ctx context.Context, walk func(visit func(localFile) error) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	files := make(chan localFile)
+	var wg sync.WaitGroup
+	for i := 0; i < r.agent.config.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				r.storeWithRetry(ctx, file)
+			}
+		}()
+	}
+
+	walkErr := walk(func(file localFile) error {
+		r.mu.Lock()
+		r.files[file.Path] = file
+		r.seen[file.Path] = true
+		r.size += file.Info.Size()
+		size := r.size
+		r.mu.Unlock()
+		// O servidor recusaria o snapshot; melhor parar antes de enviar o resto
+		if r.maxSize > 0 && size > r.maxSize {
+			return fmt.Errorf("%w: more than %d bytes", errRunTooLarge, r.maxSize)
+		}
+		if r.move(file) {
+			return nil
+		}
+
+		select {
+		case files <- file:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(files)
+	wg.Wait()
+
+	if walkErr != nil {
+		return walkErr
+	}
+	return ctx.Err()
+}
+
+// walkFunc adapts the files of a source to visit
+func (r *run) walkFunc(src source, visit func(localFile) error) utils.WalkFunc {
+	return func(filePath, rel string, info os.FileInfo) error {
+		return visit(localFile{
+			FilePath:   filePath,
+			Path:       path.Join(src.Name, rel),
+			SourcePath: src.sourcePath(rel),
+			Info:       info,
+		})
+	}
+}
+
+// skipFunc reports the files of a source left out by the walk
+func (r *run) skipFunc(src source) utils.SkipFunc {
+	return func(rel, reason string) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.results = append(r.results, FileResult{
+			Path:       path.Join(src.Name, rel),
+			SourcePath: src.sourcePath(rel),
+			Status:     statusSkipped,
+			Error:      reason,
+		})
+	}
+}
+
+func (r *run) saveCheckpoint() {
+	// O que já foi enviado fica no checkpoint para a próxima execução
+	if err := r.checkpoint.save(); err != nil {
+		logrus.WithError(err).Warn("Failed to save checkpoint")
+	}
+}
+
+// sources places each path of the profile in the snapshot. The copies of
+// its Sources are added by openSources.
+func sources(profile *Profile) []source {
+	if len(profile.Paths) == 1 && len(profile.Sources) == 0 {
+		return []source{{Root: profile.Paths[0], Walk: profile.walkOptions()}}
+	}
+	sources := make([]source, 0, len(profile.Paths))
+	for _, root := range profile.Paths {
+		sources = append(sources, source{Root: root, Name: sourceName(root), Walk: profile.walkOptions()})
+	}
+	return sources
+}
+
+// sourceName is the directory of a path in the snapshot when the profile has
+// several sources: "/home/ana/docs" becomes "home/ana/docs" and "C:\dados" "C/dados"
+func sourceName(root string) string {
+	return strings.Trim(strings.ReplaceAll(filepath.ToSlash(root), ":", ""), "/")
+}
+
+// openSources has each Source of the profile write its copy in a temporary
+// directory and adds it to the sources of the run, at the top of the
+// snapshot. The directory returned must be removed once the run ends.
+func (r *run) openSources(ctx context.Context, env []string) (string, error) {
+	if len(r.profile.Sources) == 0 {
+		return "", nil
+	}
+	staging, err := os.MkdirTemp("", "safebox-agent-")
+	if err != nil {
+		return "", err
+	}
+	for _, spec := range r.profile.Sources {
+		dir := filepath.Join(staging, spec.File)
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			return staging, err
+		}
+		root, err := spec.backupSource(env).Open(ctx, dir)
+		if err != nil {
+			return staging, fmt.Errorf("%s source %s: %w", spec.Kind, spec.File, err)
+		}
+		// Os padrões de inclusão e exclusão valem só para os caminhos
+		r.sources = append(r.sources, source{Root: root, Origin: spec.origin()})
+	}
+	return staging, nil
+}
+
+// localFile finds the local file of a snapshot path
+func (r *run) localFile(p string) (localFile, bool) {
+	for _, src := range r.sources {
+		rel := p
+		if src.Name != "" {
+			if !strings.HasPrefix(p, src.Name+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(p, src.Name+"/")
+		}
+		filePath := filepath.Join(src.Root, filepath.FromSlash(rel))
+		info, err := os.Stat(filePath)
+		if err != nil || !info.Mode().IsRegular() {
+			return localFile{}, false
+		}
+		return localFile{
+			FilePath:   filePath,
+			Path:       p,
+			SourcePath: src.sourcePath(rel),
+			Info:       info,
+		}, true
+	}
+	return localFile{}, false
+}
+
+// storeWithRetry stores a file, trying again with backoff when it fails.
+// A file that does not change since the previous snapshot or the checkpoint
+// is not read at all.
+func (r *run) storeWithRetry(ctx context.Context, file localFile) {
+	if entry, ok := r.unchanged(file); ok {
+		r.done(file, entry, false)
+		return
+	}
+
+	var (
+		entry    Entry
+		uploaded bool
+		err      error
+	)
+	for attempt := 0; attempt <= r.agent.config.Retries; attempt++ {
+		if attempt > 0 {
+			if sleep(ctx, backoff(attempt)) != nil {
+				return
+			}
+			logrus.WithError(err).WithField("file", file.FilePath).Warn("Retrying file")
+		}
+		entry, uploaded, err = r.store(ctx, file)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("file", file.FilePath).Error("Failed to back up file")
+		r.failed(file, err)
+		return
+	}
+
+	if err := r.checkpoint.add(entry); err != nil {
+		logrus.WithError(err).Warn("Failed to save checkpoint")
+	}
+	r.done(file, entry, uploaded)
+}
+
+// unchanged returns the stored entry of a file whose size and modification
+// time did not change, from the checkpoint first and then from the previous snapshot
+func (r *run) unchanged(file localFile) (Entry, bool) {
+	r.checkpoint.mu.Lock()
+	entry, ok := r.checkpoint.Entries[file.Path]
+	r.checkpoint.mu.Unlock()
+	if !ok {
+		entry, ok = r.previous[file.Path]
+	}
+	if !ok || entry.Size != file.Info.Size() || !entry.ModTime.Equal(file.Info.ModTime().UTC()) {
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// store chunks a file and uploads the chunks the server does not have.
+// The file is read twice: once to hash its chunks and look them up, and once
+// more to upload the missing ones, so memory does not depend on its size.
+func (r *run) store(ctx context.Context, file localFile) (Entry, bool, error) {
+	entry := Entry{
+		Path:        file.Path,
+		Compression: r.profile.Compression,
+		ModTime:     file.Info.ModTime().UTC(),
+		Chunks:      []ChunkRef{},
+	}
+
+	hashes, sizes, sum, err := r.hashChunks(ctx, file.FilePath)
+	if err != nil {
+		return entry, false, err
+	}
+	entry.SHA256 = sum
+	for _, size := range sizes {
+		entry.Size += size
+	}
+
+	known := map[string]ChunkRef{}
+	var missing []string
+	for start := 0; start < len(hashes); start += lookupBatch {
+		batch := hashes[start:min(start+lookupBatch, len(hashes))]
+		found, err := r.agent.client.LookupChunks(ctx, batch)
+		if err != nil {
+			return entry, false, fmt.Errorf("failed to look up chunks: %w", err)
+		}
+		for hash, ref := range found {
+			known[hash] = ref
+		}
+	}
+	for _, hash := range hashes {
+		if _, ok := known[hash]; !ok {
+			missing = append(missing, hash)
+		}
+	}
+	if len(missing) > 0 {
+		if err := r.uploadChunks(ctx, file.FilePath, hashes, known); err != nil {
+			return entry, false, err
+		}
+	}
+
+	for _, hash := range hashes {
+		entry.Chunks = append(entry.Chunks, known[hash])
+	}
+	return entry, len(missing) > 0, nil
+}
+
+// hashChunks returns the hash and size of every chunk of a file and the hash of its content
+func (r *run) hashChunks(ctx context.Context, filePath string) ([]string, []int64, string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer f.Close()
+
+	content := sha256.New()
+	chunker, err := utils.NewChunker(io.TeeReader(f, content), r.chunkOpts)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	var (
+		hashes []string
+		sizes  []int64
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, "", err
+		}
+		data, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, "", err
+		}
+		sum := sha256.Sum256(data)
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+		sizes = append(sizes, int64(len(data)))
+	}
+	return hashes, sizes, hex.EncodeToString(content.Sum(nil)), nil
+}
+
+// uploadChunks reads the file again and uploads the chunks missing from known,
+// adding them to it. The file must still cut into the same chunks.
+func (r *run) uploadChunks(ctx context.Context, filePath string, hashes []string, known map[string]ChunkRef) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	chunker, err := utils.NewChunker(f, r.chunkOpts)
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := chunker.Next()
+		if err == io.EOF {
+			if i != len(hashes) {
+				return errFileChanged
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if i >= len(hashes) || hash != hashes[i] {
+			return errFileChanged
+		}
+		if _, ok := known[hash]; ok {
+			continue
+		}
+
+		ref, err := r.uploadChunk(ctx, hash, data)
+		if err != nil {
+			return fmt.Errorf("failed to upload chunk: %w", err)
+		}
+		known[hash] = ref
+	}
+}
+
+// uploadChunk compresses and encrypts a chunk with a new key and uploads it
+func (r *run) uploadChunk(ctx context.Context, hash string, data []byte) (ChunkRef, error) {
+	compressed, _, err := utils.CompressAuto(bytes.NewReader(data), r.profile.compression())
+	if err != nil {
+		return ChunkRef{}, fmt.Errorf("compression failed: %w", err)
+	}
+	defer compressed.Close()
+
+	key, err := utils.GenerateEncryptionKey()
+	if err != nil {
+		return ChunkRef{}, fmt.Errorf("encryption key generation failed: %w", err)
+	}
+	encrypted, err := utils.EncryptReader(compressed, key)
+	if err != nil {
+		return ChunkRef{}, fmt.Errorf("encryption failed: %w", err)
+	}
+	// O corpo fica em memória para poder ser reenviado; um chunk tem no máximo MaxSize
+	body, err := io.ReadAll(encrypted)
+	if err != nil {
+		return ChunkRef{}, fmt.Errorf("encryption failed: %w", err)
+	}
+	return r.agent.client.UploadChunk(ctx, hash, int64(len(data)), key, body)
+}
+
+func (r *run) done(file localFile, entry Entry, uploaded bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[file.Path] = entry
+	r.results = append(r.results, FileResult{
+		Path:       file.Path,
+		SourcePath: file.SourcePath,
+		Status:     statusOK,
+		Size:       entry.Size,
+		Uploaded:   uploaded,
+	})
+}
+
+// move stores a new file of an incremental run as a move of an entry that
+// disappeared from a changed path, when the old file is gone and the new one
+// has its size and modification time (and its name, if several match). The
+// content is not read again.
+func (r *run) move(file localFile) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.gone) == 0 {
+		return false
+	}
+	if _, ok := r.previous[file.Path]; ok {
+		return false
+	}
+
+	var candidates []Entry
+	for _, entry := range r.gone {
+		if entry.Size == file.Info.Size() && entry.ModTime.Equal(file.Info.ModTime().UTC()) && !r.seen[entry.Path] {
+			candidates = append(candidates, entry)
+		}
+	}
+	var from *Entry
+	for i := range candidates {
+		if path.Base(candidates[i].Path) == path.Base(file.Path) {
+			from = &candidates[i]
+			break
+		}
+	}
+	if from == nil && len(candidates) == 1 {
+		from = &candidates[0]
+	}
+	if from == nil {
+		return false
+	}
+
+	delete(r.gone, from.Path)
+	entry := *from
+	entry.Path = file.Path
+	r.entries[file.Path] = entry
+	r.results = append(r.results, FileResult{
+		Path:       file.Path,
+		SourcePath: file.SourcePath,
+		Status:     statusOK,
+		Size:       entry.Size,
+		MovedFrom:  from.Path,
+	})
+	return true
+}
+
+// failed reports a file that could not be stored; its previous version, if
+// any, stays in the snapshot
+func (r *run) failed(file localFile, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.previous[file.Path]; ok {
+		r.entries[file.Path] = prev
+	}
+	r.results = append(r.results, FileResult{
+		Path:       file.Path,
+		SourcePath: file.SourcePath,
+		Status:     statusFailed,
+		Size:       file.Info.Size(),
+		Error:      err.Error(),
+	})
+}
+
+// commit sends the snapshot. When the server lost chunks of files reused from
+// the checkpoint, those files are stored again and the commit is retried once.
+// The checkpoint is removed once the snapshot is committed and kept otherwise.
+func (r *run) commit(ctx context.Context, parentID uint) (*SnapshotResult, error) {
+	for attempt := 0; ; attempt++ {
+		result, err := r.agent.client.CommitSnapshot(ctx, r.snapshot(parentID))
+		var apiErr *APIError
+		if attempt > 0 || !errors.As(err, &apiErr) || len(apiErr.MissingObjects) == 0 {
+			if err != nil {
+				r.saveCheckpoint()
+				return nil, fmt.Errorf("failed to commit snapshot: %w", err)
+			}
+			if err := r.checkpoint.remove(); err != nil {
+				logrus.WithError(err).Warn("Failed to remove checkpoint")
+			}
+			return result, nil
+		}
+
+		logrus.WithField("chunks", len(apiErr.MissingObjects)).Warn("Server is missing chunks, storing the affected files again")
+		if err := r.restore(ctx, apiErr.MissingObjects); err != nil {
+			r.saveCheckpoint()
+			return nil, err
+		}
+	}
+}
+
+// restore stores again the files that use any of the missing objects
+func (r *run) restore(ctx context.Context, missing []string) error {
+	lost := make(map[string]bool, len(missing))
+	for _, objectID := range missing {
+		lost[objectID] = true
+	}
+
+	var affected []localFile
+	paths := map[string]bool{}
+	r.mu.Lock()
+	for p, entry := range r.entries {
+		for _, chunk := range entry.Chunks {
+			if lost[chunk.ObjectID] {
+				if file, ok := r.files[p]; ok {
+					affected = append(affected, file)
+				} else if file, ok := r.localFile(p); ok {
+					// Em uma sincronização o arquivo pode não ter sido percorrido nesta execução
+					r.files[p] = file
+					affected = append(affected, file)
+				}
+				paths[p] = true
+				delete(r.entries, p)
+				// Impede que storeWithRetry reaproveite a entrada perdida
+				delete(r.previous, p)
+				break
+			}
+		}
+	}
+	results := r.results[:0]
+	for _, result := range r.results {
+		if !paths[result.Path] {
+			results = append(results, result)
+		}
+	}
+	r.results = results
+	r.mu.Unlock()
+
+	dropped := make([]string, 0, len(paths))
+	for p := range paths {
+		dropped = append(dropped, p)
+	}
+	r.checkpoint.drop(dropped)
+
+	for _, file := range affected {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.storeWithRetry(ctx, file)
+	}
+	return ctx.Err()
+}
+
+// snapshot builds the commit request from the files stored so far
+func (r *run) snapshot(parentID uint) *Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := &Snapshot{
+		RunID:     r.id,
+		Source:    r.profile.Name,
+		ParentID:  parentID,
+		Entries:   make([]Entry, 0, len(r.entries)),
+		Deleted:   []string{},
+		Files:     r.results,
+		Hooks:     r.hooks,
+		StartedAt: r.started,
+	}
+	for _, entry := range r.entries {
+		snapshot.Entries = append(snapshot.Entries, entry)
+	}
+	for p := range r.previous {
+		if !r.seen[p] {
+			snapshot.Deleted = append(snapshot.Deleted, p)
+		}
+	}
+	sort.Slice(snapshot.Entries, func(i, j int) bool { return snapshot.Entries[i].Path < snapshot.Entries[j].Path })
+	sort.Strings(snapshot.Deleted)
+	return snapshot
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointInterval is the least time between two saves of a checkpoint
+const checkpointInterval = 5 * time.Second
+
+// checkpoint records the files a run already stored, so a run that was
+// interrupted resumes without reading them again. It is removed once the
+// snapshot is committed.
+type checkpoint struct {
+	path string
+
+	mu      sync.Mutex
+	Source  string           `json:"source"`
+	Entries map[string]Entry `json:"entries"`
+	saved   time.Time
+}
+
+// openCheckpoint loads the checkpoint of the source from dir, or starts an
+// empty one when there is none
+func openCheckpoint(dir, source string) (*checkpoint, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{
+		path:    filepath.Join(dir, source+".checkpoint.json"),
+		Source:  source,
+		Entries: map[string]Entry{},
+	}
+
+	data, err := os.ReadFile(cp.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored checkpoint
+	// Um checkpoint ilegível só faz o agente ler os arquivos de novo
+	if json.Unmarshal(data, &stored) == nil && stored.Source == source && stored.Entries != nil {
+		cp.Entries = stored.Entries
+	}
+	return cp, nil
+}
+
+// add records a stored file and saves the checkpoint if the last save is old enough
+func (cp *checkpoint) add(entry Entry) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Entries[entry.Path] = entry
+	if time.Since(cp.saved) < checkpointInterval {
+		return nil
+	}
+	return cp.saveLocked()
+}
+
+// drop forgets files, for instance because the server lost their chunks
+func (cp *checkpoint) drop(paths []string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for _, p := range paths {
+		delete(cp.Entries, p)
+	}
+}
+
+func (cp *checkpoint) save() error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.saveLocked()
+}
+
+func (cp *checkpoint) saveLocked() error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	// Grava em um arquivo temporário e renomeia, para nunca deixar um checkpoint pela metade
+	tmp, err := os.CreateTemp(filepath.Dir(cp.path), ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), cp.path); err != nil {
+		return err
+	}
+	cp.saved = time.Now()
+	return nil
+}
+
+// remove deletes the checkpoint after the run was committed
+func (cp *checkpoint) remove() error {
+	err := os.Remove(cp.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
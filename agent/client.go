@@ -0,0 +1,283 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestAttempts is how many times a request that failed on the network or
+// with a server error is sent before giving up
+const requestAttempts = 4
+
+// ChunkRef locates one stored chunk of a file
+type ChunkRef struct {
+	ObjectID     string `json:"object_id"`
+	Size         int64  `json:"size"`
+	ObjectSHA256 string `json:"object_sha256"`
+}
+
+// Entry is a file of a snapshot, by its path relative to the snapshot root
+type Entry struct {
+	Path        string     `json:"path"`
+	Size        int64      `json:"size"`
+	SHA256      string     `json:"sha256"`
+	Compression string     `json:"compression"`
+	Chunks      []ChunkRef `json:"chunks"`
+	ModTime     time.Time  `json:"mod_time"`
+}
+
+// FileResult is the outcome of backing up one file
+type FileResult struct {
+	Path       string `json:"path"`
+	SourcePath string `json:"source_path"`
+	Status     string `json:"status"`
+	Size       int64  `json:"size"`
+	Uploaded   bool   `json:"uploaded,omitempty"`
+	MovedFrom  string `json:"moved_from,omitempty"` // caminho anterior de um arquivo renomeado
+	Error      string `json:"error,omitempty"`
+}
+
+// Run is the server's answer to the start of a run. ID is presented, once,
+// to commit the snapshot of the run.
+type Run struct {
+	ID           string  `json:"id"`
+	ParentID     uint    `json:"parent_id"`
+	Entries      []Entry `json:"entries"`
+	MinChunkSize int     `json:"min_chunk_size"`
+	AvgChunkSize int     `json:"avg_chunk_size"`
+	MaxChunkSize int     `json:"max_chunk_size"`
+	// MaxRunSize is the most a snapshot may hold under the plan of the user
+	MaxRunSize int64 `json:"max_run_size"`
+}
+
+// Snapshot is the result of a run committed to the server
+type Snapshot struct {
+	RunID    string       `json:"run_id"`
+	Source   string       `json:"source"`
+	ParentID uint         `json:"parent_id,omitempty"`
+	Entries  []Entry      `json:"entries"`
+	Deleted  []string     `json:"deleted"`
+	Files    []FileResult `json:"files"`
+	Hooks    []HookResult `json:"hooks,omitempty"`
+	// StartedAt is when the run began, so the server records its duration
+	StartedAt time.Time `json:"started_at"`
+}
+
+// SnapshotResult is the server's summary of a committed snapshot
+type SnapshotResult struct {
+	Status         string `json:"status"`
+	ManifestID     uint   `json:"manifest_id"`
+	SuccessCount   int    `json:"success_count"`
+	UploadedCount  int    `json:"uploaded_count"`
+	UnchangedCount int    `json:"unchanged_count"`
+	FailedCount    int    `json:"failed_count"`
+	SkippedCount   int    `json:"skipped_count"`
+}
+
+// Restore is a restore the user requested into the agent. Target is relative
+// to the restore directory of the agent.
+type Restore struct {
+	ID         uint     `json:"id"`
+	ManifestID uint     `json:"manifest_id"`
+	Paths      []string `json:"paths"`
+	Target     string   `json:"target"`
+}
+
+// RestoreReport is the outcome of a restore sent back to the server
+type RestoreReport struct {
+	SuccessCount int    `json:"success_count"`
+	FailedCount  int    `json:"failed_count"`
+	Error        string `json:"error,omitempty"`
+}
+
+// APIError is an error answered by the server
+type APIError struct {
+	Status  int
+	Message string
+	// MissingObjects lists the chunks a snapshot referenced that the server does not have
+	MissingObjects []string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server answered %d: %s", e.Status, e.Message)
+}
+
+// Client calls the agent API of a SafeBox server
+type Client struct {
+	server string
+	token  string
+	http   *http.Client
+	stream *http.Client // sem timeout: downloads longos só param pelo contexto
+}
+
+func NewClient(server, token string) *Client {
+	return &Client{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		http:   &http.Client{Timeout: 5 * time.Minute},
+		stream: &http.Client{},
+	}
+}
+
+// StartRun announces a run of source and returns its latest snapshot.
+// mode is empty for a regular run and "sync" for continuous sync.
+func (c *Client) StartRun(ctx context.Context, source, mode string) (*Run, error) {
+	var run Run
+	req := map[string]string{"source": source}
+	if mode != "" {
+		req["mode"] = mode
+	}
+	err := c.do(ctx, http.MethodPost, "/api/agent/runs", req, nil, &run)
+	return &run, err
+}
+
+// LookupChunks returns the chunks the user already has among the given hashes
+func (c *Client) LookupChunks(ctx context.Context, hashes []string) (map[string]ChunkRef, error) {
+	var resp struct {
+		Chunks map[string]ChunkRef `json:"chunks"`
+	}
+	err := c.do(ctx, http.MethodPost, "/api/agent/chunks/lookup", map[string][]string{"hashes": hashes}, nil, &resp)
+	return resp.Chunks, err
+}
+
+// UploadChunk stores a chunk already compressed and encrypted with key
+func (c *Client) UploadChunk(ctx context.Context, hash string, size int64, key, encrypted []byte) (ChunkRef, error) {
+	var ref ChunkRef
+	headers := map[string]string{
+		"Content-Type": "application/octet-stream",
+		"X-Chunk-Size": strconv.FormatInt(size, 10),
+		"X-Chunk-Key":  base64.StdEncoding.EncodeToString(key),
+	}
+	err := c.do(ctx, http.MethodPut, "/api/agent/chunks/"+hash, encrypted, headers, &ref)
+	return ref, err
+}
+
+// CommitSnapshot records the snapshot of a run
+func (c *Client) CommitSnapshot(ctx context.Context, snapshot *Snapshot) (*SnapshotResult, error) {
+	var result SnapshotResult
+	err := c.do(ctx, http.MethodPost, "/api/agent/snapshots", snapshot, nil, &result)
+	return &result, err
+}
+
+// Restores returns the restores requested for the agent that are not finished
+func (c *Client) Restores(ctx context.Context) ([]Restore, error) {
+	var resp struct {
+		Restores []Restore `json:"restores"`
+	}
+	err := c.do(ctx, http.MethodGet, "/api/agent/restores", nil, nil, &resp)
+	return resp.Restores, err
+}
+
+// DownloadRestore returns the files of a restore as a tar stream that ends
+// with the server's per-file report. It is not retried, since the stream
+// cannot be resumed.
+func (c *Client) DownloadRestore(ctx context.Context, id uint) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/agent/restores/%d/content", c.server, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, apiError(resp)
+	}
+	return resp.Body, nil
+}
+
+// ReportRestore finishes a restore with its outcome
+func (c *Client) ReportRestore(ctx context.Context, id uint, report *RestoreReport) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/api/agent/restores/%d/result", id), report, nil, &struct{}{})
+}
+
+// do sends a request, retrying network and server errors with backoff.
+// body is sent as is when it is a []byte and as JSON otherwise.
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, headers map[string]string, out interface{}) error {
+	payload, ok := body.([]byte)
+	if !ok {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	for attempt := 0; attempt < requestAttempts; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, backoff(attempt)); err != nil {
+				return err
+			}
+		}
+		err = c.send(ctx, method, path, payload, headers, out)
+		var apiErr *APIError
+		if err == nil || ctx.Err() != nil || (errors.As(err, &apiErr) && apiErr.Status < 500) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method, path string, payload []byte, headers map[string]string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return apiError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiError reads the error answered in resp
+func apiError(resp *http.Response) *APIError {
+	var failure struct {
+		Error          string   `json:"error"`
+		MissingObjects []string `json:"missing_objects"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if json.Unmarshal(data, &failure) != nil || failure.Error == "" {
+		failure.Error = strings.TrimSpace(string(data))
+	}
+	return &APIError{Status: resp.StatusCode, Message: failure.Error, MissingObjects: failure.MissingObjects}
+}
+
+// backoff returns the wait before the given retry: 1s, 2s, 4s...
+func backoff(attempt int) time.Duration {
+	return time.Second << (attempt - 1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
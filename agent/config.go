@@ -0,0 +1,304 @@
+package agent
+
+import (
+	"SafeBox/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config is the configuration file of the backup agent
+type Config struct {
+	// Server is the base URL of the SafeBox API
+	Server string `json:"server"`
+	// Token is the OAuth access token of the user; SAFEBOX_TOKEN overrides it
+	Token string `json:"token"`
+	// StateDir keeps the checkpoints of interrupted runs
+	StateDir string `json:"state_dir"`
+	// RestoreDir receives the restores requested for the agent, each in its
+	// own directory
+	RestoreDir string `json:"restore_dir"`
+	// Workers is how many files are backed up at the same time
+	Workers int `json:"workers"`
+	// Retries is how many more times a file that failed is tried
+	Retries int `json:"retries"`
+	// SyncDebounce is how long sync mode waits after the last change before
+	// committing, and SyncMaxDelay the longest a change waits while others keep coming
+	SyncDebounce Duration `json:"sync_debounce"`
+	SyncMaxDelay Duration `json:"sync_max_delay"`
+	// SyncRescan is the interval of the full rescans of sync mode when the
+	// file system cannot be watched
+	SyncRescan Duration  `json:"sync_rescan"`
+	Profiles   []Profile `json:"profiles"`
+}
+
+// Duration is a time.Duration written as a string such as "2s" or "15m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Profile selects local paths to back up into one snapshot source.
+// A single path is stored at the top of the snapshot; with several, or with
+// Sources, each one gets a directory named after its absolute path. PreHooks and PostHooks run
+// around each full run; the incremental commits of sync mode skip them.
+type Profile struct {
+	Name             string   `json:"name"`
+	Paths            []string `json:"paths"`
+	Sources          []Source `json:"sources"`
+	Include          []string `json:"include"`
+	Exclude          []string `json:"exclude"`
+	MaxFileSize      int64    `json:"max_file_size"`
+	FollowSymlinks   bool     `json:"follow_symlinks"`
+	OneFileSystem    bool     `json:"one_file_system"`
+	Compression      string   `json:"compression"`
+	CompressionLevel int      `json:"compression_level"`
+	PreHooks         []Hook   `json:"pre_hooks"`
+	PostHooks        []Hook   `json:"post_hooks"`
+}
+
+// LoadConfig reads and validates the configuration file at path
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	if token := os.Getenv("SAFEBOX_TOKEN"); token != "" {
+		config.Token = token
+	}
+	if config.StateDir == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("state_dir is not set: %w", err)
+		}
+		config.StateDir = filepath.Join(dir, "safebox-agent")
+	}
+	if config.RestoreDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("restore_dir is not set: %w", err)
+		}
+		config.RestoreDir = filepath.Join(home, "safebox-restores")
+	}
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	}
+	if config.SyncDebounce <= 0 {
+		config.SyncDebounce = Duration(2 * time.Second)
+	}
+	if config.SyncMaxDelay < config.SyncDebounce {
+		config.SyncMaxDelay = Duration(max(time.Minute, time.Duration(config.SyncDebounce)))
+	}
+	if config.SyncRescan <= 0 {
+		config.SyncRescan = Duration(15 * time.Minute)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Config) validate() error {
+	if !strings.HasPrefix(c.Server, "https://") && !strings.HasPrefix(c.Server, "http://") {
+		return errors.New("server must be an http(s) URL")
+	}
+	if c.Token == "" {
+		return errors.New("token is not set")
+	}
+	if len(c.Profiles) == 0 {
+		return errors.New("no profiles configured")
+	}
+
+	names := map[string]bool{}
+	for i := range c.Profiles {
+		profile := &c.Profiles[i]
+		if profile.Name == "" || strings.ContainsAny(profile.Name, "/\\") || profile.Name == "." || profile.Name == ".." {
+			return fmt.Errorf("invalid profile name %q", profile.Name)
+		}
+		if names[profile.Name] {
+			return fmt.Errorf("duplicate profile %q", profile.Name)
+		}
+		names[profile.Name] = true
+
+		if len(profile.Paths) == 0 && len(profile.Sources) == 0 {
+			return fmt.Errorf("profile %s: at least one path or source is required", profile.Name)
+		}
+		top := map[string]bool{}
+		for j, p := range profile.Paths {
+			abs, err := filepath.Abs(p)
+			if err != nil {
+				return fmt.Errorf("profile %s: %w", profile.Name, err)
+			}
+			profile.Paths[j] = abs
+			top[strings.SplitN(sourceName(abs), "/", 2)[0]] = true
+		}
+		for j := range profile.Sources {
+			if err := profile.Sources[j].validate(); err != nil {
+				return fmt.Errorf("profile %s: %w", profile.Name, err)
+			}
+			// As cópias ficam no topo do snapshot, ao lado dos diretórios dos caminhos
+			file := profile.Sources[j].File
+			if top[file] {
+				return fmt.Errorf("profile %s: source file %q is already used in the snapshot", profile.Name, file)
+			}
+			top[file] = true
+		}
+		for _, pattern := range append(append([]string{}, profile.Include...), profile.Exclude...) {
+			if err := utils.ValidateGlob(pattern); err != nil {
+				return fmt.Errorf("profile %s: %w", profile.Name, err)
+			}
+		}
+		if profile.Compression == "" {
+			profile.Compression = string(utils.CompressionZstd)
+		}
+		switch utils.CompressionAlgorithm(profile.Compression) {
+		case utils.CompressionZstd, utils.CompressionGzip, utils.CompressionNone:
+		default:
+			return fmt.Errorf("profile %s: unsupported compression %q", profile.Name, profile.Compression)
+		}
+		for _, hooks := range [][]Hook{profile.PreHooks, profile.PostHooks} {
+			for j := range hooks {
+				if err := hooks[j].validate(); err != nil {
+					return fmt.Errorf("profile %s: %w", profile.Name, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Profile returns the profile with the given name
+func (c *Config) Profile(name string) (*Profile, error) {
+	for i := range c.Profiles {
+		if c.Profiles[i].Name == name {
+			return &c.Profiles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("profile %q not found", name)
+}
+
+func (p *Profile) walkOptions() utils.WalkOptions {
+	return utils.WalkOptions{
+		Include:        p.Include,
+		Exclude:        p.Exclude,
+		MaxFileSize:    p.MaxFileSize,
+		FollowSymlinks: p.FollowSymlinks,
+		OneFileSystem:  p.OneFileSystem,
+	}
+}
+
+func (p *Profile) compression() utils.CompressionOptions {
+	return utils.CompressionOptions{
+		Algorithm: utils.CompressionAlgorithm(p.Compression),
+		Level:     p.CompressionLevel,
+	}
+}
+
+// Source is something a profile backs up that is not a plain directory: a
+// SQLite database copied through the online backup API (kind "sqlite") or a
+// git repository stored as a bundle ("git"), both at Path, or the standard
+// output of Command ("command"), such as pg_dump. The copy is stored as File
+// at the top of the snapshot. Commands run on the user's own machine with the
+// environment of the agent plus Env; Timeout defaults to an hour.
+type Source struct {
+	Kind    string   `json:"kind"`
+	Path    string   `json:"path"`
+	Command []string `json:"command"`
+	Env     []string `json:"env"`
+	Timeout Duration `json:"timeout"`
+	File    string   `json:"file"`
+}
+
+func (s *Source) validate() error {
+	switch s.Kind {
+	case utils.SourceSQLite, utils.SourceGit:
+		if s.Path == "" || len(s.Command) > 0 {
+			return fmt.Errorf("%s sources need a path and no command", s.Kind)
+		}
+		abs, err := filepath.Abs(s.Path)
+		if err != nil {
+			return err
+		}
+		s.Path = abs
+		if s.File == "" {
+			s.File = filepath.Base(abs)
+			if s.Kind == utils.SourceGit {
+				s.File += ".bundle"
+			}
+		}
+	case utils.SourceCommand:
+		if len(s.Command) == 0 || s.Command[0] == "" || s.Path != "" {
+			return errors.New("command sources need a command and no path")
+		}
+		if s.File == "" {
+			return errors.New("command sources need the file their output is stored as")
+		}
+	default:
+		return fmt.Errorf("unsupported source kind %q", s.Kind)
+	}
+
+	if !utils.ValidSourceFile(s.File) {
+		return fmt.Errorf("source file %q must be a plain file name", s.File)
+	}
+	for _, entry := range s.Env {
+		if !utils.ValidHookEnv(entry) {
+			return fmt.Errorf("source environment entry %q must be NAME=value", entry)
+		}
+	}
+	return nil
+}
+
+// backupSource builds the source that makes the copy
+func (s *Source) backupSource(env []string) utils.BackupSource {
+	switch s.Kind {
+	case utils.SourceSQLite:
+		return utils.SQLiteSource{Path: s.Path, File: s.File}
+	case utils.SourceGit:
+		return utils.GitSource{Repo: s.Path, File: s.File, Timeout: time.Duration(s.Timeout)}
+	default:
+		return utils.CommandSource{
+			Command: utils.HookCommand{
+				Path:    s.Command[0],
+				Args:    s.Command[1:],
+				Env:     append(append(os.Environ(), env...), s.Env...),
+				Timeout: time.Duration(s.Timeout),
+			},
+			File: s.File,
+		}
+	}
+}
+
+// origin describes where the copy came from, in the results of a run
+func (s *Source) origin() string {
+	if s.Kind == utils.SourceCommand {
+		return s.Kind + ":" + strings.Join(s.Command, " ")
+	}
+	return s.Kind + ":" + filepath.ToSlash(s.Path)
+}
@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"SafeBox/utils"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Momento e política de falha dos hooks, como no servidor
+const (
+	hookPre      = "pre"
+	hookPost     = "post"
+	hookAbort    = "abort"
+	hookContinue = "continue"
+)
+
+// defaultHookTimeout applies to hooks that set no timeout
+const defaultHookTimeout = 5 * time.Minute
+
+// errHookFailed is returned when a hook set to abort fails
+var errHookFailed = errors.New("backup hook failed")
+
+// Hook is a command the agent runs before (pre_hooks) or after (post_hooks)
+// each backup of a profile, such as a database dump into one of its paths.
+// Hooks run on the user's own machine, so any command may be used; they
+// inherit the environment of the agent plus Env and the SAFEBOX_* variables
+// describing the run. OnFailure is "abort" (the default) or "continue".
+type Hook struct {
+	Command   []string `json:"command"`
+	Env       []string `json:"env"`
+	Timeout   Duration `json:"timeout"`
+	OnFailure string   `json:"on_failure"`
+}
+
+// HookResult is the outcome of a hook, sent with the snapshot
+type HookResult struct {
+	Phase      string `json:"phase"`
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exit_code"`
+	Output     string `json:"output"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	TimedOut   bool   `json:"timed_out,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+	Aborted    bool   `json:"aborted,omitempty"`
+}
+
+func (h *Hook) validate() error {
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return errors.New("hook command is empty")
+	}
+	for _, entry := range h.Env {
+		if !utils.ValidHookEnv(entry) {
+			return fmt.Errorf("hook environment entry %q must be NAME=value", entry)
+		}
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = Duration(defaultHookTimeout)
+	}
+	switch h.OnFailure {
+	case "":
+		h.OnFailure = hookAbort
+	case hookAbort, hookContinue:
+	default:
+		return fmt.Errorf("hook on_failure must be %q or %q", hookAbort, hookContinue)
+	}
+	return nil
+}
+
+// runHooks runs the hooks of one phase in order and keeps their results for
+// the snapshot. Before the backup, a failing hook set to abort stops the
+// phase; after it, every hook runs so cleanups are not skipped.
+func (r *run) runHooks(ctx context.Context, phase string, hooks []Hook, env []string) error {
+	var failed error
+	for _, hook := range hooks {
+		output, err := utils.RunHook(ctx, utils.HookCommand{
+			Path:    hook.Command[0],
+			Args:    hook.Command[1:],
+			Env:     append(append(os.Environ(), env...), hook.Env...),
+			Timeout: time.Duration(hook.Timeout),
+		})
+		result := HookResult{
+			Phase:      phase,
+			Command:    strings.Join(hook.Command, " "),
+			ExitCode:   output.ExitCode,
+			Output:     output.Output,
+			Truncated:  output.Truncated,
+			TimedOut:   output.TimedOut,
+			DurationMs: output.Duration.Milliseconds(),
+		}
+
+		fields := logrus.Fields{"profile": r.profile.Name, "phase": phase, "command": result.Command, "exit_code": result.ExitCode}
+		if err == nil {
+			logrus.WithFields(fields).WithField("output", result.Output).Info("Hook finished")
+		} else {
+			result.Error = err.Error()
+			result.Aborted = hook.OnFailure == hookAbort
+			logrus.WithFields(fields).WithField("output", result.Output).WithError(err).Warn("Hook failed")
+		}
+
+		r.mu.Lock()
+		r.hooks = append(r.hooks, result)
+		r.mu.Unlock()
+
+		if !result.Aborted {
+			continue
+		}
+		if failed == nil {
+			failed = fmt.Errorf("%w: %s hook %q: %v", errHookFailed, phase, result.Command, err)
+		}
+		if phase == hookPre {
+			break
+		}
+	}
+	return failed
+}
+
+// hookEnv describes a run to its hooks
+func hookEnv(profile *Profile, mode string) []string {
+	if mode == "" {
+		mode = "agent"
+	}
+	return []string{
+		"SAFEBOX_PROFILE=" + profile.Name,
+		"SAFEBOX_MODE=" + mode,
+		"SAFEBOX_PATHS=" + strings.Join(profile.Paths, string(os.PathListSeparator)),
+	}
+}
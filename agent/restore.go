@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"SafeBox/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// restoreReportName is the last member of a restore stream, with the result
+// of every file the server sent
+const restoreReportName = "safebox-restore-report.json"
+
+// restoreStreamReport is the report the server appends to a restore stream
+type restoreStreamReport struct {
+	SuccessCount int `json:"success_count"`
+	FailedCount  int `json:"failed_count"`
+	Files        []struct {
+		Path string `json:"path"`
+		OK   bool   `json:"ok"`
+	} `json:"files"`
+}
+
+// Restores writes every restore requested for the agent and reports each
+// one to the server. A failed restore does not stop the others; the first
+// error is returned once all were tried.
+func (a *Agent) Restores(ctx context.Context) error {
+	restores, err := a.client.Restores(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list restores: %w", err)
+	}
+
+	var first error
+	for i := range restores {
+		restore := &restores[i]
+		report, err := a.Restore(ctx, restore)
+		if err != nil {
+			logrus.WithError(err).WithField("restore", restore.ID).Error("Restore failed")
+			if first == nil {
+				first = err
+			}
+			if ctx.Err() != nil {
+				// Interrompida, a restauração continua aberta e é tentada de novo
+				return first
+			}
+			report = &RestoreReport{Error: err.Error()}
+		}
+		if err := a.client.ReportRestore(ctx, restore.ID, report); err != nil {
+			logrus.WithError(err).WithField("restore", restore.ID).Error("Failed to report restore")
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Restore writes the files of a restore into its target directory below
+// RestoreDir. The target must not exist yet, so nothing on the machine is
+// overwritten. Files the server sent but could not verify are removed and
+// counted as failed.
+func (a *Agent) Restore(ctx context.Context, restore *Restore) (*RestoreReport, error) {
+	dir, err := utils.SafeJoin(a.config.RestoreDir, restore.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid restore target %q: %w", restore.Target, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+
+	body, err := a.client.DownloadRestore(ctx, restore.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download restore: %w", err)
+	}
+	defer body.Close()
+
+	opts := utils.DefaultExtractOptions
+	opts.AllowSymlinks = true
+	if err := utils.ExtractTar(body, dir, opts); err != nil {
+		// Interrompida, a restauração é refeita do zero na próxima vez
+		if ctx.Err() != nil {
+			os.RemoveAll(dir)
+		}
+		return nil, fmt.Errorf("failed to write restore: %w", err)
+	}
+
+	// Sem o relatório o stream terminou antes do fim
+	data, err := os.ReadFile(filepath.Join(dir, restoreReportName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.New("restore stream ended before its report")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report restoreStreamReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid restore report: %w", err)
+	}
+
+	// O tar completa com zeros os arquivos que falharam; eles não ficam no destino
+	for _, file := range report.Files {
+		if file.OK {
+			continue
+		}
+		target, err := utils.SafeJoin(dir, strings.TrimPrefix(filepath.ToSlash(file.Path), "backups/"))
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			logrus.WithError(err).WithField("path", target).Warn("Failed to remove file that failed verification")
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"restore":  restore.ID,
+		"snapshot": restore.ManifestID,
+		"target":   dir,
+		"restored": report.SuccessCount,
+		"failed":   report.FailedCount,
+	}).Info("Restore finished")
+	return &RestoreReport{SuccessCount: report.SuccessCount, FailedCount: report.FailedCount}, nil
+}
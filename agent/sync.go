@@ -0,0 +1,481 @@
+package agent
+
+import (
+	"SafeBox/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// modeSync marks the snapshots committed by continuous sync
+const modeSync = "sync"
+
+// maxSyncRetryDelay caps the wait before a failed sync is tried again
+const maxSyncRetryDelay = 5 * time.Minute
+
+// errWatchLimit is returned when the system cannot watch more directories
+var errWatchLimit = errors.New("watch limit reached")
+
+// syncer keeps a profile in sync: it watches the local paths and commits a
+// snapshot with the changed files shortly after they change
+type syncer struct {
+	agent   *Agent
+	profile *Profile
+	sources []source
+
+	watcher *fsnotify.Watcher
+	polling bool
+
+	chunkOpts utils.ChunkOptions
+	entries   map[string]Entry
+	parentID  uint
+
+	// dirty holds, per source, the relative paths changed since the last commit;
+	// "" stands for the whole source
+	dirty      map[int]map[string]bool
+	firstDirty time.Time
+}
+
+// Sync backs up a profile and then keeps backing up its changes until ctx is
+// done. Changes are found with file system notifications, batched for
+// SyncDebounce and committed as snapshots of mode "sync", which count towards
+// the daily sync limit of the plan instead of the daily backup limit. Files
+// moved inside the profile are recorded as moves without being uploaded
+// again. When the file system cannot be watched, sync falls back to full
+// rescans every SyncRescan.
+// Profiles with Sources cannot be synced, since their copies only change
+// when they are made again; regular runs back them up.
+func (a *Agent) Sync(ctx context.Context, profile *Profile) error {
+	if len(profile.Sources) > 0 {
+		return fmt.Errorf("profile %s has sources, which sync cannot watch", profile.Name)
+	}
+	s := &syncer{
+		agent:   a,
+		profile: profile,
+		sources: sources(profile),
+		dirty:   map[int]map[string]bool{},
+	}
+
+	// As observações começam antes da primeira execução para não perder mudanças feitas durante ela
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		s.fallBackToPolling(err)
+	} else {
+		s.watcher = watcher
+		defer func() {
+			if s.watcher != nil {
+				s.watcher.Close()
+			}
+		}()
+		for i := range s.sources {
+			if err := s.watchTree(s.sources[i].Root); err != nil {
+				s.fallBackToPolling(err)
+				break
+			}
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		r, result, err := a.fullRun(ctx, profile, modeSync)
+		if result != nil {
+			// Com o snapshot gravado, a falha de um hook "post" não impede a sincronização
+			if err != nil {
+				logrus.WithError(err).WithField("profile", profile.Name).Warn("Initial sync committed with a failed hook")
+			}
+			s.chunkOpts = r.chunkOpts
+			s.entries = r.entries
+			s.parentID = result.ManifestID
+			// A execução completa já cobriu o que a troca para varreduras marcou
+			if s.polling {
+				s.dirty = map[int]map[string]bool{}
+			}
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logrus.WithError(err).WithField("profile", profile.Name).Error("Initial sync failed")
+		if err := sleep(ctx, retryDelay(attempt+1)); err != nil {
+			return err
+		}
+	}
+	logrus.WithFields(logrus.Fields{"profile": profile.Name, "polling": s.polling}).Info("Watching for changes")
+	return s.loop(ctx)
+}
+
+func (s *syncer) loop(ctx context.Context) error {
+	var (
+		events  <-chan fsnotify.Event
+		errs    <-chan error
+		rescan  <-chan time.Time
+		flush   *time.Timer
+		flushC  <-chan time.Time
+		retries int
+	)
+	rescanTicker := time.NewTicker(time.Duration(s.agent.config.SyncRescan))
+	defer rescanTicker.Stop()
+
+	schedule := func(d time.Duration) {
+		if flush == nil {
+			flush = time.NewTimer(d)
+		} else {
+			flush.Stop()
+			flush.Reset(d)
+		}
+		flushC = flush.C
+	}
+	defer func() {
+		if flush != nil {
+			flush.Stop()
+		}
+	}()
+	if len(s.dirty) > 0 {
+		schedule(s.delay())
+	}
+
+	for {
+		if s.polling {
+			events, errs, rescan = nil, nil, rescanTicker.C
+		} else {
+			events, errs, rescan = s.watcher.Events, s.watcher.Errors, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case event, ok := <-events:
+			if !ok {
+				s.fallBackToPolling(errors.New("watcher closed"))
+				continue
+			}
+			if s.handle(event) && retries == 0 {
+				schedule(s.delay())
+			}
+
+		case err, ok := <-errs:
+			if !ok {
+				s.fallBackToPolling(errors.New("watcher closed"))
+				continue
+			}
+			// Eventos perdidos: só uma varredura completa garante o snapshot
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				logrus.WithField("profile", s.profile.Name).Warn("Change events were lost, rescanning")
+			} else {
+				logrus.WithError(err).WithField("profile", s.profile.Name).Warn("Watcher error, rescanning")
+			}
+			s.markAll()
+			if retries == 0 {
+				schedule(s.delay())
+			}
+
+		case <-rescan:
+			s.markAll()
+			if retries == 0 {
+				schedule(0)
+			}
+
+		case <-flushC:
+			flushC = nil
+			if len(s.dirty) == 0 {
+				continue
+			}
+			if err := s.flush(ctx); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				retries++
+				logrus.WithError(err).WithField("profile", s.profile.Name).Error("Sync failed")
+				schedule(retryDelay(retries))
+				continue
+			}
+			retries = 0
+			if len(s.dirty) > 0 {
+				schedule(s.delay())
+			}
+		}
+	}
+}
+
+// delay returns how long to wait before flushing: SyncDebounce after the
+// last change, but no later than SyncMaxDelay after the first one
+func (s *syncer) delay() time.Duration {
+	debounce := time.Duration(s.agent.config.SyncDebounce)
+	deadline := s.firstDirty.Add(time.Duration(s.agent.config.SyncMaxDelay))
+	if remaining := time.Until(deadline); remaining < debounce {
+		return max(remaining, 0)
+	}
+	return debounce
+}
+
+// retryDelay grows like backoff up to maxSyncRetryDelay
+func retryDelay(attempt int) time.Duration {
+	if attempt > 8 {
+		return maxSyncRetryDelay
+	}
+	return min(backoff(attempt), maxSyncRetryDelay)
+}
+
+// handle records the path of an event as dirty and reports whether it did
+func (s *syncer) handle(event fsnotify.Event) bool {
+	// Só permissões mudaram; o conteúdo e a data de modificação são os mesmos
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	index, rel, ok := s.locate(event.Name)
+	if !ok || s.excluded(rel) {
+		return false
+	}
+
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+			if err := s.watchTree(event.Name); err != nil {
+				s.fallBackToPolling(err)
+			}
+		}
+	}
+	if (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) && s.watcher != nil {
+		// O diretório pode já ter deixado de ser observado
+		_ = s.watcher.Remove(event.Name)
+	}
+
+	s.mark(index, rel)
+	return true
+}
+
+// locate finds the source of a local path and the path relative to its root
+func (s *syncer) locate(name string) (int, string, bool) {
+	for i, src := range s.sources {
+		rel, err := filepath.Rel(src.Root, name)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+		return i, rel, true
+	}
+	return 0, "", false
+}
+
+// excluded reports whether rel or one of its parent directories is excluded
+func (s *syncer) excluded(rel string) bool {
+	if rel == "" {
+		return false
+	}
+	segments := strings.Split(rel, "/")
+	for i := range segments {
+		for _, pattern := range s.profile.Exclude {
+			if utils.MatchGlob(pattern, path.Join(segments[:i+1]...)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *syncer) mark(index int, rel string) {
+	if len(s.dirty) == 0 {
+		s.firstDirty = time.Now()
+	}
+	if s.dirty[index] == nil {
+		s.dirty[index] = map[string]bool{}
+	}
+	s.dirty[index][rel] = true
+}
+
+func (s *syncer) markAll() {
+	for i := range s.sources {
+		s.mark(i, "")
+	}
+}
+
+// watchTree watches dir and the directories below it that are not excluded
+func (s *syncer) watchTree(dir string) error {
+	if s.watcher == nil {
+		return nil
+	}
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Um diretório ilegível ou apagado durante a varredura não impede os demais
+			if d != nil && d.IsDir() && p != dir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if _, rel, ok := s.locate(p); ok && s.excluded(rel) {
+			return fs.SkipDir
+		}
+		if err := s.watcher.Add(p); err != nil {
+			if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE) {
+				return fmt.Errorf("%w at %s: %v", errWatchLimit, p, err)
+			}
+			logrus.WithError(err).WithField("dir", p).Warn("Failed to watch directory")
+		}
+		return nil
+	})
+}
+
+// fallBackToPolling stops watching and rescans the profile periodically instead
+func (s *syncer) fallBackToPolling(err error) {
+	if s.polling {
+		return
+	}
+	logrus.WithError(err).WithFields(logrus.Fields{
+		"profile": s.profile.Name,
+		"rescan":  time.Duration(s.agent.config.SyncRescan),
+	}).Warn("Cannot watch the file system, falling back to periodic rescans")
+	if s.watcher != nil {
+		s.watcher.Close()
+		s.watcher = nil
+	}
+	s.polling = true
+	// O que mudou até agora pode não ter gerado eventos
+	s.markAll()
+}
+
+// flush stores the dirty paths and commits a snapshot if anything changed.
+// On failure the paths stay dirty and are tried again later.
+func (s *syncer) flush(ctx context.Context) error {
+	dirty := s.dirty
+	s.dirty = map[int]map[string]bool{}
+	if err := s.commitDirty(ctx, dirty); err != nil {
+		for index, rels := range dirty {
+			for rel := range rels {
+				if s.dirty[index] == nil {
+					s.dirty[index] = map[string]bool{}
+				}
+				s.dirty[index][rel] = true
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *syncer) commitDirty(ctx context.Context, dirty map[int]map[string]bool) error {
+	r, err := s.agent.newRun(s.profile, s.chunkOpts, s.entries)
+	if err != nil {
+		return err
+	}
+	r.gone = map[string]Entry{}
+	for p, entry := range s.entries {
+		r.entries[p] = entry
+		r.seen[p] = true
+	}
+
+	// As entradas abaixo dos caminhos alterados saem do snapshot e só voltam
+	// se o arquivo for encontrado de novo, no mesmo lugar ou movido
+	type target struct {
+		src source
+		rel string
+	}
+	var targets []target
+	for index, rels := range dirty {
+		src := s.sources[index]
+		for _, rel := range compactPaths(rels) {
+			targets = append(targets, target{src: src, rel: rel})
+			prefix := path.Join(src.Name, rel)
+			for p, entry := range r.entries {
+				if prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+					r.gone[p] = entry
+					delete(r.entries, p)
+					delete(r.seen, p)
+				}
+			}
+		}
+	}
+
+	err = r.process(ctx, func(visit func(localFile) error) error {
+		for _, t := range targets {
+			if err := utils.WalkSourcePath(t.src.Root, t.rel, s.profile.walkOptions(), r.walkFunc(t.src, visit), r.skipFunc(t.src)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.saveCheckpoint()
+		return err
+	}
+
+	if !s.changed(r) {
+		if err := r.checkpoint.remove(); err != nil {
+			logrus.WithError(err).Warn("Failed to remove checkpoint")
+		}
+		return nil
+	}
+	// Cada commit da sincronização é uma execução admitida pelo servidor
+	start, err := s.agent.client.StartRun(ctx, s.profile.Name, modeSync)
+	if err != nil {
+		r.saveCheckpoint()
+		return fmt.Errorf("failed to start run: %w", err)
+	}
+	r.id = start.ID
+	result, err := r.commit(ctx, s.parentID)
+	if err != nil {
+		return err
+	}
+	s.entries = r.entries
+	s.parentID = result.ManifestID
+	logrus.WithFields(logrus.Fields{
+		"profile":  s.profile.Name,
+		"snapshot": result.ManifestID,
+		"uploaded": result.UploadedCount,
+		"failed":   result.FailedCount,
+	}).Info("Changes synced")
+	return nil
+}
+
+// changed reports whether a sync run differs from the last committed snapshot
+func (s *syncer) changed(r *run) bool {
+	if len(r.entries) != len(s.entries) {
+		return true
+	}
+	for _, result := range r.results {
+		if result.Status == statusFailed || result.MovedFrom != "" {
+			return true
+		}
+	}
+	for p, entry := range r.entries {
+		prev, ok := s.entries[p]
+		if !ok || prev.Size != entry.Size || prev.SHA256 != entry.SHA256 || !prev.ModTime.Equal(entry.ModTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// compactPaths drops the paths that are below another path of the set
+func compactPaths(rels map[string]bool) []string {
+	if rels[""] {
+		return []string{""}
+	}
+	var compact []string
+	for rel := range rels {
+		covered := false
+		for dir := path.Dir(rel); dir != "." && !covered; dir = path.Dir(dir) {
+			covered = rels[dir]
+		}
+		if !covered {
+			compact = append(compact, rel)
+		}
+	}
+	sort.Strings(compact)
+	return compact
+}
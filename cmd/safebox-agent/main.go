@@ -0,0 +1,102 @@
+// Command safebox-agent backs up local paths of the client machine to a
+// SafeBox server. Files are chunked, compressed and encrypted locally; only
+// the chunks the server does not have yet are uploaded.
+//
+// Usage:
+//
+//	safebox-agent -config agent.json [-profile name] [-sync]
+//	safebox-agent -config agent.json -restore
+//
+// Without -profile every profile of the configuration is backed up in turn.
+// An interrupted run resumes from its checkpoint the next time it starts.
+// With -sync the agent keeps running and backs up the changes of the selected
+// profiles as soon as they happen, until it is interrupted. With -restore the
+// agent writes the restores requested for it below restore_dir and exits.
+package main
+
+import (
+	"SafeBox/agent"
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	configPath := flag.String("config", "safebox-agent.json", "path of the configuration file")
+	profileName := flag.String("profile", "", "back up only this profile")
+	syncMode := flag.Bool("sync", false, "keep watching the profiles and back up their changes continuously")
+	restoreMode := flag.Bool("restore", false, "write the restores requested for this agent and exit")
+	flag.Parse()
+
+	config, err := agent.LoadConfig(*configPath)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to load configuration")
+	}
+
+	profiles := config.Profiles
+	if *profileName != "" {
+		profile, err := config.Profile(*profileName)
+		if err != nil {
+			logrus.WithError(err).Fatal("Invalid profile")
+		}
+		profiles = []agent.Profile{*profile}
+	}
+
+	// Interromper o agente grava o checkpoint; a próxima execução continua dali
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a := agent.New(config)
+	if *restoreMode {
+		if err := a.Restores(ctx); err != nil {
+			logrus.WithError(err).Error("Restore failed")
+			stop()
+			os.Exit(1)
+		}
+		return
+	}
+	if *syncMode {
+		syncProfiles(ctx, a, profiles)
+		return
+	}
+
+	failed := false
+	for i := range profiles {
+		result, err := a.Run(ctx, &profiles[i])
+		if err != nil {
+			logrus.WithError(err).WithField("profile", profiles[i].Name).Error("Backup failed")
+			failed = true
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if result.FailedCount > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		stop()
+		os.Exit(1)
+	}
+}
+
+// syncProfiles keeps every profile in sync until ctx is done
+func syncProfiles(ctx context.Context, a *agent.Agent, profiles []agent.Profile) {
+	var wg sync.WaitGroup
+	for i := range profiles {
+		wg.Add(1)
+		go func(profile *agent.Profile) {
+			defer wg.Done()
+			if err := a.Sync(ctx, profile); err != nil && ctx.Err() == nil {
+				logrus.WithError(err).WithField("profile", profile.Name).Error("Sync stopped")
+			}
+		}(&profiles[i])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,145 @@
+// Command safebox-restore rebuilds the files of a snapshot export without a
+// SafeBox server. It only needs the export, the user's recovery key and the
+// signing keys listed in the recovery kit; when the export references its
+// objects instead of holding them, they are read from a copy of the storage
+// bucket.
+//
+// Usage:
+//
+//	safebox-restore -export snapshot.zip -signing-key hex [-signing-key hex] [-objects dir] [-target dir] [path...]
+//
+// The manifest of the export must be signed with one of the -signing-key
+// keys; the key carried by the export itself is not trusted.
+// The recovery key is read from SAFEBOX_RECOVERY_KEY, from the file given by
+// -key-file or, failing both, from the first line of standard input. Paths,
+// relative to the snapshot root, restore only the files below them.
+package main
+
+import (
+	"SafeBox/recovery"
+	"SafeBox/utils"
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	exportPath := flag.String("export", "", "path of the snapshot export")
+	objectsDir := flag.String("objects", "", "directory holding the objects the export only references, each named by its ID")
+	target := flag.String("target", "restore", "directory the files are written to")
+	keyFile := flag.String("key-file", "", "file holding the recovery key")
+	report := flag.String("report", "", "write the per-file results as JSON to this file")
+	var signingKeys keyList
+	flag.Var(&signingKeys, "signing-key", "hex signing key from the recovery kit; may be repeated")
+	flag.Parse()
+
+	if *exportPath == "" || len(signingKeys) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	bundle, err := recovery.Open(*exportPath, *objectsDir)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to open export")
+	}
+	defer bundle.Close()
+	header := bundle.Header
+	if !header.ObjectsIncluded && *objectsDir == "" {
+		logrus.Fatal("The export only references its objects; pass -objects with a copy of the storage bucket")
+	}
+
+	recoveryKey, err := readRecoveryKey(*keyFile)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to read recovery key")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logrus.WithFields(logrus.Fields{
+		"snapshot": header.SnapshotID,
+		"source":   header.AppName,
+		"created":  header.SnapshotCreatedAt,
+		"files":    header.FileCount,
+	}).Info("Restoring snapshot")
+	result, err := bundle.Restore(ctx, recoveryKey, signingKeys, *target, flag.Args())
+	if result != nil && *report != "" {
+		if writeErr := writeReport(*report, result); writeErr != nil {
+			logrus.WithError(writeErr).Error("Failed to write report")
+		}
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Restore failed")
+		stop()
+		os.Exit(1)
+	}
+
+	for _, file := range result.Files {
+		if !file.OK {
+			logrus.WithField("path", file.Path).Error("File not restored: ", file.Error)
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"target":   result.Target,
+		"restored": result.SuccessCount,
+		"failed":   result.FailedCount,
+	}).Info("Restore finished")
+	if result.FailedCount > 0 {
+		stop()
+		os.Exit(1)
+	}
+}
+
+// keyList collects the repeated -signing-key flags
+type keyList []ed25519.PublicKey
+
+func (k *keyList) String() string {
+	return fmt.Sprint(len(*k), " keys")
+}
+
+func (k *keyList) Set(text string) error {
+	key, err := recovery.ParseSigningKey(text)
+	if err != nil {
+		return err
+	}
+	*k = append(*k, key)
+	return nil
+}
+
+// readRecoveryKey reads the recovery key from the environment, a file or stdin
+func readRecoveryKey(keyFile string) ([]byte, error) {
+	text := os.Getenv("SAFEBOX_RECOVERY_KEY")
+	if text == "" && keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	if text == "" {
+		fmt.Fprint(os.Stderr, "Recovery key: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return nil, errors.New("no recovery key given")
+		}
+		text = line
+	}
+	return utils.ParseRecoveryKey(text)
+}
+
+func writeReport(path string, result *recovery.Result) error {
+	body, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, body, 0600)
+}
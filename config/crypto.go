@@ -0,0 +1,44 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// LoadMasterKey reads the server master key used to wrap per-user keys.
+// SAFEBOX_MASTER_KEY must hold 32 bytes encoded in standard base64.
+func LoadMasterKey() ([]byte, error) {
+	encoded := os.Getenv("SAFEBOX_MASTER_KEY")
+	if encoded == "" {
+		return nil, fmt.Errorf("SAFEBOX_MASTER_KEY is not set")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAFEBOX_MASTER_KEY: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("SAFEBOX_MASTER_KEY must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// LoadSigningKey reads the server Ed25519 key used to sign backup manifests.
+// SAFEBOX_SIGNING_KEY must hold the 32-byte seed encoded in standard base64.
+func LoadSigningKey() (ed25519.PrivateKey, error) {
+	encoded := os.Getenv("SAFEBOX_SIGNING_KEY")
+	if encoded == "" {
+		return nil, fmt.Errorf("SAFEBOX_SIGNING_KEY is not set")
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAFEBOX_SIGNING_KEY: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("SAFEBOX_SIGNING_KEY must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/services"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+type AccountController struct {
+	Shredder      *services.ShreddingService
+	Limits        *services.BackupLimitService
+	Keys          *services.KeyService
+	Notifications *services.NotificationService
+	Manifests     *services.ManifestService
+}
+
+// NewAccountController creates a new instance of AccountController
+func NewAccountController(shredder *services.ShreddingService, limits *services.BackupLimitService, keys *services.KeyService, notifications *services.NotificationService, manifests *services.ManifestService) *AccountController {
+	return &AccountController{Shredder: shredder, Limits: limits, Keys: keys, Notifications: notifications, Manifests: manifests}
+}
+
+// Delete destroys every key of the authenticated user and removes the account.
+// Stored objects become unreadable immediately and are garbage-collected later.
+func (a *AccountController) Delete(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	record, err := a.Shredder.DeleteUser(c.Request().Context(), user.ID)
+	if err != nil {
+		logrus.Error("Erro ao excluir conta: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error deleting account"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":     "Account deleted",
+		"destruction": record,
+	})
+}
+
+// KeyDestructions lists the key destruction records of the authenticated user
+func (a *AccountController) KeyDestructions(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	records, err := a.Shredder.UserDestructions(c.Request().Context(), user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error reading destruction log"})
+	}
+	return c.JSON(http.StatusOK, records)
+}
+
+// VerifyKeyDestructions checks the integrity of the whole destruction hash chain
+func (a *AccountController) VerifyKeyDestructions(c echo.Context) error {
+	status, err := a.Shredder.VerifyLog(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error verifying destruction log"})
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// SetTimezone sets the IANA time zone, such as "America/Sao_Paulo", in which
+// the daily backup limit of the authenticated user restarts
+func (a *AccountController) SetTimezone(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	var req struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid request"})
+	}
+
+	if err := a.Limits.SetTimezone(c.Request().Context(), user, req.Timezone); err != nil {
+		if errors.Is(err, services.ErrInvalidTimezone) {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		}
+		logrus.Error("Erro ao alterar fuso horário: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error updating timezone"})
+	}
+
+	allowance, err := a.Limits.Allowance(c.Request().Context(), user)
+	if err != nil {
+		logrus.Error("Erro ao calcular limites de backup: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error reading backup allowance"})
+	}
+	allowance.WriteHeaders(c.Response().Header())
+	return c.JSON(http.StatusOK, allowance)
+}
+
+// CreateRecoveryKey generates the recovery key that restores snapshot exports
+// without the server. The key is shown only in this response, together with
+// the signing keys safebox-restore pins to check the manifests of exports;
+// creating a new one replaces it for later exports.
+func (a *AccountController) CreateRecoveryKey(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	ctx := c.Request().Context()
+	signingKeys, err := a.Manifests.SigningKeys(ctx, user.ID)
+	if err != nil {
+		logrus.Error("Erro ao ler chaves de assinatura: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error creating recovery key"})
+	}
+	recoveryKey, keyID, err := a.Keys.CreateRecoveryKey(ctx, user.ID)
+	if err != nil {
+		logrus.Error("Erro ao gerar chave de recuperação: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error creating recovery key"})
+	}
+	pinned := make([]string, len(signingKeys))
+	for i, key := range signingKeys {
+		pinned[i] = hex.EncodeToString(key)
+	}
+
+	logrus.WithFields(logrus.Fields{"user_id": user.ID, "recovery_key_id": keyID}).Info("Recovery key created")
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"recovery_key":    recoveryKey,
+		"recovery_key_id": keyID,
+		"signing_keys":    pinned,
+		"message":         "Store this key and the signing keys safely: the key is not shown again and both are required to restore exports",
+	})
+}
+
+// NotificationSettings returns which backup e-mails the authenticated user receives
+func (a *AccountController) NotificationSettings(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	settings, err := a.Notifications.Settings(c.Request().Context(), user.ID)
+	if err != nil {
+		logrus.Error("Erro ao ler preferências de notificação: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error reading notification settings"})
+	}
+	return c.JSON(http.StatusOK, notificationResponse(settings))
+}
+
+// UpdateNotificationSettings chooses which backup e-mails the authenticated
+// user receives: the outcome of each run, a warning after stale_after_days
+// without a successful backup, and a weekly digest. Profiles may override
+// the per-run and stale choices.
+func (a *AccountController) UpdateNotificationSettings(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	var req services.NotificationInput
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "invalid request"})
+	}
+
+	settings, err := a.Notifications.UpdateSettings(c.Request().Context(), user.ID, req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidNotification) {
+			return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		}
+		logrus.Error("Erro ao salvar preferências de notificação: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error updating notification settings"})
+	}
+	return c.JSON(http.StatusOK, notificationResponse(settings))
+}
+
+func notificationResponse(settings *models.NotificationSettings) map[string]interface{} {
+	return map[string]interface{}{
+		"email":            settings.Email,
+		"on_success":       settings.OnSuccess,
+		"on_partial":       settings.OnPartial,
+		"on_failure":       settings.OnFailure,
+		"stale_after_days": settings.StaleAfterDays,
+		"weekly_digest":    settings.WeeklyDigest,
+		"digest_sent_at":   settings.DigestSentAt,
+	}
+}
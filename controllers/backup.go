@@ -1,52 +1,121 @@
 package controllers
 
 import (
+	"SafeBox/services"
 	"SafeBox/services/storage"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/semaphore"
+	"gorm.io/gorm"
 
 	"SafeBox/models"
 	"SafeBox/repositories"
 	"SafeBox/utils"
 )
 
+// BackupResult summarizes a backup run; Files lists the outcome of every
+// file by its path in the snapshot
 type BackupResult struct {
-	SuccessCount int
-	FailedFiles  []string
-	Error        error
+	Status           string              `json:"status,omitempty"`
+	SuccessCount     int                 `json:"success_count"`
+	UploadedCount    int                 `json:"uploaded_count"`
+	UnchangedCount   int                 `json:"unchanged_count"`
+	FailedCount      int                 `json:"failed_count"`
+	SkippedCount     int                 `json:"skipped_count"`
+	DeletedFiles     []string            `json:"deleted_files,omitempty"`
+	ManifestID       uint                `json:"manifest_id,omitempty"`
+	ParentManifestID uint                `json:"parent_manifest_id,omitempty"`
+	Files            []BackupFileResult  `json:"files"`
+	Hooks            []models.HookResult `json:"hooks,omitempty"`
+	Error            error               `json:"-"`
+
+	entries []services.ManifestEntry
 }
 
+// ArchiveFormat decides how a backup run is laid out in storage
+type ArchiveFormat string
+
 const (
-	maxFileSize      = 25 * 1024 * 1024 * 1024 // 25 GB
-	maxBackupsPerDay = 10
+	// FormatFiles stores one object per file
+	FormatFiles ArchiveFormat = models.ArchiveFormatFiles
+	// FormatTarZstd stores the whole tree as a single tar+zstd object,
+	// preserving ownership, links, xattrs and sparse files
+	FormatTarZstd ArchiveFormat = models.ArchiveFormatTarZstd
 )
 
+// BackupConfig is a backup profile prepared for a run
 type BackupConfig struct {
-	AllowedExtensions []string
-	MaxFileSize       int64
-	BasePath          string
+	Name        string                 // origem dos snapshots (AppName)
+	Sources     []string               // diretórios relativos à raiz de origem dos backups
+	AppSources  []models.ProfileSource // bancos SQLite, repositórios git e saídas de comandos
+	Walk        utils.WalkOptions
+	Compression utils.CompressionOptions
+	Format      ArchiveFormat
+	PreHooks    []models.BackupHook
+	PostHooks   []models.BackupHook
+}
+
+// backupSource is a source directory on disk and its location in the snapshot
+type backupSource struct {
+	Name string // caminho relativo à raiz de origem; vazio nas cópias geradas
+	Root string
+	Dest string
+	Walk utils.WalkOptions
+}
+
+// backupRun identifies who triggered a backup run and where its progress goes
+type backupRun struct {
+	UserID     uint
+	Mode       string
+	ScheduleID *uint
+	Progress   *services.JobProgress
+	MaxSize    int64     // bytes que a execução pode selecionar, conforme o plano
+	Started    time.Time // início da execução, para a duração registrada
 }
 
 type BackupController struct {
 	Storage    storage.Storage
 	backupRepo *repositories.BackupRepository
+	metadata   *services.FileMetadataService
+	keys       *services.KeyService
+	manifests  *services.ManifestService
+	profiles   *services.ProfileService
+	jobs       *services.BackupJobService
+	chunks     *services.ChunkService
+	limits     *services.BackupLimitService
+	hooks      *services.HookService
+	notifier   *services.NotificationService
 }
 
-func NewBackupController(storage storage.Storage, backupRepo *repositories.BackupRepository) *BackupController {
+func NewBackupController(storage storage.Storage, backupRepo *repositories.BackupRepository, metadata *services.FileMetadataService, keys *services.KeyService, manifests *services.ManifestService, profiles *services.ProfileService, jobs *services.BackupJobService, chunks *services.ChunkService, limits *services.BackupLimitService, hooks *services.HookService, notifier *services.NotificationService) *BackupController {
 	return &BackupController{
 		Storage:    storage,
 		backupRepo: backupRepo,
+		metadata:   metadata,
+		keys:       keys,
+		manifests:  manifests,
+		profiles:   profiles,
+		jobs:       jobs,
+		chunks:     chunks,
+		limits:     limits,
+		hooks:      hooks,
+		notifier:   notifier,
 	}
 }
 
@@ -56,87 +125,456 @@ func (b *BackupController) Backup(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 	}
 
-	count, err := b.backupRepo.CountUserBackupsToday(user.ID)
+	allowance, err := b.limits.Allowance(c.Request().Context(), user)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to read backup allowance")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	allowance.WriteHeaders(c.Response().Header())
+
+	// Um perfil do usuário (?profile=<id>) ou um modelo embutido (?type=gallery)
+	var profileID *uint
+	if value := c.QueryParam("profile"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid profile id"})
+		}
+		profileID = new(uint)
+		*profileID = uint(id)
+	}
+	config, err := b.getBackupConfig(c.Request().Context(), user.ID, profileID, c.QueryParam("type"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "profile not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if format := c.QueryParam("format"); format != "" {
+		if config.Format, err = parseArchiveFormat(format); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	// O backup roda em segundo plano; o cliente acompanha pelo ID do job
+	job := &models.BackupJob{
+		UserID:     user.ID,
+		Source:     config.Name,
+		ProfileID:  profileID,
+		BackupType: c.QueryParam("type"),
+		Format:     string(config.Format),
+		Mode:       models.BackupModeManual,
+	}
+	run := backupRun{UserID: user.ID, Mode: models.BackupModeManual, MaxSize: allowance.Limits.MaxRunSize}
+	// Os limites do plano são conferidos de novo ao criar o job, com o usuário travado
+	status, err := b.jobs.Submit(c.Request().Context(), job, b.jobRunner(run, config))
+	if errors.Is(err, services.ErrJobActive) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	if errors.Is(err, services.ErrDailyBackupLimit) || errors.Is(err, services.ErrConcurrentBackupLimit) {
+		// Outro pedido levou a vaga depois da leitura acima
+		if current, readErr := b.limits.Allowance(c.Request().Context(), user); readErr == nil {
+			allowance = current
+			allowance.WriteHeaders(c.Response().Header())
+		}
+		return limitExceeded(c, allowance, err)
+	}
 	if err != nil {
-		logrus.WithError(err).Error("Failed to count user backups")
+		logrus.WithError(err).Error("Failed to submit backup job")
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
 	}
-	if count >= maxBackupsPerDay {
-		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "daily backup limit exceeded"})
+	allowance.Consume()
+	allowance.WriteHeaders(c.Response().Header())
+
+	return c.JSON(http.StatusAccepted, status)
+}
+
+// Manifest returns a backup manifest record and, for zero-knowledge users,
+// the payload that must be signed client-side
+func (b *BackupController) Manifest(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	record, err := b.findManifest(c, user)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "manifest not found"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"manifest":        record,
+		"signing_payload": base64.StdEncoding.EncodeToString(services.SigningPayload(record)),
+	})
+}
+
+// SignManifest attaches a client-side Ed25519 signature to a zero-knowledge manifest
+func (b *BackupController) SignManifest(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	record, err := b.findManifest(c, user)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "manifest not found"})
+	}
+	if record.SignerType != models.ManifestSignerUser {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "manifest is signed by the server"})
 	}
 
-	backupType := c.QueryParam("type")
-	config, err := b.getBackupConfig(backupType)
+	var req struct {
+		Signature string `json:"signature"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	signature, err := base64.StdEncoding.DecodeString(req.Signature)
 	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "signature must be base64"})
+	}
+
+	if err := b.manifests.AttachUserSignature(c.Request().Context(), record, signature); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
+	return c.JSON(http.StatusOK, record)
+}
 
-	result, err := b.processBackup(c.Request().Context(), user, config)
+// VerifyManifest checks the manifest signature and the hash of every object it lists
+func (b *BackupController) VerifyManifest(c echo.Context) error {
+	user, err := b.validateUser(c)
 	if err != nil {
-		logrus.WithError(err).Error("Backup failed")
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "backup failed"})
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 	}
 
-	return c.JSON(http.StatusOK, result)
+	record, err := b.findManifest(c, user)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "manifest not found"})
+	}
+
+	manifest, err := b.openManifest(c.Request().Context(), record)
+	if err != nil {
+		logrus.WithError(err).Warn("Manifest verification failed")
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{"valid": false, "error": err.Error()})
+	}
+
+	checks, ok := b.manifests.VerifyObjects(manifest, func(objectID string) (io.ReadCloser, error) {
+		return b.Storage.Download(objectID)
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"valid":   ok,
+		"objects": checks,
+	})
 }
 
-func (b *BackupController) getBackupConfig(backupType string) (*BackupConfig, error) {
-	switch backupType {
-	case "gallery":
-		return &BackupConfig{
-			AllowedExtensions: []string{".jpg", ".jpeg", ".png", ".gif"},
-			MaxFileSize:       maxFileSize,
-			BasePath:          "gallery",
-		}, nil
-	case "documents":
-		return &BackupConfig{
-			AllowedExtensions: []string{".pdf", ".doc", ".docx", ".txt"},
-			MaxFileSize:       maxFileSize,
-			BasePath:          "documents",
-		}, nil
-	default:
-		return nil, fmt.Errorf("unsupported backup type: %s", backupType)
+// RegisterSigningKey enables zero-knowledge manifest signing with the user's Ed25519 public key
+func (b *BackupController) RegisterSigningKey(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	var req struct {
+		PublicKey string `json:"public_key"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(req.PublicKey)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "public key must be base64"})
+	}
+
+	if err := b.manifests.RegisterSigningKey(c.Request().Context(), user.ID, publicKey); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "signing key registered"})
 }
 
-func (b *BackupController) processBackup(ctx context.Context, user *models.OAuthUser, config *BackupConfig) (*BackupResult, error) {
-	basePath := config.BasePath
-	destDir := filepath.Join("backups", basePath)
+func (b *BackupController) findManifest(c echo.Context, user *models.OAuthUser) (*models.BackupManifest, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return b.manifests.Find(c.Request().Context(), user.ID, uint(id))
+}
 
-	// Valida o caminho base
-	if err := validatePath(basePath); err != nil {
-		return nil, fmt.Errorf("invalid base path: %w", err)
+// openManifest downloads and verifies a manifest body
+func (b *BackupController) openManifest(ctx context.Context, record *models.BackupManifest) (*services.Manifest, error) {
+	sealed, err := b.Storage.Download(record.ObjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download manifest: %w", err)
 	}
+	defer sealed.Close()
 
-	// Realiza o backup do diretório
-	result := backupDirectory(ctx, basePath, destDir, b.Storage, false, 10)
-	if result.Error != nil {
-		return nil, result.Error
+	return b.manifests.Open(ctx, record, sealed)
+}
+
+// getBackupConfig resolves what a run backs up: a profile of the user when
+// profileID is set, otherwise the built-in template with the given name
+func (b *BackupController) getBackupConfig(ctx context.Context, userID uint, profileID *uint, template string) (*BackupConfig, error) {
+	var (
+		profile *models.BackupProfile
+		err     error
+	)
+	if profileID != nil {
+		profile, err = b.profiles.Find(ctx, userID, *profileID)
+	} else {
+		profile, err = b.profiles.Template(template)
 	}
+	if err != nil {
+		return nil, err
+	}
+	return newBackupConfig(profile), nil
+}
+
+// newBackupConfig converts a stored profile into the settings of a run
+func newBackupConfig(profile *models.BackupProfile) *BackupConfig {
+	return &BackupConfig{
+		Name:       profile.Name,
+		Sources:    profile.Sources,
+		AppSources: profile.AppSources,
+		Walk: utils.WalkOptions{
+			Include:        profile.Include,
+			Exclude:        profile.Exclude,
+			MaxFileSize:    profile.MaxFileSize,
+			FollowSymlinks: profile.FollowSymlinks,
+			OneFileSystem:  profile.OneFileSystem,
+		},
+		Compression: utils.CompressionOptions{
+			Algorithm: utils.CompressionAlgorithm(profile.Compression),
+			Level:     profile.CompressionLevel,
+		},
+		Format:    ArchiveFormat(profile.Format),
+		PreHooks:  profile.PreHooks,
+		PostHooks: profile.PostHooks,
+	}
+}
+
+// openSources places each source of a run in the snapshot. Directories are
+// read in place under the user's backup source root; the other sources first write
+// their copy under staging. A single directory is stored at the top of the
+// snapshot; with several sources, each directory gets one named after its
+// path, while the other sources are always stored at the top as their file.
+func (b *BackupController) openSources(ctx context.Context, userID uint, config *BackupConfig, destDir, staging string, env []string) ([]backupSource, error) {
+	root := backupSourceRoot(userID)
+	// Links seguidos não podem sair do diretório do usuário
+	walk := config.Walk
+	walk.Within = root
+	multiple := len(config.Sources)+len(config.AppSources) > 1
+	sources := make([]backupSource, 0, len(config.Sources)+len(config.AppSources))
+	for _, source := range config.Sources {
+		dir, err := utils.ResolveSource(root, source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source %s: %w", source, err)
+		}
+		if err := validatePath(dir); err != nil {
+			return nil, fmt.Errorf("invalid source %s: %w", source, err)
+		}
 
-	// Cria registros de backup no banco de dados
-	for _, filePath := range result.FailedFiles {
-		if err := b.createBackupRecord(ctx, user, basePath, filePath); err != nil {
-			logrus.WithFields(logrus.Fields{
-				"file":  filePath,
-				"error": err,
-			}).Error("Failed to create backup record for failed file")
+		dest := destDir
+		if multiple {
+			dest = filepath.Join(destDir, filepath.FromSlash(source))
 		}
+		sources = append(sources, backupSource{Name: source, Root: dir, Dest: dest, Walk: walk})
 	}
 
-	for i := 0; i < result.SuccessCount; i++ {
-		filePath := fmt.Sprintf("successful_backup_%d", i)
-		if err := b.createBackupRecord(ctx, user, basePath, filePath); err != nil {
-			logrus.WithFields(logrus.Fields{
-				"file":  filePath,
-				"error": err,
-			}).Error("Failed to create backup record for successful file")
+	for _, spec := range config.AppSources {
+		source, err := b.appSource(ctx, spec, root, env)
+		if err != nil {
+			return nil, err
+		}
+		// Cada origem escreve em um diretório próprio, com o nome do arquivo que gera
+		dir := filepath.Join(staging, spec.File)
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			return nil, err
+		}
+		started := time.Now()
+		opened, err := source.Open(ctx, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s source %s: %w", spec.Kind, spec.File, err)
 		}
+		logrus.WithFields(logrus.Fields{"kind": spec.Kind, "file": spec.File, "duration": time.Since(started).Round(time.Millisecond)}).Info("Backup source ready")
+		// Os padrões de inclusão e exclusão valem só para diretórios
+		sources = append(sources, backupSource{Root: opened, Dest: destDir})
 	}
+	return sources, nil
+}
 
+// appSource builds the source that copies a SQLite database or bundles a git
+// repository found inside the user's source root, or runs an approved command
+func (b *BackupController) appSource(ctx context.Context, spec models.ProfileSource, root string, env []string) (utils.BackupSource, error) {
+	timeout := time.Duration(spec.TimeoutSeconds) * time.Second
+	switch spec.Kind {
+	case models.SourceKindSQLite, models.SourceKindGit:
+		p, err := utils.ResolveSource(root, spec.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s source %s: %w", spec.Kind, spec.File, err)
+		}
+		if spec.Kind == models.SourceKindSQLite {
+			return utils.SQLiteSource{Path: p, File: spec.File}, nil
+		}
+		return utils.GitSource{Repo: p, File: spec.File, Timeout: timeout}, nil
+	case models.SourceKindCommand:
+		// O comando é procurado de novo para que um comando revogado não rode
+		command, err := b.hooks.Command(ctx, spec.Hook(), env)
+		if err != nil {
+			return nil, fmt.Errorf("command source %s: %w", spec.File, err)
+		}
+		return utils.CommandSource{Command: command, File: spec.File}, nil
+	default:
+		return nil, fmt.Errorf("unsupported source kind %q", spec.Kind)
+	}
+}
+
+// backupSourceRoot is the directory holding the sources of a user. Each user
+// only reaches their own directory below BACKUP_SOURCE_ROOT.
+func backupSourceRoot(userID uint) string {
+	base := os.Getenv("BACKUP_SOURCE_ROOT")
+	if base == "" {
+		base = "sources"
+	}
+	return utils.UserSourceRoot(base, userID)
+}
+
+func (b *BackupController) processBackup(ctx context.Context, run backupRun, config *BackupConfig) (*BackupResult, error) {
+	run.Started = time.Now()
+	destDir := filepath.Join("backups", config.Name)
+	env := hookEnv(run, config)
+
+	// Os hooks "pre" preparam a origem; se um deles abortar, nada é copiado
+	hooks, err := b.hooks.Run(ctx, models.HookPhasePre, config.PreHooks, env)
+	status := "aborted"
+	var result BackupResult
+	if err == nil {
+		result, err = b.backupSources(ctx, run, config, destDir)
+		status = "success"
+		if err != nil {
+			status = "failed"
+		}
+	}
+
+	// Os hooks "post" rodam mesmo quando o backup falha ou o job é pausado
+	env = append(env, "SAFEBOX_STATUS="+status, "SAFEBOX_SNAPSHOT_ID="+strconv.FormatUint(uint64(result.ManifestID), 10))
+	post, postErr := b.hooks.Run(context.WithoutCancel(ctx), models.HookPhasePost, config.PostHooks, env)
+	result.Hooks = append(hooks, post...)
+	logHooks(run, config.Name, result.Hooks)
+	// Uma falha depois do backup não desfaz o snapshot, mas a execução conta como falha
+	if err == nil {
+		err = postErr
+	}
+
+	// Execuções que falham também ficam no histórico, com os arquivos já processados
+	result.Error = err
+	b.recordBackupRun(ctx, run, config.Name, destDir, &result)
+	if err != nil {
+		return nil, err
+	}
 	return &result, nil
 }
 
+// backupSources copies the sources of a run and stores its manifest
+func (b *BackupController) backupSources(ctx context.Context, run backupRun, config *BackupConfig, destDir string) (BackupResult, error) {
+	var (
+		result   BackupResult
+		parentID *uint
+	)
+
+	// As origens que não são diretórios gravam suas cópias em um diretório temporário
+	staging := ""
+	if len(config.AppSources) > 0 {
+		dir, err := os.MkdirTemp("", "safebox-sources-")
+		if err != nil {
+			return result, err
+		}
+		defer os.RemoveAll(dir)
+		staging = dir
+	}
+	sources, err := b.openSources(ctx, run.UserID, config, destDir, staging, hookEnv(run, config))
+	if err != nil {
+		return result, err
+	}
+	selected, err := scanSources(ctx, sources, run.Progress)
+	if err != nil {
+		return result, err
+	}
+	// Nada é enviado se a execução passar do limite do plano
+	if run.MaxSize > 0 && selected > run.MaxSize {
+		return result, fmt.Errorf("%w: %d bytes selected, at most %d", services.ErrRunSizeLimit, selected, run.MaxSize)
+	}
+
+	// Realiza o backup do diretório no formato escolhido
+	switch config.Format {
+	case FormatTarZstd:
+		// Um arquivo tar por origem
+		for _, source := range sources {
+			part := b.backupArchive(ctx, run.UserID, config, source, run.Progress)
+			if part.Error != nil {
+				result.Error = part.Error
+				break
+			}
+			result.SuccessCount += part.SuccessCount
+			result.UploadedCount += part.SuccessCount
+			result.Files = append(result.Files, part.Files...)
+			result.entries = append(result.entries, part.entries...)
+		}
+	default:
+		// Compara com o último manifesto e envia apenas o que mudou
+		var previous map[string]services.ManifestEntry
+		parentID, previous = b.previousEntries(ctx, run.UserID, config.Name)
+		// Um job retomado reaproveita os arquivos que já concluiu, se os chunks ainda existem
+		resumed, err := b.checkpointEntries(ctx, run.UserID, run.Progress.Resumed())
+		if err != nil {
+			return result, fmt.Errorf("failed to check checkpointed chunks: %w", err)
+		}
+		result = b.backupDirectory(ctx, run.UserID, config, sources, withCheckpoint(previous, resumed), 10, run.Progress)
+		result.DeletedFiles = deletedFromParent(result.DeletedFiles, previous)
+	}
+	if result.Error != nil {
+		return result, result.Error
+	}
+	if parentID != nil {
+		result.ParentManifestID = *parentID
+	}
+
+	// Registra o manifesto assinado que liga todos os objetos deste backup
+	manifestID, err := b.storeManifest(ctx, run, config.Name, parentID, result.entries, result.DeletedFiles)
+	if err != nil {
+		return result, fmt.Errorf("failed to store backup manifest: %w", err)
+	}
+	result.ManifestID = manifestID
+	return result, nil
+}
+
+// hookEnv describes a run to its hooks
+func hookEnv(run backupRun, config *BackupConfig) []string {
+	return []string{
+		"SAFEBOX_PROFILE=" + config.Name,
+		"SAFEBOX_MODE=" + run.Mode,
+		"SAFEBOX_SOURCE_ROOT=" + backupSourceRoot(run.UserID),
+		"SAFEBOX_JOB_ID=" + strconv.FormatUint(uint64(run.Progress.JobID()), 10),
+	}
+}
+
+// logHooks writes the outcome of the hooks of a run to the server log; the
+// full output is kept in the run history
+func logHooks(run backupRun, source string, hooks []models.HookResult) {
+	for _, hook := range hooks {
+		entry := logrus.WithFields(logrus.Fields{
+			"user_id":     run.UserID,
+			"source":      source,
+			"phase":       hook.Phase,
+			"command":     hook.Command,
+			"exit_code":   hook.ExitCode,
+			"duration_ms": hook.DurationMs,
+		})
+		if hook.Error != "" {
+			entry.WithField("output", hook.Output).Warnf("Backup hook failed: %s", hook.Error)
+		} else {
+			entry.Info("Backup hook finished")
+		}
+	}
+}
+
 func (b *BackupController) validateUser(c echo.Context) (*models.OAuthUser, error) {
 	user, ok := c.Get("user").(*models.OAuthUser)
 	if !ok {
@@ -145,6 +583,19 @@ func (b *BackupController) validateUser(c echo.Context) (*models.OAuthUser, erro
 	return user, nil
 }
 
+// limitExceeded answers a request refused by a backup limit of the plan.
+// When the daily limit is the cause, Retry-After says when the window restarts.
+func limitExceeded(c echo.Context, allowance *services.BackupAllowance, err error) error {
+	if errors.Is(err, services.ErrDailyBackupLimit) {
+		retry := int64(time.Until(allowance.ResetAt).Seconds()) + 1
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+	}
+	return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+		"error":     err.Error(),
+		"allowance": allowance,
+	})
+}
+
 // validatePath checks if the given path is valid and accessible
 func validatePath(path string) error {
 	if path == "" {
@@ -161,167 +612,253 @@ func validatePath(path string) error {
 	return nil
 }
 
-// compressAndEncrypt compresses and encrypts a file
-func compressAndEncrypt(filePath string) ([]byte, string, error) {
-	compressedFile, err := utils.Compress(filePath)
+// processAndUpload backs up a single file.
+// destPath is the logical location. The content is split into
+// content-defined chunks and only the chunks the user does not have yet are
+// uploaded, so a small edit in a large file uploads little more than the
+// changed bytes. When prev is the entry of the same path in the previous
+// manifest, the file is only read if its size or mtime changed and only
+// stored if its content changed; otherwise prev's chunks are reused.
+// The file is hashed and chunked as a stream, so memory use does not depend
+// on the file size.
+// It returns the manifest entry of the file and whether new chunks were uploaded.
+func (b *BackupController) processAndUpload(ctx context.Context, userID uint, filePath, destPath string, compression utils.CompressionOptions, prev *services.ManifestEntry) (*services.ManifestEntry, bool, error) {
+	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, "", fmt.Errorf("compression failed: %w", err)
+		return nil, false, fmt.Errorf("failed to open file: %w", err)
 	}
+	defer file.Close()
 
-	encryptionKey, err := utils.GenerateEncryptionKey()
-	if err != nil {
-		return nil, "", fmt.Errorf("encryption key generation failed: %w", err)
-	}
-	var encryptedBuffer bytes.Buffer
-	err = utils.EncryptStream(bytes.NewReader(compressedFile), &encryptedBuffer, encryptionKey)
+	info, err := file.Stat()
 	if err != nil {
-		return nil, "", fmt.Errorf("encryption failed: %w", err)
+		return nil, false, fmt.Errorf("failed to stat file: %w", err)
 	}
-	return encryptedBuffer.Bytes(), string(encryptionKey), nil
-}
+	modTime := info.ModTime().UTC()
 
-// processAndUpload processes a single file for backup
-func processAndUpload(ctx context.Context, filePath, destPath string, storage storage.Storage, replace bool) error {
-	encryptedFile, encryptionKey, err := compressAndEncrypt(filePath)
-	if err != nil {
-		return err
-	}
+	if prev != nil {
+		if prev.Size == info.Size() && prev.ModTime.Equal(modTime) {
+			return prev, false, nil
+		}
 
-	if !replace {
-		exists, err := storage.Exists(destPath)
+		// Tamanho ou data mudaram: confere o conteúdo antes de enviar
+		sum, err := hashFile(file)
 		if err != nil {
-			return fmt.Errorf("failed to check if file exists: %w", err)
+			return nil, false, fmt.Errorf("failed to hash file: %w", err)
 		}
-		if exists {
-			logrus.WithFields(logrus.Fields{
-				"file": destPath,
-			}).Warn("The file already exists. Skipping upload.")
-			return nil
+		if sum == prev.SHA256 {
+			entry := *prev
+			entry.ModTime = modTime
+			return &entry, false, nil
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, false, fmt.Errorf("failed to rewind file: %w", err)
 		}
 	}
 
-	_, err = storage.Upload(bytes.NewReader(encryptedFile), destPath)
+	// Pipeline: arquivo -> hash do conteúdo -> chunks -> compressão -> criptografia -> upload
+	// O codec de cada chunk é escolhido pelo conteúdo e gravado no cabeçalho do objeto
+	contentHash := sha256.New()
+	chunks, stats, err := b.chunks.Store(ctx, userID, io.TeeReader(file, contentHash), compression, recordCompression)
 	if err != nil {
-		return fmt.Errorf("upload failed: %w", err)
+		return nil, false, err
 	}
+	chunkUploads.Add(float64(stats.NewChunks))
+	chunkReuses.Add(float64(stats.Chunks - stats.NewChunks))
+
+	return &services.ManifestEntry{
+		Path:        destPath,
+		Size:        info.Size(),
+		SHA256:      hex.EncodeToString(contentHash.Sum(nil)),
+		Compression: compression.Algorithm,
+		Chunks:      chunks,
+		ModTime:     modTime,
+	}, stats.NewChunks > 0, nil
+}
 
-	err = storeEncryptionKey(ctx, encryptionKey, destPath)
+// storeManifest signs and uploads the manifest of a backup run
+func (b *BackupController) storeManifest(ctx context.Context, run backupRun, appName string, parentID *uint, entries []services.ManifestEntry, deleted []string) (uint, error) {
+	record, sealed, err := b.manifests.Build(ctx, run.UserID, appName, parentID, entries, deleted)
 	if err != nil {
-		return fmt.Errorf("failed to store encryption key: %w", err)
+		return 0, err
 	}
+	record.ScheduleID = run.ScheduleID
+	record.Mode = run.Mode
 
-	return nil
+	if _, err := b.Storage.Upload(bytes.NewReader(sealed), record.ObjectID); err != nil {
+		return 0, fmt.Errorf("upload failed: %w", err)
+	}
+
+	// Os chunks são referenciados antes do manifesto existir: uma falha aqui
+	// deixa referências a mais, nunca um snapshot com chunks sem referência
+	var chunks []services.ChunkRef
+	for _, entry := range entries {
+		chunks = append(chunks, entry.Chunks...)
+	}
+	if err := b.chunks.Retain(ctx, run.UserID, chunks); err != nil {
+		return 0, fmt.Errorf("failed to reference chunks: %w", err)
+	}
+	if err := b.manifests.Save(ctx, record); err != nil {
+		if releaseErr := b.chunks.Release(ctx, run.UserID, chunks); releaseErr != nil {
+			logrus.WithError(releaseErr).Warn("Failed to release chunks of unsaved manifest")
+		}
+		return 0, err
+	}
+	return record.ID, nil
 }
 
-// backupDirectory backups a directory, processing files concurrently
-func backupDirectory(ctx context.Context, basePath, destDir string, storage storage.Storage, replace bool, maxWorkers int) BackupResult {
+// backupDirectory backups the source directories of a profile, processing
+// files concurrently. previous holds the entries of the last manifest by
+// logical path; files that did not change reuse their objects, and paths that
+// disappeared or are now filtered out are reported as deleted. A file that
+// fails keeps its previous entry so the new manifest still describes a
+// complete snapshot.
+func (b *BackupController) backupDirectory(ctx context.Context, userID uint, config *BackupConfig, sources []backupSource, previous map[string]services.ManifestEntry, maxWorkers int, progress *services.JobProgress) BackupResult {
 	var (
-		wg            sync.WaitGroup
-		mu            sync.Mutex
-		failedFiles   = make(chan string, 100)
-		expectedCount = 0
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		result BackupResult
+		seen   = map[string]bool{}
+		err    error
 	)
 
 	sem := semaphore.NewWeighted(int64(maxWorkers))
 
-	err := filepath.Walk(basePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return fmt.Errorf("walk error at %s: %w", path, err)
-		}
-		if info.IsDir() {
-			return nil
-		}
-
-		if ctx.Err() != nil {
-			return ctx.Err()
-		}
-
-		if err := sem.Acquire(ctx, 1); err != nil {
-			return err
-		}
-
-		wg.Add(1)
-		go func(filePath string) {
-			defer wg.Done()
-			defer sem.Release(1)
+	for _, source := range sources {
+		dest := source.Dest
+		err = utils.WalkSource(source.Root, source.Walk, func(filePath, rel string, info os.FileInfo) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 
-			relPath, err := filepath.Rel(basePath, filePath)
-			if err != nil {
-				failedFiles <- filePath
-				return
+			if err := sem.Acquire(ctx, 1); err != nil {
+				return err
 			}
 
-			destPath := filepath.Join(destDir, relPath)
-			if err := processAndUpload(ctx, filePath, destPath, storage, replace); err != nil {
-				failedFiles <- filePath
-			} else {
-				mu.Lock()
-				expectedCount++
-				mu.Unlock()
+			destPath := filepath.Join(dest, filepath.FromSlash(rel))
+			var prev *services.ManifestEntry
+			if entry, ok := previous[destPath]; ok {
+				prev = &entry
 			}
-		}(path)
-		return nil
-	})
 
-	go func() {
-		wg.Wait()
-		close(failedFiles)
-	}()
+			mu.Lock()
+			seen[destPath] = true
+			mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer sem.Release(1)
+
+				progress.Started(destPath)
+				entry, uploaded, err := b.processAndUpload(ctx, userID, filePath, destPath, config.Compression, prev)
+				if err != nil {
+					progress.Failed(destPath, info.Size(), err)
+				} else {
+					progress.Done(info.Size())
+					progress.Checkpoint(*entry)
+				}
+
+				file := BackupFileResult{
+					Path:       destPath,
+					SourcePath: path.Join(source.Name, rel),
+					Size:       info.Size(),
+				}
 
-	var failed []string
-	for f := range failedFiles {
-		failed = append(failed, f)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					file.Status = models.ItemStatusFailed
+					file.Error = err.Error()
+					result.FailedCount++
+					result.Files = append(result.Files, file)
+					if prev != nil {
+						result.entries = append(result.entries, *prev)
+					}
+					return
+				}
+
+				result.SuccessCount++
+				if uploaded {
+					result.UploadedCount++
+				} else {
+					result.UnchangedCount++
+				}
+				result.Files = append(result.Files, file.stored(*entry, uploaded))
+				result.entries = append(result.entries, *entry)
+			}()
+			return nil
+		}, func(rel, reason string) {
+			mu.Lock()
+			defer mu.Unlock()
+			result.SkippedCount++
+			result.Files = append(result.Files, BackupFileResult{
+				Path:       filepath.Join(dest, filepath.FromSlash(rel)),
+				SourcePath: path.Join(source.Name, rel),
+				Status:     models.ItemStatusSkipped,
+				Error:      reason,
+			})
+		})
+		if err != nil {
+			break
+		}
 	}
 
-	return BackupResult{
-		SuccessCount: expectedCount,
-		FailedFiles:  failed,
-		Error:        err,
+	wg.Wait()
+	result.Error = err
+
+	if err == nil {
+		for destPath := range previous {
+			if !seen[destPath] {
+				result.DeletedFiles = append(result.DeletedFiles, destPath)
+			}
+		}
 	}
+	return result
 }
 
-// createBackupRecord creates a backup record in the database
-func (b *BackupController) createBackupRecord(ctx context.Context, user *models.OAuthUser, appName, filePath string) error {
-	backup := models.Backup{
-		UserID:   user.ID,
-		AppName:  appName,
-		FilePath: filePath,
+// RunScheduled executes the backup, or the verification, of a schedule fired by the scheduler
+func (b *BackupController) RunScheduled(ctx context.Context, schedule *models.BackupSchedule) error {
+	if schedule.Action == models.ScheduleActionVerify {
+		return b.verifyScheduled(ctx, schedule)
 	}
 
-	backupHistory := models.BackupHistory{
-		UserID:     user.ID,
-		AppName:    appName,
-		BackupDate: time.Now(),
-		BackupMode: "manual",
-		FilePath:   filePath,
+	config, err := b.getBackupConfig(ctx, schedule.UserID, schedule.ProfileID, schedule.BackupType)
+	if err != nil {
+		return err
 	}
-
-	tx := repositories.DBConnection.WithContext(ctx).Begin()
-	if err := tx.Create(&backup).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to create backup record: %w", err)
+	if schedule.Format != "" {
+		if config.Format, err = parseArchiveFormat(schedule.Format); err != nil {
+			return err
+		}
 	}
 
-	if err := tx.Create(&backupHistory).Error; err != nil {
-		tx.Rollback()
-		return fmt.Errorf("failed to create backup history: %w", err)
+	// Execuções agendadas seguem os mesmos limites do plano, conferidos ao criar o job
+	allowance, err := b.limits.UserAllowance(ctx, schedule.UserID)
+	if err != nil {
+		return err
 	}
 
-	return tx.Commit().Error
-}
-
-// storeEncryptionKey stores the encryption key securely in the database
-func storeEncryptionKey(ctx context.Context, key, filePath string) error {
-	encryptionKeyRecord := models.EncryptionKey{
-		FilePath:  filePath,
-		Key:       key,
-		CreatedAt: time.Now(),
+	scheduleID := schedule.ID
+	job := &models.BackupJob{
+		UserID:     schedule.UserID,
+		Source:     config.Name,
+		ProfileID:  schedule.ProfileID,
+		BackupType: schedule.BackupType,
+		Format:     string(config.Format),
+		Mode:       models.BackupModeScheduled,
+		ScheduleID: &scheduleID,
 	}
-
-	// Salva a chave no banco de dados
-	if err := repositories.DBConnection.WithContext(ctx).Create(&encryptionKeyRecord).Error; err != nil {
-		return fmt.Errorf("failed to store encryption key: %w", err)
+	status, err := b.jobs.Run(ctx, job, b.jobRunner(backupRun{
+		UserID:     schedule.UserID,
+		Mode:       models.BackupModeScheduled,
+		ScheduleID: &scheduleID,
+		MaxSize:    allowance.Limits.MaxRunSize,
+	}, config))
+	if err != nil {
+		return err
+	}
+	if status.FilesFailed > 0 {
+		return fmt.Errorf("%d files failed to back up", status.FilesFailed)
 	}
-
 	return nil
 }
@@ -0,0 +1,422 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/services"
+	"SafeBox/utils"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// maxAgentLookup bounds the chunk hashes an agent may look up in one request
+const maxAgentLookup = 1000
+
+// maxAgentHooks bounds the hook results a snapshot may carry
+const maxAgentHooks = 32
+
+// chunkEncodingOverhead is the most a client-compressed and encrypted chunk
+// may exceed its plain size by: incompressible data is stored as is, plus
+// the headers of the compression frame and of the cipher
+const chunkEncodingOverhead = 64 * 1024
+
+// The backup agent (cmd/safebox-agent) walks, hashes, chunks, compresses and
+// encrypts files on the client machine. The server only stores the encrypted
+// chunks it receives, wraps their keys with the user key and indexes the
+// snapshot the agent commits at the end of a run.
+
+// AgentRunRequest starts an agent run for a backup source. Mode is empty for
+// a regular run, which counts towards the daily backup limit, and "sync" for
+// continuous sync, which counts towards the daily sync limit.
+type AgentRunRequest struct {
+	Source string `json:"source"`
+	Mode   string `json:"mode,omitempty"`
+}
+
+// AgentRun is what an agent needs to back up a source incrementally: the ID
+// to commit the run with, the chunk sizes to cut files with and the files of
+// the latest snapshot, by their path in the snapshot
+type AgentRun struct {
+	ID           string                   `json:"id"`
+	ParentID     uint                     `json:"parent_id,omitempty"`
+	Entries      []services.ManifestEntry `json:"entries"`
+	MinChunkSize int                      `json:"min_chunk_size"`
+	AvgChunkSize int                      `json:"avg_chunk_size"`
+	MaxChunkSize int                      `json:"max_chunk_size"`
+	MaxRunSize   int64                    `json:"max_run_size"` // total dos arquivos do snapshot permitido pelo plano
+}
+
+// AgentSnapshotRequest commits the result of an agent run. RunID is the ID
+// StartAgentRun issued; each run commits one snapshot, in the mode it was
+// started with. Entries and Files use paths relative to the snapshot root;
+// every entry must be stored as chunks.
+type AgentSnapshotRequest struct {
+	RunID    string                   `json:"run_id"`
+	Source   string                   `json:"source"`
+	ParentID uint                     `json:"parent_id,omitempty"`
+	Entries  []services.ManifestEntry `json:"entries"`
+	Deleted  []string                 `json:"deleted"`
+	Files    []BackupFileResult       `json:"files"`
+	Hooks    []models.HookResult      `json:"hooks,omitempty"` // hooks que o agente rodou em volta da execução
+	// StartedAt is when the agent started the run, for its recorded duration
+	StartedAt time.Time `json:"started_at,omitempty"`
+}
+
+// StartAgentRun checks the backup limits of the user, issues the ID of the
+// run and returns the latest snapshot of the source, so the agent only reads
+// the files that changed
+func (b *BackupController) StartAgentRun(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	var req AgentRunRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	if err := validateAgentSource(req.Source); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	mode, err := agentMode(req.Mode)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	// Execuções do agente não são jobs do servidor; só os limites diários se aplicam
+	agentRun, allowance, err := b.limits.ReserveAgentRun(c.Request().Context(), user.ID, req.Source, mode)
+	if errors.Is(err, services.ErrDailyBackupLimit) || errors.Is(err, services.ErrSyncLimit) {
+		allowance.WriteHeaders(c.Response().Header())
+		return limitExceeded(c, allowance, err)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to reserve agent run")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	allowance.WriteHeaders(c.Response().Header())
+
+	opts := b.chunks.ChunkOptions()
+	run := AgentRun{
+		ID:           agentRun.ID,
+		Entries:      []services.ManifestEntry{},
+		MinChunkSize: opts.MinSize,
+		AvgChunkSize: opts.AvgSize,
+		MaxChunkSize: opts.MaxSize,
+		MaxRunSize:   allowance.Limits.MaxRunSize,
+	}
+	parentID, previous := b.previousEntries(c.Request().Context(), user.ID, req.Source)
+	if parentID != nil {
+		run.ParentID = *parentID
+	}
+	root := path.Join("backups", req.Source) + "/"
+	for _, entry := range previous {
+		// Só arquivos em chunks podem ser reaproveitados pelo agente
+		if !entry.Chunked() {
+			continue
+		}
+		entry.Path = strings.TrimPrefix(filepath.ToSlash(entry.Path), root)
+		run.Entries = append(run.Entries, entry)
+	}
+	return c.JSON(http.StatusOK, run)
+}
+
+// LookupAgentChunks returns which of the given chunk hashes the user already
+// has, so the agent only uploads the others
+func (b *BackupController) LookupAgentChunks(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	var req struct {
+		Hashes []string `json:"hashes"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	if len(req.Hashes) > maxAgentLookup {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("at most %d hashes per request", maxAgentLookup)})
+	}
+
+	found, err := b.chunks.Lookup(c.Request().Context(), user.ID, req.Hashes)
+	if errors.Is(err, services.ErrInvalidChunk) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to look up chunks")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"chunks": found})
+}
+
+// UploadAgentChunk stores one chunk compressed and encrypted by the agent.
+// The path carries the hex SHA-256 of the plain chunk; the X-Chunk-Size and
+// X-Chunk-Key headers carry its plain size and its base64 encryption key.
+// New chunks take storage quota as they arrive; an upload that does not fit
+// is answered with 403 before anything is stored.
+func (b *BackupController) UploadAgentChunk(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	size, err := strconv.ParseInt(c.Request().Header.Get("X-Chunk-Size"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid chunk size"})
+	}
+	key, err := base64.StdEncoding.DecodeString(c.Request().Header.Get("X-Chunk-Key"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "chunk key must be base64"})
+	}
+
+	limit := int64(b.chunks.ChunkOptions().MaxSize) + chunkEncodingOverhead
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, limit)
+	ref, created, err := b.chunks.Import(c.Request().Context(), user.ID, c.Param("hash"), size, key, body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "chunk too large"})
+	}
+	if errors.Is(err, services.ErrInvalidChunk) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if errors.Is(err, models.ErrStorageLimitExceeded) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to store agent chunk")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+
+	if created {
+		chunkUploads.Inc()
+		return c.JSON(http.StatusCreated, ref)
+	}
+	chunkReuses.Inc()
+	return c.JSON(http.StatusOK, ref)
+}
+
+// CommitAgentSnapshot records the snapshot of an agent run and its per-file
+// results. The run must have been started with StartAgentRun and not be
+// committed yet. Chunks the user does not have, for instance because the sweep
+// removed them while a run was paused for too long, are answered with 409
+// and listed so the agent can upload the affected files again.
+func (b *BackupController) CommitAgentSnapshot(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	var req AgentSnapshotRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	if err := validateAgentSource(req.Source); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	ctx := c.Request().Context()
+	// O modo vem da execução admitida pelo limite, não do pedido do agente
+	agentRun, err := b.limits.AgentRun(ctx, user.ID, req.RunID, req.Source)
+	if errors.Is(err, services.ErrUnknownAgentRun) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load agent run")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+
+	destDir := filepath.Join("backups", req.Source)
+	var (
+		chunks []services.ChunkRef
+		total  int64
+	)
+	seen := map[string]bool{}
+	for i := range req.Entries {
+		entry := &req.Entries[i]
+		p, err := agentSnapshotPath(entry.Path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if seen[p] {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("duplicate path %q", p)})
+		}
+		seen[p] = true
+		if !entry.Chunked() || entry.ArchivePath != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("entry %q must be stored as chunks", p)})
+		}
+
+		var size int64
+		for _, chunk := range entry.Chunks {
+			size += chunk.Size
+		}
+		if size != entry.Size {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("chunks of %q do not add up to its size", p)})
+		}
+		total += entry.Size
+		entry.Path = filepath.Join(destDir, filepath.FromSlash(p))
+		entry.KeyID = ""
+		entry.ObjectSHA256 = ""
+		chunks = append(chunks, entry.Chunks...)
+	}
+	if maxSize := models.ParsePlan(user.Plan).BackupLimits().MaxRunSize; total > maxSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+			"error": fmt.Sprintf("%v: %d bytes selected, at most %d", services.ErrRunSizeLimit, total, maxSize),
+		})
+	}
+	deleted := make([]string, 0, len(req.Deleted))
+	for _, p := range req.Deleted {
+		p, err := agentSnapshotPath(p)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		deleted = append(deleted, filepath.Join(destDir, filepath.FromSlash(p)))
+	}
+
+	missing, err := b.chunks.Missing(ctx, user.ID, chunks)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to check agent chunks")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	if len(missing) > 0 {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error":           "snapshot references unknown chunks",
+			"missing_objects": missing,
+		})
+	}
+
+	// O pai só é aceito se for um snapshot da mesma origem do usuário
+	var parentID *uint
+	if req.ParentID != 0 {
+		parent, err := b.manifests.Find(ctx, user.ID, req.ParentID)
+		if err == nil && parent.AppName == req.Source {
+			parentID = &parent.ID
+		}
+	}
+
+	result := BackupResult{DeletedFiles: deleted, entries: req.Entries}
+	if parentID != nil {
+		result.ParentManifestID = *parentID
+	}
+	for _, file := range req.Files {
+		p, err := agentSnapshotPath(file.Path)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		file.Path = filepath.Join(destDir, filepath.FromSlash(p))
+		if file.MovedFrom != "" {
+			from, err := agentSnapshotPath(file.MovedFrom)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+			file.MovedFrom = filepath.Join(destDir, filepath.FromSlash(from))
+		}
+		switch file.Status {
+		case models.ItemStatusOK:
+			result.SuccessCount++
+			if file.Uploaded {
+				result.UploadedCount++
+			} else {
+				result.UnchangedCount++
+			}
+		case models.ItemStatusFailed:
+			result.FailedCount++
+		case models.ItemStatusSkipped:
+			result.SkippedCount++
+		default:
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid status %q for %q", file.Status, p)})
+		}
+		result.Files = append(result.Files, file)
+	}
+
+	// Os hooks do agente rodam na máquina do usuário; o servidor só guarda a saída
+	if len(req.Hooks) > maxAgentHooks {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("at most %d hook results", maxAgentHooks)})
+	}
+	var hookErr error
+	for _, hook := range req.Hooks {
+		if hook.Phase != models.HookPhasePre && hook.Phase != models.HookPhasePost {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid hook phase %q", hook.Phase)})
+		}
+		if len(hook.Output) > utils.MaxHookOutput {
+			hook.Output = strings.ToValidUTF8(hook.Output[:utils.MaxHookOutput], "")
+			hook.Truncated = true
+		}
+		if hook.Aborted && hookErr == nil {
+			hookErr = fmt.Errorf("%w: %s hook %q: %s", services.ErrHookFailed, hook.Phase, hook.Command, hook.Error)
+		}
+		result.Hooks = append(result.Hooks, hook)
+	}
+
+	// Só um commit por execução, mesmo com pedidos repetidos em paralelo
+	err = b.limits.ConsumeAgentRun(ctx, agentRun)
+	if errors.Is(err, services.ErrUnknownAgentRun) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to consume agent run")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+
+	run := backupRun{UserID: user.ID, Mode: agentRun.Mode}
+	// O relógio do agente pode estar adiantado; um início no futuro é ignorado
+	if !req.StartedAt.IsZero() && req.StartedAt.Before(time.Now()) {
+		run.Started = req.StartedAt
+	}
+	manifestID, err := b.storeManifest(ctx, run, req.Source, parentID, req.Entries, deleted)
+	if err != nil {
+		result.Error = fmt.Errorf("failed to store backup manifest: %w", err)
+		b.recordBackupRun(ctx, run, req.Source, destDir, &result)
+		logrus.WithError(err).Error("Failed to store agent snapshot")
+		// O agente repete os pedidos que falharam no servidor com o mesmo ID
+		if err := b.limits.ReleaseAgentRun(context.WithoutCancel(ctx), agentRun); err != nil {
+			logrus.WithError(err).Error("Failed to release agent run")
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	result.ManifestID = manifestID
+	// Um hook "post" que falhou não desfaz o snapshot, mas a execução conta como falha
+	result.Error = hookErr
+	b.recordBackupRun(ctx, run, req.Source, destDir, &result)
+
+	return c.JSON(http.StatusCreated, result)
+}
+
+// agentMode maps the mode sent by the agent to the mode of the snapshot
+func agentMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return models.BackupModeAgent, nil
+	case models.BackupModeSync:
+		return models.BackupModeSync, nil
+	default:
+		return "", fmt.Errorf("invalid mode %q", mode)
+	}
+}
+
+// validateAgentSource checks that a source name can be used as a snapshot
+// directory and in the subject of notification e-mails
+func validateAgentSource(source string) error {
+	if source == "" || source == "." || source == ".." || strings.ContainsAny(source, "/\\") || strings.TrimSpace(source) != source || utils.ContainsControl(source) {
+		return fmt.Errorf("invalid source name %q", source)
+	}
+	return nil
+}
+
+// agentSnapshotPath validates a slash-separated path relative to the snapshot root
+func agentSnapshotPath(p string) (string, error) {
+	clean := path.Clean(p)
+	if p == "" || p == "." || clean != p || path.IsAbs(p) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(p, "\\") {
+		return "", fmt.Errorf("invalid snapshot path %q", p)
+	}
+	return clean, nil
+}
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/services"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Restores into the agent are requested like any other restore, with the
+// agent format. The server only records the request; the agent lists the open
+// ones, downloads each as a tar stream of files verified against the signed
+// manifest, writes them below its restore directory and reports the result.
+
+var (
+	errInvalidRestoreID = errors.New("invalid restore id")
+	errRestoreFinished  = errors.New("restore already finished")
+)
+
+// AgentRestoreReport is the outcome of a restore written by the agent. Error
+// is set when the restore could not be written at all.
+type AgentRestoreReport struct {
+	SuccessCount int    `json:"success_count"`
+	FailedCount  int    `json:"failed_count"`
+	Error        string `json:"error,omitempty"`
+}
+
+// requestAgentRestore records a restore for the agent to pull
+func (b *BackupController) requestAgentRestore(c echo.Context, user *models.OAuthUser, record *models.BackupManifest, req RestoreRequest) error {
+	target := req.Target
+	if target == "" {
+		target = time.Now().UTC().Format("20060102T150405Z")
+	}
+	if _, err := agentSnapshotPath(target); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid restore target"})
+	}
+
+	restore := &models.AgentRestore{
+		UserID:     user.ID,
+		ManifestID: record.ID,
+		Paths:      req.Paths,
+		Target:     target,
+		Status:     models.AgentRestorePending,
+	}
+	if err := b.backupRepo.CreateAgentRestore(c.Request().Context(), restore); err != nil {
+		logrus.WithError(err).Error("Failed to record agent restore")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	return c.JSON(http.StatusAccepted, restore)
+}
+
+// ListAgentRestores returns the restores the agent has not finished yet
+func (b *BackupController) ListAgentRestores(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	restores, err := b.backupRepo.OpenAgentRestores(c.Request().Context(), user.ID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list agent restores")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"restores": restores})
+}
+
+// DownloadAgentRestore streams the files of a restore as a tar archive ending
+// with the per-file report, like a tar download. Every selected object is
+// verified against the manifest before anything is sent.
+func (b *BackupController) DownloadAgentRestore(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	restore, err := b.findAgentRestore(c, user)
+	if err != nil {
+		return agentRestoreError(c, err)
+	}
+
+	ctx := c.Request().Context()
+	record, err := b.manifests.Find(ctx, user.ID, restore.ManifestID)
+	if err != nil {
+		b.failAgentRestore(c, restore, "snapshot not found")
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "manifest not found"})
+	}
+	manifest, err := b.openManifest(ctx, record)
+	if err != nil {
+		logrus.WithError(err).Warn("Refusing to restore from unverified manifest")
+		b.failAgentRestore(c, restore, err.Error())
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+
+	match := matchPaths(restore.Paths)
+	selected := selectEntries(manifest, match)
+	if len(selected) == 0 {
+		b.failAgentRestore(c, restore, "no files match the requested paths")
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "no files match the requested paths"})
+	}
+	checks, ok := b.manifests.VerifyObjects(&services.Manifest{Entries: selected}, func(objectID string) (io.ReadCloser, error) {
+		return b.Storage.Download(objectID)
+	})
+	if !ok {
+		b.failAgentRestore(c, restore, "backup objects failed verification")
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   "backup objects failed verification",
+			"objects": checks,
+		})
+	}
+
+	if err := b.backupRepo.StartAgentRestore(ctx, restore); err != nil {
+		logrus.WithError(err).Error("Failed to start agent restore")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	result := &RestoreResult{ManifestID: record.ID, Format: RestoreTar}
+	members := &archiveMembers{manifest: manifest, match: match}
+	if err := b.restoreDownload(c, user, record, selected, members, result); err != nil {
+		// A resposta já começou; o agente vê o tar incompleto e pode baixar de novo
+		logrus.WithError(err).Error("Agent restore download failed")
+	}
+	return nil
+}
+
+// ReportAgentRestore finishes a restore with the result the agent wrote and
+// records it in the backup history
+func (b *BackupController) ReportAgentRestore(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	restore, err := b.findAgentRestore(c, user)
+	if err != nil {
+		return agentRestoreError(c, err)
+	}
+
+	var report AgentRestoreReport
+	if err := c.Bind(&report); err != nil || report.SuccessCount < 0 || report.FailedCount < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+
+	restore.Status = models.AgentRestoreDone
+	restore.SuccessCount = report.SuccessCount
+	restore.FailedCount = report.FailedCount
+	restore.Error = report.Error
+	if report.Error != "" {
+		restore.Status = models.AgentRestoreFailed
+	}
+	ctx := c.Request().Context()
+	finished, err := b.backupRepo.FinishAgentRestore(ctx, restore)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to finish agent restore")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	if !finished {
+		return c.JSON(http.StatusConflict, map[string]string{"error": errRestoreFinished.Error()})
+	}
+
+	// Só restaurações que chegaram a gravar arquivos entram no histórico
+	if restore.Status == models.AgentRestoreDone {
+		record, err := b.manifests.Find(ctx, user.ID, restore.ManifestID)
+		if err == nil {
+			result := &RestoreResult{
+				ManifestID:   record.ID,
+				Format:       RestoreAgent,
+				Target:       restore.Target,
+				SuccessCount: restore.SuccessCount,
+				FailedCount:  restore.FailedCount,
+			}
+			err = b.recordRestore(user, record, result)
+		}
+		if err != nil {
+			logrus.WithError(err).Error("Failed to record restore history")
+		}
+	}
+	return c.JSON(http.StatusOK, restore)
+}
+
+// findAgentRestore loads the restore named in the path while it is open
+func (b *BackupController) findAgentRestore(c echo.Context, user *models.OAuthUser) (*models.AgentRestore, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, errInvalidRestoreID
+	}
+	restore, err := b.backupRepo.FindAgentRestore(c.Request().Context(), user.ID, uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if !restore.Open() {
+		return nil, errRestoreFinished
+	}
+	return restore, nil
+}
+
+// agentRestoreError answers the errors of findAgentRestore
+func agentRestoreError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errInvalidRestoreID):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "restore not found"})
+	case errors.Is(err, errRestoreFinished):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		logrus.WithError(err).Error("Failed to load agent restore")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+}
+
+// failAgentRestore finishes a restore the server cannot send
+func (b *BackupController) failAgentRestore(c echo.Context, restore *models.AgentRestore, reason string) {
+	restore.Status = models.AgentRestoreFailed
+	restore.Error = reason
+	if _, err := b.backupRepo.FinishAgentRestore(c.Request().Context(), restore); err != nil {
+		logrus.WithError(err).Error("Failed to finish agent restore")
+	}
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"SafeBox/services"
+	"SafeBox/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+func parseArchiveFormat(format string) (ArchiveFormat, error) {
+	switch ArchiveFormat(format) {
+	case FormatFiles, FormatTarZstd:
+		return ArchiveFormat(format), nil
+	default:
+		return "", fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// backupArchive streams a whole source directory as a single tar+zstd object.
+// Every regular file still gets its own manifest entry pointing into the archive.
+func (b *BackupController) backupArchive(ctx context.Context, userID uint, config *BackupConfig, source backupSource, progress *services.JobProgress) BackupResult {
+	var (
+		mu      sync.Mutex
+		entries []services.ManifestEntry
+	)
+
+	name := fmt.Sprintf("%s-%s.tar.zst", filepath.Base(source.Root), time.Now().UTC().Format("20060102T150405Z"))
+	obj, err := b.metadata.Register(ctx, userID, source.Dest, name, map[string]string{"format": string(FormatTarZstd)}, 0)
+	if err != nil {
+		return BackupResult{Error: fmt.Errorf("failed to register archive: %w", err)}
+	}
+
+	archive, stats, err := utils.TarArchiveStream(source.Root, utils.TarOptions{
+		Compression: config.Compression,
+		Walk:        source.Walk,
+		OnFile: func(entry utils.TarEntry) {
+			progress.Started(filepath.Join(source.Dest, filepath.FromSlash(entry.Path)))
+			progress.Done(entry.Size)
+
+			mu.Lock()
+			defer mu.Unlock()
+			entries = append(entries, services.ManifestEntry{
+				Path:        filepath.Join(source.Dest, filepath.FromSlash(entry.Path)),
+				ObjectID:    obj.ObjectID,
+				Size:        entry.Size,
+				SHA256:      entry.SHA256,
+				ArchivePath: entry.Path,
+				ArchiveLink: entry.Link,
+				ModTime:     entry.ModTime.UTC(),
+			})
+		},
+	})
+	if err != nil {
+		return BackupResult{Error: fmt.Errorf("failed to create archive: %w", err)}
+	}
+	defer archive.Close()
+
+	encryptionKey, err := utils.GenerateEncryptionKey()
+	if err != nil {
+		return BackupResult{Error: fmt.Errorf("encryption key generation failed: %w", err)}
+	}
+	encrypted, err := utils.EncryptReader(archive, encryptionKey)
+	if err != nil {
+		return BackupResult{Error: fmt.Errorf("encryption failed: %w", err)}
+	}
+
+	objectHash := sha256.New()
+	if _, err := b.Storage.Upload(io.TeeReader(encrypted, objectHash), obj.ObjectID); err != nil {
+		return BackupResult{Error: fmt.Errorf("upload failed: %w", err)}
+	}
+	recordCompression(stats)
+
+	keyID, err := b.keys.StoreFileKey(ctx, userID, obj.ObjectID, encryptionKey)
+	if err != nil {
+		return BackupResult{Error: fmt.Errorf("failed to store encryption key: %w", err)}
+	}
+
+	archiveHash := hex.EncodeToString(objectHash.Sum(nil))
+	files := make([]BackupFileResult, 0, len(entries))
+	for i := range entries {
+		entries[i].ObjectSHA256 = archiveHash
+		entries[i].KeyID = keyID
+		entries[i].Compression = stats.Options.Algorithm
+
+		file := BackupFileResult{
+			Path:       entries[i].Path,
+			SourcePath: path.Join(source.Name, entries[i].ArchivePath),
+			Size:       entries[i].Size,
+		}
+		files = append(files, file.stored(entries[i], true))
+	}
+
+	return BackupResult{
+		SuccessCount: len(entries),
+		Files:        files,
+		entries:      entries,
+	}
+}
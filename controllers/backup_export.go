@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/recovery"
+	"SafeBox/services"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// ExportSnapshot streams snapshot :id as a zip export that can be restored
+// with the user's recovery key by safebox-restore, without this server or
+// its database. The objects are included unless objects=reference is given,
+// in which case the export only lists them and they must be read from a
+// copy of the storage bucket.
+func (b *BackupController) ExportSnapshot(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	include := true
+	switch c.QueryParam("objects") {
+	case "", "include":
+	case "reference":
+		include = false
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "objects must be include or reference"})
+	}
+
+	record, err := b.findManifest(c, user)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "manifest not found"})
+	}
+
+	ctx := c.Request().Context()
+	userKey, err := b.keys.RecoveryKey(ctx, user.ID)
+	if errors.Is(err, services.ErrNoRecoveryKey) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "set up a recovery key before exporting"})
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load recovery key")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "export failed"})
+	}
+
+	manifest, err := b.openManifest(ctx, record)
+	if err != nil {
+		logrus.WithError(err).Warn("Refusing to export unverified manifest")
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+	header, err := b.exportHeader(ctx, record, manifest, userKey)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to build export")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "export failed"})
+	}
+	header.ObjectsIncluded = include
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "application/zip")
+	resp.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%d-export.zip", record.AppName, record.ID)))
+	resp.WriteHeader(http.StatusOK)
+
+	// Um erro depois do cabeçalho deixa o zip sem diretório central, e a
+	// exportação incompleta não abre
+	if err := b.writeExport(ctx, recovery.NewWriter(resp), header); err != nil {
+		logrus.WithError(err).WithField("manifest_id", record.ID).Error("Export failed")
+		return nil
+	}
+	logrus.WithFields(logrus.Fields{
+		"user_id":     user.ID,
+		"manifest_id": record.ID,
+		"objects":     len(header.Objects),
+		"included":    include,
+	}).Info("Snapshot exported")
+	return nil
+}
+
+// exportHeader lists every object of the snapshot, the manifest included,
+// with its key still wrapped. Objects whose key was destroyed are listed
+// without one and cannot be restored.
+func (b *BackupController) exportHeader(ctx context.Context, record *models.BackupManifest, manifest *services.Manifest, userKey *models.UserKey) (*recovery.Header, error) {
+	publicKey, err := b.manifests.SignerPublicKey(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	header := &recovery.Header{
+		UserID:            record.UserID,
+		SnapshotID:        record.ID,
+		AppName:           record.AppName,
+		SnapshotCreatedAt: record.CreatedAt,
+		ExportedAt:        time.Now().UTC(),
+		FileCount:         record.FileCount,
+		TotalSize:         record.TotalSize,
+		Manifest: recovery.ManifestInfo{
+			ObjectID:        record.ObjectID,
+			Digest:          record.Digest,
+			SignerType:      record.SignerType,
+			SignerKeyID:     record.SignerKeyID,
+			SignerPublicKey: publicKey,
+			Signature:       record.Signature,
+		},
+		RecoveryKeyID:  userKey.RecoveryKeyID,
+		WrappedRootKey: userKey.RecoveryWrappedKey,
+	}
+
+	// Arquivos tar e chunks repetidos compartilham objetos; cada um entra uma vez
+	listed := map[string]bool{}
+	add := func(objectID, objectSHA256 string) error {
+		if listed[objectID] {
+			return nil
+		}
+		listed[objectID] = true
+		wrapped, err := b.keys.WrappedFileKey(ctx, record.UserID, objectID)
+		if err != nil && !errors.Is(err, services.ErrKeyDestroyed) {
+			return err
+		}
+		header.Objects = append(header.Objects, recovery.Object{ID: objectID, SHA256: objectSHA256, WrappedKey: wrapped})
+		return nil
+	}
+	if err := add(record.ObjectID, ""); err != nil {
+		return nil, err
+	}
+	for _, entry := range manifest.Entries {
+		if !entry.Chunked() {
+			if err := add(entry.ObjectID, entry.ObjectSHA256); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, chunk := range entry.Chunks {
+			if err := add(chunk.ObjectID, chunk.ObjectSHA256); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return header, nil
+}
+
+// writeExport writes the header and, when they are included, the objects
+// that still have a key, checking each against its recorded hash
+func (b *BackupController) writeExport(ctx context.Context, w *recovery.Writer, header *recovery.Header) error {
+	if err := w.WriteHeader(header); err != nil {
+		return err
+	}
+	if !header.ObjectsIncluded {
+		return w.Close()
+	}
+	for _, object := range header.Objects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(object.WrappedKey) == 0 {
+			continue
+		}
+		if err := b.exportObject(w, object); err != nil {
+			return fmt.Errorf("object %s: %w", object.ID, err)
+		}
+	}
+	return w.Close()
+}
+
+func (b *BackupController) exportObject(w *recovery.Writer, object recovery.Object) error {
+	content, err := b.Storage.Download(object.ID)
+	if err != nil {
+		return fmt.Errorf("failed to download object: %w", err)
+	}
+	defer content.Close()
+
+	hash := sha256.New()
+	if _, err := w.WriteObject(object.ID, io.TeeReader(content, hash)); err != nil {
+		return err
+	}
+	if object.SHA256 != "" && hex.EncodeToString(hash.Sum(nil)) != object.SHA256 {
+		return errors.New("object hash mismatch")
+	}
+	return nil
+}
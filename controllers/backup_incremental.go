@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"SafeBox/services"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+)
+
+// previousEntries loads the last manifest of an app as the base of an
+// incremental backup. Entries stored inside tar archives cannot be reused per
+// file and are left out. When no usable manifest exists the run is a full backup.
+func (b *BackupController) previousEntries(ctx context.Context, userID uint, appName string) (*uint, map[string]services.ManifestEntry) {
+	record, err := b.manifests.Latest(ctx, userID, appName)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to look up previous manifest, running a full backup")
+		return nil, nil
+	}
+	if record == nil {
+		return nil, nil
+	}
+
+	manifest, err := b.openManifest(ctx, record)
+	if err != nil {
+		logrus.WithError(err).WithField("manifest", record.ID).Warn("Previous manifest failed verification, running a full backup")
+		return nil, nil
+	}
+
+	previous := make(map[string]services.ManifestEntry, len(manifest.Entries))
+	for _, entry := range manifest.Entries {
+		if entry.ArchivePath == "" {
+			previous[entry.Path] = entry
+		}
+	}
+	if len(previous) == 0 {
+		return nil, nil
+	}
+	return &record.ID, previous
+}
+
+// withCheckpoint overlays the files a resumed job already completed on the
+// entries of the previous manifest; processAndUpload then reuses their objects
+// as long as the files did not change since
+func withCheckpoint(previous, resumed map[string]services.ManifestEntry) map[string]services.ManifestEntry {
+	if len(resumed) == 0 {
+		return previous
+	}
+	merged := make(map[string]services.ManifestEntry, len(previous)+len(resumed))
+	for path, entry := range previous {
+		merged[path] = entry
+	}
+	for path, entry := range resumed {
+		merged[path] = entry
+	}
+	return merged
+}
+
+// checkpointEntries returns the files a resumed job already completed whose
+// chunks still exist. Checkpointed chunks are not retained until the manifest
+// is stored, so after a long pause the sweep may have removed them; those
+// files are processed again like any other.
+func (b *BackupController) checkpointEntries(ctx context.Context, userID uint, resumed map[string]services.ManifestEntry) (map[string]services.ManifestEntry, error) {
+	if len(resumed) == 0 {
+		return nil, nil
+	}
+	var refs []services.ChunkRef
+	for _, entry := range resumed {
+		refs = append(refs, entry.Chunks...)
+	}
+	missing, err := b.chunks.Reuse(ctx, userID, refs)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) == 0 {
+		return resumed, nil
+	}
+
+	gone := make(map[string]bool, len(missing))
+	for _, objectID := range missing {
+		gone[objectID] = true
+	}
+	kept := make(map[string]services.ManifestEntry, len(resumed))
+	for path, entry := range resumed {
+		if !slices.ContainsFunc(entry.Chunks, func(ref services.ChunkRef) bool { return gone[ref.ObjectID] }) {
+			kept[path] = entry
+		}
+	}
+	logrus.WithFields(logrus.Fields{"user_id": userID, "files": len(resumed) - len(kept)}).Warn("Checkpointed chunks were swept, backing up those files again")
+	return kept, nil
+}
+
+// deletedFromParent keeps only the deleted paths that exist in the parent
+// manifest, dropping files a resumed job checkpointed that are gone again
+func deletedFromParent(deleted []string, parent map[string]services.ManifestEntry) []string {
+	kept := deleted[:0]
+	for _, path := range deleted {
+		if _, ok := parent[path]; ok {
+			kept = append(kept, path)
+		}
+	}
+	return kept
+}
+
+// hashFile returns the hex SHA-256 of the rest of r
+func hashFile(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
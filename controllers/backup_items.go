@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/services"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const historyListLimit = 100
+
+// BackupFileResult is the outcome of backing up a single file
+type BackupFileResult struct {
+	Path       string `json:"path"`        // caminho no snapshot
+	SourcePath string `json:"source_path"` // caminho relativo à raiz de origem
+	Status     string `json:"status"`
+	Size       int64  `json:"size"`
+	ObjectID   string `json:"object_id,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+	Uploaded   bool   `json:"uploaded,omitempty"`
+	MovedFrom  string `json:"moved_from,omitempty"` // caminho anterior no snapshot de um arquivo renomeado
+	Error      string `json:"error,omitempty"`
+
+	keyID string
+}
+
+// HistoryRecord is the API view of a BackupHistory row
+type HistoryRecord struct {
+	ID           uint                `json:"id"`
+	Source       string              `json:"source"`
+	Mode         string              `json:"mode"`
+	Status       string              `json:"status,omitempty"`
+	Error        string              `json:"error,omitempty"`
+	SnapshotID   *uint               `json:"snapshot_id,omitempty"`
+	JobID        *uint               `json:"job_id,omitempty"`
+	FilesOK      int                 `json:"files_ok"`
+	FilesFailed  int                 `json:"files_failed"`
+	FilesSkipped int                 `json:"files_skipped"`
+	BytesTotal   int64               `json:"bytes_total"`
+	DurationMs   int64               `json:"duration_ms,omitempty"`
+	Date         time.Time           `json:"date"`
+	Hooks        []models.HookResult `json:"hooks,omitempty"`
+}
+
+func newHistoryRecord(history *models.BackupHistory) HistoryRecord {
+	return HistoryRecord{
+		ID:           history.ID,
+		Source:       history.AppName,
+		Mode:         history.BackupMode,
+		Status:       history.Status,
+		Error:        history.Error,
+		SnapshotID:   history.SnapshotID,
+		JobID:        history.JobID,
+		FilesOK:      history.FilesOK,
+		FilesFailed:  history.FilesFailed,
+		FilesSkipped: history.FilesSkipped,
+		BytesTotal:   history.BytesTotal,
+		DurationMs:   history.DurationMs,
+		Date:         history.BackupDate,
+		Hooks:        history.Hooks,
+	}
+}
+
+// stored completes the result of a file that is part of the snapshot
+func (f BackupFileResult) stored(entry services.ManifestEntry, uploaded bool) BackupFileResult {
+	f.Status = models.ItemStatusOK
+	f.ObjectID = entry.ObjectID
+	f.SHA256 = entry.SHA256
+	f.Uploaded = uploaded
+	f.keyID = entry.KeyID
+	return f
+}
+
+// ListHistory returns the most recent backup, restore and verify operations of the user
+func (b *BackupController) ListHistory(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	history, err := b.backupRepo.ListHistory(c.Request().Context(), user.ID, historyListLimit)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list backup history")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	records := make([]HistoryRecord, 0, len(history))
+	for i := range history {
+		records = append(records, newHistoryRecord(&history[i]))
+	}
+	return c.JSON(http.StatusOK, records)
+}
+
+// HistoryFiles returns the result of a backup run with the outcome of each file.
+// The optional "status" query parameter (ok, failed, skipped) filters the files.
+func (b *BackupController) HistoryFiles(c echo.Context) error {
+	return b.runFiles(c, b.backupRepo.FindHistory)
+}
+
+// JobFiles returns the result of the run of a backup job with the outcome of each file
+func (b *BackupController) JobFiles(c echo.Context) error {
+	return b.runFiles(c, b.backupRepo.FindJobHistory)
+}
+
+func (b *BackupController) runFiles(c echo.Context, find func(ctx context.Context, userID, id uint) (*models.BackupHistory, error)) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid id"})
+	}
+	status := c.QueryParam("status")
+	switch status {
+	case "", models.ItemStatusOK, models.ItemStatusFailed, models.ItemStatusSkipped:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid status"})
+	}
+
+	ctx := c.Request().Context()
+	history, err := find(ctx, user.ID, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "backup run not found"})
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load backup history")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	items, err := b.backupRepo.ListItems(ctx, user.ID, history.ID, status)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list backup items")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+
+	result := BackupResult{
+		Status:       history.Status,
+		SuccessCount: history.FilesOK,
+		FailedCount:  history.FilesFailed,
+		SkippedCount: history.FilesSkipped,
+		Files:        make([]BackupFileResult, 0, len(items)),
+	}
+	if history.SnapshotID != nil {
+		result.ManifestID = *history.SnapshotID
+	}
+	for _, item := range items {
+		result.Files = append(result.Files, BackupFileResult{
+			Path:       item.Path,
+			SourcePath: item.SourcePath,
+			Status:     item.Status,
+			Size:       item.Size,
+			ObjectID:   item.ObjectID,
+			SHA256:     item.SHA256,
+			Uploaded:   item.Uploaded,
+			MovedFrom:  item.MovedFrom,
+			Error:      item.Error,
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"run":    newHistoryRecord(history),
+		"result": result,
+	})
+}
+
+// recordBackupRun stores the summary of a backup run in BackupHistory and one
+// BackupItem per file. A run that failed is recorded with the files it got to.
+func (b *BackupController) recordBackupRun(ctx context.Context, run backupRun, appName, destDir string, result *BackupResult) {
+	switch {
+	case result.Error != nil && ctx.Err() != nil:
+		result.Status = models.HistoryStatusInterrupted
+	case result.Error != nil:
+		result.Status = models.HistoryStatusFailed
+	case result.FailedCount > 0:
+		result.Status = models.HistoryStatusPartial
+	default:
+		result.Status = models.HistoryStatusSuccess
+	}
+
+	history := &models.BackupHistory{
+		UserID:       run.UserID,
+		AppName:      appName,
+		BackupDate:   time.Now(),
+		BackupMode:   run.Mode,
+		FilePath:     destDir,
+		Status:       result.Status,
+		FilesOK:      result.SuccessCount,
+		FilesFailed:  result.FailedCount,
+		FilesSkipped: result.SkippedCount,
+		Hooks:        result.Hooks,
+	}
+	if result.ManifestID != 0 {
+		history.SnapshotID = &result.ManifestID
+	}
+	if jobID := run.Progress.JobID(); jobID != 0 {
+		history.JobID = &jobID
+	}
+	if !run.Started.IsZero() {
+		history.DurationMs = time.Since(run.Started).Milliseconds()
+	}
+	if result.Error != nil {
+		history.Error = result.Error.Error()
+	}
+
+	items := make([]models.BackupItem, 0, len(result.Files))
+	for _, file := range result.Files {
+		if file.Status == models.ItemStatusOK {
+			history.BytesTotal += file.Size
+		}
+		items = append(items, models.BackupItem{
+			SourcePath: file.SourcePath,
+			Path:       file.Path,
+			ObjectID:   file.ObjectID,
+			Size:       file.Size,
+			SHA256:     file.SHA256,
+			KeyID:      file.keyID,
+			Uploaded:   file.Uploaded,
+			MovedFrom:  file.MovedFrom,
+			Status:     file.Status,
+			Error:      file.Error,
+		})
+	}
+
+	// O registro não pode se perder porque o job foi pausado ou cancelado
+	if err := b.backupRepo.CreateRun(context.WithoutCancel(ctx), history, items); err != nil {
+		logrus.WithError(err).Error("Failed to create backup history")
+	}
+	b.notifyOutcome(history, result)
+}
+
+// notifyOutcome mails the outcome of a run to its owner, if their settings
+// ask for it. It runs in the background so a slow mail server does not hold
+// the run.
+func (b *BackupController) notifyOutcome(history *models.BackupHistory, result *BackupResult) {
+	outcome := services.BackupOutcome{
+		UserID:       history.UserID,
+		Source:       history.AppName,
+		Mode:         history.BackupMode,
+		Status:       history.Status,
+		SnapshotID:   result.ManifestID,
+		FilesOK:      history.FilesOK,
+		FilesFailed:  history.FilesFailed,
+		FilesSkipped: history.FilesSkipped,
+		Bytes:        history.BytesTotal,
+		Duration:     time.Duration(history.DurationMs) * time.Millisecond,
+		Error:        history.Error,
+	}
+	for _, file := range result.Files {
+		if file.Status == models.ItemStatusFailed {
+			outcome.FailedFiles = append(outcome.FailedFiles, services.FailedFile{Path: file.Path, Error: file.Error})
+		}
+	}
+
+	go func() {
+		if err := b.notifier.BackupFinished(context.Background(), outcome); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{"user_id": outcome.UserID, "source": outcome.Source}).Error("Failed to send backup notification")
+		}
+	}()
+}
@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/services"
+	"SafeBox/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// jobPollInterval is how often the event stream reads a job running on another replica
+	jobPollInterval = 2 * time.Second
+	// jobKeepAlive keeps idle event streams open through proxies
+	jobKeepAlive = 15 * time.Second
+	jobListLimit = 50
+)
+
+// jobRunner adapts a backup run to the job service
+func (b *BackupController) jobRunner(run backupRun, config *BackupConfig) services.JobRunner {
+	return func(ctx context.Context, progress *services.JobProgress) (*services.JobResult, error) {
+		run.Progress = progress
+		result, err := b.processBackup(ctx, run, config)
+		if err != nil {
+			return nil, err
+		}
+		return &services.JobResult{
+			SnapshotID: result.ManifestID,
+			Uploaded:   result.UploadedCount,
+			Unchanged:  result.UnchangedCount,
+			Skipped:    result.SkippedCount,
+			Deleted:    len(result.DeletedFiles),
+		}, nil
+	}
+}
+
+// ResumeRunner rebuilds the runner of a stored job from its profile or template
+func (b *BackupController) ResumeRunner(ctx context.Context, job *models.BackupJob) (services.JobRunner, error) {
+	config, err := b.getBackupConfig(ctx, job.UserID, job.ProfileID, job.BackupType)
+	if err != nil {
+		return nil, err
+	}
+	if job.Format != "" {
+		if config.Format, err = parseArchiveFormat(job.Format); err != nil {
+			return nil, err
+		}
+	}
+	// O limite por execução é o do plano atual, que pode ter mudado desde o início do job
+	allowance, err := b.limits.UserAllowance(ctx, job.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return b.jobRunner(backupRun{UserID: job.UserID, Mode: job.Mode, ScheduleID: job.ScheduleID, MaxSize: allowance.Limits.MaxRunSize}, config), nil
+}
+
+// scanSources counts the files and bytes a run will process, so its progress
+// can show an ETA, and returns the bytes selected
+func scanSources(ctx context.Context, sources []backupSource, progress *services.JobProgress) (int64, error) {
+	var total int64
+	for _, source := range sources {
+		err := utils.WalkSource(source.Root, source.Walk, func(_, _ string, info os.FileInfo) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			progress.Scanned(1, info.Size())
+			total += info.Size()
+			return nil
+		}, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to scan %s: %w", source.Root, err)
+		}
+	}
+	return total, nil
+}
+
+// ListJobs returns the most recent backup jobs of the user
+func (b *BackupController) ListJobs(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	jobs, err := b.jobs.List(c.Request().Context(), user.ID, jobListLimit)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list backup jobs")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// JobStatus returns the progress of a backup job, or its result once finished
+func (b *BackupController) JobStatus(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid job id"})
+	}
+
+	status, err := b.jobs.Status(c.Request().Context(), user.ID, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "job not found"})
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load backup job")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// JobEvents streams the progress of a backup job as Server-Sent Events.
+// Every "progress" event carries the full status, so a client that reconnects
+// loses nothing. A "done" event ends the stream once the job stops, whether it
+// finished, failed, was paused or was canceled.
+func (b *BackupController) JobEvents(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid job id"})
+	}
+
+	ctx := c.Request().Context()
+	status, err := b.jobs.Status(ctx, user.ID, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "job not found"})
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load backup job")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	// Jobs de outra réplica não têm assinatura local e são lidos do banco
+	updates, cancel, live := b.jobs.Subscribe(user.ID, uint(id))
+	if live {
+		defer cancel()
+	} else if err := writeJobEvent(res, status); err != nil || !status.Active() {
+		return nil
+	}
+
+	poll := time.NewTicker(jobPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(jobKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case next, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			if err := writeJobEvent(res, next); err != nil || !next.Active() {
+				return nil
+			}
+		case <-poll.C:
+			if live {
+				continue
+			}
+			next, err := b.jobs.Status(ctx, user.ID, uint(id))
+			if err != nil {
+				logrus.WithError(err).Warn("Failed to poll backup job")
+				continue
+			}
+			if err := writeJobEvent(res, next); err != nil || !next.Active() {
+				return nil
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+// PauseJob stops a running backup job; it can be resumed later
+func (b *BackupController) PauseJob(c echo.Context) error {
+	return b.controlJob(c, b.jobs.Pause)
+}
+
+// CancelJob stops a running backup job
+func (b *BackupController) CancelJob(c echo.Context) error {
+	return b.controlJob(c, b.jobs.Cancel)
+}
+
+// ResumeJob runs a paused, canceled or failed backup job again from its last checkpoint.
+// Jobs stored as a single tar archive start over.
+func (b *BackupController) ResumeJob(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid job id"})
+	}
+
+	status, err := b.jobs.Resume(c.Request().Context(), user.ID, uint(id))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "job not found"})
+	case errors.Is(err, services.ErrJobNotResumable), errors.Is(err, services.ErrJobActive):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case err != nil:
+		logrus.WithError(err).Error("Failed to resume backup job")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	return c.JSON(http.StatusAccepted, status)
+}
+
+func (b *BackupController) controlJob(c echo.Context, action func(ctx context.Context, userID, id uint) error) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid job id"})
+	}
+
+	err = action(c.Request().Context(), user.ID, uint(id))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "job not found"})
+	case errors.Is(err, services.ErrJobNotActive):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case err != nil:
+		logrus.WithError(err).Error("Failed to control backup job")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	// A parada é assíncrona: o estado final chega pelo status ou pelos eventos do job
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "Request accepted"})
+}
+
+// writeJobEvent writes one job status as an SSE event
+func writeJobEvent(res *echo.Response, status services.JobStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	event := "progress"
+	if !status.Active() {
+		event = "done"
+	}
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"SafeBox/utils"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	compressionInputBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "safebox",
+			Name:      "backup_compression_input_bytes_total",
+			Help:      "Bytes read by the backup compression stage, by codec",
+		},
+		[]string{"codec"},
+	)
+	compressionOutputBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "safebox",
+			Name:      "backup_compression_output_bytes_total",
+			Help:      "Bytes written by the backup compression stage, by codec",
+		},
+		[]string{"codec"},
+	)
+	compressionRatio = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "safebox",
+			Name:      "backup_compression_ratio",
+			Help:      "Compressed size over original size per file, by codec",
+			Buckets:   []float64{0.1, 0.25, 0.5, 0.75, 0.9, 1, 1.1},
+		},
+		[]string{"codec"},
+	)
+	compressionSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "safebox",
+			Name:      "backup_compression_seconds_total",
+			Help:      "Time spent streaming files through the compression stage, by codec",
+		},
+		[]string{"codec"},
+	)
+	compressionTimeSaved = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "safebox",
+			Name:      "backup_compression_time_saved_seconds_total",
+			Help:      "Estimated compression time avoided by storing incompressible files",
+		},
+	)
+	chunkUploads = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "safebox",
+			Name:      "backup_chunks_uploaded_total",
+			Help:      "Chunks uploaded by backups because the user did not have them yet",
+		},
+	)
+	chunkReuses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "safebox",
+			Name:      "backup_chunks_deduplicated_total",
+			Help:      "Chunks of changed files that were already stored and not uploaded again",
+		},
+	)
+	verifyRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "safebox",
+			Name:      "backup_verify_runs_total",
+			Help:      "Snapshot verifications, by result",
+		},
+		[]string{"result"},
+	)
+	verifyDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "safebox",
+			Name:      "backup_verify_duration_seconds",
+			Help:      "Time taken to verify a snapshot",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		},
+	)
+	verifyFailedFiles = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "safebox",
+			Name:      "backup_verify_failed_files_total",
+			Help:      "Files that could not be read back during verification",
+		},
+	)
+	verifyLastSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "safebox",
+			Name:      "backup_verify_last_success_timestamp_seconds",
+			Help:      "Unix time of the last snapshot verification that passed",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		compressionInputBytes,
+		compressionOutputBytes,
+		compressionRatio,
+		compressionSeconds,
+		compressionTimeSaved,
+		chunkUploads,
+		chunkReuses,
+		verifyRuns,
+		verifyDuration,
+		verifyFailedFiles,
+		verifyLastSuccess,
+	)
+}
+
+// compressionThroughput tracks the observed bytes per second of real
+// compression, used to estimate the time saved on stored files
+var compressionThroughput struct {
+	sync.Mutex
+	bytes   int64
+	seconds float64
+}
+
+// recordCompression exports the stats of a finished compression stream
+func recordCompression(stats *utils.CompressionStats) {
+	codec := string(stats.Options.Algorithm)
+	compressionInputBytes.WithLabelValues(codec).Add(float64(stats.InputBytes))
+	compressionOutputBytes.WithLabelValues(codec).Add(float64(stats.OutputBytes))
+	compressionRatio.WithLabelValues(codec).Observe(stats.Ratio())
+	compressionSeconds.WithLabelValues(codec).Add(stats.Duration.Seconds())
+
+	compressionThroughput.Lock()
+	defer compressionThroughput.Unlock()
+	if stats.Options.Algorithm != utils.CompressionNone {
+		compressionThroughput.bytes += stats.InputBytes
+		compressionThroughput.seconds += stats.Duration.Seconds()
+		return
+	}
+	if compressionThroughput.bytes > 0 {
+		rate := float64(compressionThroughput.bytes) / compressionThroughput.seconds
+		compressionTimeSaved.Add(float64(stats.InputBytes) / rate)
+	}
+}
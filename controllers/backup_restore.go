@@ -0,0 +1,921 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/services"
+	"SafeBox/utils"
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// RestoreFormat decides where the restored files are written
+type RestoreFormat string
+
+const (
+	// RestoreZip streams the files back as a zip download
+	RestoreZip RestoreFormat = "zip"
+	// RestoreTar streams the files back as a tar download
+	RestoreTar RestoreFormat = "tar"
+	// RestoreDirectory writes the files into a directory under the user's restore root
+	RestoreDirectory RestoreFormat = "directory"
+	// RestoreAgent writes the files on the machine of the backup agent, which
+	// pulls them from the server
+	RestoreAgent RestoreFormat = "agent"
+)
+
+// restoreReportName is appended to zip and tar downloads with the per-file results
+const restoreReportName = "safebox-restore-report.json"
+
+var (
+	errRestoreHashMismatch = errors.New("content hash mismatch")
+	errRestoreSizeMismatch = errors.New("content size mismatch")
+	errRestoreChunk        = errors.New("failed to read chunk")
+)
+
+// RestoreRequest selects what to restore and where.
+// An empty Paths restores the whole backup; a path (relative to the snapshot
+// root) also selects everything below it.
+type RestoreRequest struct {
+	Paths  []string      `json:"paths"`
+	Format RestoreFormat `json:"format"`
+	Target string        `json:"target"`
+}
+
+// RestoreFileResult is the outcome of restoring a single file
+type RestoreFileResult struct {
+	Path  string `json:"path"`
+	OK    bool   `json:"ok"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// RestoreResult summarizes a restore run
+type RestoreResult struct {
+	ManifestID   uint                `json:"manifest_id"`
+	Format       RestoreFormat       `json:"format"`
+	Target       string              `json:"target,omitempty"`
+	SuccessCount int                 `json:"success_count"`
+	FailedCount  int                 `json:"failed_count"`
+	Files        []RestoreFileResult `json:"files"`
+}
+
+// restoreFile describes a file written to a sink
+type restoreFile struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Header holds the mode, owner, times and extended attributes recorded in
+	// a tar archive; nil for files stored as their own object or as chunks
+	Header *tar.Header
+}
+
+// restoreSink receives the decrypted files of a restore
+type restoreSink interface {
+	WriteFile(file restoreFile, content io.Reader) error
+	Close() error
+}
+
+// restoreLinker is implemented by sinks that can write a hard link to a
+// file they already received
+type restoreLinker interface {
+	Link(name, existing string) error
+}
+
+// archiveSink is implemented by sinks that also restore the symlinks and
+// directories of tar archives, with their metadata
+type archiveSink interface {
+	Symlink(name string, header *tar.Header) error
+	Dir(name string, header *tar.Header) error
+}
+
+// archiveMembers selects the symlinks and directories restored from the tar
+// archives that hold selected files. They are not listed in the manifest, so
+// they are matched by their path in the snapshot.
+type archiveMembers struct {
+	manifest *services.Manifest
+	match    func(snapshotPath string) bool
+}
+
+// Restore restores a whole backup or selected paths from a verified manifest,
+// either as a streamed zip/tar download, into a target directory or, with the
+// agent format, into a target directory on the machine of the backup agent
+func (b *BackupController) Restore(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	var req RestoreRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+	switch req.Format {
+	case "":
+		req.Format = RestoreZip
+	case RestoreZip, RestoreTar, RestoreDirectory, RestoreAgent:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unsupported restore format: %s", req.Format)})
+	}
+
+	record, err := b.findManifest(c, user)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "manifest not found"})
+	}
+
+	ctx := c.Request().Context()
+	manifest, err := b.openManifest(ctx, record)
+	if err != nil {
+		logrus.WithError(err).Warn("Refusing to restore from unverified manifest")
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+
+	match := matchPaths(req.Paths)
+	selected := selectEntries(manifest, match)
+	if len(selected) == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "no files match the requested paths"})
+	}
+	if req.Format == RestoreAgent {
+		// O agente busca os arquivos depois; os objetos são verificados quando ele os baixa
+		return b.requestAgentRestore(c, user, record, req)
+	}
+
+	// Nada é escrito antes de todos os objetos selecionados conferirem com o manifesto
+	checks, ok := b.manifests.VerifyObjects(&services.Manifest{Entries: selected}, func(objectID string) (io.ReadCloser, error) {
+		return b.Storage.Download(objectID)
+	})
+	if !ok {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   "backup objects failed verification",
+			"objects": checks,
+		})
+	}
+
+	result := &RestoreResult{ManifestID: record.ID, Format: req.Format}
+	members := &archiveMembers{manifest: manifest, match: match}
+	if req.Format == RestoreDirectory {
+		err = b.restoreDirectory(ctx, user, req.Target, selected, members, result)
+	} else {
+		err = b.restoreDownload(c, user, record, selected, members, result)
+	}
+
+	if histErr := b.recordRestore(user, record, result); histErr != nil {
+		logrus.WithError(histErr).Error("Failed to record restore history")
+	}
+
+	if req.Format != RestoreDirectory {
+		// A resposta já foi enviada junto com o arquivo
+		if err != nil {
+			logrus.WithError(err).Error("Restore download failed")
+		}
+		return nil
+	}
+	if errors.Is(err, utils.ErrUnsafePath) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid restore target"})
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Restore failed")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "restore failed"})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// restoreDownload streams the selected files as a zip or tar archive,
+// ending with a JSON report of the per-file results
+func (b *BackupController) restoreDownload(c echo.Context, user *models.OAuthUser, record *models.BackupManifest, entries []services.ManifestEntry, members *archiveMembers, result *RestoreResult) error {
+	name := fmt.Sprintf("%s-%d.%s", record.AppName, record.ID, result.Format)
+	contentType := "application/zip"
+	if result.Format == RestoreTar {
+		contentType = "application/x-tar"
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, contentType)
+	resp.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", name))
+	resp.WriteHeader(http.StatusOK)
+
+	var sink restoreSink
+	if result.Format == RestoreTar {
+		sink = &tarSink{tw: tar.NewWriter(resp)}
+	} else {
+		sink = &zipSink{zw: zip.NewWriter(resp)}
+	}
+
+	if err := b.restoreEntries(c.Request().Context(), user.ID, entries, members, sink, result); err != nil {
+		sink.Close()
+		return err
+	}
+
+	report, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		sink.Close()
+		return err
+	}
+	if err := sink.WriteFile(restoreFile{Name: restoreReportName, Size: int64(len(report)), ModTime: time.Now()}, bytes.NewReader(report)); err != nil {
+		sink.Close()
+		return err
+	}
+	return sink.Close()
+}
+
+// restoreDirectory writes the selected files below the user's restore root
+func (b *BackupController) restoreDirectory(ctx context.Context, user *models.OAuthUser, target string, entries []services.ManifestEntry, members *archiveMembers, result *RestoreResult) error {
+	root := filepath.Join(restoreBaseDir(), fmt.Sprintf("user_%d", user.ID))
+	if target == "" {
+		target = time.Now().UTC().Format("20060102T150405Z")
+	}
+	dir, err := utils.SafeJoin(root, target)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	result.Target = target
+
+	sink := &dirSink{root: dir}
+	if err := b.restoreEntries(ctx, user.ID, entries, members, sink, result); err != nil {
+		return err
+	}
+	return sink.Close()
+}
+
+// restoreEntries decrypts every object once and feeds its files to the sink.
+// Failures of single files are reported in result; only sink errors that
+// break the output are returned. With members, the symlinks and directories
+// of the archives are restored as well.
+func (b *BackupController) restoreEntries(ctx context.Context, userID uint, entries []services.ManifestEntry, members *archiveMembers, sink restoreSink, result *RestoreResult) error {
+	var order []string
+	byObject := map[string][]services.ManifestEntry{}
+	for _, entry := range entries {
+		if entry.Chunked() {
+			// Arquivos em chunks não compartilham objeto com outras entradas
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := b.restoreChunkedFile(ctx, userID, entry, sink, result); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := byObject[entry.ObjectID]; !ok {
+			order = append(order, entry.ObjectID)
+		}
+		byObject[entry.ObjectID] = append(byObject[entry.ObjectID], entry)
+	}
+
+	for _, objectID := range order {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		group := byObject[objectID]
+		var err error
+		if group[0].ArchivePath != "" {
+			err = b.restoreArchiveObject(ctx, userID, group, members, sink, result)
+		} else {
+			for _, entry := range group {
+				if err = b.restoreFileObject(ctx, userID, entry, sink, result); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFileObject restores an entry stored as its own object
+func (b *BackupController) restoreFileObject(ctx context.Context, userID uint, entry services.ManifestEntry, sink restoreSink, result *RestoreResult) error {
+	plain, closeObject, err := b.openObject(ctx, userID, entry.ObjectID)
+	if err != nil {
+		result.add(entry, err)
+		return nil
+	}
+	defer closeObject()
+
+	dec, err := utils.DecompressAuto(plain)
+	if err != nil {
+		result.add(entry, err)
+		return nil
+	}
+	defer dec.Close()
+
+	_, err = result.write(sink, entry, restoreFile{Name: restoreName(entry), Size: entry.Size, ModTime: entry.ModTime}, dec)
+	return err
+}
+
+// restoreChunkedFile restores an entry stored as a list of chunks
+func (b *BackupController) restoreChunkedFile(ctx context.Context, userID uint, entry services.ManifestEntry, sink restoreSink, result *RestoreResult) error {
+	content := b.chunks.Open(ctx, userID, entry.Chunks)
+	defer content.Close()
+
+	_, err := result.write(sink, entry, restoreFile{Name: restoreName(entry), Size: entry.Size, ModTime: entry.ModTime}, chunkErrorReader{content})
+	return err
+}
+
+// restoreArchiveObject restores the selected entries of a tar archive object
+// with the metadata the archive recorded. Hard links take their content from
+// the file they share it with; sinks that can link write them as links, the
+// others get the content again in a further pass over the archive.
+func (b *BackupController) restoreArchiveObject(ctx context.Context, userID uint, group []services.ManifestEntry, members *archiveMembers, sink restoreSink, result *RestoreResult) error {
+	for len(group) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		if group, err = b.restoreArchivePass(ctx, userID, group, members, sink, result); err != nil {
+			return err
+		}
+		// Links simbólicos e diretórios só na primeira passada
+		members = nil
+	}
+	return nil
+}
+
+// restoreArchivePass makes a single pass over the archive and returns the
+// entries left for the next one
+func (b *BackupController) restoreArchivePass(ctx context.Context, userID uint, group []services.ManifestEntry, members *archiveMembers, sink restoreSink, result *RestoreResult) ([]services.ManifestEntry, error) {
+	wanted := make(map[string][]services.ManifestEntry, len(group))
+	for _, entry := range group {
+		wanted[entry.ArchiveSource()] = append(wanted[entry.ArchiveSource()], entry)
+	}
+	failAll := func(err error) {
+		for _, entries := range wanted {
+			for _, entry := range entries {
+				result.add(entry, err)
+			}
+		}
+	}
+
+	// Caminho do topo do arquivo tar no snapshot e na árvore restaurada
+	special, _ := sink.(archiveSink)
+	if special == nil {
+		members = nil
+	}
+	var snapshotBase, restoreBase string
+	if members != nil {
+		snapshotBase = strings.TrimSuffix(services.SnapshotPath(members.manifest, group[0]), group[0].ArchivePath)
+		restoreBase = strings.TrimSuffix(restoreName(group[0]), group[0].ArchivePath)
+	}
+
+	plain, closeObject, err := b.openObject(ctx, userID, group[0].ObjectID)
+	if err != nil {
+		failAll(err)
+		return nil, nil
+	}
+	defer closeObject()
+
+	tr, dec, err := utils.OpenTarArchive(plain)
+	if err != nil {
+		failAll(err)
+		return nil, nil
+	}
+	defer dec.Close()
+
+	var next []services.ManifestEntry
+	for len(wanted) > 0 || members != nil {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			failAll(fmt.Errorf("failed to read archive: %w", err))
+			return nil, nil
+		}
+
+		if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeDir {
+			if members == nil {
+				continue
+			}
+			member := strings.TrimSuffix(header.Name, "/")
+			if !members.match(snapshotBase + member) {
+				continue
+			}
+			if err := restoreMember(special, header, restoreBase+member, result); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		entries, ok := wanted[header.Name]
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		delete(wanted, header.Name)
+
+		first := entries[0]
+		file := restoreFile{Name: restoreName(first), Size: first.Size, ModTime: header.ModTime, Header: header}
+		written, err := result.write(sink, first, file, tr)
+		if err != nil {
+			return nil, err
+		}
+		linker, canLink := sink.(restoreLinker)
+		for _, entry := range entries[1:] {
+			switch {
+			case !written:
+				result.add(entry, errors.New("linked file could not be restored"))
+			case canLink:
+				if err := linker.Link(restoreName(entry), restoreName(first)); err != nil {
+					var broken *sinkError
+					if errors.As(err, &broken) {
+						result.add(entry, broken.err)
+						return nil, broken.err
+					}
+					result.add(entry, err)
+					continue
+				}
+				result.add(entry, nil)
+			default:
+				next = append(next, entry)
+			}
+		}
+	}
+
+	failAll(errors.New("file missing from archive"))
+	return next, nil
+}
+
+// restoreMember restores a symlink or directory of an archive. Symlinks are
+// reported like files; directories only when they fail.
+func restoreMember(sink archiveSink, header *tar.Header, name string, result *RestoreResult) error {
+	entry := services.ManifestEntry{Path: "backups/" + name}
+	var err error
+	if header.Typeflag == tar.TypeSymlink {
+		err = sink.Symlink(name, header)
+	} else {
+		err = sink.Dir(name, header)
+	}
+
+	var broken *sinkError
+	if errors.As(err, &broken) {
+		result.add(entry, broken.err)
+		return broken.err
+	}
+	if err != nil || header.Typeflag == tar.TypeSymlink {
+		result.add(entry, err)
+	}
+	return nil
+}
+
+// openObject downloads and decrypts an object with its recorded key
+func (b *BackupController) openObject(ctx context.Context, userID uint, objectID string) (io.Reader, func() error, error) {
+	key, err := b.keys.FileKey(ctx, userID, objectID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	object, err := b.Storage.Download(objectID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to download object: %w", err)
+	}
+
+	plain, err := utils.DecryptReader(object, key)
+	if err != nil {
+		object.Close()
+		return nil, nil, fmt.Errorf("failed to decrypt object: %w", err)
+	}
+	return plain, object.Close, nil
+}
+
+// recordRestore registers the restore in BackupHistory
+func (b *BackupController) recordRestore(user *models.OAuthUser, record *models.BackupManifest, result *RestoreResult) error {
+	status := models.HistoryStatusSuccess
+	if result.FailedCount > 0 {
+		status = models.HistoryStatusPartial
+	}
+	return b.backupRepo.CreateBackupHistory(&models.BackupHistory{
+		UserID:      user.ID,
+		AppName:     record.AppName,
+		SnapshotID:  &record.ID,
+		BackupDate:  time.Now(),
+		BackupMode:  models.BackupModeRestore,
+		FilePath:    fmt.Sprintf("manifest_%d/%s", record.ID, result.Format),
+		Status:      status,
+		FilesOK:     result.SuccessCount,
+		FilesFailed: result.FailedCount,
+	})
+}
+
+// write sends one file to the sink while checking its size and hash against the manifest.
+// Errors that leave the sink unusable are returned; everything else is reported
+// per file. It reports whether the file was restored.
+func (r *RestoreResult) write(sink restoreSink, entry services.ManifestEntry, file restoreFile, content io.Reader) (bool, error) {
+	verified := &verifyingReader{src: content, hash: sha256.New(), size: entry.Size, want: entry.SHA256}
+	err := sink.WriteFile(file, verified)
+
+	var broken *sinkError
+	if errors.As(err, &broken) {
+		r.add(entry, broken.err)
+		return false, broken.err
+	}
+	r.add(entry, err)
+	return err == nil, nil
+}
+
+func (r *RestoreResult) add(entry services.ManifestEntry, err error) {
+	file := RestoreFileResult{Path: entry.Path, Size: entry.Size, OK: err == nil}
+	if err != nil {
+		file.Error = err.Error()
+		r.FailedCount++
+	} else {
+		r.SuccessCount++
+	}
+	r.Files = append(r.Files, file)
+}
+
+// matchPaths returns a matcher for the requested paths, given relative to
+// the snapshot root as shown when browsing it. A path also matches
+// everything below it; no paths match everything.
+func matchPaths(paths []string) func(snapshotPath string) bool {
+	cleaned := make([]string, 0, len(paths))
+	for _, p := range paths {
+		cleaned = append(cleaned, strings.Trim(path.Clean("/"+filepath.ToSlash(p)), "/"))
+	}
+	return func(snapshotPath string) bool {
+		if len(cleaned) == 0 {
+			return true
+		}
+		for _, p := range cleaned {
+			if p == "" || snapshotPath == p || strings.HasPrefix(snapshotPath, p+"/") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// selectEntries returns the manifest entries whose snapshot path matches
+func selectEntries(manifest *services.Manifest, match func(string) bool) []services.ManifestEntry {
+	var selected []services.ManifestEntry
+	for _, entry := range manifest.Entries {
+		if match(services.SnapshotPath(manifest, entry)) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected
+}
+
+// restoreName is the path of an entry inside the restored tree
+func restoreName(entry services.ManifestEntry) string {
+	return strings.TrimPrefix(filepath.ToSlash(entry.Path), "backups/")
+}
+
+func restoreBaseDir() string {
+	if dir := os.Getenv("RESTORE_BASE_DIR"); dir != "" {
+		return dir
+	}
+	return "restores"
+}
+
+// verifyingReader fails at EOF when the content does not match the manifest,
+// and as soon as it grows past the recorded size
+type verifyingReader struct {
+	src  io.Reader
+	hash hash.Hash
+	size int64
+	want string
+	n    int64
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.src.Read(p)
+	v.n += int64(n)
+	v.hash.Write(p[:n])
+	if v.n > v.size {
+		return n, errRestoreSizeMismatch
+	}
+	if err == io.EOF {
+		if v.n != v.size {
+			return n, errRestoreSizeMismatch
+		}
+		if hex.EncodeToString(v.hash.Sum(nil)) != v.want {
+			return n, errRestoreHashMismatch
+		}
+	}
+	return n, err
+}
+
+// chunkErrorReader marks the errors of a chunk list as content errors, so a
+// missing or damaged chunk fails only the file that uses it
+type chunkErrorReader struct {
+	src io.Reader
+}
+
+func (r chunkErrorReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %v", errRestoreChunk, err)
+	}
+	return n, err
+}
+
+// isContentError reports whether err comes from the content of a single file
+// rather than from the sink
+func isContentError(err error) bool {
+	return errors.Is(err, errRestoreHashMismatch) || errors.Is(err, errRestoreSizeMismatch) || errors.Is(err, errRestoreChunk)
+}
+
+// sinkError marks an error after which the sink can no longer be written
+type sinkError struct {
+	err error
+}
+
+func (e *sinkError) Error() string { return e.err.Error() }
+
+type zipSink struct {
+	zw *zip.Writer
+}
+
+// zipHeader builds the zip header of an entry, keeping the mode recorded
+// in a tar archive
+func zipHeader(name string, modTime time.Time, header *tar.Header) (*zip.FileHeader, error) {
+	if header == nil {
+		return &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}, nil
+	}
+	fh, err := zip.FileInfoHeader(header.FileInfo())
+	if err != nil {
+		return nil, err
+	}
+	fh.Name = name
+	if !header.FileInfo().IsDir() {
+		fh.Method = zip.Deflate
+	}
+	return fh, nil
+}
+
+func (s *zipSink) WriteFile(file restoreFile, content io.Reader) error {
+	fh, err := zipHeader(file.Name, file.ModTime, file.Header)
+	if err != nil {
+		return err
+	}
+	w, err := s.zw.CreateHeader(fh)
+	if err != nil {
+		return &sinkError{err}
+	}
+	_, err = io.Copy(w, content)
+	if isContentError(err) {
+		return err
+	}
+	if err != nil {
+		return &sinkError{err}
+	}
+	return nil
+}
+
+// Symlink stores the link target as the content, as zip tools expect
+func (s *zipSink) Symlink(name string, header *tar.Header) error {
+	fh, err := zipHeader(name, header.ModTime, header)
+	if err != nil {
+		return err
+	}
+	w, err := s.zw.CreateHeader(fh)
+	if err != nil {
+		return &sinkError{err}
+	}
+	if _, err := io.WriteString(w, header.Linkname); err != nil {
+		return &sinkError{err}
+	}
+	return nil
+}
+
+func (s *zipSink) Dir(name string, header *tar.Header) error {
+	fh, err := zipHeader(name+"/", header.ModTime, header)
+	if err != nil {
+		return err
+	}
+	if _, err := s.zw.CreateHeader(fh); err != nil {
+		return &sinkError{err}
+	}
+	return nil
+}
+
+func (s *zipSink) Close() error {
+	return s.zw.Close()
+}
+
+type tarSink struct {
+	tw *tar.Writer
+}
+
+// tarHeader copies the metadata recorded in the backup, when there is any,
+// under the restored name
+func tarHeader(name string, typeflag byte, modTime time.Time, recorded *tar.Header) *tar.Header {
+	header := &tar.Header{Mode: 0644, ModTime: modTime}
+	if recorded != nil {
+		copied := *recorded
+		header = &copied
+	}
+	header.Typeflag = typeflag
+	header.Name = name
+	header.Format = tar.FormatPAX
+	return header
+}
+
+func (s *tarSink) WriteFile(file restoreFile, content io.Reader) error {
+	header := tarHeader(file.Name, tar.TypeReg, file.ModTime, file.Header)
+	header.Size = file.Size
+	if err := s.tw.WriteHeader(header); err != nil {
+		return &sinkError{err}
+	}
+
+	// O cabeçalho já anunciou o tamanho: conteúdo curto é completado com zeros
+	// para manter o tar válido, e o arquivo é marcado como falho no relatório
+	size := file.Size
+	written, err := io.Copy(s.tw, io.LimitReader(content, size))
+	if err == nil && written < size {
+		err = errRestoreSizeMismatch
+	}
+	if isContentError(err) {
+		if written < size {
+			if _, padErr := io.CopyN(s.tw, zeroReader{}, size-written); padErr != nil {
+				return &sinkError{padErr}
+			}
+		}
+		return err
+	}
+	if err != nil {
+		return &sinkError{err}
+	}
+
+	// LimitReader para no tamanho anunciado; lê até o EOF para validar o hash
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *tarSink) Link(name, existing string) error {
+	header := tarHeader(name, tar.TypeLink, time.Time{}, nil)
+	header.Linkname = existing
+	if err := s.tw.WriteHeader(header); err != nil {
+		return &sinkError{err}
+	}
+	return nil
+}
+
+func (s *tarSink) Symlink(name string, recorded *tar.Header) error {
+	if err := s.tw.WriteHeader(tarHeader(name, tar.TypeSymlink, recorded.ModTime, recorded)); err != nil {
+		return &sinkError{err}
+	}
+	return nil
+}
+
+func (s *tarSink) Dir(name string, recorded *tar.Header) error {
+	if err := s.tw.WriteHeader(tarHeader(name+"/", tar.TypeDir, recorded.ModTime, recorded)); err != nil {
+		return &sinkError{err}
+	}
+	return nil
+}
+
+func (s *tarSink) Close() error {
+	return s.tw.Close()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// dirSink writes files below root. Each file goes to a temporary name and is
+// only renamed into place after its hash was verified. Symlinks and paths
+// are checked like in utils.ExtractTarArchive: nothing is written through a
+// symlink and no link target leaves root.
+type dirSink struct {
+	root  string
+	links *utils.LinkGuard
+	dirs  []dirMetadata // aplicados no Close, pois criar arquivos altera o diretório
+}
+
+type dirMetadata struct {
+	path   string
+	header *tar.Header
+}
+
+// target resolves name below root and creates its parent directories
+func (s *dirSink) target(name string) (string, error) {
+	target, err := utils.SafeJoin(s.root, name)
+	if err != nil {
+		return "", err
+	}
+	if err := utils.CheckParents(s.root, target); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+func (s *dirSink) WriteFile(file restoreFile, content io.Reader) error {
+	target, err := s.target(file.Name)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	if file.Header != nil {
+		return utils.ApplyTarMetadata(target, file.Header)
+	}
+	if !file.ModTime.IsZero() {
+		return os.Chtimes(target, file.ModTime, file.ModTime)
+	}
+	return nil
+}
+
+func (s *dirSink) Link(name, existing string) error {
+	target, err := s.target(name)
+	if err != nil {
+		return err
+	}
+	source, err := utils.SafeJoin(s.root, existing)
+	if err != nil {
+		return err
+	}
+	// Como no WriteFile, um arquivo anterior no mesmo caminho é substituído
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(source, target)
+}
+
+func (s *dirSink) Symlink(name string, header *tar.Header) error {
+	target, err := s.target(name)
+	if err != nil {
+		return err
+	}
+	if s.links == nil {
+		s.links = utils.NewLinkGuard(s.root)
+	}
+	if err := s.links.CheckLinkTarget(target, header.Linkname); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(header.Linkname, target); err != nil {
+		return err
+	}
+	return utils.ApplyTarMetadata(target, header)
+}
+
+func (s *dirSink) Dir(name string, header *tar.Header) error {
+	target, err := s.target(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	s.dirs = append(s.dirs, dirMetadata{path: target, header: header})
+	return nil
+}
+
+// Close applies the metadata of the restored directories, deepest first
+func (s *dirSink) Close() error {
+	for i := len(s.dirs) - 1; i >= 0; i-- {
+		if err := utils.ApplyTarMetadata(s.dirs[i].path, s.dirs[i].header); err != nil {
+			return err
+		}
+	}
+	s.dirs = nil
+	return nil
+}
@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/services"
+	"SafeBox/utils"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// VerifyResult is the outcome of reading a snapshot back from storage.
+// Only the objects and files that failed are listed.
+type VerifyResult struct {
+	ManifestID    uint                   `json:"manifest_id"`
+	Source        string                 `json:"source"`
+	Extract       bool                   `json:"extract"`
+	Valid         bool                   `json:"valid"`
+	Error         string                 `json:"error,omitempty"`
+	ObjectCount   int                    `json:"object_count"`
+	FailedObjects []services.ObjectCheck `json:"failed_objects,omitempty"`
+	FileCount     int                    `json:"file_count"`
+	FailedFiles   []RestoreFileResult    `json:"failed_files,omitempty"`
+	StartedAt     time.Time              `json:"started_at"`
+	Duration      float64                `json:"duration_seconds"`
+
+	filesOK int
+}
+
+// VerifySnapshot reads every object of a snapshot back from storage, decrypts
+// it and checks its hashes against the manifest. With extract=true the files
+// are also written to a scratch directory, which is removed afterwards.
+func (b *BackupController) VerifySnapshot(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+	extract := false
+	if value := c.QueryParam("extract"); value != "" {
+		if extract, err = strconv.ParseBool(value); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid extract value"})
+		}
+	}
+
+	record, err := b.findManifest(c, user)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "manifest not found"})
+	}
+
+	result := b.verify(c.Request().Context(), user.ID, record, extract)
+	return c.JSON(http.StatusOK, result)
+}
+
+// verifyScheduled verifies the latest snapshot of the source of a verify schedule
+func (b *BackupController) verifyScheduled(ctx context.Context, schedule *models.BackupSchedule) error {
+	config, err := b.getBackupConfig(ctx, schedule.UserID, schedule.ProfileID, schedule.BackupType)
+	if err != nil {
+		return err
+	}
+	record, err := b.manifests.Latest(ctx, schedule.UserID, config.Name)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		// Não ter o que verificar também merece alerta
+		err := fmt.Errorf("no snapshot of %s to verify", config.Name)
+		verifyRuns.WithLabelValues(models.HistoryStatusFailed).Inc()
+		alertVerifyFailure(schedule.UserID, &VerifyResult{Source: config.Name, Error: err.Error()})
+		return err
+	}
+
+	result := b.verify(ctx, schedule.UserID, record, schedule.VerifyExtract)
+	if !result.Valid {
+		return fmt.Errorf("snapshot %d failed verification: %d objects and %d files failed",
+			record.ID, len(result.FailedObjects), len(result.FailedFiles))
+	}
+	return nil
+}
+
+// verify runs a verification and records its result in BackupHistory and the
+// metrics, alerting when it fails
+func (b *BackupController) verify(ctx context.Context, userID uint, record *models.BackupManifest, extract bool) *VerifyResult {
+	result := b.verifySnapshot(ctx, userID, record, extract)
+
+	status := models.HistoryStatusSuccess
+	if !result.Valid {
+		status = models.HistoryStatusFailed
+		alertVerifyFailure(userID, result)
+	}
+	verifyRuns.WithLabelValues(status).Inc()
+	verifyDuration.Observe(result.Duration)
+	verifyFailedFiles.Add(float64(len(result.FailedFiles)))
+	if result.Valid {
+		verifyLastSuccess.SetToCurrentTime()
+	}
+
+	if err := b.recordVerify(userID, record, result); err != nil {
+		logrus.WithError(err).Error("Failed to record verify history")
+	}
+	return result
+}
+
+// verifySnapshot checks the manifest signature, then the stored hash of every
+// object, then decrypts and decompresses each file checking its size and hash.
+// Files of objects that already failed are not read a second time.
+func (b *BackupController) verifySnapshot(ctx context.Context, userID uint, record *models.BackupManifest, extract bool) *VerifyResult {
+	result := &VerifyResult{
+		ManifestID: record.ID,
+		Source:     record.AppName,
+		Extract:    extract,
+		StartedAt:  time.Now(),
+	}
+	defer func() {
+		result.Duration = time.Since(result.StartedAt).Seconds()
+	}()
+
+	manifest, err := b.openManifest(ctx, record)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.FileCount = len(manifest.Entries)
+
+	checks, _ := b.manifests.VerifyObjects(manifest, func(objectID string) (io.ReadCloser, error) {
+		return b.Storage.Download(objectID)
+	})
+	objects := map[string]bool{}
+	failed := map[string]bool{}
+	readable := make([]services.ManifestEntry, 0, len(manifest.Entries))
+	for i, check := range checks {
+		entry := manifest.Entries[i]
+		if check.OK {
+			readable = append(readable, entry)
+		}
+		if entry.Chunked() {
+			for _, chunk := range entry.Chunks {
+				objects[chunk.ObjectID] = true
+			}
+			if !check.OK {
+				result.FailedObjects = append(result.FailedObjects, check)
+			}
+			continue
+		}
+		objects[entry.ObjectID] = true
+		// Entradas de um mesmo arquivo tar compartilham o objeto; ele é listado uma vez
+		if !check.OK && !failed[entry.ObjectID] {
+			failed[entry.ObjectID] = true
+			result.FailedObjects = append(result.FailedObjects, check)
+		}
+	}
+	result.ObjectCount = len(objects)
+
+	var sink restoreSink = discardSink{}
+	if extract {
+		scratch, err := os.MkdirTemp(verifyScratchDir(), "safebox-verify-*")
+		if err != nil {
+			result.Error = fmt.Sprintf("failed to create scratch directory: %v", err)
+			return result
+		}
+		defer os.RemoveAll(scratch)
+		sink = &dirSink{root: scratch}
+	}
+
+	files := &RestoreResult{ManifestID: record.ID}
+	if err := b.restoreEntries(ctx, userID, readable, nil, sink, files); err != nil {
+		result.Error = err.Error()
+	}
+	result.filesOK = files.SuccessCount
+	for _, file := range files.Files {
+		if !file.OK {
+			result.FailedFiles = append(result.FailedFiles, file)
+		}
+	}
+
+	result.Valid = result.Error == "" && len(result.FailedObjects) == 0 && len(result.FailedFiles) == 0
+	return result
+}
+
+// recordVerify registers the verification in BackupHistory
+func (b *BackupController) recordVerify(userID uint, record *models.BackupManifest, result *VerifyResult) error {
+	history := &models.BackupHistory{
+		UserID:      userID,
+		AppName:     record.AppName,
+		SnapshotID:  &record.ID,
+		BackupDate:  time.Now(),
+		BackupMode:  models.BackupModeVerify,
+		FilePath:    fmt.Sprintf("manifest_%d", record.ID),
+		Status:      models.HistoryStatusSuccess,
+		FilesOK:     result.filesOK,
+		FilesFailed: result.FileCount - result.filesOK,
+	}
+	if !result.Valid {
+		history.Status = models.HistoryStatusFailed
+		history.Error = result.summary()
+	}
+	return b.backupRepo.CreateBackupHistory(history)
+}
+
+// summary describes why a verification failed in a single line
+func (r *VerifyResult) summary() string {
+	var parts []string
+	if r.Error != "" {
+		parts = append(parts, r.Error)
+	}
+	if len(r.FailedObjects) > 0 {
+		parts = append(parts, fmt.Sprintf("%d objects failed the hash check", len(r.FailedObjects)))
+	}
+	if len(r.FailedFiles) > 0 {
+		parts = append(parts, fmt.Sprintf("%d of %d files failed to read back", len(r.FailedFiles), r.FileCount))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// alertVerifyFailure logs a failed verification and, when VERIFY_ALERT_EMAIL
+// is set, mails it to that address
+func alertVerifyFailure(userID uint, result *VerifyResult) {
+	logrus.WithFields(logrus.Fields{
+		"user_id":     userID,
+		"manifest_id": result.ManifestID,
+		"source":      result.Source,
+	}).Error("Backup verification failed: ", result.summary())
+
+	to := os.Getenv("VERIFY_ALERT_EMAIL")
+	if to == "" {
+		return
+	}
+	subject := fmt.Sprintf("SafeBox: falha na verificação do backup %s", result.Source)
+	body := fmt.Sprintf("Usuário %d, snapshot %d (%s)\n\n%s\n", userID, result.ManifestID, result.Source, result.summary())
+	if err := utils.SendEmail(to, subject, body); err != nil {
+		logrus.WithError(err).Error("Failed to send verification alert")
+	}
+}
+
+func verifyScratchDir() string {
+	if dir := os.Getenv("VERIFY_SCRATCH_DIR"); dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
+
+// discardSink reads every file to the end, so its hash is checked, and keeps nothing
+type discardSink struct{}
+
+func (discardSink) WriteFile(file restoreFile, content io.Reader) error {
+	_, err := io.Copy(io.Discard, content)
+	return err
+}
+
+func (discardSink) Close() error {
+	return nil
+}
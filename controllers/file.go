@@ -3,11 +3,13 @@ package controllers
 import (
 	"SafeBox/models"
 	"SafeBox/repositories"
+	"SafeBox/services"
 	"SafeBox/services/storage"
 	"SafeBox/utils"
-	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
 
@@ -39,12 +41,22 @@ var (
 )
 
 type FileController struct {
-	Storage storage.Storage
+	Storage  storage.Storage
+	Metadata *services.FileMetadataService
+	Keys     *services.KeyService
+	Shredder *services.ShreddingService
+	Chunks   *services.ChunkService
 }
 
 // NewFileController creates a new instance of FileController
-func NewFileController(storage storage.Storage) *FileController {
-	return &FileController{Storage: storage}
+func NewFileController(storage storage.Storage, metadata *services.FileMetadataService, keys *services.KeyService, shredder *services.ShreddingService, chunks *services.ChunkService) *FileController {
+	return &FileController{
+		Storage:  storage,
+		Metadata: metadata,
+		Keys:     keys,
+		Shredder: shredder,
+		Chunks:   chunks,
+	}
 }
 
 // Upload function to handle file upload
@@ -68,19 +80,31 @@ func (f *FileController) Upload(c echo.Context) error {
 		return c.JSON(http.StatusForbidden, map[string]interface{}{"error": "Storage limit exceeded"})
 	}
 
-	// Criptografar arquivo
-	encryptionKey, err := utils.GenerateEncryptionKey()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error generating encryption key"})
+	// Registrar nome e metadados cifrados; o storage só conhece IDs opacos
+	ctx := c.Request().Context()
+	obj, err := f.Metadata.Register(ctx, user.ID, c.FormValue("folder"), file.Filename, map[string]string{
+		"content-type": file.Header.Get("Content-Type"),
+		"storage":      services.StorageChunks,
+	}, file.Size)
+	if errors.Is(err, services.ErrFileExists) {
+		return c.JSON(http.StatusConflict, map[string]interface{}{"error": "A file with this name already exists"})
 	}
-	var encryptedFile bytes.Buffer
-	if err := utils.EncryptStream(src, &encryptedFile, encryptionKey); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error encrypting the file"})
+	if err != nil {
+		logrus.Error("Erro ao registrar metadados do arquivo: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error registering the file"})
 	}
 
-	// Salvar arquivo criptografado
-	path, err := f.Storage.Upload(bytes.NewReader(encryptedFile.Bytes()), file.Filename)
+	// Dividir o arquivo em chunks cifrados; só os chunks que o usuário ainda não tem são enviados
+	refs, _, err := f.Chunks.Store(ctx, user.ID, src, utils.DefaultCompression, recordCompression)
+	if err == nil {
+		err = f.Chunks.AttachFile(ctx, obj, refs)
+	}
 	if err != nil {
+		logrus.Error("Erro ao salvar os chunks do arquivo: ", err)
+		// Sem o registro, o nome fica livre para um novo envio
+		if err := f.Metadata.Remove(context.WithoutCancel(ctx), obj); err != nil {
+			logrus.Error("Erro ao remover o registro do arquivo: ", err)
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error saving the file"})
 	}
 
@@ -101,7 +125,7 @@ func (f *FileController) Upload(c echo.Context) error {
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"message": "File uploaded successfully",
-		"path":    path,
+		"id":      obj.ObjectID,
 	})
 }
 
@@ -110,31 +134,58 @@ func (f *FileController) Download(c echo.Context) error {
 	logrus.Info("Recebendo solicitação de download de arquivo")
 	downloadCounter.Inc()
 
-	filename := c.Param("id")
-	file, err := f.Storage.Download(filename)
+	user := c.Get("user").(*models.OAuthUser)
+	obj, err := f.Metadata.FindByObjectID(c.Request().Context(), user.ID, c.Param("id"))
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]interface{}{"error": "File not found"})
 	}
-	defer file.Close()
+	meta, err := f.Metadata.Decrypt(c.Request().Context(), obj)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error decrypting file metadata"})
+	}
 
-	// Ler o conteúdo do arquivo
-	fileContent, err := ioutil.ReadAll(file)
+	content, err := f.openContent(c.Request().Context(), obj, meta)
+	if errors.Is(err, services.ErrKeyDestroyed) {
+		return c.JSON(http.StatusGone, map[string]interface{}{"error": "File has been deleted"})
+	}
 	if err != nil {
+		logrus.Error("Erro ao abrir o arquivo: ", err)
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error reading the file"})
 	}
+	defer content.Close()
 
-	// Descriptografar arquivo
-	encryptionKey, err := utils.GenerateEncryptionKey() // Recuperar a chave de criptografia correta
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", meta.Name))
+	return c.Stream(http.StatusOK, "application/octet-stream", content)
+}
+
+// openContent returns the decrypted content of a file, stored either as a
+// chunk list or, for files uploaded before chunking, as a single object
+func (f *FileController) openContent(ctx context.Context, obj *models.FileObject, meta *services.FileMetadata) (io.ReadCloser, error) {
+	if meta.Metadata["storage"] == services.StorageChunks {
+		refs, err := f.Chunks.FileChunks(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		return f.Chunks.Open(ctx, obj.UserID, refs), nil
+	}
+
+	encryptionKey, err := f.Keys.FileKey(ctx, obj.UserID, obj.ObjectID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error generating encryption key"})
+		return nil, err
 	}
-	var decryptedFile bytes.Buffer
-	err = utils.DecryptStream(bytes.NewReader(fileContent), &decryptedFile, encryptionKey)
+	file, err := f.Storage.Download(obj.ObjectID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error decrypting the file"})
+		return nil, err
 	}
-
-	return c.Blob(http.StatusOK, "application/octet-stream", decryptedFile.Bytes())
+	decryptedFile, err := utils.DecryptReader(file, encryptionKey)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{decryptedFile, file}, nil
 }
 
 // Delete function to handle file deletion
@@ -142,26 +193,59 @@ func (f *FileController) Delete(c echo.Context) error {
 	logrus.Info("Recebendo solicitação de exclusão de arquivo")
 	deleteCounter.Inc()
 
-	filename := c.Param("id")
-	if err := f.Storage.Delete(filename); err != nil {
+	// A chave é destruída primeiro; o objeto físico é removido de forma assíncrona
+	user := c.Get("user").(*models.OAuthUser)
+	ctx := c.Request().Context()
+	obj, err := f.Metadata.FindByObjectID(ctx, user.ID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{"error": "File not found"})
+	}
+	// Os chunks podem ser usados por outros arquivos; só perdem a referência deste
+	if err := f.Chunks.DetachFile(ctx, obj); err != nil {
+		logrus.Error("Erro ao liberar os chunks do arquivo: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error deleting the file"})
+	}
+	record, err := f.Shredder.DeleteFile(ctx, user.ID, obj.ObjectID)
+	if err != nil {
+		logrus.Error("Erro ao excluir arquivo: ", err)
 		return c.JSON(http.StatusNotFound, map[string]interface{}{"error": "File not found"})
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{"message": "File deleted"})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":     "File deleted",
+		"destruction": record,
+	})
 }
 
 // ListFiles function to list all uploaded files
 func (f *FileController) ListFiles(c echo.Context) error {
 	logrus.Info("Recebendo solicitação de listagem de arquivos")
-	files, err := ioutil.ReadDir("./uploads")
+	user := c.Get("user").(*models.OAuthUser)
+	files, err := f.Metadata.List(c.Request().Context(), user.ID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error reading directory"})
+		logrus.Error("Erro ao listar arquivos: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error listing files"})
 	}
-	var fileList []string
-	for _, file := range files {
-		fileList = append(fileList, file.Name()) // Append each file name to the list
+	return c.JSON(http.StatusOK, files)
+}
+
+// Lookup function to find a file by its exact folder and name
+func (f *FileController) Lookup(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	obj, err := f.Metadata.FindByName(c.Request().Context(), user.ID, c.QueryParam("folder"), c.QueryParam("name"))
+	if err != nil {
+		logrus.Error("Erro ao buscar arquivo: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error looking up file"})
+	}
+	if obj == nil {
+		return c.JSON(http.StatusNotFound, map[string]interface{}{"error": "File not found"})
+	}
+
+	meta, err := f.Metadata.Decrypt(c.Request().Context(), obj)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": "Error decrypting file metadata"})
 	}
-	return c.JSON(http.StatusOK, fileList)
+	return c.JSON(http.StatusOK, meta)
 }
 
 // Update function to handle file updates (replace an existing file)
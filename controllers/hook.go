@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/services"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// HookController manages the commands backup hooks may run on the server.
+// Its routes are registered under /api/admin, behind AuthMiddleware.RequirePermission(models.ADMIN).
+type HookController struct {
+	Hooks *services.HookService
+}
+
+// NewHookController creates a new instance of HookController
+func NewHookController(hooks *services.HookService) *HookController {
+	return &HookController{Hooks: hooks}
+}
+
+// hookCommandRequest is the body of an approval
+type hookCommandRequest struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	Args        []string `json:"args"`
+	AllowArgs   bool     `json:"allow_args"`
+	AllowedEnv  []string `json:"allowed_env"` // nomes das variáveis que os perfis podem definir
+	MaxTimeout  int      `json:"max_timeout"` // segundos
+	Description string   `json:"description"`
+}
+
+// List returns the approved hook commands
+func (h *HookController) List(c echo.Context) error {
+	commands, err := h.Hooks.List(c.Request().Context())
+	if err != nil {
+		logrus.Error("Erro ao listar comandos de hook: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error listing hook commands"})
+	}
+	return c.JSON(http.StatusOK, commands)
+}
+
+// Approve allows profiles to run a command as a hook
+func (h *HookController) Approve(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	var req hookCommandRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+
+	command := &models.HookCommand{
+		Name:        req.Name,
+		Path:        req.Path,
+		Args:        req.Args,
+		AllowArgs:   req.AllowArgs,
+		AllowedEnv:  req.AllowedEnv,
+		MaxTimeout:  req.MaxTimeout,
+		Description: req.Description,
+	}
+	if err := h.Hooks.Approve(c.Request().Context(), user.ID, command); err != nil {
+		return h.hookError(c, err)
+	}
+	logrus.WithFields(logrus.Fields{"admin_id": user.ID, "command": command.Name, "path": command.Path}).Info("Hook command approved")
+	return c.JSON(http.StatusCreated, command)
+}
+
+// Revoke stops a command from running as a hook
+func (h *HookController) Revoke(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	name := c.Param("name")
+	if err := h.Hooks.Revoke(c.Request().Context(), name); err != nil {
+		return h.hookError(c, err)
+	}
+	logrus.WithFields(logrus.Fields{"admin_id": user.ID, "command": name}).Info("Hook command revoked")
+	return c.JSON(http.StatusOK, map[string]string{"message": "Hook command revoked"})
+}
+
+func (h *HookController) hookError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidHook):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, services.ErrHookCommandExists):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "hook command not found"})
+	default:
+		logrus.Error("Erro ao salvar comando de hook: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error saving hook command"})
+	}
+}
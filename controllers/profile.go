@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type ProfileController struct {
+	Profiles *services.ProfileService
+}
+
+// NewProfileController creates a new instance of ProfileController
+func NewProfileController(profiles *services.ProfileService) *ProfileController {
+	return &ProfileController{Profiles: profiles}
+}
+
+// Templates returns the built-in profiles that can be run or copied
+func (p *ProfileController) Templates(c echo.Context) error {
+	return c.JSON(http.StatusOK, p.Profiles.Templates())
+}
+
+// List returns the backup profiles of the authenticated user
+func (p *ProfileController) List(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	profiles, err := p.Profiles.List(c.Request().Context(), user.ID)
+	if err != nil {
+		logrus.Error("Erro ao listar perfis: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error listing profiles"})
+	}
+	return c.JSON(http.StatusOK, profiles)
+}
+
+// Get returns one backup profile
+func (p *ProfileController) Get(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid profile id"})
+	}
+
+	profile, err := p.Profiles.Find(c.Request().Context(), user.ID, uint(id))
+	if err != nil {
+		return p.profileError(c, err)
+	}
+	return c.JSON(http.StatusOK, profile)
+}
+
+// Create registers a new backup profile, optionally based on a template
+func (p *ProfileController) Create(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	var input services.ProfileInput
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+
+	profile, err := p.Profiles.Create(c.Request().Context(), user.ID, input)
+	if err != nil {
+		return p.profileError(c, err)
+	}
+	return c.JSON(http.StatusCreated, profile)
+}
+
+// Update changes an existing backup profile
+func (p *ProfileController) Update(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid profile id"})
+	}
+	var input services.ProfileInput
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+
+	profile, err := p.Profiles.Update(c.Request().Context(), user.ID, uint(id), input)
+	if err != nil {
+		return p.profileError(c, err)
+	}
+	return c.JSON(http.StatusOK, profile)
+}
+
+// Delete removes a backup profile that no schedule uses
+func (p *ProfileController) Delete(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid profile id"})
+	}
+
+	if err := p.Profiles.Delete(c.Request().Context(), user.ID, uint(id)); err != nil {
+		return p.profileError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Profile deleted"})
+}
+
+func (p *ProfileController) profileError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidProfile):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, services.ErrProfileInUse):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "profile not found"})
+	default:
+		logrus.Error("Erro ao salvar perfil: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error saving profile"})
+	}
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"SafeBox/models"
+	"SafeBox/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type ScheduleController struct {
+	Schedules *services.ScheduleService
+	Pruner    *services.PruneService
+	backups   *BackupController
+}
+
+// NewScheduleController creates a new instance of ScheduleController
+func NewScheduleController(schedules *services.ScheduleService, pruner *services.PruneService, backups *BackupController) *ScheduleController {
+	return &ScheduleController{
+		Schedules: schedules,
+		Pruner:    pruner,
+		backups:   backups,
+	}
+}
+
+// List returns the backup schedules of the authenticated user
+func (s *ScheduleController) List(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	schedules, err := s.Schedules.List(c.Request().Context(), user.ID)
+	if err != nil {
+		logrus.Error("Erro ao listar agendamentos: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error listing schedules"})
+	}
+	return c.JSON(http.StatusOK, schedules)
+}
+
+// Create registers a new backup schedule
+func (s *ScheduleController) Create(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	input, err := s.bindInput(c, user.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	schedule, err := s.Schedules.Create(c.Request().Context(), user.ID, input)
+	if err != nil {
+		return s.scheduleError(c, err)
+	}
+	return c.JSON(http.StatusCreated, schedule)
+}
+
+// Update changes an existing backup schedule
+func (s *ScheduleController) Update(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid schedule id"})
+	}
+	input, err := s.bindInput(c, user.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	schedule, err := s.Schedules.Update(c.Request().Context(), user.ID, uint(id), input)
+	if err != nil {
+		return s.scheduleError(c, err)
+	}
+	return c.JSON(http.StatusOK, schedule)
+}
+
+// Delete removes a backup schedule
+func (s *ScheduleController) Delete(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid schedule id"})
+	}
+
+	if err := s.Schedules.Delete(c.Request().Context(), user.ID, uint(id)); err != nil {
+		return s.scheduleError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Schedule deleted"})
+}
+
+// Prune applies the retention policy of a schedule now.
+// With dry_run=true it only reports which snapshots and objects would be removed.
+func (s *ScheduleController) Prune(c echo.Context) error {
+	user := c.Get("user").(*models.OAuthUser)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid schedule id"})
+	}
+	dryRun := false
+	if value := c.QueryParam("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid dry_run value"})
+		}
+	}
+
+	schedule, err := s.Schedules.Find(c.Request().Context(), user.ID, uint(id))
+	if err != nil {
+		return s.scheduleError(c, err)
+	}
+	report, err := s.Pruner.PruneSchedule(c.Request().Context(), schedule, dryRun)
+	if err != nil {
+		logrus.Error("Erro ao aplicar retenção: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error pruning snapshots"})
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// bindInput reads a schedule from the request and checks its profile or backup type and its format
+func (s *ScheduleController) bindInput(c echo.Context, userID uint) (services.ScheduleInput, error) {
+	var input services.ScheduleInput
+	if err := c.Bind(&input); err != nil {
+		return input, errors.New("invalid request")
+	}
+	if input.ProfileID != nil || input.BackupType != "" {
+		if _, err := s.backups.getBackupConfig(c.Request().Context(), userID, input.ProfileID, input.BackupType); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return input, errors.New("profile not found")
+			}
+			return input, err
+		}
+	}
+	if input.Format != "" {
+		if _, err := parseArchiveFormat(input.Format); err != nil {
+			return input, err
+		}
+	}
+	return input, nil
+}
+
+func (s *ScheduleController) scheduleError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidSchedule):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "schedule not found"})
+	default:
+		logrus.Error("Erro ao salvar agendamento: ", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error saving schedule"})
+	}
+}
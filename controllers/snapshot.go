@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"SafeBox/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// ListSnapshots lists the backup snapshots of the user, newest first.
+// The optional "source" query parameter restricts the list to one backup source.
+func (b *BackupController) ListSnapshots(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	records, err := b.manifests.List(c.Request().Context(), user.ID, c.QueryParam("source"))
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list snapshots")
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+
+	snapshots := make([]services.Snapshot, 0, len(records))
+	for i := range records {
+		snapshots = append(snapshots, services.NewSnapshot(&records[i]))
+	}
+	return c.JSON(http.StatusOK, snapshots)
+}
+
+// BrowseSnapshot lists one directory of a snapshot, given by the "path" query parameter
+func (b *BackupController) BrowseSnapshot(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	record, err := b.findManifest(c, user)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "snapshot not found"})
+	}
+	manifest, err := b.openManifest(c.Request().Context(), record)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to open snapshot")
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+
+	nodes, err := services.BrowseTree(manifest, c.QueryParam("path"))
+	if errors.Is(err, services.ErrSnapshotPathNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"snapshot": services.NewSnapshot(record),
+		"path":     c.QueryParam("path"),
+		"entries":  nodes,
+	})
+}
+
+// DiffSnapshots compares snapshot :id with snapshot :other
+func (b *BackupController) DiffSnapshots(c echo.Context) error {
+	user, err := b.validateUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	}
+
+	from, err := b.findManifest(c, user)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "snapshot not found"})
+	}
+	otherID, err := strconv.ParseUint(c.Param("other"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid snapshot id"})
+	}
+	to, err := b.manifests.Find(c.Request().Context(), user.ID, uint(otherID))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "snapshot not found"})
+	}
+
+	fromManifest, err := b.openManifest(c.Request().Context(), from)
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+	toManifest, err := b.openManifest(c.Request().Context(), to)
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, services.DiffSnapshots(from.ID, fromManifest, to.ID, toManifest))
+}
@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"SafeBox/models"
+	"context"
+	"errors"
+
+	"github.com/labstack/echo/v4"
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// errUnauthorized is returned by resolvers that need an authenticated user
+var errUnauthorized = errors.New("unauthorized")
+
+// UserContext passes the user set by the auth middleware on to the resolvers
+func UserContext(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if user, ok := c.Get("user").(*models.OAuthUser); ok {
+			ctx := context.WithValue(c.Request().Context(), userContextKey, user)
+			c.SetRequest(c.Request().WithContext(ctx))
+		}
+		return next(c)
+	}
+}
+
+// currentUser returns the authenticated user of a request
+func currentUser(ctx context.Context) (*models.OAuthUser, error) {
+	user, ok := ctx.Value(userContextKey).(*models.OAuthUser)
+	if !ok {
+		return nil, errUnauthorized
+	}
+	return user, nil
+}
@@ -2,6 +2,34 @@
 
 package model
 
+type BackupHookInput struct {
+	Command        string   `json:"command"`
+	Args           []string `json:"args,omitempty"`
+	Env            []string `json:"env,omitempty"`
+	TimeoutSeconds *int     `json:"timeoutSeconds,omitempty"`
+	OnFailure      *string  `json:"onFailure,omitempty"`
+}
+
+type BackupProfileInput struct {
+	Name             string                     `json:"name"`
+	Template         *string                    `json:"template,omitempty"`
+	Sources          []string                   `json:"sources,omitempty"`
+	Include          []string                   `json:"include,omitempty"`
+	Exclude          []string                   `json:"exclude,omitempty"`
+	AppSources       []*ProfileSourceInput      `json:"appSources,omitempty"`
+	MaxFileSize      *int                       `json:"maxFileSize,omitempty"`
+	FollowSymlinks   *bool                      `json:"followSymlinks,omitempty"`
+	OneFileSystem    *bool                      `json:"oneFileSystem,omitempty"`
+	Compression      *string                    `json:"compression,omitempty"`
+	CompressionLevel *int                       `json:"compressionLevel,omitempty"`
+	Format           *string                    `json:"format,omitempty"`
+	Encryption       *string                    `json:"encryption,omitempty"`
+	PreHooks         []*BackupHookInput         `json:"preHooks,omitempty"`
+	PostHooks        []*BackupHookInput         `json:"postHooks,omitempty"`
+	Notifications    *ProfileNotificationsInput `json:"notifications,omitempty"`
+	Retention        *RetentionPolicyInput      `json:"retention,omitempty"`
+}
+
 type Mutation struct {
 }
 
@@ -12,5 +40,31 @@ type NewUserInput struct {
 	Plan     *string `json:"plan,omitempty"`
 }
 
+type ProfileNotificationsInput struct {
+	OnSuccess      *bool `json:"onSuccess,omitempty"`
+	OnPartial      *bool `json:"onPartial,omitempty"`
+	OnFailure      *bool `json:"onFailure,omitempty"`
+	StaleAfterDays *int  `json:"staleAfterDays,omitempty"`
+}
+
+type ProfileSourceInput struct {
+	Kind           string   `json:"kind"`
+	Path           *string  `json:"path,omitempty"`
+	Command        *string  `json:"command,omitempty"`
+	Args           []string `json:"args,omitempty"`
+	Env            []string `json:"env,omitempty"`
+	TimeoutSeconds *int     `json:"timeoutSeconds,omitempty"`
+	File           *string  `json:"file,omitempty"`
+}
+
 type Query struct {
 }
+
+type RetentionPolicyInput struct {
+	KeepLast    *int    `json:"keepLast,omitempty"`
+	KeepDaily   *int    `json:"keepDaily,omitempty"`
+	KeepWeekly  *int    `json:"keepWeekly,omitempty"`
+	KeepMonthly *int    `json:"keepMonthly,omitempty"`
+	KeepYearly  *int    `json:"keepYearly,omitempty"`
+	KeepWithin  *string `json:"keepWithin,omitempty"`
+}
@@ -0,0 +1,212 @@
+package graph
+
+import (
+	"SafeBox/graph/model"
+	"SafeBox/models"
+	"SafeBox/services"
+	"context"
+	"fmt"
+	"strconv"
+)
+
+type backupProfileResolver struct{ *Resolver }
+
+// ID is the resolver for the id field.
+func (r *backupProfileResolver) ID(ctx context.Context, obj *models.BackupProfile) (string, error) {
+	return strconv.FormatUint(uint64(obj.ID), 10), nil
+}
+
+// CreateBackupProfile is the resolver for the createBackupProfile field.
+func (r *mutationResolver) CreateBackupProfile(ctx context.Context, input model.BackupProfileInput) (*models.BackupProfile, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.Resolver.Profiles.Create(ctx, user.ID, profileInput(input))
+}
+
+// UpdateBackupProfile is the resolver for the updateBackupProfile field.
+func (r *mutationResolver) UpdateBackupProfile(ctx context.Context, id string, input model.BackupProfileInput) (*models.BackupProfile, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	profileID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.Resolver.Profiles.Update(ctx, user.ID, profileID, profileInput(input))
+}
+
+// DeleteBackupProfile is the resolver for the deleteBackupProfile field.
+func (r *mutationResolver) DeleteBackupProfile(ctx context.Context, id string) (bool, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return false, err
+	}
+	profileID, err := parseID(id)
+	if err != nil {
+		return false, err
+	}
+	if err := r.Resolver.Profiles.Delete(ctx, user.ID, profileID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// BackupProfiles is the resolver for the backupProfiles field.
+func (r *queryResolver) BackupProfiles(ctx context.Context) ([]*models.BackupProfile, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := r.Resolver.Profiles.List(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return profilePointers(profiles), nil
+}
+
+// BackupProfile is the resolver for the backupProfile field.
+func (r *queryResolver) BackupProfile(ctx context.Context, id string) (*models.BackupProfile, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	profileID, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.Resolver.Profiles.Find(ctx, user.ID, profileID)
+}
+
+// BackupProfileTemplates is the resolver for the backupProfileTemplates field.
+func (r *queryResolver) BackupProfileTemplates(ctx context.Context) ([]*models.BackupProfile, error) {
+	return profilePointers(r.Resolver.Profiles.Templates()), nil
+}
+
+func parseID(id string) (uint, error) {
+	value, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", id)
+	}
+	return uint(value), nil
+}
+
+func profilePointers(profiles []models.BackupProfile) []*models.BackupProfile {
+	result := make([]*models.BackupProfile, len(profiles))
+	for i := range profiles {
+		result[i] = &profiles[i]
+	}
+	return result
+}
+
+// profileInput converts the GraphQL input; omitted fields keep their zero value
+func profileInput(input model.BackupProfileInput) services.ProfileInput {
+	out := services.ProfileInput{
+		Name:    input.Name,
+		Sources: input.Sources,
+		Include: input.Include,
+		Exclude: input.Exclude,
+	}
+	for _, source := range input.AppSources {
+		out.AppSources = append(out.AppSources, sourceInput(source))
+	}
+	if input.Template != nil {
+		out.Template = *input.Template
+	}
+	if input.MaxFileSize != nil {
+		out.MaxFileSize = int64(*input.MaxFileSize)
+	}
+	if input.FollowSymlinks != nil {
+		out.FollowSymlinks = *input.FollowSymlinks
+	}
+	if input.OneFileSystem != nil {
+		out.OneFileSystem = *input.OneFileSystem
+	}
+	if input.Compression != nil {
+		out.Compression = *input.Compression
+	}
+	if input.CompressionLevel != nil {
+		out.CompressionLevel = *input.CompressionLevel
+	}
+	if input.Format != nil {
+		out.Format = *input.Format
+	}
+	if input.Encryption != nil {
+		out.Encryption = *input.Encryption
+	}
+	out.PreHooks = hookInputs(input.PreHooks)
+	out.PostHooks = hookInputs(input.PostHooks)
+	if n := input.Notifications; n != nil {
+		out.Notifications = &models.ProfileNotifications{
+			OnSuccess:      n.OnSuccess,
+			OnPartial:      n.OnPartial,
+			OnFailure:      n.OnFailure,
+			StaleAfterDays: n.StaleAfterDays,
+		}
+	}
+	if r := input.Retention; r != nil {
+		if r.KeepLast != nil {
+			out.KeepLast = *r.KeepLast
+		}
+		if r.KeepDaily != nil {
+			out.KeepDaily = *r.KeepDaily
+		}
+		if r.KeepWeekly != nil {
+			out.KeepWeekly = *r.KeepWeekly
+		}
+		if r.KeepMonthly != nil {
+			out.KeepMonthly = *r.KeepMonthly
+		}
+		if r.KeepYearly != nil {
+			out.KeepYearly = *r.KeepYearly
+		}
+		if r.KeepWithin != nil {
+			out.KeepWithin = *r.KeepWithin
+		}
+	}
+	return out
+}
+
+// hookInputs converts the hooks of one phase of a profile input
+func hookInputs(inputs []*model.BackupHookInput) []models.BackupHook {
+	var hooks []models.BackupHook
+	for _, input := range inputs {
+		hook := models.BackupHook{
+			Command: input.Command,
+			Args:    input.Args,
+			Env:     input.Env,
+		}
+		if input.TimeoutSeconds != nil {
+			hook.TimeoutSeconds = *input.TimeoutSeconds
+		}
+		if input.OnFailure != nil {
+			hook.OnFailure = *input.OnFailure
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks
+}
+
+// sourceInput converts an application-aware source of a profile input
+func sourceInput(input *model.ProfileSourceInput) models.ProfileSource {
+	source := models.ProfileSource{
+		Kind: input.Kind,
+		Args: input.Args,
+		Env:  input.Env,
+	}
+	if input.Path != nil {
+		source.Path = *input.Path
+	}
+	if input.Command != nil {
+		source.Command = *input.Command
+	}
+	if input.TimeoutSeconds != nil {
+		source.TimeoutSeconds = *input.TimeoutSeconds
+	}
+	if input.File != nil {
+		source.File = *input.File
+	}
+	return source
+}
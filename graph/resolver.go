@@ -1,6 +1,8 @@
 package graph
 
 import (
+	"SafeBox/services"
+
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/playground"
@@ -10,7 +12,8 @@ import (
 
 // Resolver serves as dependency injection for your app, add any dependencies you require here.
 type Resolver struct {
-	DB *gorm.DB
+	DB       *gorm.DB
+	Profiles *services.ProfileService
 }
 
 func NewExecutableSchema(cfg Config) graphql.ExecutableSchema {
@@ -24,10 +27,13 @@ type Config struct {
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 func (r *Resolver) Query() QueryResolver       { return &queryResolver{r} }
 func (r *Resolver) User() UserResolver         { return &userResolver{r} }
+func (r *Resolver) BackupProfile() BackupProfileResolver {
+	return &backupProfileResolver{r}
+}
 
-func NewGraphQLHandler(db *gorm.DB) *handler.Server {
+func NewGraphQLHandler(db *gorm.DB, profiles *services.ProfileService) *handler.Server {
 	return handler.NewDefaultServer(NewExecutableSchema(Config{
-		Resolvers: &Resolver{DB: db},
+		Resolvers: &Resolver{DB: db, Profiles: profiles},
 	}))
 }
 
@@ -10,10 +10,11 @@ import (
 
 type QuotaHandler struct {
 	quotaService services.QuotaServiceInterface
+	limits       *services.BackupLimitService
 }
 
-func NewQuotaHandler(qs services.QuotaServiceInterface) *QuotaHandler {
-	return &QuotaHandler{quotaService: qs}
+func NewQuotaHandler(qs services.QuotaServiceInterface, limits *services.BackupLimitService) *QuotaHandler {
+	return &QuotaHandler{quotaService: qs, limits: limits}
 }
 
 func (h *QuotaHandler) GetQuotaUsage(c echo.Context) error {
@@ -22,8 +23,15 @@ func (h *QuotaHandler) GetQuotaUsage(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
+	// Quanto resta dos limites de backup do plano, com o horário em que o dia recomeça
+	backups, err := h.limits.UserAllowance(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	backups.WriteHeaders(c.Response().Header())
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"used":  used,
-		"limit": h.quotaService.GetLimit(userID),
+		"used":    used,
+		"limit":   h.quotaService.GetLimit(userID),
+		"backups": backups,
 	})
 }
@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"SafeBox/services"
+	"context"
+	"log"
+	"time"
+)
+
+// staleJobTimeout is how long an active job may go without saving progress;
+// jobs save every few seconds, so only jobs of a stopped replica get there
+const staleJobTimeout = 2 * time.Minute
+
+// StartBackupJobReaper takes over the backup jobs abandoned by a replica that
+// stopped and resumes them from their last checkpoint
+func StartBackupJobReaper(jobs *services.BackupJobService) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		resumed, failed, err := jobs.ResumeStale(context.Background(), staleJobTimeout)
+		if err != nil {
+			log.Printf("[JOB] Erro ao retomar jobs de backup abandonados: %v", err)
+			continue
+		}
+		if resumed > 0 || failed > 0 {
+			log.Printf("[JOB] Jobs de backup abandonados: %d retomados, %d marcados como falha", resumed, failed)
+		}
+	}
+}
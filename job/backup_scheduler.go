@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"SafeBox/services"
+	"context"
+	"log"
+	"time"
+)
+
+// BackupRunner executa o backup de um agendamento
+type BackupRunner func(ctx context.Context, schedule *models.BackupSchedule) error
+
+const (
+	schedulerInterval  = time.Minute
+	schedulerBatchSize = 50
+	// Ocorrências atrasadas além disso são tratadas como perdidas (servidor parado)
+	missedRunGrace = 2 * schedulerInterval
+	// Uma execução "running" mais antiga que isso é considerada abandonada
+	staleRunTimeout  = 12 * time.Hour
+	maxScheduledRuns = 4
+)
+
+// StartBackupScheduler fires due backup schedules. Every replica may run it:
+// each occurrence is claimed atomically in the database before running, so a
+// schedule is executed only once. Occurrences missed during downtime are run
+// once or skipped according to the schedule's MissedRunPolicy.
+func StartBackupScheduler(repo *repositories.ScheduleRepository, run BackupRunner) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	slots := make(chan struct{}, maxScheduledRuns)
+	for range ticker.C {
+		runDueSchedules(repo, run, slots)
+	}
+}
+
+func runDueSchedules(repo *repositories.ScheduleRepository, run BackupRunner, slots chan struct{}) {
+	ctx := context.Background()
+	now := time.Now()
+
+	due, err := repo.Due(ctx, now, schedulerBatchSize)
+	if err != nil {
+		log.Printf("[JOB] Erro ao obter agendamentos pendentes: %v", err)
+		return
+	}
+
+	for i := range due {
+		schedule := due[i]
+
+		next, err := services.NextRun(schedule.CronExpr, schedule.Timezone, now)
+		if err != nil {
+			log.Printf("[JOB] Desativando agendamento %d: %v", schedule.ID, err)
+			if err := repo.Disable(ctx, schedule.ID, err.Error()); err != nil {
+				log.Printf("[JOB] Erro ao desativar agendamento %d: %v", schedule.ID, err)
+			}
+			continue
+		}
+
+		status := models.ScheduleStatusRunning
+		missed := now.Sub(schedule.NextRunAt) > missedRunGrace
+		if missed && schedule.MissedRunPolicy == models.MissedRunSkip {
+			status = models.ScheduleStatusSkipped
+		}
+		// Não sobrepõe uma execução anterior do mesmo agendamento que ainda esteja rodando
+		if schedule.LastStatus == models.ScheduleStatusRunning && schedule.LastRunAt != nil && now.Sub(*schedule.LastRunAt) < staleRunTimeout {
+			status = models.ScheduleStatusSkipped
+		}
+
+		claimed, err := repo.Claim(ctx, &schedule, next, status)
+		if err != nil {
+			log.Printf("[JOB] Erro ao reservar agendamento %d: %v", schedule.ID, err)
+			continue
+		}
+		if !claimed {
+			// Outra réplica já assumiu esta ocorrência
+			continue
+		}
+		if status == models.ScheduleStatusSkipped {
+			log.Printf("[JOB] Ocorrência do agendamento %d ignorada, próxima em %s", schedule.ID, next.Format(time.RFC3339))
+			continue
+		}
+
+		if missed {
+			log.Printf("[JOB] Recuperando execução perdida do agendamento %d", schedule.ID)
+		}
+
+		slots <- struct{}{}
+		go func(schedule models.BackupSchedule) {
+			defer func() { <-slots }()
+
+			log.Printf("[JOB] Iniciando backup agendado %d (%s)", schedule.ID, schedule.Name)
+			status, lastError := models.ScheduleStatusSuccess, ""
+			if err := run(ctx, &schedule); err != nil {
+				log.Printf("[JOB] Erro no backup agendado %d: %v", schedule.ID, err)
+				status, lastError = models.ScheduleStatusFailed, err.Error()
+			}
+			if err := repo.Finish(ctx, schedule.ID, status, lastError); err != nil {
+				log.Printf("[JOB] Erro ao registrar resultado do agendamento %d: %v", schedule.ID, err)
+			}
+		}(schedule)
+	}
+}
@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"SafeBox/services"
+	"context"
+	"log"
+	"time"
+)
+
+// chunkSweepGrace is how long an unreferenced chunk is kept after its last use.
+// A backup stores its chunks before the snapshot references them, so this
+// must be longer than the longest backup run.
+const chunkSweepGrace = 24 * time.Hour
+
+// StartChunkSweepJob removes the chunks no snapshot or file references anymore
+func StartChunkSweepJob(chunks *services.ChunkService) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removed, err := chunks.Sweep(context.Background(), chunkSweepGrace)
+		if err != nil {
+			log.Printf("[JOB] Erro ao remover chunks sem referência: %v", err)
+			continue
+		}
+		if removed > 0 {
+			log.Printf("[JOB] %d chunks sem referência removidos", removed)
+		}
+	}
+}
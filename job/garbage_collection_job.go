@@ -0,0 +1,54 @@
+package jobs
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"SafeBox/services/storage"
+	"context"
+	"log"
+	"time"
+)
+
+const garbageCollectionBatchSize = 100
+
+// StartGarbageCollectionJob removes physical objects whose keys were already
+// destroyed. Failed deletions stay queued and are retried with a growing
+// delay, so a few broken objects never hold back the rest of the queue.
+func StartGarbageCollectionJob(
+	repo *repositories.ShreddingRepository,
+	storage storage.StorageRepository,
+) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := context.Background()
+		pending, err := repo.PendingDeletions(ctx, time.Now(), garbageCollectionBatchSize)
+		if err != nil {
+			log.Printf("[JOB] Erro ao obter objetos pendentes de exclusão: %v", err)
+			continue
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		log.Printf("[JOB] Removendo %d objetos com chaves destruídas...", len(pending))
+		for i := range pending {
+			item := &pending[i]
+			if err := storage.Delete(ctx, item.UserID, item.ObjectID); err != nil {
+				log.Printf("[JOB] Erro ao remover objeto %s: %v", item.ObjectID, err)
+				dead, err := repo.FailDeletion(ctx, item, err)
+				if err != nil {
+					log.Printf("[JOB] Erro ao registrar falha do objeto %s: %v", item.ObjectID, err)
+				} else if dead {
+					log.Printf("[JOB] Exclusão do objeto %s abandonada após %d tentativas; remoção manual necessária", item.ObjectID, models.MaxDeletionAttempts)
+				}
+				continue
+			}
+
+			if err := repo.CompleteDeletion(ctx, item); err != nil {
+				log.Printf("[JOB] Erro ao concluir exclusão do objeto %s: %v", item.ObjectID, err)
+			}
+		}
+	}
+}
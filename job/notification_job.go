@@ -0,0 +1,36 @@
+package jobs
+
+import (
+	"SafeBox/services"
+	"context"
+	"log"
+	"time"
+)
+
+// StartNotificationJob warns, once an hour, about sources without a recent
+// successful backup and sends the weekly digests that are due
+func StartNotificationJob(notifications *services.NotificationService) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := context.Background()
+		now := time.Now()
+
+		sent, err := notifications.CheckStale(ctx, now)
+		if err != nil {
+			log.Printf("[JOB] Erro ao verificar backups atrasados: %v", err)
+		}
+		if sent > 0 {
+			log.Printf("[JOB] %d avisos de backup atrasado enviados", sent)
+		}
+
+		sent, err = notifications.SendDigests(ctx, now)
+		if err != nil {
+			log.Printf("[JOB] Erro ao enviar resumos semanais: %v", err)
+		}
+		if sent > 0 {
+			log.Printf("[JOB] %d resumos semanais enviados", sent)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package jobs
+
+import (
+	"SafeBox/repositories"
+	"SafeBox/services"
+	"context"
+	"log"
+	"time"
+)
+
+// StartPruneJob applies the retention policy of every schedule and profile
+// once a day. Objects of forgotten snapshots are crypto-shredded and then
+// removed by the garbage collection job.
+func StartPruneJob(repo *repositories.ScheduleRepository, profiles *repositories.ProfileRepository, pruner *services.PruneService) {
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := context.Background()
+		schedules, err := repo.WithRetention(ctx)
+		if err != nil {
+			log.Printf("[JOB] Erro ao obter políticas de retenção: %v", err)
+			continue
+		}
+
+		for i := range schedules {
+			report, err := pruner.PruneSchedule(ctx, &schedules[i], false)
+			if err != nil {
+				log.Printf("[JOB] Erro ao aplicar retenção do agendamento %d: %v", schedules[i].ID, err)
+				continue
+			}
+			if len(report.Forget) > 0 {
+				log.Printf("[JOB] Agendamento %d: %d snapshots removidos, %d bytes liberados",
+					schedules[i].ID, len(report.Forget), report.ReclaimedBytes)
+			}
+		}
+
+		// Perfis cuidam dos snapshots manuais e dos agendamentos sem política própria
+		withRetention, err := profiles.WithRetention(ctx)
+		if err != nil {
+			log.Printf("[JOB] Erro ao obter políticas de retenção dos perfis: %v", err)
+			continue
+		}
+		for i := range withRetention {
+			report, err := pruner.PruneProfile(ctx, &withRetention[i], false)
+			if err != nil {
+				log.Printf("[JOB] Erro ao aplicar retenção do perfil %d: %v", withRetention[i].ID, err)
+				continue
+			}
+			if len(report.Forget) > 0 {
+				log.Printf("[JOB] Perfil %d: %d snapshots removidos, %d bytes liberados",
+					withRetention[i].ID, len(report.Forget), report.ReclaimedBytes)
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"SafeBox/config"
+	"SafeBox/controllers"
 	"SafeBox/graph"
 	"SafeBox/handlers"
 	jobs "SafeBox/job"
@@ -17,8 +18,8 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	_ "golang.org/x/oauth2"
-	_ "golang.org/x/oauth2/google"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"log"
@@ -47,28 +48,167 @@ func main() {
 	// Serviços
 	quotaRepo := repositories.NewQuotaRepository(db)
 	quotaService := services.NewQuotaService(quotaRepo, unifiedStorage, config.RedisClient)
-	quotaHandler := handlers.NewQuotaHandler(quotaService)
 	quotaMiddleware := middlewares.NewQuotaMiddleware(quotaService, config.RedisClient)
 
 	// Configurar job de reconciliação com processamento em batch
 	go jobs.StartReconciliationJob(quotaRepo, unifiedStorage)
 
+	// Remove objetos cujas chaves foram destruídas
+	shreddingRepo := repositories.NewShreddingRepository(db)
+	go jobs.StartGarbageCollectionJob(shreddingRepo, unifiedStorage)
+
+	// Backups
+	masterKey, err := config.LoadMasterKey()
+	if err != nil {
+		log.Fatalf("Falha ao carregar chave mestra: %v", err)
+	}
+	signingKey, err := config.LoadSigningKey()
+	if err != nil {
+		log.Fatalf("Falha ao carregar chave de assinatura: %v", err)
+	}
+	keyService := services.NewKeyService(repositories.NewKeyRepository(db), masterKey)
+	metadataService := services.NewFileMetadataService(keyService, repositories.NewFileObjectRepository(db))
+	manifestService := services.NewManifestService(repositories.NewManifestRepository(db), keyService, signingKey)
+	// Hooks dos perfis só executam comandos aprovados por um administrador
+	hookService := services.NewHookService(repositories.NewHookRepository(db))
+	profileRepo := repositories.NewProfileRepository(db)
+	profileService := services.NewProfileService(profileRepo, hookService)
+	// Arquivos são guardados em chunks deduplicados por usuário
+	shreddingService := services.NewShreddingService(shreddingRepo, metadataService)
+	var objectStorage storage.Storage = unifiedStorage
+	chunkService := services.NewChunkService(repositories.NewChunkRepository(db), keyService, shreddingService, quotaService, objectStorage.Upload, objectStorage.Download)
+	go jobs.StartChunkSweepJob(chunkService)
+	// Os backups rodam como jobs em segundo plano, no máximo 4 ao mesmo tempo por réplica
+	jobRepo := repositories.NewJobRepository(db)
+	backupRepo := repositories.NewBackupRepository(db)
+	jobService := services.NewBackupJobService(jobRepo, keyService, 4)
+	// Limites de backup por plano, com o dia contado no fuso de cada usuário
+	limitService := services.NewBackupLimitService(repositories.NewUserRepository(db), jobRepo)
+	jobService.SetLimiter(limitService)
+	quotaHandler := handlers.NewQuotaHandler(quotaService, limitService)
+	// Avisos por e-mail do resultado dos backups, de origens atrasadas e o resumo semanal
+	notificationService := services.NewNotificationService(repositories.NewNotificationRepository(db), repositories.NewUserRepository(db), profileRepo, backupRepo)
+	go jobs.StartNotificationJob(notificationService)
+	backupController := controllers.NewBackupController(objectStorage, backupRepo, metadataService, keyService, manifestService, profileService, jobService, chunkService, limitService, hookService, notificationService)
+	jobService.SetRunnerFactory(backupController.ResumeRunner)
+
+	// Dispara os backups agendados e aplica as políticas de retenção
+	scheduleRepo := repositories.NewScheduleRepository(db)
+	pruneService := services.NewPruneService(manifestService, shreddingService, chunkService, repositories.NewUserRepository(db), backupController.Storage.Download)
+	go jobs.StartBackupScheduler(scheduleRepo, backupController.RunScheduled)
+	go jobs.StartPruneJob(scheduleRepo, profileRepo, pruneService)
+	go jobs.StartBackupJobReaper(jobService)
+
 	// Echo
 	e := echo.New()
 	e.Use(
 		middleware.Logger(),
 		middleware.Recover(),
 		middleware.CORS(),
-		quotaMiddleware.EnforceQuota,
 	)
 
+	// Autenticação: tokens OAuth do Google, validados a cada requisição.
+	// A cota é conferida depois dela, quando o usuário já é conhecido.
+	oauthSettings := config.LoadOAuthConfig()
+	authMiddleware := middlewares.NewAuthMiddleware(repositories.NewUserRepository(db), &oauth2.Config{
+		ClientID:     oauthSettings.ClientID,
+		ClientSecret: oauthSettings.ClientSecret,
+		RedirectURL:  oauthSettings.RedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		Endpoint:     google.Endpoint,
+	})
+	requireAuth := authMiddleware.WithConfig(middlewares.AuthConfig{RequireAuth: true})
+	api := e.Group("/api", requireAuth, quotaMiddleware.EnforceQuota)
+	admin := api.Group("/admin", authMiddleware.RequirePermission(models.ADMIN))
+
 	// Rotas
-	e.GET("/api/quota", quotaHandler.GetQuotaUsage)
+	api.GET("/quota", quotaHandler.GetQuotaUsage)
+
+	// Arquivos
+	fileController := controllers.NewFileController(objectStorage, metadataService, keyService, shreddingService, chunkService)
+	files := api.Group("/files")
+	files.GET("", fileController.ListFiles)
+	files.POST("", fileController.Upload)
+	files.GET("/lookup", fileController.Lookup)
+	files.GET("/:id", fileController.Download)
+	files.PUT("/:id", fileController.Update)
+	files.DELETE("/:id", fileController.Delete)
+
+	// Conta
+	accountController := controllers.NewAccountController(shreddingService, limitService, keyService, notificationService, manifestService)
+	api.DELETE("/account", accountController.Delete)
+	api.GET("/account/key-destructions", accountController.KeyDestructions)
+	api.PUT("/account/signing-key", backupController.RegisterSigningKey)
+	api.PUT("/account/timezone", accountController.SetTimezone)
+	api.POST("/account/recovery-key", accountController.CreateRecoveryKey)
+	api.GET("/account/notifications", accountController.NotificationSettings)
+	api.PUT("/account/notifications", accountController.UpdateNotificationSettings)
+	admin.GET("/key-destructions/verify", accountController.VerifyKeyDestructions)
+
+	// Backups e restauração
+	api.POST("/backups", backupController.Backup)
+	jobRoutes := api.Group("/jobs")
+	jobRoutes.GET("", backupController.ListJobs)
+	jobRoutes.GET("/:id", backupController.JobStatus)
+	jobRoutes.GET("/:id/events", backupController.JobEvents)
+	jobRoutes.POST("/:id/pause", backupController.PauseJob)
+	jobRoutes.POST("/:id/cancel", backupController.CancelJob)
+	jobRoutes.POST("/:id/resume", backupController.ResumeJob)
+	jobRoutes.GET("/:id/files", backupController.JobFiles)
+	api.GET("/history", backupController.ListHistory)
+	api.GET("/history/:id/files", backupController.HistoryFiles)
+	snapshots := api.Group("/snapshots")
+	snapshots.GET("", backupController.ListSnapshots)
+	snapshots.GET("/:id/tree", backupController.BrowseSnapshot)
+	snapshots.GET("/:id/diff/:other", backupController.DiffSnapshots)
+	snapshots.GET("/:id/manifest", backupController.Manifest)
+	snapshots.POST("/:id/signature", backupController.SignManifest)
+	snapshots.GET("/:id/verify", backupController.VerifyManifest)
+	snapshots.POST("/:id/verify", backupController.VerifySnapshot)
+	snapshots.POST("/:id/restore", backupController.Restore)
+	snapshots.GET("/:id/export", backupController.ExportSnapshot)
+
+	// Agente de backup. Fora do grupo /api: os workers do agente fazem consultas
+	// em paralelo, que a trava da cota recusaria; os limites do plano são
+	// conferidos ao iniciar cada execução e a cota é reservada a cada chunk
+	agent := e.Group("/api/agent", requireAuth)
+	agent.POST("/runs", backupController.StartAgentRun)
+	agent.POST("/chunks/lookup", backupController.LookupAgentChunks)
+	agent.PUT("/chunks/:hash", backupController.UploadAgentChunk)
+	agent.POST("/snapshots", backupController.CommitAgentSnapshot)
+	agent.GET("/restores", backupController.ListAgentRestores)
+	agent.GET("/restores/:id/content", backupController.DownloadAgentRestore)
+	agent.POST("/restores/:id/result", backupController.ReportAgentRestore)
+
+	// Agendamentos
+	scheduleController := controllers.NewScheduleController(services.NewScheduleService(scheduleRepo), pruneService, backupController)
+	schedules := api.Group("/schedules")
+	schedules.GET("", scheduleController.List)
+	schedules.POST("", scheduleController.Create)
+	schedules.PUT("/:id", scheduleController.Update)
+	schedules.DELETE("/:id", scheduleController.Delete)
+	schedules.POST("/:id/prune", scheduleController.Prune)
+
+	// Perfis de backup
+	profileController := controllers.NewProfileController(profileService)
+	profiles := api.Group("/profiles")
+	profiles.GET("", profileController.List)
+	profiles.POST("", profileController.Create)
+	profiles.GET("/templates", profileController.Templates)
+	profiles.GET("/:id", profileController.Get)
+	profiles.PUT("/:id", profileController.Update)
+	profiles.DELETE("/:id", profileController.Delete)
+
+	// Comandos aprovados para hooks, só para administradores
+	hookController := controllers.NewHookController(hookService)
+	admin.GET("/hook-commands", hookController.List)
+	admin.POST("/hook-commands", hookController.Approve)
+	admin.DELETE("/hook-commands/:name", hookController.Revoke)
 
 	// GraphQL
-	srv := graph.NewGraphQLHandler(db)
+	srv := graph.NewGraphQLHandler(db, profileService)
 	e.GET("/playground", echo.WrapHandler(playground.Handler("GraphQL Playground", "/query")))
-	e.POST("/query", echo.WrapHandler(srv))
+	e.POST("/query", echo.WrapHandler(srv), requireAuth, graph.UserContext)
 
 	startServer(e)
 }
@@ -83,7 +223,8 @@ func initDB() *gorm.DB {
 		os.Getenv("DB_PASSWORD"),
 		os.Getenv("DB_NAME"),
 	)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// TranslateError faz violações de índice único virarem gorm.ErrDuplicatedKey
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("Falha ao conectar no banco de dados: %v", err)
 	}
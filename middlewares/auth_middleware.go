@@ -59,6 +59,27 @@ func (am *AuthMiddleware) RequireAuth() echo.MiddlewareFunc {
 	}
 }
 
+// RequirePermission only lets through users granted permission; it runs
+// after RequireAuth
+func (am *AuthMiddleware) RequirePermission(permission models.Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := c.Get("user").(*models.OAuthUser)
+			if !ok {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "authentication required"})
+			}
+			allowed, err := am.userRepo.HasPermission(user.ID, permission)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to check permissions"})
+			}
+			if !allowed {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": fmt.Sprintf("permission '%s' required", permission)})
+			}
+			return next(c)
+		}
+	}
+}
+
 func (am *AuthMiddleware) RequirePlan(plan string) echo.MiddlewareFunc {
 	return am.WithConfig(AuthConfig{
 		RequiredPlan: plan,
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"SafeBox/models"
 	"SafeBox/services"
 	"fmt"
 	"net/http"
@@ -28,7 +29,12 @@ func (m *QuotaMiddleware) EnforceQuota(next echo.HandlerFunc) echo.HandlerFunc {
 			return next(c)
 		}
 
-		userID := c.Get("userID").(uint)
+		// Roda depois da autenticação; requisições sem usuário não reservam espaço
+		user, ok := c.Get("user").(*models.OAuthUser)
+		if !ok {
+			return next(c)
+		}
+		userID := user.ID
 		contentLength := c.Request().ContentLength
 
 		// Usar Redis Lock para operações concorrentes
@@ -22,21 +22,80 @@ func RunMigrations(db *gorm.DB) error {
 		return fmt.Errorf("failed to migrate OAuthUser: %w", err)
 	}
 
-	// Cria a tabela de backups
-	if err := db.AutoMigrate(&models.Backup{}); err != nil {
-		return fmt.Errorf("failed to migrate Backup: %w", err)
-	}
-
 	// Cria a tabela de histórico de backups
 	if err := db.AutoMigrate(&models.BackupHistory{}); err != nil {
 		return fmt.Errorf("failed to migrate BackupHistory: %w", err)
 	}
 
+	// Cria a tabela de arquivos de cada execução de backup
+	if err := db.AutoMigrate(&models.BackupItem{}); err != nil {
+		return fmt.Errorf("failed to migrate BackupItem: %w", err)
+	}
+
 	// Cria a tabela de chaves de criptografia
 	if err := db.AutoMigrate(&models.EncryptionKey{}); err != nil {
 		return fmt.Errorf("failed to migrate EncryptionKey: %w", err)
 	}
 
+	// Cria a tabela de chaves por usuário
+	if err := db.AutoMigrate(&models.UserKey{}); err != nil {
+		return fmt.Errorf("failed to migrate UserKey: %w", err)
+	}
+
+	// Cria a tabela de objetos com nomes e metadados cifrados
+	if err := db.AutoMigrate(&models.FileObject{}); err != nil {
+		return fmt.Errorf("failed to migrate FileObject: %w", err)
+	}
+
+	// Cria o registro encadeado de destruição de chaves e a fila de exclusão
+	if err := db.AutoMigrate(&models.KeyDestruction{}, &models.PendingDeletion{}); err != nil {
+		return fmt.Errorf("failed to migrate KeyDestruction: %w", err)
+	}
+
+	// Cria a tabela de manifestos assinados de backup
+	if err := db.AutoMigrate(&models.BackupManifest{}, &models.UserSigningKey{}); err != nil {
+		return fmt.Errorf("failed to migrate BackupManifest: %w", err)
+	}
+
+	// Cria a tabela de agendamentos de backup
+	if err := db.AutoMigrate(&models.BackupSchedule{}); err != nil {
+		return fmt.Errorf("failed to migrate BackupSchedule: %w", err)
+	}
+
+	// Cria a tabela de perfis de backup
+	if err := db.AutoMigrate(&models.BackupProfile{}); err != nil {
+		return fmt.Errorf("failed to migrate BackupProfile: %w", err)
+	}
+
+	// Cria a tabela de jobs de backup, seus checkpoints, os pedidos aceitos pelo limite diário e as execuções do agente
+	if err := db.AutoMigrate(&models.BackupJob{}, &models.BackupCheckpoint{}, &models.BackupSubmission{}, &models.AgentRun{}); err != nil {
+		return fmt.Errorf("failed to migrate BackupJob: %w", err)
+	}
+	// Um único job ativo por origem, mesmo com várias réplicas aceitando pedidos
+	if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_backup_jobs_active_source ON backup_jobs (user_id, source) WHERE status IN ('queued', 'running')").Error; err != nil {
+		return fmt.Errorf("failed to create idx_backup_jobs_active_source: %w", err)
+	}
+
+	// Cria a tabela de chunks deduplicados e a lista de chunks dos uploads
+	if err := db.AutoMigrate(&models.Chunk{}, &models.FileChunk{}); err != nil {
+		return fmt.Errorf("failed to migrate Chunk: %w", err)
+	}
+
+	// Cria a tabela de comandos aprovados para os hooks de backup
+	if err := db.AutoMigrate(&models.HookCommand{}); err != nil {
+		return fmt.Errorf("failed to migrate HookCommand: %w", err)
+	}
+
+	// Cria a tabela das restaurações feitas pelo agente na máquina do cliente
+	if err := db.AutoMigrate(&models.AgentRestore{}); err != nil {
+		return fmt.Errorf("failed to migrate AgentRestore: %w", err)
+	}
+
+	// Cria a tabela das preferências de notificação dos backups
+	if err := db.AutoMigrate(&models.NotificationSettings{}); err != nil {
+		return fmt.Errorf("failed to migrate NotificationSettings: %w", err)
+	}
+
 	log.Println("Migrations completed successfully!")
 	return nil
 }
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Estados de uma restauração pedida para o agente
+const (
+	AgentRestorePending = "pending" // esperando o agente buscar
+	AgentRestoreRunning = "running" // o agente está baixando os arquivos
+	AgentRestoreDone    = "done"
+	AgentRestoreFailed  = "failed"
+)
+
+// AgentRestore is a restore the user asked to write on the machine of the
+// backup agent. The agent pulls the selected files of the snapshot, verified
+// by the server, writes them into Target below its restore directory and
+// reports the per-file result.
+type AgentRestore struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"index;not null" json:"-"`
+	ManifestID   uint      `gorm:"not null" json:"manifest_id"`
+	Paths        []string  `gorm:"serializer:json" json:"paths"`
+	Target       string    `gorm:"not null" json:"target"` // relativo ao diretório de restauração do agente
+	Status       string    `gorm:"type:varchar(10);index;not null" json:"status"`
+	SuccessCount int       `gorm:"not null;default:0" json:"success_count"`
+	FailedCount  int       `gorm:"not null;default:0" json:"failed_count"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Open reports whether the agent may still pull the restore
+func (r *AgentRestore) Open() bool {
+	return r.Status == AgentRestorePending || r.Status == AgentRestoreRunning
+}
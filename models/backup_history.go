@@ -2,21 +2,45 @@ package models
 
 import "time"
 
-// Backup represents a backup entry in the database
-type Backup struct {
-	ID        uint      `gorm:"primaryKey"`
-	UserID    uint      `gorm:"not null"`
-	AppName   string    `gorm:"not null"`
-	FilePath  string    `gorm:"not null"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
-}
+// Modos registrados em BackupHistory
+const (
+	BackupModeManual    = "manual"
+	BackupModeScheduled = "scheduled"
+	BackupModeAgent     = "agent" // enviado pelo safebox-agent a partir da máquina do cliente
+	BackupModeSync      = "sync"  // sincronização contínua do safebox-agent; tem um limite diário próprio
+	BackupModeRestore   = "restore"
+	BackupModeVerify    = "verify"
+)
+
+// Resultado de uma operação registrada em BackupHistory
+const (
+	HistoryStatusSuccess     = "success"
+	HistoryStatusPartial     = "partial" // alguns arquivos falharam
+	HistoryStatusFailed      = "failed"
+	HistoryStatusInterrupted = "interrupted" // job pausado ou cancelado
+)
 
-// BackupHistory represents the history of backups in the database
+// BackupHistory is the log of backup, restore and verify operations.
+// The backed up data itself is described by the snapshot (BackupManifest)
+// referenced by SnapshotID. For backup runs it is also the summary row of the
+// per-file BackupItem records.
 type BackupHistory struct {
 	ID         uint      `gorm:"primaryKey"`
 	UserID     uint      `gorm:"not null"`
 	AppName    string    `gorm:"not null"`
+	SnapshotID *uint     `gorm:"index"`
 	BackupDate time.Time `gorm:"not null"`
 	BackupMode string    `gorm:"not null"`
 	FilePath   string    `gorm:"not null"`
+	JobID      *uint     `gorm:"index"`
+	Status     string    // vazio nos registros anteriores às verificações
+	Error      string
+	// Totais da execução; os detalhes por arquivo ficam em BackupItem
+	FilesOK      int
+	FilesFailed  int
+	FilesSkipped int
+	BytesTotal   int64
+	DurationMs   int64 // duração da execução; zero quando desconhecida
+	// Saída dos hooks antes e depois do backup
+	Hooks []HookResult `gorm:"serializer:json"`
 }
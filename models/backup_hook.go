@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// Momento da execução em que um hook roda
+const (
+	HookPhasePre  = "pre"
+	HookPhasePost = "post"
+)
+
+// O que a falha de um hook faz com a execução
+const (
+	HookOnFailureAbort    = "abort"    // "pre": o backup não roda; "post": a execução é marcada como falha
+	HookOnFailureContinue = "continue" // a falha só fica registrada
+)
+
+// BackupHook is a command a profile runs before or after each of its backups,
+// for instance to flush a database or freeze a file system. Command names a
+// HookCommand approved by an administrator; the profile only chooses extra
+// arguments, when the command allows them, the environment variables the
+// command allows, the timeout and what a failure does to the run.
+type BackupHook struct {
+	Command        string   `json:"command"`
+	Args           []string `json:"args,omitempty"`
+	Env            []string `json:"env,omitempty"`   // KEY=value
+	TimeoutSeconds int      `json:"timeout_seconds"` // 0 usa o padrão do comando
+	OnFailure      string   `json:"on_failure"`
+}
+
+// HookCommand is an executable an administrator approved for the hooks of
+// server-side backup sources
+type HookCommand struct {
+	ID          uint     `gorm:"primaryKey"`
+	Name        string   `gorm:"uniqueIndex;not null"`
+	Path        string   `gorm:"not null"`        // caminho absoluto do executável
+	Args        []string `gorm:"serializer:json"` // argumentos fixos, antes dos do perfil
+	AllowArgs   bool     `gorm:"not null"`        // perfis podem acrescentar argumentos
+	AllowedEnv  []string `gorm:"serializer:json"` // únicas variáveis de ambiente que os perfis podem definir
+	MaxTimeout  int      `gorm:"not null"`        // segundos
+	Description string
+	ApprovedBy  uint      `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+// HookResult is the outcome of one hook of a run, kept in its history
+type HookResult struct {
+	Phase      string `json:"phase"`
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exit_code"`
+	Output     string `json:"output"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	TimedOut   bool   `json:"timed_out,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+	Aborted    bool   `json:"aborted,omitempty"` // a falha fez a execução falhar
+}
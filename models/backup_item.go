@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Resultado de cada arquivo em uma execução de backup
+const (
+	ItemStatusOK      = "ok"
+	ItemStatusFailed  = "failed"
+	ItemStatusSkipped = "skipped"
+)
+
+// BackupItem records what happened to one file of a backup run.
+// Files that were not backed up keep the reason in Error; ObjectID, SHA256
+// and KeyID are only set for files present in the snapshot.
+type BackupItem struct {
+	ID         uint   `gorm:"primaryKey"`
+	HistoryID  uint   `gorm:"index;not null"` // resumo da execução em BackupHistory
+	UserID     uint   `gorm:"index;not null"`
+	SourcePath string `gorm:"not null"` // caminho relativo à raiz de origem
+	Path       string `gorm:"not null"` // caminho no snapshot
+	ObjectID   string
+	Size       int64
+	SHA256     string
+	KeyID      string
+	Uploaded   bool   // falso quando o objeto do snapshot anterior foi reaproveitado
+	MovedFrom  string // caminho anterior no snapshot de um arquivo renomeado
+	Status     string `gorm:"type:varchar(10);index;not null"`
+	Error      string
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
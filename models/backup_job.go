@@ -0,0 +1,100 @@
+package models
+
+import "time"
+
+// Estados de um job de backup
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusPaused    = "paused"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCanceled  = "canceled"
+)
+
+// Ações pedidas a um job que roda em outra réplica
+const (
+	JobActionPause  = "pause"
+	JobActionCancel = "cancel"
+)
+
+// BackupJob is a backup run executed in the background.
+// Progress counters are flushed periodically while the job runs, so UpdatedAt
+// also works as a heartbeat: an active job that stops updating was abandoned.
+// Files already backed up are checkpointed, so a paused, canceled or
+// interrupted job can be resumed without starting over. A partial unique
+// index (see migrations) allows one queued or running job per user and source.
+type BackupJob struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index;not null"`
+	Source     string `gorm:"not null"` // nome do perfil ou modelo
+	ProfileID  *uint  `gorm:"index"`
+	BackupType string
+	Format     string
+	Mode       string `gorm:"not null;default:'manual'"`
+	ScheduleID *uint  `gorm:"index"`
+	Status     string `gorm:"type:varchar(10);index;not null"`
+	// RequestedAction é lido pela réplica que executa o job no próximo flush
+	RequestedAction string `gorm:"type:varchar(10)"`
+	Attempts        int    `gorm:"not null;default:0"`
+	FilesScanned    int    `gorm:"not null;default:0"`
+	BytesScanned    int64  `gorm:"not null;default:0"`
+	FilesDone       int    `gorm:"not null;default:0"`
+	BytesDone       int64  `gorm:"not null;default:0"`
+	FilesFailed     int    `gorm:"not null;default:0"`
+	FilesUploaded   int    `gorm:"not null;default:0"`
+	FilesUnchanged  int    `gorm:"not null;default:0"`
+	FilesSkipped    int    `gorm:"not null;default:0"`
+	FilesDeleted    int    `gorm:"not null;default:0"`
+	CurrentFile     string
+	Errors          []string `gorm:"serializer:json"` // erros mais recentes por arquivo
+	Error           string   // motivo da falha do job
+	SnapshotID      *uint
+	StartedAt       *time.Time
+	FinishedAt      *time.Time
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
+}
+
+// Active reports whether the job is queued or running
+func (j *BackupJob) Active() bool {
+	return j.Status == JobStatusQueued || j.Status == JobStatusRunning
+}
+
+// Resumable reports whether the job stopped before finishing and can be resumed
+func (j *BackupJob) Resumable() bool {
+	return j.Status == JobStatusPaused || j.Status == JobStatusFailed || j.Status == JobStatusCanceled
+}
+
+// BackupCheckpoint is a batch of files a job finished, sealed with the
+// owner's key. The batches of a job are replayed in order when it resumes.
+type BackupCheckpoint struct {
+	ID        uint      `gorm:"primaryKey"`
+	JobID     uint      `gorm:"index;not null"`
+	Sealed    []byte    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// BackupSubmission records a backup request accepted under the daily limits of
+// the user's plan: a job submitted to the server or a run started by the
+// agent. Runs of continuous sync are recorded with mode "sync" and counted
+// against a limit of their own; resumes are not submissions.
+type BackupSubmission struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"index:idx_backup_submissions_user_created;not null"`
+	Source    string    `gorm:"not null"`
+	Mode      string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"index:idx_backup_submissions_user_created;autoCreateTime"`
+}
+
+// AgentRun is a run the agent started under the daily limits. Its ID is
+// issued when the run starts and must be presented, once, to commit the
+// snapshot of the run; the source and mode are the ones it was admitted with.
+type AgentRun struct {
+	ID          string     `gorm:"primaryKey;size:32"`
+	UserID      uint       `gorm:"index;not null"`
+	Source      string     `gorm:"not null"`
+	Mode        string     `gorm:"not null"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime"`
+	CommittedAt *time.Time // nil enquanto o snapshot da execução não foi gravado
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+const (
+	ManifestSignerServer = "server"
+	ManifestSignerUser   = "user"
+)
+
+// BackupManifest is the snapshot of a backup run: it ties together every
+// object the run left in storage. The manifest body with the file tree is
+// stored encrypted under ObjectID; only its digest and signature are kept
+// in the database.
+type BackupManifest struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index;not null"`
+	AppName     string `gorm:"not null"`
+	ScheduleID  *uint  `gorm:"index"` // agendamento que gerou o backup, se houver
+	ParentID    *uint  `gorm:"index"` // manifesto anterior usado como base do backup incremental
+	Mode        string `gorm:"not null;default:'manual'"`
+	ObjectID    string `gorm:"uniqueIndex;not null"`
+	Digest      string `gorm:"not null"` // SHA-256 do manifesto canônico
+	FileCount   int    `gorm:"not null"`
+	TotalSize   int64  `gorm:"not null"`
+	SignerType  string `gorm:"type:varchar(10);not null"`
+	SignerKeyID string `gorm:"not null"`
+	Signature   []byte // vazio enquanto aguarda a assinatura do usuário
+	SignedAt    *time.Time
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
+
+// UserSigningKey is the Ed25519 public key of a user in zero-knowledge mode.
+// Manifests of such users are signed client-side instead of by the server.
+type UserSigningKey struct {
+	ID        uint      `gorm:"primaryKey"`
+	UserID    uint      `gorm:"uniqueIndex;not null"`
+	PublicKey []byte    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
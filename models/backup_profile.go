@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+// Formatos de armazenamento de um backup
+const (
+	ArchiveFormatFiles   = "files"   // um objeto por arquivo
+	ArchiveFormatTarZstd = "tar.zst" // a árvore inteira em um único objeto tar+zstd
+)
+
+// EncryptionAES256CTR is the cipher used for backup objects, with one key per object
+const EncryptionAES256CTR = "aes-256-ctr"
+
+// BackupProfile describes what a user backs up and how.
+// Sources are directories relative to the owner's directory under the
+// server's backup source root; Include and Exclude are glob patterns matched
+// against paths inside each source. AppSources are copied by an application-aware source instead of
+// being walked. Profiles created from a built-in template record its name.
+// PreHooks and PostHooks run before and after each backup of the profile.
+// Notifications, when set, overrides the owner's notification settings.
+// Retention prunes the snapshots of the profile that no schedule policy covers.
+type BackupProfile struct {
+	ID               uint                  `gorm:"primaryKey"`
+	UserID           uint                  `gorm:"uniqueIndex:idx_backup_profiles_user_name;not null"`
+	Name             string                `gorm:"uniqueIndex:idx_backup_profiles_user_name;not null"`
+	Template         string                // modelo embutido de origem, se houver
+	Sources          []string              `gorm:"serializer:json;not null"`
+	Include          []string              `gorm:"serializer:json"`
+	Exclude          []string              `gorm:"serializer:json"`
+	AppSources       []ProfileSource       `gorm:"serializer:json"`
+	MaxFileSize      int64                 `gorm:"not null"`
+	FollowSymlinks   bool                  `gorm:"not null"`
+	OneFileSystem    bool                  `gorm:"not null"`
+	Compression      string                `gorm:"type:varchar(10);not null;default:'zstd'"`
+	CompressionLevel int                   `gorm:"not null;default:0"` // 0 usa o nível padrão do algoritmo
+	Format           string                `gorm:"type:varchar(10);not null;default:'files'"`
+	Encryption       string                `gorm:"type:varchar(20);not null;default:'aes-256-ctr'"`
+	PreHooks         []BackupHook          `gorm:"serializer:json"`
+	PostHooks        []BackupHook          `gorm:"serializer:json"`
+	Notifications    *ProfileNotifications `gorm:"serializer:json"`
+	Retention        RetentionPolicy       `gorm:"embedded"`
+	CreatedAt        time.Time             `gorm:"autoCreateTime"`
+	UpdatedAt        time.Time             `gorm:"autoUpdateTime"`
+}
+
+// Tipos de ProfileSource
+const (
+	SourceKindSQLite  = "sqlite"  // cópia consistente pela API de backup online
+	SourceKindGit     = "git"     // bundle com todas as refs
+	SourceKindCommand = "command" // saída padrão de um comando aprovado, como pg_dump
+)
+
+// ProfileSource is a source of a profile that is not a plain directory: a
+// SQLite database or git repository under the owner's source root, or the
+// output of a command approved for hooks. Its copy is stored as File at the
+// top of the snapshot.
+type ProfileSource struct {
+	Kind           string   `json:"kind"`
+	Path           string   `json:"path,omitempty"`    // sqlite e git
+	Command        string   `json:"command,omitempty"` // command: nome do comando aprovado
+	Args           []string `json:"args,omitempty"`
+	Env            []string `json:"env,omitempty"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"`
+	File           string   `json:"file"`
+}
+
+// Hook is the command of a command source, as checked and run for hooks
+func (s ProfileSource) Hook() BackupHook {
+	return BackupHook{
+		Command:        s.Command,
+		Args:           s.Args,
+		Env:            s.Env,
+		TimeoutSeconds: s.TimeoutSeconds,
+		OnFailure:      HookOnFailureAbort,
+	}
+}
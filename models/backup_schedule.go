@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Políticas para execuções perdidas enquanto o servidor estava parado
+const (
+	MissedRunCatchUp = "catch_up" // executa uma única vez assim que possível
+	MissedRunSkip    = "skip"     // ignora e espera a próxima ocorrência
+)
+
+// O que um agendamento executa
+const (
+	ScheduleActionBackup = "backup"
+	ScheduleActionVerify = "verify" // verifica o snapshot mais recente da origem
+)
+
+// Estado da última execução de um agendamento
+const (
+	ScheduleStatusRunning = "running"
+	ScheduleStatusSuccess = "success"
+	ScheduleStatusFailed  = "failed"
+	ScheduleStatusSkipped = "skipped"
+)
+
+// BackupSchedule runs a backup profile, or a built-in template, on a cron expression evaluated in Timezone.
+// Verify schedules check the latest snapshot of that source instead of backing it up.
+// NextRunAt is the claim token used by the scheduler: a replica only runs a
+// schedule after atomically moving it to the following occurrence.
+type BackupSchedule struct {
+	ID              uint   `gorm:"primaryKey"`
+	UserID          uint   `gorm:"index;not null"`
+	Name            string `gorm:"not null"`
+	CronExpr        string `gorm:"not null"`
+	Timezone        string `gorm:"not null;default:'UTC'"`
+	BackupType      string // modelo embutido, usado quando ProfileID é nulo
+	ProfileID       *uint  `gorm:"index"`
+	Format          string
+	Action          string          `gorm:"type:varchar(10);not null;default:'backup'"`
+	VerifyExtract   bool            // verificações agendadas também extraem os arquivos
+	MissedRunPolicy string          `gorm:"type:varchar(10);not null;default:'catch_up'"`
+	Retention       RetentionPolicy `gorm:"embedded"`
+	Enabled         bool            `gorm:"not null"`
+	NextRunAt       time.Time       `gorm:"index;not null"`
+	LastRunAt       *time.Time
+	LastStatus      string
+	LastError       string
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Chunk is a piece of file content produced by content-defined chunking,
+// stored once per user as its own encrypted object. Hash is a blind index of
+// the content, so equal chunks are found without storing their hash in clear.
+// RefCount counts the snapshot entries and uploaded files that use the chunk;
+// unreferenced chunks are removed once LastUsedAt is older than a grace period.
+type Chunk struct {
+	ID           uint      `gorm:"primaryKey"`
+	UserID       uint      `gorm:"not null;uniqueIndex:idx_chunks_user_hash"`
+	Hash         string    `gorm:"not null;uniqueIndex:idx_chunks_user_hash"`
+	ObjectID     string    `gorm:"uniqueIndex;not null"`
+	Size         int64     `gorm:"not null"` // tamanho original
+	StoredSize   int64     `gorm:"not null"` // tamanho comprimido e cifrado
+	ObjectSHA256 string    `gorm:"not null"`
+	RefCount     int       `gorm:"index;not null;default:0"`
+	LastUsedAt   time.Time `gorm:"index;not null"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+}
+
+// FileChunk places a chunk in an uploaded file
+type FileChunk struct {
+	ID           uint   `gorm:"primaryKey"`
+	FileObjectID uint   `gorm:"not null;uniqueIndex:idx_file_chunks_position"`
+	Position     int    `gorm:"not null;uniqueIndex:idx_file_chunks_position"`
+	ObjectID     string `gorm:"not null"` // objeto do chunk
+	Size         int64  `gorm:"not null"`
+	ObjectSHA256 string `gorm:"not null"`
+}
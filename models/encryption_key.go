@@ -5,8 +5,11 @@ import (
 )
 
 type EncryptionKey struct {
-	ID        uint      `gorm:"primaryKey"`
-	FilePath  string    `gorm:"uniqueIndex;not null"` // Caminho do arquivo associado à chave
-	Key       string    `gorm:"not null"`             // Chave de criptografia
-	CreatedAt time.Time `gorm:"autoCreateTime"`       // Data de criação
+	ID          uint       `gorm:"primaryKey"`
+	UserID      uint       `gorm:"index"`
+	KeyID       string     `gorm:"index"`
+	FilePath    string     `gorm:"uniqueIndex;not null"` // ID do objeto associado à chave
+	Key         string     `gorm:"not null"`             // Chave do arquivo cifrada com a chave do usuário (base64); vazia após destruição
+	CreatedAt   time.Time  `gorm:"autoCreateTime"`       // Data de criação
+	DestroyedAt *time.Time // Preenchido quando a chave é destruída (crypto-shredding)
 }
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// FileObject maps an opaque storage object ID to the user's encrypted logical file.
+// NameIndex is a blind index over folder and name, so exact lookups work without
+// storing either in clear text.
+type FileObject struct {
+	ID                uint   `gorm:"primaryKey"`
+	UserID            uint   `gorm:"not null;uniqueIndex:idx_file_objects_user_name"`
+	ObjectID          string `gorm:"uniqueIndex;not null"`
+	NameIndex         string `gorm:"not null;uniqueIndex:idx_file_objects_user_name"`
+	EncryptedName     []byte `gorm:"not null"`
+	EncryptedPath     []byte `gorm:"not null"`
+	EncryptedMetadata []byte
+	KeyID             string `gorm:"not null"` // UserKey usado para cifrar os metadados
+	Size              int64
+	CreatedAt         time.Time `gorm:"autoCreateTime"`
+	UpdatedAt         time.Time `gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	DestructionScopeFile = "file"
+	DestructionScopeUser = "user"
+)
+
+// MaxDeletionAttempts is how many times the deletion of an object is tried
+// before it is dead-lettered
+const MaxDeletionAttempts = 12
+
+// DeletionRetryDelay is the wait before the next try after attempts failures:
+// 10 minutes, doubling up to one day
+func DeletionRetryDelay(attempts int) time.Duration {
+	delay := 10 * time.Minute
+	for i := 1; i < attempts && delay < 24*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 24*time.Hour {
+		delay = 24 * time.Hour
+	}
+	return delay
+}
+
+// KeyDestruction is an append-only, hash-chained record of a destroyed key.
+// Each entry commits to the previous one, so removing or altering a record
+// breaks the chain and is detectable.
+type KeyDestruction struct {
+	ID             uint      `gorm:"primaryKey"`
+	UserID         uint      `gorm:"index;not null"`
+	Scope          string    `gorm:"type:varchar(10);not null"`
+	KeyID          string    `gorm:"not null"`
+	ObjectID       string    // vazio quando o escopo é o usuário inteiro
+	KeyFingerprint string    `gorm:"not null"` // SHA-256 da chave cifrada destruída
+	DestroyedAt    time.Time `gorm:"not null"`
+	PrevHash       string    `gorm:"not null"`
+	Hash           string    `gorm:"uniqueIndex;not null"`
+}
+
+// ComputeHash returns the chain hash of the record over its content and PrevHash
+func (k *KeyDestruction) ComputeHash() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%s|%s|%s|%s",
+		k.PrevHash,
+		k.UserID,
+		k.Scope,
+		k.KeyID,
+		k.ObjectID,
+		k.KeyFingerprint,
+		k.DestroyedAt.UTC().Format(time.RFC3339Nano),
+	)))
+	return hex.EncodeToString(sum[:])
+}
+
+// PendingDeletion is a physical object waiting to be removed from the storage
+// backends after its key has been destroyed. Failed deletions are retried
+// with a growing delay; after too many attempts the row is dead-lettered
+// (DeadAt set) and left for an operator.
+type PendingDeletion struct {
+	ID            uint   `gorm:"primaryKey"`
+	UserID        uint   `gorm:"index;not null"`
+	ObjectID      string `gorm:"not null"`
+	Attempts      int    `gorm:"not null;default:0"`
+	LastError     string
+	NextAttemptAt *time.Time `gorm:"index"` // nulo: pronto para a próxima execução
+	DeadAt        *time.Time `gorm:"index"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime"`
+}
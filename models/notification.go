@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// NotificationSettings decides which backup e-mails a user receives: one
+// after each run, depending on how it ended, a warning when a source had no
+// successful backup for StaleAfterDays, and a weekly digest. Profiles may
+// override the per-run and stale choices for their own runs.
+type NotificationSettings struct {
+	ID             uint   `gorm:"primaryKey"`
+	UserID         uint   `gorm:"uniqueIndex;not null"`
+	Email          string // destino; vazio usa o e-mail da conta
+	OnSuccess      bool   `gorm:"not null"`
+	OnPartial      bool   `gorm:"not null"`
+	OnFailure      bool   `gorm:"not null"`
+	StaleAfterDays int    `gorm:"not null"` // 0 desativa o aviso
+	WeeklyDigest   bool   `gorm:"not null"`
+	DigestSentAt   *time.Time
+	// StaleWarnings records, per source, when the last stale warning was sent
+	StaleWarnings map[string]time.Time `gorm:"serializer:json"`
+	UpdatedAt     time.Time            `gorm:"autoUpdateTime"`
+}
+
+// DefaultNotificationSettings are the settings of a user who never changed
+// them: only runs with failed files are reported
+func DefaultNotificationSettings(userID uint) *NotificationSettings {
+	return &NotificationSettings{
+		UserID:    userID,
+		OnPartial: true,
+		OnFailure: true,
+	}
+}
+
+// ProfileNotifications overrides, for the runs of one profile, the
+// notification settings of its owner. Nil fields keep the user's choice.
+type ProfileNotifications struct {
+	OnSuccess      *bool `json:"on_success,omitempty"`
+	OnPartial      *bool `json:"on_partial,omitempty"`
+	OnFailure      *bool `json:"on_failure,omitempty"`
+	StaleAfterDays *int  `json:"stale_after_days,omitempty"`
+}
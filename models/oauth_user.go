@@ -20,7 +20,8 @@ type OAuthUser struct {
 	StorageUsed  int64
 	StorageLimit int64
 	Plan         string
-	Backups      []Backup `gorm:"foreignKey:UserID"`
+	Timezone     string           `gorm:"not null;default:'UTC'"` // fuso dos limites diários de backup
+	Snapshots    []BackupManifest `gorm:"foreignKey:UserID"`
 	AccessToken  string
 	RefreshToken string
 	TokenExpiry  time.Time
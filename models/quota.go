@@ -1,6 +1,9 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type StoragePlan string
 
@@ -17,6 +20,14 @@ type UserQuota struct {
 	Plan   StoragePlan `gorm:"type:varchar(20)"`
 }
 
+// BackupLimits are the backup allowances of a plan
+type BackupLimits struct {
+	BackupsPerDay  int   `json:"backups_per_day"`   // dia no fuso horário do usuário
+	SyncRunsPerDay int   `json:"sync_runs_per_day"` // commits da sincronização contínua do agente
+	ConcurrentRuns int   `json:"concurrent_runs"`   // jobs na fila ou em execução
+	MaxRunSize     int64 `json:"max_run_size"`      // bytes selecionados por execução
+}
+
 var ErrStorageLimitExceeded = fmt.Errorf("limite excedido. Atualize seu plano")
 
 func (u *UserQuota) SetDefaults() {
@@ -27,3 +38,32 @@ func (u *UserQuota) SetDefaults() {
 		u.Limit = 20 * 1024 * 1024 * 1024 // 20GB
 	}
 }
+
+// ParsePlan returns the plan with the given name, ignoring case; unknown
+// names fall back to Free
+func ParsePlan(name string) StoragePlan {
+	if strings.EqualFold(name, string(Premium)) {
+		return Premium
+	}
+	return Free
+}
+
+// BackupLimits returns the backup limits of the plan
+func (p StoragePlan) BackupLimits() BackupLimits {
+	switch p {
+	case Premium:
+		return BackupLimits{
+			BackupsPerDay:  48,
+			SyncRunsPerDay: 2880, // uma a cada 30 segundos em média
+			ConcurrentRuns: 4,
+			MaxRunSize:     45 * 1024 * 1024 * 1024, // 45GB
+		}
+	default:
+		return BackupLimits{
+			BackupsPerDay:  10,
+			SyncRunsPerDay: 720, // uma a cada 2 minutos em média
+			ConcurrentRuns: 1,
+			MaxRunSize:     5 * 1024 * 1024 * 1024, // 5GB
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package models
+
+// RetentionPolicy decides which snapshots of a schedule survive pruning
+// (grandfather-father-son). A snapshot is kept when any rule selects it;
+// a policy with every rule unset keeps everything.
+type RetentionPolicy struct {
+	KeepLast    int    `gorm:"not null;default:0"`
+	KeepDaily   int    `gorm:"not null;default:0"`
+	KeepWeekly  int    `gorm:"not null;default:0"`
+	KeepMonthly int    `gorm:"not null;default:0"`
+	KeepYearly  int    `gorm:"not null;default:0"`
+	KeepWithin  string // duração relativa ao snapshot mais recente, ex: "72h", "30d", "1y"
+}
+
+// IsEmpty reports whether the policy keeps every snapshot
+func (p RetentionPolicy) IsEmpty() bool {
+	return p.KeepLast == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 &&
+		p.KeepMonthly == 0 && p.KeepYearly == 0 && p.KeepWithin == ""
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// UserKey holds a user's root key wrapped with the server master key.
+// File names, folder paths and metadata are encrypted with subkeys derived from it.
+// Once the user sets up a recovery key, a second copy of the root key wrapped
+// with it is kept as well, so exports can be restored without the server.
+type UserKey struct {
+	ID                 uint   `gorm:"primaryKey"`
+	UserID             uint   `gorm:"uniqueIndex;not null"`
+	KeyID              string `gorm:"uniqueIndex;not null"`
+	WrappedKey         []byte `gorm:"not null"`
+	RecoveryKeyID      string // identifica a chave de recuperação, que o servidor não guarda
+	RecoveryWrappedKey []byte // chave raiz cifrada com a chave de recuperação
+	RecoveryCreatedAt  *time.Time
+	CreatedAt          time.Time `gorm:"autoCreateTime"`
+}
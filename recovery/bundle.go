@@ -0,0 +1,144 @@
+package recovery
+
+import (
+	"SafeBox/utils"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrWrongRecoveryKey is returned when the recovery key is not the one the export was made with
+	ErrWrongRecoveryKey = errors.New("recovery key does not match the export")
+	// ErrObjectMissing is returned when an object is neither in the export nor in the objects directory
+	ErrObjectMissing = errors.New("object not found")
+	// ErrObjectCorrupted is returned when a stored object does not match its recorded hash
+	ErrObjectCorrupted = errors.New("object hash mismatch")
+)
+
+// Bundle is an opened export
+type Bundle struct {
+	Header Header
+
+	zr         *zip.ReadCloser
+	objects    map[string]*zip.File
+	objectsDir string
+	info       map[string]Object
+}
+
+// Open reads the header of the export at path. objectsDir, which may be
+// empty, is where objects left out of the export are read from: a copy of
+// the storage bucket holding each object under its ID.
+func Open(path, objectsDir string) (*Bundle, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export: %w", err)
+	}
+	b := &Bundle{
+		zr:         zr,
+		objects:    map[string]*zip.File{},
+		objectsDir: objectsDir,
+		info:       map[string]Object{},
+	}
+
+	var header *zip.File
+	for _, f := range zr.File {
+		if f.Name == headerName {
+			header = f
+		} else if id, ok := strings.CutPrefix(f.Name, objectDir); ok {
+			b.objects[id] = f
+		}
+	}
+	if header == nil {
+		zr.Close()
+		return nil, fmt.Errorf("%s is not a SafeBox export", path)
+	}
+	if err := b.readHeader(header); err != nil {
+		zr.Close()
+		return nil, err
+	}
+	for _, object := range b.Header.Objects {
+		b.info[object.ID] = object
+	}
+	return b, nil
+}
+
+func (b *Bundle) readHeader(f *zip.File) error {
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read export header: %w", err)
+	}
+	defer r.Close()
+
+	if err := json.NewDecoder(r).Decode(&b.Header); err != nil {
+		return fmt.Errorf("failed to read export header: %w", err)
+	}
+	if b.Header.Format != Format {
+		return fmt.Errorf("unknown export format %q", b.Header.Format)
+	}
+	if b.Header.Version > Version {
+		return fmt.Errorf("export version %d is newer than this tool supports (%d)", b.Header.Version, Version)
+	}
+	return nil
+}
+
+// Close releases the export
+func (b *Bundle) Close() error {
+	return b.zr.Close()
+}
+
+// openObject returns a stored object, from the export when it is there and
+// from the objects directory otherwise. Reading it to the end fails when it
+// does not match its recorded hash.
+func (b *Bundle) openObject(id string) (io.ReadCloser, error) {
+	info, ok := b.info[id]
+	if !ok {
+		return nil, fmt.Errorf("object %s is not listed in the export", id)
+	}
+
+	var (
+		object io.ReadCloser
+		err    error
+	)
+	if f, ok := b.objects[id]; ok {
+		object, err = f.Open()
+	} else if b.objectsDir != "" && utils.ValidSourceFile(id) {
+		object, err = os.Open(filepath.Join(b.objectsDir, id))
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%w: %s", ErrObjectMissing, id)
+		}
+	} else {
+		err = fmt.Errorf("%w: %s", ErrObjectMissing, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.SHA256 == "" {
+		return object, nil
+	}
+	return &hashedObject{ReadCloser: object, hash: sha256.New(), want: info.SHA256}, nil
+}
+
+// hashedObject fails at EOF when the object does not match its hash
+type hashedObject struct {
+	io.ReadCloser
+	hash hash.Hash
+	want string
+}
+
+func (h *hashedObject) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(h.hash.Sum(nil)) != h.want {
+		return n, ErrObjectCorrupted
+	}
+	return n, err
+}
@@ -0,0 +1,106 @@
+// Package recovery reads and writes snapshot exports: zip bundles holding the
+// encrypted manifest of a snapshot, the wrapped keys of every object it uses
+// and, optionally, the objects themselves. With the user's recovery key an
+// export can be restored without the server or its database.
+package recovery
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+const (
+	// Format names the export format in its header
+	Format = "safebox-export"
+	// Version is the version of the export format written by this package
+	Version = 1
+
+	headerName = "safebox-export.json"
+	objectDir  = "objects/"
+)
+
+// Header describes an export: the snapshot it holds and every key needed to
+// read it. Keys are still wrapped: object keys with the user's root key, and
+// the root key with the user's recovery key, which is never part of an export.
+type Header struct {
+	Format            string       `json:"format"`
+	Version           int          `json:"version"`
+	UserID            uint         `json:"user_id"`
+	SnapshotID        uint         `json:"snapshot_id"`
+	AppName           string       `json:"app_name"`
+	SnapshotCreatedAt time.Time    `json:"snapshot_created_at"`
+	ExportedAt        time.Time    `json:"exported_at"`
+	FileCount         int          `json:"file_count"`
+	TotalSize         int64        `json:"total_size"`
+	Manifest          ManifestInfo `json:"manifest"`
+	RecoveryKeyID     string       `json:"recovery_key_id"`
+	WrappedRootKey    []byte       `json:"wrapped_root_key"`
+	// ObjectsIncluded is false when the export only references the objects,
+	// which must then be read from a copy of the storage bucket
+	ObjectsIncluded bool     `json:"objects_included"`
+	Objects         []Object `json:"objects"`
+}
+
+// ManifestInfo locates the encrypted manifest of the snapshot and carries
+// what is needed to check it. SignerPublicKey is informative only: a restore
+// checks the signature against the keys pinned from the recovery kit.
+type ManifestInfo struct {
+	ObjectID        string `json:"object_id"`
+	Digest          string `json:"digest"` // SHA-256 do manifesto decifrado
+	SignerType      string `json:"signer_type"`
+	SignerKeyID     string `json:"signer_key_id"`
+	SignerPublicKey []byte `json:"signer_public_key"`
+	Signature       []byte `json:"signature"`
+}
+
+// Object is a stored object the snapshot uses, the manifest included
+type Object struct {
+	ID         string `json:"id"`
+	SHA256     string `json:"sha256,omitempty"`      // hash do objeto armazenado; vazio para o manifesto
+	WrappedKey []byte `json:"wrapped_key,omitempty"` // vazio quando a chave foi destruída
+}
+
+// Writer writes an export. The header must be written first, followed by
+// the objects when they are included.
+type Writer struct {
+	zw *zip.Writer
+}
+
+// NewWriter returns a Writer streaming the export to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w)}
+}
+
+// WriteHeader writes the header of the export
+func (w *Writer) WriteHeader(header *Header) error {
+	header.Format = Format
+	header.Version = Version
+	body, err := json.MarshalIndent(header, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := w.zw.CreateHeader(&zip.FileHeader{Name: headerName, Method: zip.Deflate, Modified: header.ExportedAt})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(body)
+	return err
+}
+
+// WriteObject adds a stored object to the export as it is, still compressed
+// and encrypted
+func (w *Writer) WriteObject(id string, content io.Reader) (int64, error) {
+	// Objetos cifrados não comprimem; são guardados sem deflate
+	f, err := w.zw.CreateHeader(&zip.FileHeader{Name: objectDir + id, Method: zip.Store})
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(f, content)
+}
+
+// Close finishes the export
+func (w *Writer) Close() error {
+	return w.zw.Close()
+}
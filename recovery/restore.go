@@ -0,0 +1,485 @@
+package recovery
+
+import (
+	"SafeBox/utils"
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrManifestInvalid is returned when the manifest of the export does not match its digest or signature
+	ErrManifestInvalid = errors.New("manifest verification failed")
+	// ErrKeyDestroyed is returned for objects whose key was destroyed before the export
+	ErrKeyDestroyed = errors.New("encryption key has been destroyed")
+	// ErrUntrustedSigner is returned when the manifest was not signed with one of the pinned keys
+	ErrUntrustedSigner = errors.New("manifest signed with an untrusted key")
+
+	errSizeMismatch = errors.New("content size mismatch")
+	errHashMismatch = errors.New("content hash mismatch")
+)
+
+// manifest is the part of a snapshot manifest a restore needs. It mirrors
+// services.Manifest, which cannot be imported without the server's dependencies.
+type manifest struct {
+	Version int     `json:"version"`
+	UserID  uint    `json:"user_id"`
+	AppName string  `json:"app_name"`
+	Entries []entry `json:"entries"`
+}
+
+type entry struct {
+	Path        string    `json:"path"`
+	ObjectID    string    `json:"object_id"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	ArchivePath string    `json:"archive_path,omitempty"`
+	ArchiveLink string    `json:"archive_link,omitempty"`
+	Chunks      []chunk   `json:"chunks,omitempty"`
+	ModTime     time.Time `json:"mod_time,omitempty"`
+}
+
+type chunk struct {
+	ObjectID string `json:"object_id"`
+	Size     int64  `json:"size"`
+}
+
+// chunked reports whether the entry is stored as a chunk list, as in services.ManifestEntry
+func (e entry) chunked() bool {
+	return e.ObjectID == ""
+}
+
+// archiveSource is the path inside the tar archive holding the content of
+// the entry, as in services.ManifestEntry
+func (e entry) archiveSource() string {
+	if e.ArchiveLink != "" {
+		return e.ArchiveLink
+	}
+	return e.ArchivePath
+}
+
+// name is the path of the entry inside the restored tree, the same the
+// server restore uses
+func (e entry) name() string {
+	return strings.TrimPrefix(filepath.ToSlash(e.Path), "backups/")
+}
+
+// FileResult is the outcome of restoring a single file
+type FileResult struct {
+	Path  string `json:"path"`
+	OK    bool   `json:"ok"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// Result summarizes an offline restore
+type Result struct {
+	SnapshotID   uint         `json:"snapshot_id"`
+	Target       string       `json:"target"`
+	SuccessCount int          `json:"success_count"`
+	FailedCount  int          `json:"failed_count"`
+	Files        []FileResult `json:"files"`
+}
+
+// Restore decrypts the snapshot of the export with the user's recovery key
+// and writes its files below target. The manifest must be signed with one of
+// signingKeys, the keys listed in the recovery kit: the key the export
+// carries is never trusted, since whoever edits the export can replace it.
+// An empty paths restores every file; a path also selects everything below
+// it. Files that cannot be restored are reported in the result without
+// stopping the others.
+func (b *Bundle) Restore(ctx context.Context, recoveryKey []byte, signingKeys []ed25519.PublicKey, target string, paths []string) (*Result, error) {
+	rootKey, err := b.unlock(recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	m, err := b.openManifest(rootKey, signingKeys)
+	if err != nil {
+		return nil, err
+	}
+	entries := selectEntries(m.Entries, paths)
+	if len(entries) == 0 {
+		return nil, errors.New("no files match the requested paths")
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+
+	result := &Result{SnapshotID: b.Header.SnapshotID, Target: target}
+	var order []string
+	byObject := map[string][]entry{}
+	for _, e := range entries {
+		if e.chunked() {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			content := &chunkReader{bundle: b, rootKey: rootKey, chunks: e.Chunks}
+			result.add(e, writeFile(target, e, e.ModTime, content))
+			content.Close()
+			continue
+		}
+		if _, ok := byObject[e.ObjectID]; !ok {
+			order = append(order, e.ObjectID)
+		}
+		byObject[e.ObjectID] = append(byObject[e.ObjectID], e)
+	}
+
+	for _, objectID := range order {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		group := byObject[objectID]
+		if group[0].ArchivePath != "" {
+			b.restoreArchive(rootKey, target, group, result)
+			continue
+		}
+		for _, e := range group {
+			b.restoreFile(rootKey, target, e, result)
+		}
+	}
+	return result, nil
+}
+
+// unlock checks the recovery key and returns the root key of the user
+func (b *Bundle) unlock(recoveryKey []byte) ([]byte, error) {
+	if utils.RecoveryKeyID(recoveryKey) != b.Header.RecoveryKeyID {
+		return nil, ErrWrongRecoveryKey
+	}
+	rootKey, err := utils.UnwrapRootKey(recoveryKey, b.Header.WrappedRootKey, b.Header.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWrongRecoveryKey, err)
+	}
+	return rootKey, nil
+}
+
+// openManifest decrypts the manifest, then checks it against the digest and
+// a signature made with one of the pinned signing keys
+func (b *Bundle) openManifest(rootKey []byte, signingKeys []ed25519.PublicKey) (*manifest, error) {
+	info := b.Header.Manifest
+	var signer ed25519.PublicKey
+	for _, key := range signingKeys {
+		if SigningKeyID(key) == info.SignerKeyID {
+			signer = key
+			break
+		}
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("%w: key %s is not pinned", ErrUntrustedSigner, info.SignerKeyID)
+	}
+	// Mesma carga assinada por services.SigningPayload
+	if !ed25519.Verify(signer, []byte("safebox-manifest-v1:"+info.Digest), info.Signature) {
+		return nil, fmt.Errorf("%w: invalid signature", ErrManifestInvalid)
+	}
+
+	plain, closeObject, err := b.openPlain(rootKey, info.ObjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer closeObject()
+	var body bytes.Buffer
+	if _, err := io.Copy(&body, plain); err != nil {
+		return nil, fmt.Errorf("failed to decrypt manifest: %w", err)
+	}
+	digest := sha256.Sum256(body.Bytes())
+	if hex.EncodeToString(digest[:]) != info.Digest {
+		return nil, fmt.Errorf("%w: digest mismatch", ErrManifestInvalid)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(body.Bytes(), &m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if m.UserID != b.Header.UserID {
+		return nil, fmt.Errorf("%w: owner mismatch", ErrManifestInvalid)
+	}
+	return &m, nil
+}
+
+// SigningKeyID is the fingerprint of a signing key, the same the server
+// records as the signer of a manifest
+func SigningKeyID(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParseSigningKey reads a signing key in the hex form of the recovery kit
+func ParseSigningKey(text string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("signing key must be %d hex-encoded bytes", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// openPlain opens an object and decrypts it with its key
+func (b *Bundle) openPlain(rootKey []byte, objectID string) (io.Reader, func() error, error) {
+	info, ok := b.info[objectID]
+	if !ok {
+		return nil, nil, fmt.Errorf("object %s is not listed in the export", objectID)
+	}
+	if len(info.WrappedKey) == 0 {
+		return nil, nil, ErrKeyDestroyed
+	}
+	key, err := utils.UnwrapFileKey(rootKey, info.WrappedKey, objectID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unwrap key of object %s: %w", objectID, err)
+	}
+
+	object, err := b.openObject(objectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := utils.DecryptReader(object, key)
+	if err != nil {
+		object.Close()
+		return nil, nil, fmt.Errorf("failed to decrypt object %s: %w", objectID, err)
+	}
+	return plain, object.Close, nil
+}
+
+// restoreFile restores an entry stored as its own object
+func (b *Bundle) restoreFile(rootKey []byte, target string, e entry, result *Result) {
+	plain, closeObject, err := b.openPlain(rootKey, e.ObjectID)
+	if err != nil {
+		result.add(e, err)
+		return
+	}
+	defer closeObject()
+
+	dec, err := utils.DecompressAuto(plain)
+	if err != nil {
+		result.add(e, err)
+		return
+	}
+	defer dec.Close()
+	result.add(e, writeFile(target, e, e.ModTime, dec))
+}
+
+// restoreArchive restores the entries of a tar archive object in a single
+// pass over the archive. Hard links to the same content are restored as
+// links to the first of them.
+func (b *Bundle) restoreArchive(rootKey []byte, target string, group []entry, result *Result) {
+	wanted := make(map[string][]entry, len(group))
+	for _, e := range group {
+		wanted[e.archiveSource()] = append(wanted[e.archiveSource()], e)
+	}
+	failAll := func(err error) {
+		for _, entries := range wanted {
+			for _, e := range entries {
+				result.add(e, err)
+			}
+		}
+	}
+
+	plain, closeObject, err := b.openPlain(rootKey, group[0].ObjectID)
+	if err != nil {
+		failAll(err)
+		return
+	}
+	defer closeObject()
+	tr, dec, err := utils.OpenTarArchive(plain)
+	if err != nil {
+		failAll(err)
+		return
+	}
+	defer dec.Close()
+
+	for len(wanted) > 0 {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			failAll(fmt.Errorf("failed to read archive: %w", err))
+			return
+		}
+		entries, ok := wanted[header.Name]
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		delete(wanted, header.Name)
+
+		first := entries[0]
+		err = writeFile(target, first, header.ModTime, tr)
+		result.add(first, err)
+		for _, e := range entries[1:] {
+			if err != nil {
+				result.add(e, errors.New("linked file could not be restored"))
+				continue
+			}
+			result.add(e, linkFile(target, e, first))
+		}
+	}
+	failAll(errors.New("file missing from archive"))
+}
+
+func (r *Result) add(e entry, err error) {
+	file := FileResult{Path: e.name(), Size: e.Size, OK: err == nil}
+	if err != nil {
+		file.Error = err.Error()
+		r.FailedCount++
+	} else {
+		r.SuccessCount++
+	}
+	r.Files = append(r.Files, file)
+}
+
+// writeFile writes one file below root through a temporary name, which is
+// only renamed into place once its size and hash match the manifest
+func writeFile(root string, e entry, modTime time.Time, content io.Reader) error {
+	target, err := utils.SafeJoin(root, e.name())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	// Lê um byte além do tamanho registrado para detectar conteúdo maior
+	written, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(content, e.Size+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if written != e.Size {
+		return errSizeMismatch
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != e.SHA256 {
+		return errHashMismatch
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		return os.Chtimes(target, modTime, modTime)
+	}
+	return nil
+}
+
+// linkFile restores e as a hard link to the already restored file existing
+func linkFile(root string, e, existing entry) error {
+	target, err := utils.SafeJoin(root, e.name())
+	if err != nil {
+		return err
+	}
+	source, err := utils.SafeJoin(root, existing.name())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(source, target)
+}
+
+// selectEntries returns the entries matching the requested paths, given
+// relative to the restored tree
+func selectEntries(entries []entry, paths []string) []entry {
+	if len(paths) == 0 {
+		return entries
+	}
+	var selected []entry
+	for _, e := range entries {
+		name := e.name()
+		for _, p := range paths {
+			p = strings.Trim(path.Clean("/"+filepath.ToSlash(p)), "/")
+			if p == "" || name == p || strings.HasPrefix(name, p+"/") {
+				selected = append(selected, e)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// chunkReader reads the chunks of a file one after the other, failing when a
+// chunk does not have its recorded size
+type chunkReader struct {
+	bundle  *Bundle
+	rootKey []byte
+	chunks  []chunk
+	current io.Reader
+	close   func() error
+	n       int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.EOF
+			}
+			if err := r.open(r.chunks[0]); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.current.Read(p)
+		r.n += int64(n)
+		if r.n > r.chunks[0].Size {
+			return n, fmt.Errorf("chunk %s is larger than recorded", r.chunks[0].ObjectID)
+		}
+		if err == io.EOF {
+			if r.n != r.chunks[0].Size {
+				return n, fmt.Errorf("chunk %s is smaller than recorded", r.chunks[0].ObjectID)
+			}
+			r.Close()
+			r.chunks = r.chunks[1:]
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) open(c chunk) error {
+	plain, closeObject, err := r.bundle.openPlain(r.rootKey, c.ObjectID)
+	if err != nil {
+		return err
+	}
+	dec, err := utils.DecompressAuto(plain)
+	if err != nil {
+		closeObject()
+		return fmt.Errorf("failed to decompress chunk %s: %w", c.ObjectID, err)
+	}
+	r.current, r.n = dec, 0
+	r.close = func() error {
+		dec.Close()
+		return closeObject()
+	}
+	return nil
+}
+
+func (r *chunkReader) Close() error {
+	var err error
+	if r.close != nil {
+		err = r.close()
+	}
+	r.current, r.close = nil, nil
+	return err
+}
@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"SafeBox/models"
+	"context"
 	"time"
 
 	"gorm.io/gorm"
@@ -15,17 +16,149 @@ func NewBackupRepository(db *gorm.DB) *BackupRepository {
 	return &BackupRepository{db: db}
 }
 
-func (r *BackupRepository) CreateBackup(backup *models.Backup) error {
-	return r.db.Create(backup).Error
-}
-
 func (r *BackupRepository) CreateBackupHistory(history *models.BackupHistory) error {
 	return r.db.Create(history).Error
 }
 
-func (r *BackupRepository) CountUserBackupsToday(userID uint) (int64, error) {
-	var count int64
-	startOfDay := time.Now().Truncate(24 * time.Hour)
-	err := r.db.Model(&models.Backup{}).Where("user_id = ? AND created_at >= ?", userID, startOfDay).Count(&count).Error
-	return count, err
+// CreateRun stores the summary of a backup run together with its per-file items
+func (r *BackupRepository) CreateRun(ctx context.Context, history *models.BackupHistory, items []models.BackupItem) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(history).Error; err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		for i := range items {
+			items[i].HistoryID = history.ID
+			items[i].UserID = history.UserID
+		}
+		return tx.CreateInBatches(items, 500).Error
+	})
+}
+
+// FindHistory returns a history record owned by the user
+func (r *BackupRepository) FindHistory(ctx context.Context, userID, id uint) (*models.BackupHistory, error) {
+	var history models.BackupHistory
+	if err := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&history).Error; err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// FindJobHistory returns the summary recorded by a backup job
+func (r *BackupRepository) FindJobHistory(ctx context.Context, userID, jobID uint) (*models.BackupHistory, error) {
+	var history models.BackupHistory
+	err := r.db.WithContext(ctx).
+		Where("job_id = ? AND user_id = ?", jobID, userID).
+		Order("id DESC").
+		First(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// ListHistory returns the most recent operations of the user
+func (r *BackupRepository) ListHistory(ctx context.Context, userID uint, limit int) ([]models.BackupHistory, error) {
+	var history []models.BackupHistory
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&history).Error
+	return history, err
+}
+
+// ListItems returns the files of a run, optionally only those with the given status
+func (r *BackupRepository) ListItems(ctx context.Context, userID, historyID uint, status string) ([]models.BackupItem, error) {
+	query := r.db.WithContext(ctx).Where("history_id = ? AND user_id = ?", historyID, userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var items []models.BackupItem
+	err := query.Order("path").Find(&items).Error
+	return items, err
+}
+
+// SourceActivity is when the backups of one source ran and last succeeded
+type SourceActivity struct {
+	AppName     string
+	FirstRun    time.Time
+	LastRun     time.Time
+	LastSuccess *time.Time
+}
+
+// SourceActivity returns, per source of the user, its first and last backup
+// run and its last successful one. Restores and verifications do not count.
+func (r *BackupRepository) SourceActivity(ctx context.Context, userID uint) ([]SourceActivity, error) {
+	var activity []SourceActivity
+	err := r.db.WithContext(ctx).Model(&models.BackupHistory{}).
+		Select("app_name, MIN(backup_date) AS first_run, MAX(backup_date) AS last_run, MAX(CASE WHEN status = ? THEN backup_date END) AS last_success", models.HistoryStatusSuccess).
+		Where("user_id = ? AND backup_mode NOT IN ?", userID, []string{models.BackupModeRestore, models.BackupModeVerify}).
+		Group("app_name").
+		Order("app_name").
+		Scan(&activity).Error
+	return activity, err
+}
+
+// ListRunsSince returns the backup runs of the user from since on, oldest first
+func (r *BackupRepository) ListRunsSince(ctx context.Context, userID uint, since time.Time) ([]models.BackupHistory, error) {
+	var runs []models.BackupHistory
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND backup_date >= ? AND backup_mode NOT IN ?", userID, since, []string{models.BackupModeRestore, models.BackupModeVerify}).
+		Order("backup_date").
+		Find(&runs).Error
+	return runs, err
+}
+
+// CreateAgentRestore records a restore for the agent to pull
+func (r *BackupRepository) CreateAgentRestore(ctx context.Context, restore *models.AgentRestore) error {
+	return r.db.WithContext(ctx).Create(restore).Error
+}
+
+// OpenAgentRestores returns the restores of the user the agent has not finished, oldest first
+func (r *BackupRepository) OpenAgentRestores(ctx context.Context, userID uint) ([]models.AgentRestore, error) {
+	var restores []models.AgentRestore
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND status IN ?", userID, []string{models.AgentRestorePending, models.AgentRestoreRunning}).
+		Order("id").
+		Find(&restores).Error
+	return restores, err
+}
+
+// FindAgentRestore returns a restore owned by the user
+func (r *BackupRepository) FindAgentRestore(ctx context.Context, userID, id uint) (*models.AgentRestore, error) {
+	var restore models.AgentRestore
+	if err := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&restore).Error; err != nil {
+		return nil, err
+	}
+	return &restore, nil
+}
+
+// StartAgentRestore moves a pending restore to running. A restore already
+// running may be pulled again, for instance after a dropped connection.
+func (r *BackupRepository) StartAgentRestore(ctx context.Context, restore *models.AgentRestore) error {
+	err := r.db.WithContext(ctx).Model(&models.AgentRestore{}).
+		Where("id = ? AND status = ?", restore.ID, models.AgentRestorePending).
+		Update("status", models.AgentRestoreRunning).Error
+	if err == nil {
+		restore.Status = models.AgentRestoreRunning
+	}
+	return err
+}
+
+// FinishAgentRestore stores the outcome of a restore that is still open.
+// Only one report wins; it returns false when the restore was already finished.
+func (r *BackupRepository) FinishAgentRestore(ctx context.Context, restore *models.AgentRestore) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.AgentRestore{}).
+		Where("id = ? AND status IN ?", restore.ID, []string{models.AgentRestorePending, models.AgentRestoreRunning}).
+		Updates(map[string]interface{}{
+			"status":        restore.Status,
+			"success_count": restore.SuccessCount,
+			"failed_count":  restore.FailedCount,
+			"error":         restore.Error,
+			"updated_at":    time.Now(),
+		})
+	return result.RowsAffected > 0, result.Error
 }
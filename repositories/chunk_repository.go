@@ -0,0 +1,167 @@
+package repositories
+
+import (
+	"SafeBox/models"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ChunkRepository struct {
+	db *gorm.DB
+}
+
+func NewChunkRepository(db *gorm.DB) *ChunkRepository {
+	return &ChunkRepository{db: db}
+}
+
+func (r *ChunkRepository) Create(ctx context.Context, chunk *models.Chunk) error {
+	return r.db.WithContext(ctx).Create(chunk).Error
+}
+
+// FindByHash returns the chunk of the user with the given content index
+func (r *ChunkRepository) FindByHash(ctx context.Context, userID uint, hash string) (*models.Chunk, error) {
+	var chunk models.Chunk
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND hash = ?", userID, hash).First(&chunk).Error; err != nil {
+		return nil, err
+	}
+	return &chunk, nil
+}
+
+// FindByObjectIDs returns the chunks of the user stored under the given objects
+func (r *ChunkRepository) FindByObjectIDs(ctx context.Context, userID uint, objectIDs []string) ([]models.Chunk, error) {
+	var chunks []models.Chunk
+	// Em lotes, para ficar abaixo do limite de parâmetros do Postgres
+	for start := 0; start < len(objectIDs); start += 1000 {
+		end := min(start+1000, len(objectIDs))
+		var batch []models.Chunk
+		err := r.db.WithContext(ctx).Where("user_id = ? AND object_id IN ?", userID, objectIDs[start:end]).Find(&batch).Error
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, batch...)
+	}
+	return chunks, nil
+}
+
+// Touch marks a chunk as just used, protecting it from the sweep for a grace period
+func (r *ChunkRepository) Touch(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Model(&models.Chunk{}).
+		Where("id = ?", id).
+		Update("last_used_at", time.Now()).Error
+}
+
+// TouchObjects marks the chunks of the user stored under the given objects as just used
+func (r *ChunkRepository) TouchObjects(ctx context.Context, userID uint, objectIDs []string) error {
+	for start := 0; start < len(objectIDs); start += 1000 {
+		end := min(start+1000, len(objectIDs))
+		err := r.db.WithContext(ctx).Model(&models.Chunk{}).
+			Where("user_id = ? AND object_id IN ?", userID, objectIDs[start:end]).
+			Update("last_used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddRefs changes the reference count of chunks, by object ID, in a single transaction
+func (r *ChunkRepository) AddRefs(ctx context.Context, userID uint, deltas map[string]int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return addChunkRefs(tx, userID, deltas)
+	})
+}
+
+// Unreferenced returns chunks without references that were last used before the given time
+func (r *ChunkRepository) Unreferenced(ctx context.Context, before time.Time, limit int) ([]models.Chunk, error) {
+	var chunks []models.Chunk
+	err := r.db.WithContext(ctx).
+		Where("ref_count <= 0 AND last_used_at < ?", before).
+		Order("id").
+		Limit(limit).
+		Find(&chunks).Error
+	return chunks, err
+}
+
+// DeleteUnreferenced removes a chunk record only if it is still unreferenced
+// and unused since before, so a backup that just found it keeps it
+func (r *ChunkRepository) DeleteUnreferenced(ctx context.Context, id uint, before time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Where("id = ? AND ref_count <= 0 AND last_used_at < ?", id, before).
+		Delete(&models.Chunk{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// AttachFile stores the chunk list of an uploaded file and references its chunks
+func (r *ChunkRepository) AttachFile(ctx context.Context, userID uint, chunks []models.FileChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	deltas := map[string]int{}
+	for _, chunk := range chunks {
+		deltas[chunk.ObjectID]++
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(chunks, 500).Error; err != nil {
+			return err
+		}
+		return addChunkRefs(tx, userID, deltas)
+	})
+}
+
+// FileChunks returns the chunks of an uploaded file in order
+func (r *ChunkRepository) FileChunks(ctx context.Context, fileObjectID uint) ([]models.FileChunk, error) {
+	var chunks []models.FileChunk
+	err := r.db.WithContext(ctx).
+		Where("file_object_id = ?", fileObjectID).
+		Order("position").
+		Find(&chunks).Error
+	return chunks, err
+}
+
+// DetachFile removes the chunk list of a file and releases its chunks
+func (r *ChunkRepository) DetachFile(ctx context.Context, userID, fileObjectID uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var chunks []models.FileChunk
+		if err := tx.Where("file_object_id = ?", fileObjectID).Find(&chunks).Error; err != nil {
+			return err
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+		deltas := map[string]int{}
+		for _, chunk := range chunks {
+			deltas[chunk.ObjectID]--
+		}
+		if err := tx.Where("file_object_id = ?", fileObjectID).Delete(&models.FileChunk{}).Error; err != nil {
+			return err
+		}
+		return addChunkRefs(tx, userID, deltas)
+	})
+}
+
+func addChunkRefs(tx *gorm.DB, userID uint, deltas map[string]int) error {
+	// Ordem fixa para que transações concorrentes travem as linhas na mesma sequência
+	objectIDs := make([]string, 0, len(deltas))
+	for objectID := range deltas {
+		objectIDs = append(objectIDs, objectID)
+	}
+	sort.Strings(objectIDs)
+
+	for _, objectID := range objectIDs {
+		result := tx.Model(&models.Chunk{}).
+			Where("user_id = ? AND object_id = ?", userID, objectID).
+			Update("ref_count", gorm.Expr("ref_count + ?", deltas[objectID]))
+		if result.Error != nil {
+			return result.Error
+		}
+		// Referenciar um chunk já varrido deixaria um snapshot sem dados
+		if result.RowsAffected == 0 && deltas[objectID] > 0 {
+			return fmt.Errorf("chunk %s: %w", objectID, gorm.ErrRecordNotFound)
+		}
+	}
+	return nil
+}
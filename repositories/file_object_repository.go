@@ -0,0 +1,54 @@
+package repositories
+
+import (
+	"SafeBox/models"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type FileObjectRepository struct {
+	db *gorm.DB
+}
+
+func NewFileObjectRepository(db *gorm.DB) *FileObjectRepository {
+	return &FileObjectRepository{db: db}
+}
+
+func (r *FileObjectRepository) Create(ctx context.Context, obj *models.FileObject) error {
+	return r.db.WithContext(ctx).Create(obj).Error
+}
+
+func (r *FileObjectRepository) Update(ctx context.Context, obj *models.FileObject) error {
+	return r.db.WithContext(ctx).Save(obj).Error
+}
+
+func (r *FileObjectRepository) FindByObjectID(ctx context.Context, userID uint, objectID string) (*models.FileObject, error) {
+	var obj models.FileObject
+	err := r.db.WithContext(ctx).Where("user_id = ? AND object_id = ?", userID, objectID).First(&obj).Error
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (r *FileObjectRepository) FindByNameIndex(ctx context.Context, userID uint, nameIndex string) (*models.FileObject, error) {
+	var obj models.FileObject
+	err := r.db.WithContext(ctx).Where("user_id = ? AND name_index = ?", userID, nameIndex).First(&obj).Error
+	if err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+func (r *FileObjectRepository) ListByUser(ctx context.Context, userID uint) ([]models.FileObject, error) {
+	var objs []models.FileObject
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&objs).Error; err != nil {
+		return nil, err
+	}
+	return objs, nil
+}
+
+func (r *FileObjectRepository) Delete(ctx context.Context, obj *models.FileObject) error {
+	return r.db.WithContext(ctx).Delete(obj).Error
+}
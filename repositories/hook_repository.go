@@ -0,0 +1,45 @@
+package repositories
+
+import (
+	"SafeBox/models"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type HookRepository struct {
+	db *gorm.DB
+}
+
+func NewHookRepository(db *gorm.DB) *HookRepository {
+	return &HookRepository{db: db}
+}
+
+func (r *HookRepository) Create(ctx context.Context, command *models.HookCommand) error {
+	return r.db.WithContext(ctx).Create(command).Error
+}
+
+func (r *HookRepository) Delete(ctx context.Context, name string) error {
+	result := r.db.WithContext(ctx).Where("name = ?", name).Delete(&models.HookCommand{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *HookRepository) FindByName(ctx context.Context, name string) (*models.HookCommand, error) {
+	var command models.HookCommand
+	if err := r.db.WithContext(ctx).Where("name = ?", name).First(&command).Error; err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+func (r *HookRepository) List(ctx context.Context) ([]models.HookCommand, error) {
+	var commands []models.HookCommand
+	err := r.db.WithContext(ctx).Order("name").Find(&commands).Error
+	return commands, err
+}
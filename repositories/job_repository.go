@@ -0,0 +1,215 @@
+package repositories
+
+import (
+	"SafeBox/models"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type JobRepository struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) *JobRepository {
+	return &JobRepository{db: db}
+}
+
+func (r *JobRepository) Create(ctx context.Context, job *models.BackupJob) error {
+	return r.db.WithContext(ctx).Create(job).Error
+}
+
+func (r *JobRepository) Update(ctx context.Context, job *models.BackupJob) error {
+	return r.db.WithContext(ctx).Save(job).Error
+}
+
+func (r *JobRepository) FindByID(ctx context.Context, userID, id uint) (*models.BackupJob, error) {
+	var job models.BackupJob
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND id = ?", userID, id).First(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ListByUser returns the most recent jobs of the user, newest first
+func (r *JobRepository) ListByUser(ctx context.Context, userID uint, limit int) ([]models.BackupJob, error) {
+	var jobs []models.BackupJob
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// HasActive reports whether the user has a queued or running job for source.
+// It is only a fast path: the partial unique index on (user_id, source)
+// rejects a second active job created concurrently.
+func (r *JobRepository) HasActive(ctx context.Context, userID uint, source string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.BackupJob{}).
+		Where("user_id = ? AND source = ? AND status IN ?", userID, source, []string{models.JobStatusQueued, models.JobStatusRunning}).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CountActive counts the queued and running jobs of the user, of any source
+func (r *JobRepository) CountActive(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.BackupJob{}).
+		Where("user_id = ? AND status IN ?", userID, []string{models.JobStatusQueued, models.JobStatusRunning}).
+		Count(&count).Error
+	return count, err
+}
+
+// Reserve runs fn in a transaction holding a lock on the user's row, so the
+// backup limits fn checks cannot be passed by a concurrent request of the same
+// user. fn gets a repository bound to the transaction and the locked user.
+func (r *JobRepository) Reserve(ctx context.Context, userID uint, fn func(jobs *JobRepository, user *models.OAuthUser) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.OAuthUser
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		return fn(&JobRepository{db: tx}, &user)
+	})
+}
+
+// AddSubmission records a backup request accepted under the daily limit
+func (r *JobRepository) AddSubmission(ctx context.Context, submission *models.BackupSubmission) error {
+	return r.db.WithContext(ctx).Create(submission).Error
+}
+
+// CountSubmissionsSince counts the backup requests of the user accepted from
+// since on. sync selects the runs of continuous sync instead of the others.
+func (r *JobRepository) CountSubmissionsSince(ctx context.Context, userID uint, since time.Time, sync bool) (int64, error) {
+	var count int64
+	query := r.db.WithContext(ctx).Model(&models.BackupSubmission{}).
+		Where("user_id = ? AND created_at >= ?", userID, since)
+	if sync {
+		query = query.Where("mode = ?", models.BackupModeSync)
+	} else {
+		query = query.Where("mode <> ?", models.BackupModeSync)
+	}
+	err := query.Count(&count).Error
+	return count, err
+}
+
+// AddAgentRun records a run admitted for the agent
+func (r *JobRepository) AddAgentRun(ctx context.Context, run *models.AgentRun) error {
+	return r.db.WithContext(ctx).Create(run).Error
+}
+
+// FindAgentRun returns a run of the user whose snapshot was not committed yet
+func (r *JobRepository) FindAgentRun(ctx context.Context, userID uint, id string) (*models.AgentRun, error) {
+	var run models.AgentRun
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND id = ? AND committed_at IS NULL", userID, id).First(&run).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// ConsumeAgentRun marks a run as committed. Only one caller wins, so a run ID
+// commits a single snapshot even when the agent sends it twice.
+func (r *JobRepository) ConsumeAgentRun(ctx context.Context, run *models.AgentRun) (bool, error) {
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&models.AgentRun{}).
+		Where("id = ? AND user_id = ? AND committed_at IS NULL", run.ID, run.UserID).
+		Update("committed_at", now)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	run.CommittedAt = &now
+	return true, nil
+}
+
+// ReleaseAgentRun undoes ConsumeAgentRun when the snapshot could not be stored,
+// so the agent may commit it again
+func (r *JobRepository) ReleaseAgentRun(ctx context.Context, run *models.AgentRun) error {
+	return r.db.WithContext(ctx).Model(&models.AgentRun{}).
+		Where("id = ? AND user_id = ?", run.ID, run.UserID).
+		Update("committed_at", nil).Error
+}
+
+// SaveProgress stores the counters of a running job without touching the
+// action another replica may have requested in the meantime
+func (r *JobRepository) SaveProgress(ctx context.Context, job *models.BackupJob) error {
+	return r.db.WithContext(ctx).Omit("requested_action").Save(job).Error
+}
+
+// RequestAction asks the replica running an active job to pause or cancel it.
+// It returns false when the job is not active.
+func (r *JobRepository) RequestAction(ctx context.Context, userID, id uint, action string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.BackupJob{}).
+		Where("user_id = ? AND id = ? AND status IN ?", userID, id, []string{models.JobStatusQueued, models.JobStatusRunning}).
+		Update("requested_action", action)
+	return result.RowsAffected > 0, result.Error
+}
+
+// RequestedAction returns the action requested for a job, if any
+func (r *JobRepository) RequestedAction(ctx context.Context, id uint) (string, error) {
+	var job models.BackupJob
+	err := r.db.WithContext(ctx).Select("requested_action").Where("id = ?", id).First(&job).Error
+	return job.RequestedAction, err
+}
+
+// Claim moves a job from one of the given statuses to queued and counts the
+// attempt. Only one caller wins, so a job is never resumed twice. When before
+// is set the job must also have stopped reporting progress before it.
+func (r *JobRepository) Claim(ctx context.Context, job *models.BackupJob, from []string, before *time.Time) (bool, error) {
+	query := r.db.WithContext(ctx).Model(&models.BackupJob{}).Where("id = ? AND status IN ?", job.ID, from)
+	if before != nil {
+		query = query.Where("updated_at < ?", *before)
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":           models.JobStatusQueued,
+		"requested_action": "",
+		"attempts":         gorm.Expr("attempts + 1"),
+		"updated_at":       time.Now(),
+	})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	return true, r.db.WithContext(ctx).First(job, job.ID).Error
+}
+
+// Stale returns active jobs that stopped reporting progress before the given time
+func (r *JobRepository) Stale(ctx context.Context, before time.Time, limit int) ([]models.BackupJob, error) {
+	var jobs []models.BackupJob
+	err := r.db.WithContext(ctx).
+		Where("status IN ? AND updated_at < ?", []string{models.JobStatusQueued, models.JobStatusRunning}, before).
+		Order("id").
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// FailStale marks an abandoned job as failed unless another replica already took it
+func (r *JobRepository) FailStale(ctx context.Context, id uint, before time.Time, reason string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.BackupJob{}).
+		Where("id = ? AND status IN ? AND updated_at < ?", id, []string{models.JobStatusQueued, models.JobStatusRunning}, before).
+		Updates(map[string]interface{}{
+			"status":       models.JobStatusFailed,
+			"error":        reason,
+			"current_file": "",
+			"finished_at":  time.Now(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *JobRepository) AddCheckpoint(ctx context.Context, checkpoint *models.BackupCheckpoint) error {
+	return r.db.WithContext(ctx).Create(checkpoint).Error
+}
+
+// Checkpoints returns the checkpoint batches of a job in the order they were written
+func (r *JobRepository) Checkpoints(ctx context.Context, jobID uint) ([]models.BackupCheckpoint, error) {
+	var checkpoints []models.BackupCheckpoint
+	err := r.db.WithContext(ctx).Where("job_id = ?", jobID).Order("id").Find(&checkpoints).Error
+	return checkpoints, err
+}
+
+func (r *JobRepository) DeleteCheckpoints(ctx context.Context, jobID uint) error {
+	return r.db.WithContext(ctx).Where("job_id = ?", jobID).Delete(&models.BackupCheckpoint{}).Error
+}
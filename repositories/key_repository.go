@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"SafeBox/models"
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type KeyRepository struct {
+	db *gorm.DB
+}
+
+func NewKeyRepository(db *gorm.DB) *KeyRepository {
+	return &KeyRepository{db: db}
+}
+
+func (r *KeyRepository) FindUserKey(ctx context.Context, userID uint) (*models.UserKey, error) {
+	var key models.UserKey
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// CreateUserKey stores the root key of a user unless one already exists, as
+// happens when concurrent first uses race; it reports whether key was stored
+func (r *KeyRepository) CreateUserKey(ctx context.Context, key *models.UserKey) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoNothing: true,
+	}).Create(key)
+	return result.RowsAffected > 0, result.Error
+}
+
+// SaveRecoveryKey replaces the copy of the user's root key wrapped with a recovery key
+func (r *KeyRepository) SaveRecoveryKey(ctx context.Context, key *models.UserKey) error {
+	return r.db.WithContext(ctx).Model(key).Select("recovery_key_id", "recovery_wrapped_key", "recovery_created_at").Updates(key).Error
+}
+
+// SaveFileKey stores the wrapped key of an object, replacing any previous key
+func (r *KeyRepository) SaveFileKey(ctx context.Context, key *models.EncryptionKey) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_path"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "key_id", "key", "created_at", "destroyed_at"}),
+	}).Create(key).Error
+}
+
+func (r *KeyRepository) FindFileKey(ctx context.Context, userID uint, objectID string) (*models.EncryptionKey, error) {
+	var key models.EncryptionKey
+	err := r.db.WithContext(ctx).Where("user_id = ? AND file_path = ?", userID, objectID).First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
@@ -0,0 +1,99 @@
+package repositories
+
+import (
+	"SafeBox/models"
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ManifestRepository struct {
+	db *gorm.DB
+}
+
+func NewManifestRepository(db *gorm.DB) *ManifestRepository {
+	return &ManifestRepository{db: db}
+}
+
+func (r *ManifestRepository) Create(ctx context.Context, manifest *models.BackupManifest) error {
+	return r.db.WithContext(ctx).Create(manifest).Error
+}
+
+func (r *ManifestRepository) Update(ctx context.Context, manifest *models.BackupManifest) error {
+	return r.db.WithContext(ctx).Save(manifest).Error
+}
+
+func (r *ManifestRepository) FindByID(ctx context.Context, userID, id uint) (*models.BackupManifest, error) {
+	var manifest models.BackupManifest
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND id = ?", userID, id).First(&manifest).Error; err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// ListByUser returns the manifests of a user, newest first, optionally
+// restricted to one app
+func (r *ManifestRepository) ListByUser(ctx context.Context, userID uint, appName string) ([]models.BackupManifest, error) {
+	var manifests []models.BackupManifest
+	query := r.db.WithContext(ctx).Where("user_id = ?", userID)
+	if appName != "" {
+		query = query.Where("app_name = ?", appName)
+	}
+	err := query.Order("id DESC").Find(&manifests).Error
+	return manifests, err
+}
+
+func (r *ManifestRepository) ListBySchedule(ctx context.Context, userID, scheduleID uint) ([]models.BackupManifest, error) {
+	var manifests []models.BackupManifest
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND schedule_id = ?", userID, scheduleID).
+		Order("id DESC").
+		Find(&manifests).Error
+	return manifests, err
+}
+
+// ListForProfile returns the manifests of a profile that no schedule
+// retention policy covers, newest first
+func (r *ManifestRepository) ListForProfile(ctx context.Context, userID uint, appName string) ([]models.BackupManifest, error) {
+	var manifests []models.BackupManifest
+	covered := r.db.Model(&models.BackupSchedule{}).Select("id").Where(retentionCondition)
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND app_name = ?", userID, appName).
+		Where("schedule_id IS NULL OR schedule_id NOT IN (?)", covered).
+		Order("id DESC").
+		Find(&manifests).Error
+	return manifests, err
+}
+
+func (r *ManifestRepository) Delete(ctx context.Context, manifest *models.BackupManifest) error {
+	return r.db.WithContext(ctx).Delete(manifest).Error
+}
+
+// FindLatest returns the newest signed manifest of an app
+func (r *ManifestRepository) FindLatest(ctx context.Context, userID uint, appName string) (*models.BackupManifest, error) {
+	var manifest models.BackupManifest
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND app_name = ? AND signed_at IS NOT NULL", userID, appName).
+		Order("id DESC").
+		First(&manifest).Error
+	if err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func (r *ManifestRepository) FindSigningKey(ctx context.Context, userID uint) (*models.UserSigningKey, error) {
+	var key models.UserSigningKey
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *ManifestRepository) SaveSigningKey(ctx context.Context, key *models.UserSigningKey) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"public_key", "created_at"}),
+	}).Create(key).Error
+}
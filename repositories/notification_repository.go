@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"SafeBox/models"
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type NotificationRepository struct {
+	db *gorm.DB
+}
+
+func NewNotificationRepository(db *gorm.DB) *NotificationRepository {
+	return &NotificationRepository{db: db}
+}
+
+func (r *NotificationRepository) FindSettings(ctx context.Context, userID uint) (*models.NotificationSettings, error) {
+	var settings models.NotificationSettings
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&settings).Error; err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SaveSettings creates or replaces the settings of a user
+func (r *NotificationRepository) SaveSettings(ctx context.Context, settings *models.NotificationSettings) error {
+	return r.db.WithContext(ctx).Save(settings).Error
+}
+
+// ListWeeklyDigests returns the settings of the users who asked for the weekly digest
+func (r *NotificationRepository) ListWeeklyDigests(ctx context.Context) ([]models.NotificationSettings, error) {
+	var settings []models.NotificationSettings
+	err := r.db.WithContext(ctx).Where("weekly_digest = ?", true).Order("user_id").Find(&settings).Error
+	return settings, err
+}
+
+// ClaimStaleWarnings records the stale warnings about to be sent. The update
+// only matches while the settings are unchanged since they were read, so when
+// several replicas check the same user exactly one of them sends the warning.
+// Settings never saved are claimed by creating them.
+func (r *NotificationRepository) ClaimStaleWarnings(ctx context.Context, settings *models.NotificationSettings, warnings map[string]time.Time) (bool, error) {
+	if settings.ID == 0 {
+		claimed := *settings
+		claimed.StaleWarnings = warnings
+		err := r.db.WithContext(ctx).Create(&claimed).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		*settings = claimed
+		return true, nil
+	}
+
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&models.NotificationSettings{}).
+		Where("id = ? AND updated_at = ?", settings.ID, settings.UpdatedAt).
+		Select("stale_warnings", "updated_at").
+		Updates(&models.NotificationSettings{StaleWarnings: warnings, UpdatedAt: now})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	settings.StaleWarnings = warnings
+	settings.UpdatedAt = now
+	return true, nil
+}
+
+// ClaimDigest records when the weekly digest was sent. The update only
+// matches while digest_sent_at is unchanged, so exactly one replica sends it.
+func (r *NotificationRepository) ClaimDigest(ctx context.Context, settings *models.NotificationSettings, sentAt *time.Time) (bool, error) {
+	query := r.db.WithContext(ctx).Model(&models.NotificationSettings{}).Where("id = ?", settings.ID)
+	if settings.DigestSentAt == nil {
+		query = query.Where("digest_sent_at IS NULL")
+	} else {
+		query = query.Where("digest_sent_at = ?", *settings.DigestSentAt)
+	}
+	result := query.Update("digest_sent_at", sentAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	settings.DigestSentAt = sentAt
+	return true, nil
+}
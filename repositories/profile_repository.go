@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"SafeBox/models"
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type ProfileRepository struct {
+	db *gorm.DB
+}
+
+func NewProfileRepository(db *gorm.DB) *ProfileRepository {
+	return &ProfileRepository{db: db}
+}
+
+func (r *ProfileRepository) Create(ctx context.Context, profile *models.BackupProfile) error {
+	return r.db.WithContext(ctx).Create(profile).Error
+}
+
+func (r *ProfileRepository) Update(ctx context.Context, profile *models.BackupProfile) error {
+	return r.db.WithContext(ctx).Save(profile).Error
+}
+
+func (r *ProfileRepository) Delete(ctx context.Context, userID, id uint) error {
+	result := r.db.WithContext(ctx).Where("user_id = ? AND id = ?", userID, id).Delete(&models.BackupProfile{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *ProfileRepository) FindByID(ctx context.Context, userID, id uint) (*models.BackupProfile, error) {
+	var profile models.BackupProfile
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND id = ?", userID, id).First(&profile).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (r *ProfileRepository) FindByName(ctx context.Context, userID uint, name string) (*models.BackupProfile, error) {
+	var profile models.BackupProfile
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND name = ?", userID, name).First(&profile).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (r *ProfileRepository) ListByUser(ctx context.Context, userID uint) ([]models.BackupProfile, error) {
+	var profiles []models.BackupProfile
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("name").Find(&profiles).Error
+	return profiles, err
+}
+
+// WithRetention returns the profiles that have a retention policy
+func (r *ProfileRepository) WithRetention(ctx context.Context) ([]models.BackupProfile, error) {
+	var profiles []models.BackupProfile
+	err := r.db.WithContext(ctx).
+		Where(retentionCondition).
+		Order("id").
+		Find(&profiles).Error
+	return profiles, err
+}
+
+// NameTaken reports whether another profile of the user already uses name
+func (r *ProfileRepository) NameTaken(ctx context.Context, userID uint, name string, exceptID uint) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.BackupProfile{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, exceptID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CountSchedules returns how many schedules of the user run the profile
+func (r *ProfileRepository) CountSchedules(ctx context.Context, userID, profileID uint) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.BackupSchedule{}).
+		Where("user_id = ? AND profile_id = ?", userID, profileID).
+		Count(&count).Error
+	return count, err
+}
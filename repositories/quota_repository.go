@@ -11,6 +11,8 @@ type QuotaRepositoryInterface interface {
 	UpdateUserQuota(ctx context.Context, quota *models.UserQuota) error
 	GetTotalUsage(ctx context.Context, userID uint) (int64, error)
 	ReconcileUserQuota(ctx context.Context, userID uint) error // Adicionando este método
+	ReserveUsage(ctx context.Context, userID uint, size int64) (bool, error)
+	ReleaseUsage(ctx context.Context, userID uint, size int64) error
 }
 
 type QuotaRepository struct {
@@ -53,3 +55,19 @@ func (qr *QuotaRepository) ReconcileUserQuota(ctx context.Context, userID uint)
 	quota.Used = actualUsed
 	return qr.UpdateUserQuota(ctx, quota)
 }
+
+// ReserveUsage adds size to the usage of the user only if it stays within the
+// limit, so concurrent reservations cannot pass it together
+func (qr *QuotaRepository) ReserveUsage(ctx context.Context, userID uint, size int64) (bool, error) {
+	result := qr.db.WithContext(ctx).Model(&models.UserQuota{}).
+		Where(`user_id = ? AND used + ? <= "limit"`, userID, size).
+		Update("used", gorm.Expr("used + ?", size))
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseUsage gives back space of the user's usage
+func (qr *QuotaRepository) ReleaseUsage(ctx context.Context, userID uint, size int64) error {
+	return qr.db.WithContext(ctx).Model(&models.UserQuota{}).
+		Where("user_id = ?", userID).
+		Update("used", gorm.Expr("GREATEST(used - ?, 0)", size)).Error
+}
@@ -0,0 +1,122 @@
+package repositories
+
+import (
+	"SafeBox/models"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ScheduleRepository struct {
+	db *gorm.DB
+}
+
+func NewScheduleRepository(db *gorm.DB) *ScheduleRepository {
+	return &ScheduleRepository{db: db}
+}
+
+func (r *ScheduleRepository) Create(ctx context.Context, schedule *models.BackupSchedule) error {
+	return r.db.WithContext(ctx).Create(schedule).Error
+}
+
+func (r *ScheduleRepository) Update(ctx context.Context, schedule *models.BackupSchedule) error {
+	return r.db.WithContext(ctx).Save(schedule).Error
+}
+
+func (r *ScheduleRepository) Delete(ctx context.Context, userID, id uint) error {
+	result := r.db.WithContext(ctx).Where("user_id = ? AND id = ?", userID, id).Delete(&models.BackupSchedule{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *ScheduleRepository) FindByID(ctx context.Context, userID, id uint) (*models.BackupSchedule, error) {
+	var schedule models.BackupSchedule
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND id = ?", userID, id).First(&schedule).Error; err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func (r *ScheduleRepository) ListByUser(ctx context.Context, userID uint) ([]models.BackupSchedule, error) {
+	var schedules []models.BackupSchedule
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&schedules).Error
+	return schedules, err
+}
+
+// Due returns the enabled schedules whose next run is not after now
+func (r *ScheduleRepository) Due(ctx context.Context, now time.Time, limit int) ([]models.BackupSchedule, error) {
+	var schedules []models.BackupSchedule
+	err := r.db.WithContext(ctx).
+		Where("enabled = ? AND next_run_at <= ?", true, now).
+		Order("next_run_at").
+		Limit(limit).
+		Find(&schedules).Error
+	return schedules, err
+}
+
+// retentionCondition matches the rows whose embedded RetentionPolicy is set
+const retentionCondition = "keep_last > 0 OR keep_daily > 0 OR keep_weekly > 0 OR keep_monthly > 0 OR keep_yearly > 0 OR keep_within <> ''"
+
+// WithRetention returns the schedules that have a retention policy
+func (r *ScheduleRepository) WithRetention(ctx context.Context) ([]models.BackupSchedule, error) {
+	var schedules []models.BackupSchedule
+	err := r.db.WithContext(ctx).
+		Where(retentionCondition).
+		Order("id").
+		Find(&schedules).Error
+	return schedules, err
+}
+
+// Claim moves a due schedule to its next occurrence and records the status of
+// this one. The update only matches while next_run_at is unchanged, so when
+// several replicas see the same due schedule exactly one of them wins.
+func (r *ScheduleRepository) Claim(ctx context.Context, schedule *models.BackupSchedule, next time.Time, status string) (bool, error) {
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&models.BackupSchedule{}).
+		Where("id = ? AND next_run_at = ?", schedule.ID, schedule.NextRunAt).
+		Updates(map[string]interface{}{
+			"next_run_at": next,
+			"last_run_at": now,
+			"last_status": status,
+			"last_error":  "",
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	schedule.NextRunAt = next
+	schedule.LastRunAt = &now
+	schedule.LastStatus = status
+	schedule.LastError = ""
+	return true, nil
+}
+
+// Finish records the outcome of a claimed run
+func (r *ScheduleRepository) Finish(ctx context.Context, id uint, status, lastError string) error {
+	return r.db.WithContext(ctx).Model(&models.BackupSchedule{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_status": status,
+			"last_error":  lastError,
+		}).Error
+}
+
+// Disable turns off a schedule that can no longer be evaluated
+func (r *ScheduleRepository) Disable(ctx context.Context, id uint, reason string) error {
+	return r.db.WithContext(ctx).Model(&models.BackupSchedule{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"enabled":     false,
+			"last_status": models.ScheduleStatusFailed,
+			"last_error":  reason,
+		}).Error
+}
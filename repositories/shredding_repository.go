@@ -0,0 +1,233 @@
+package repositories
+
+import (
+	"SafeBox/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ShreddingRepository destroys wrapped keys, records the destruction in the
+// hash-chained log and queues the physical objects for garbage collection,
+// all within a single transaction
+type ShreddingRepository struct {
+	db *gorm.DB
+}
+
+func NewShreddingRepository(db *gorm.DB) *ShreddingRepository {
+	return &ShreddingRepository{db: db}
+}
+
+// ShredFile destroys the key of a single object and forgets its metadata
+func (r *ShreddingRepository) ShredFile(ctx context.Context, obj *models.FileObject) (*models.KeyDestruction, error) {
+	var record *models.KeyDestruction
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var key models.EncryptionKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND file_path = ?", obj.UserID, obj.ObjectID).
+			First(&key).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to load file key: %w", err)
+		}
+
+		now := destructionTime()
+		if key.ID != 0 && key.DestroyedAt == nil {
+			record = &models.KeyDestruction{
+				UserID:         obj.UserID,
+				Scope:          models.DestructionScopeFile,
+				KeyID:          key.KeyID,
+				ObjectID:       obj.ObjectID,
+				KeyFingerprint: fingerprint([]byte(key.Key)),
+				DestroyedAt:    now,
+			}
+			if err := destroyKeys(tx, now, "id = ?", key.ID); err != nil {
+				return err
+			}
+			if err := appendDestruction(tx, record); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(&models.PendingDeletion{UserID: obj.UserID, ObjectID: obj.ObjectID}).Error; err != nil {
+			return fmt.Errorf("failed to queue object deletion: %w", err)
+		}
+		if obj.ID == 0 {
+			// Objeto sem metadados registrados, como o corpo de um manifesto
+			return nil
+		}
+		return tx.Delete(obj).Error
+	})
+	return record, err
+}
+
+// ShredUser destroys the root key and every file key of the user, queues all
+// of the user's objects for deletion and removes the account
+func (r *ShreddingRepository) ShredUser(ctx context.Context, userID uint) (*models.KeyDestruction, error) {
+	var record *models.KeyDestruction
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := destructionTime()
+
+		var userKey models.UserKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&userKey).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to load user key: %w", err)
+		}
+		if userKey.ID != 0 {
+			record = &models.KeyDestruction{
+				UserID:         userID,
+				Scope:          models.DestructionScopeUser,
+				KeyID:          userKey.KeyID,
+				KeyFingerprint: fingerprint(userKey.WrappedKey),
+				DestroyedAt:    now,
+			}
+			if err := tx.Delete(&userKey).Error; err != nil {
+				return fmt.Errorf("failed to destroy user key: %w", err)
+			}
+			if err := appendDestruction(tx, record); err != nil {
+				return err
+			}
+		}
+
+		if err := destroyKeys(tx, now, "user_id = ? AND destroyed_at IS NULL", userID); err != nil {
+			return err
+		}
+
+		var objs []models.FileObject
+		if err := tx.Where("user_id = ?", userID).Find(&objs).Error; err != nil {
+			return fmt.Errorf("failed to list user objects: %w", err)
+		}
+		for _, obj := range objs {
+			if err := tx.Create(&models.PendingDeletion{UserID: userID, ObjectID: obj.ObjectID}).Error; err != nil {
+				return fmt.Errorf("failed to queue object deletion: %w", err)
+			}
+		}
+		// Os chunks deduplicados são objetos próprios, fora de FileObject
+		var chunkObjects []string
+		if err := tx.Model(&models.Chunk{}).Where("user_id = ?", userID).Pluck("object_id", &chunkObjects).Error; err != nil {
+			return fmt.Errorf("failed to list user chunks: %w", err)
+		}
+		for _, objectID := range chunkObjects {
+			if err := tx.Create(&models.PendingDeletion{UserID: userID, ObjectID: objectID}).Error; err != nil {
+				return fmt.Errorf("failed to queue chunk deletion: %w", err)
+			}
+		}
+		userFiles := tx.Model(&models.FileObject{}).Select("id").Where("user_id = ?", userID)
+		if err := tx.Where("file_object_id IN (?)", userFiles).Delete(&models.FileChunk{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user chunk lists: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.Chunk{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user chunks: %w", err)
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&models.FileObject{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user objects: %w", err)
+		}
+
+		return tx.Unscoped().Delete(&models.OAuthUser{}, userID).Error
+	})
+	return record, err
+}
+
+// ListDestructions returns the whole destruction log in chain order
+func (r *ShreddingRepository) ListDestructions(ctx context.Context) ([]models.KeyDestruction, error) {
+	var records []models.KeyDestruction
+	if err := r.db.WithContext(ctx).Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ListUserDestructions returns the destruction records of a single user
+func (r *ShreddingRepository) ListUserDestructions(ctx context.Context, userID uint) ([]models.KeyDestruction, error) {
+	var records []models.KeyDestruction
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// PendingDeletions returns the queued objects whose deletion is due, leaving
+// out dead-lettered rows and those still waiting for their retry delay
+func (r *ShreddingRepository) PendingDeletions(ctx context.Context, now time.Time, limit int) ([]models.PendingDeletion, error) {
+	var pending []models.PendingDeletion
+	err := r.db.WithContext(ctx).
+		Where("dead_at IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", now).
+		Order("id").
+		Limit(limit).
+		Find(&pending).Error
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func (r *ShreddingRepository) CompleteDeletion(ctx context.Context, pending *models.PendingDeletion) error {
+	return r.db.WithContext(ctx).Delete(pending).Error
+}
+
+// FailDeletion records a failed attempt and schedules the next one, doubling
+// the delay each time. It reports whether the row was dead-lettered.
+func (r *ShreddingRepository) FailDeletion(ctx context.Context, pending *models.PendingDeletion, cause error) (bool, error) {
+	attempts := pending.Attempts + 1
+	now := time.Now()
+	updates := map[string]interface{}{
+		"attempts":   attempts,
+		"last_error": cause.Error(),
+	}
+	dead := attempts >= models.MaxDeletionAttempts
+	if dead {
+		updates["dead_at"] = now
+	} else {
+		updates["next_attempt_at"] = now.Add(models.DeletionRetryDelay(attempts))
+	}
+	return dead, r.db.WithContext(ctx).Model(pending).Updates(updates).Error
+}
+
+func destroyKeys(tx *gorm.DB, now time.Time, query string, args ...interface{}) error {
+	err := tx.Model(&models.EncryptionKey{}).Where(query, args...).Updates(map[string]interface{}{
+		"key":          "",
+		"destroyed_at": now,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to destroy file keys: %w", err)
+	}
+	return nil
+}
+
+// appendDestruction links the record to the last entry of the log.
+// The table lock serialises concurrent appends so the chain never forks.
+func appendDestruction(tx *gorm.DB, record *models.KeyDestruction) error {
+	if err := tx.Exec("LOCK TABLE key_destructions IN EXCLUSIVE MODE").Error; err != nil {
+		return fmt.Errorf("failed to lock destruction log: %w", err)
+	}
+
+	var last models.KeyDestruction
+	err := tx.Order("id DESC").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to read destruction log: %w", err)
+	}
+
+	record.PrevHash = last.Hash
+	record.Hash = record.ComputeHash()
+	if err := tx.Create(record).Error; err != nil {
+		return fmt.Errorf("failed to record key destruction: %w", err)
+	}
+	return nil
+}
+
+func fingerprint(wrapped []byte) string {
+	sum := sha256.Sum256(wrapped)
+	return hex.EncodeToString(sum[:])
+}
+
+// destructionTime is truncated to the database precision so the chain hash
+// can be recomputed from stored values
+func destructionTime() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
@@ -17,6 +17,9 @@ type UserRepository interface {
 	CreateUser(user *models.OAuthUser) error
 	Update(user *models.OAuthUser) error
 	ListAllUsers() ([]*models.OAuthUser, error)
+	FindByID(id uint) (*models.OAuthUser, error)
+	UpdateTimezone(id uint, timezone string) error
+	HasPermission(id uint, permission models.Permission) (bool, error)
 }
 
 // userRepositoryImpl implements UserRepository interface
@@ -53,6 +56,25 @@ func (r *userRepositoryImpl) FindByEmail(email string) (*models.OAuthUser, error
 	return &user, nil
 }
 
+func (r *userRepositoryImpl) FindByID(id uint) (*models.OAuthUser, error) {
+	var user models.OAuthUser
+	if err := r.db.First(&user, id).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepositoryImpl) UpdateTimezone(id uint, timezone string) error {
+	return r.db.Model(&models.OAuthUser{}).Where("id = ?", id).Update("timezone", timezone).Error
+}
+
+func (r *userRepositoryImpl) HasPermission(id uint, permission models.Permission) (bool, error) {
+	user := models.OAuthUser{Model: gorm.Model{ID: id}}
+	association := r.db.Model(&user).Where("name = ?", string(permission)).Association("Permissions")
+	count := association.Count()
+	return count > 0, association.Error
+}
+
 func (r *userRepositoryImpl) Update(user *models.OAuthUser) error {
 	return r.db.Save(user).Error
 }
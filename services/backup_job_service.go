@@ -0,0 +1,792 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"SafeBox/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// jobFlushInterval is how often a running job saves its progress and
+	// wakes up subscribers that missed a throttled update
+	jobFlushInterval = 2 * time.Second
+	// jobNotifyInterval throttles progress events sent to subscribers
+	jobNotifyInterval = 250 * time.Millisecond
+	// maxJobErrors is how many per-file errors a job keeps
+	maxJobErrors = 50
+	// maxJobAttempts limits automatic resumes of a job whose replica keeps stopping
+	maxJobAttempts = 3
+	staleJobBatch  = 20
+)
+
+var (
+	// ErrJobActive is returned when the source already has a queued or running job
+	ErrJobActive = errors.New("a backup of this source is already in progress")
+	// ErrJobNotActive is returned when pausing or canceling a job that is not running
+	ErrJobNotActive = errors.New("job is not running")
+	// ErrJobNotResumable is returned when resuming a job that did not stop early
+	ErrJobNotResumable = errors.New("job cannot be resumed")
+
+	// Causas do cancelamento do contexto de um job
+	errJobPaused   = errors.New("job paused")
+	errJobCanceled = errors.New("job canceled")
+)
+
+// JobStatus is the progress of a backup job as exposed by the API
+type JobStatus struct {
+	ID             uint       `json:"id"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	Source         string     `json:"source"`
+	Mode           string     `json:"mode"`
+	FilesScanned   int        `json:"files_scanned"`
+	BytesScanned   int64      `json:"bytes_scanned"`
+	FilesDone      int        `json:"files_done"`
+	BytesDone      int64      `json:"bytes_done"`
+	FilesFailed    int        `json:"files_failed"`
+	FilesUploaded  int        `json:"files_uploaded"`
+	FilesUnchanged int        `json:"files_unchanged"`
+	FilesSkipped   int        `json:"files_skipped"`
+	FilesDeleted   int        `json:"files_deleted"`
+	CurrentFile    string     `json:"current_file,omitempty"`
+	ETASeconds     *int64     `json:"eta_seconds,omitempty"`
+	Errors         []string   `json:"errors,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	SnapshotID     *uint      `json:"snapshot_id,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// Active reports whether the job is queued or running
+func (s JobStatus) Active() bool {
+	return s.Status == models.JobStatusQueued || s.Status == models.JobStatusRunning
+}
+
+// NewJobStatus returns the API view of a job record
+func NewJobStatus(job *models.BackupJob) JobStatus {
+	return JobStatus{
+		ID:             job.ID,
+		Status:         job.Status,
+		Attempts:       job.Attempts,
+		Source:         job.Source,
+		Mode:           job.Mode,
+		FilesScanned:   job.FilesScanned,
+		BytesScanned:   job.BytesScanned,
+		FilesDone:      job.FilesDone,
+		BytesDone:      job.BytesDone,
+		FilesFailed:    job.FilesFailed,
+		FilesUploaded:  job.FilesUploaded,
+		FilesUnchanged: job.FilesUnchanged,
+		FilesSkipped:   job.FilesSkipped,
+		FilesDeleted:   job.FilesDeleted,
+		CurrentFile:    job.CurrentFile,
+		Errors:         append([]string(nil), job.Errors...),
+		Error:          job.Error,
+		SnapshotID:     job.SnapshotID,
+		CreatedAt:      job.CreatedAt,
+		StartedAt:      job.StartedAt,
+		FinishedAt:     job.FinishedAt,
+	}
+}
+
+// JobResult is the outcome of a finished backup run
+type JobResult struct {
+	SnapshotID uint
+	Uploaded   int
+	Unchanged  int
+	Skipped    int
+	Deleted    int
+}
+
+// JobRunner performs the backup of a job, reporting to progress as it goes.
+// It must stop when ctx is canceled, which happens when the job is paused or canceled.
+type JobRunner func(ctx context.Context, progress *JobProgress) (*JobResult, error)
+
+// JobRunnerFactory rebuilds the runner of a stored job so it can be resumed
+type JobRunnerFactory func(ctx context.Context, job *models.BackupJob) (JobRunner, error)
+
+// JobProgress collects the progress of a running job.
+// All methods are safe for concurrent use and do nothing on a nil receiver,
+// so backup code can report unconditionally.
+type JobProgress struct {
+	mu          sync.Mutex
+	job         models.BackupJob
+	dirty       bool
+	lastNotify  time.Time
+	subscribers map[chan JobStatus]struct{}
+	ctx         context.Context // contexto da execução, cancelado ao pausar ou cancelar
+	cancel      context.CancelCauseFunc
+	pending     []ManifestEntry          // arquivos concluídos ainda fora de um checkpoint
+	resumed     map[string]ManifestEntry // arquivos concluídos em execuções anteriores
+}
+
+// JobID returns the ID of the job, or 0 for a nil progress
+func (p *JobProgress) JobID() uint {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.job.ID
+}
+
+// Scanned adds files found while scanning the sources
+func (p *JobProgress) Scanned(files int, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.update(func(job *models.BackupJob) {
+		job.FilesScanned += files
+		job.BytesScanned += bytes
+	})
+}
+
+// Started records the file being processed
+func (p *JobProgress) Started(path string) {
+	if p == nil {
+		return
+	}
+	p.update(func(job *models.BackupJob) { job.CurrentFile = path })
+}
+
+// Done records a file that was backed up
+func (p *JobProgress) Done(bytes int64) {
+	if p == nil {
+		return
+	}
+	p.update(func(job *models.BackupJob) {
+		job.FilesDone++
+		job.BytesDone += bytes
+	})
+}
+
+// Failed records a file that could not be backed up; its bytes still count
+// as processed so the ETA is not skewed
+func (p *JobProgress) Failed(path string, bytes int64, err error) {
+	if p == nil {
+		return
+	}
+	p.update(func(job *models.BackupJob) {
+		job.FilesFailed++
+		job.BytesDone += bytes
+		job.Errors = append(job.Errors, fmt.Sprintf("%s: %v", path, err))
+		if len(job.Errors) > maxJobErrors {
+			job.Errors = job.Errors[len(job.Errors)-maxJobErrors:]
+		}
+	})
+}
+
+// Checkpoint records a file that is fully backed up, so a resumed job does not process it again
+func (p *JobProgress) Checkpoint(entry ManifestEntry) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, entry)
+}
+
+// Resumed returns the files completed by earlier runs of the job, by logical path.
+// The map must not be modified.
+func (p *JobProgress) Resumed() map[string]ManifestEntry {
+	if p == nil {
+		return nil
+	}
+	return p.resumed
+}
+
+// stop cancels the run of the job for the given action
+func (p *JobProgress) stop(action string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel == nil {
+		return
+	}
+	if action == models.JobActionPause {
+		p.cancel(errJobPaused)
+	} else {
+		p.cancel(errJobCanceled)
+	}
+}
+
+// Status returns the current progress with an estimate of the remaining time
+func (p *JobProgress) Status() JobStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status()
+}
+
+func (p *JobProgress) status() JobStatus {
+	status := NewJobStatus(&p.job)
+	if p.job.Status == models.JobStatusRunning && p.job.StartedAt != nil && p.job.BytesDone > 0 {
+		elapsed := time.Since(*p.job.StartedAt)
+		remaining := p.job.BytesScanned - p.job.BytesDone
+		if remaining < 0 {
+			remaining = 0
+		}
+		eta := int64(elapsed.Seconds() * float64(remaining) / float64(p.job.BytesDone))
+		status.ETASeconds = &eta
+	}
+	return status
+}
+
+func (p *JobProgress) update(fn func(job *models.BackupJob)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn(&p.job)
+	p.dirty = true
+	if time.Since(p.lastNotify) >= jobNotifyInterval {
+		p.notify()
+	}
+}
+
+// notify sends the current status to every subscriber, replacing an update
+// the subscriber has not read yet. Callers must hold p.mu.
+func (p *JobProgress) notify() {
+	p.lastNotify = time.Now()
+	status := p.status()
+	for ch := range p.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- status
+	}
+}
+
+// snapshot returns a copy of the job record for saving and takes the files
+// waiting for a checkpoint. Subscribers are woken up if anything changed.
+func (p *JobProgress) snapshot() (job models.BackupJob, pending []ManifestEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dirty {
+		p.dirty = false
+		p.notify()
+	}
+	job = p.job
+	job.Errors = append([]string(nil), p.job.Errors...)
+	pending, p.pending = p.pending, nil
+	return job, pending
+}
+
+// BackupJobService runs backup jobs in the background, keeps their progress in
+// memory for live subscribers and persists it so clients can reconnect later,
+// even to another replica. Completed files are checkpointed, sealed with the
+// owner's key, so stopped jobs resume where they left off.
+type BackupJobService struct {
+	repo    *repositories.JobRepository
+	keys    *KeyService
+	factory JobRunnerFactory
+	limiter JobLimiter
+	slots   chan struct{}
+	mu      sync.Mutex
+	active  map[uint]*JobProgress
+}
+
+func NewBackupJobService(repo *repositories.JobRepository, keys *KeyService, maxConcurrent int) *BackupJobService {
+	return &BackupJobService{
+		repo:   repo,
+		keys:   keys,
+		slots:  make(chan struct{}, maxConcurrent),
+		active: map[uint]*JobProgress{},
+	}
+}
+
+// SetRunnerFactory sets how stored jobs are turned back into runners on resume
+func (s *BackupJobService) SetRunnerFactory(factory JobRunnerFactory) {
+	s.factory = factory
+}
+
+// JobLimiter admits new jobs under the limits of the user's plan. Admit runs
+// in the transaction that creates the job, with the user's row locked.
+type JobLimiter interface {
+	Admit(ctx context.Context, jobs *repositories.JobRepository, user *models.OAuthUser, job *models.BackupJob) error
+}
+
+// SetLimiter sets the limits new jobs must pass; without one every job is accepted
+func (s *BackupJobService) SetLimiter(limiter JobLimiter) {
+	s.limiter = limiter
+}
+
+// Submit stores a queued job and runs it in the background.
+// Only one job per user and source may be active at a time, and the limiter,
+// if set, must admit it.
+func (s *BackupJobService) Submit(ctx context.Context, job *models.BackupJob, run JobRunner) (JobStatus, error) {
+	progress, err := s.start(ctx, job)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	go s.execute(progress, run)
+	return progress.Status(), nil
+}
+
+// Run stores a job and runs it in the calling goroutine, returning an error
+// unless it succeeded
+func (s *BackupJobService) Run(ctx context.Context, job *models.BackupJob, run JobRunner) (JobStatus, error) {
+	progress, err := s.start(ctx, job)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	status := s.execute(progress, run)
+	switch {
+	case status.Status == models.JobStatusSucceeded:
+		return status, nil
+	case status.Error != "":
+		return status, errors.New(status.Error)
+	default:
+		return status, fmt.Errorf("backup job %s", status.Status)
+	}
+}
+
+// Pause stops a running job; files it completed are kept for Resume
+func (s *BackupJobService) Pause(ctx context.Context, userID, id uint) error {
+	return s.control(ctx, userID, id, models.JobActionPause)
+}
+
+// Cancel stops a running job. Its checkpoints are kept, so it can still be resumed.
+func (s *BackupJobService) Cancel(ctx context.Context, userID, id uint) error {
+	return s.control(ctx, userID, id, models.JobActionCancel)
+}
+
+// Resume runs a paused, canceled or failed job again, skipping the files
+// its checkpoints record as done
+func (s *BackupJobService) Resume(ctx context.Context, userID, id uint) (JobStatus, error) {
+	job, err := s.repo.FindByID(ctx, userID, id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	if !job.Resumable() {
+		return JobStatus{}, ErrJobNotResumable
+	}
+	claimed, err := s.repo.Claim(ctx, job, []string{models.JobStatusPaused, models.JobStatusFailed, models.JobStatusCanceled}, nil)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return JobStatus{}, ErrJobActive
+	}
+	if err != nil {
+		return JobStatus{}, fmt.Errorf("failed to claim job: %w", err)
+	}
+	if !claimed {
+		return JobStatus{}, ErrJobNotResumable
+	}
+	return s.resume(ctx, job)
+}
+
+// ResumeStale takes over the active jobs whose replica stopped reporting
+// progress. Jobs interrupted too many times are marked as failed instead.
+func (s *BackupJobService) ResumeStale(ctx context.Context, timeout time.Duration) (resumed, failed int, err error) {
+	before := time.Now().Add(-timeout)
+	jobs, err := s.repo.Stale(ctx, before, staleJobBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+		if job.Attempts >= maxJobAttempts {
+			ok, err := s.repo.FailStale(ctx, job.ID, before, "job interrupted too many times")
+			if err != nil {
+				logrus.WithError(err).WithField("job", job.ID).Error("Failed to fail stale backup job")
+			} else if ok {
+				failed++
+			}
+			continue
+		}
+
+		claimed, err := s.repo.Claim(ctx, job, []string{models.JobStatusQueued, models.JobStatusRunning}, &before)
+		if err != nil {
+			logrus.WithError(err).WithField("job", job.ID).Error("Failed to claim stale backup job")
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if _, err := s.resume(ctx, job); err != nil {
+			logrus.WithError(err).WithField("job", job.ID).Error("Failed to resume backup job")
+			continue
+		}
+		resumed++
+	}
+	return resumed, failed, nil
+}
+
+// Status returns the progress of a job, live when it runs on this replica
+func (s *BackupJobService) Status(ctx context.Context, userID, id uint) (JobStatus, error) {
+	if progress := s.lookup(userID, id); progress != nil {
+		return progress.Status(), nil
+	}
+	job, err := s.repo.FindByID(ctx, userID, id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	return NewJobStatus(job), nil
+}
+
+// List returns the most recent jobs of the user
+func (s *BackupJobService) List(ctx context.Context, userID uint, limit int) ([]JobStatus, error) {
+	jobs, err := s.repo.ListByUser(ctx, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]JobStatus, 0, len(jobs))
+	for i := range jobs {
+		if progress := s.lookup(userID, jobs[i].ID); progress != nil {
+			statuses = append(statuses, progress.Status())
+			continue
+		}
+		statuses = append(statuses, NewJobStatus(&jobs[i]))
+	}
+	return statuses, nil
+}
+
+// Subscribe returns a channel receiving the progress of a job running on this
+// replica. The channel is closed after the final status has been sent.
+// ok is false when the job is not running here.
+func (s *BackupJobService) Subscribe(userID, id uint) (updates <-chan JobStatus, cancel func(), ok bool) {
+	progress := s.lookup(userID, id)
+	if progress == nil {
+		return nil, nil, false
+	}
+
+	ch := make(chan JobStatus, 1)
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if progress.subscribers == nil {
+		// O job terminou entre a busca e a inscrição
+		return nil, nil, false
+	}
+	progress.subscribers[ch] = struct{}{}
+	ch <- progress.status()
+
+	cancel = func() {
+		progress.mu.Lock()
+		defer progress.mu.Unlock()
+		if _, ok := progress.subscribers[ch]; ok {
+			delete(progress.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel, true
+}
+
+func (s *BackupJobService) start(ctx context.Context, job *models.BackupJob) (*JobProgress, error) {
+	active, err := s.repo.HasActive(ctx, job.UserID, job.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check active jobs: %w", err)
+	}
+	if active {
+		return nil, ErrJobActive
+	}
+
+	job.Status = models.JobStatusQueued
+	job.Attempts = 1
+	if job.Mode == "" {
+		job.Mode = models.BackupModeManual
+	}
+	err = s.repo.Reserve(ctx, job.UserID, func(jobs *repositories.JobRepository, user *models.OAuthUser) error {
+		if s.limiter != nil {
+			if err := s.limiter.Admit(ctx, jobs, user, job); err != nil {
+				return err
+			}
+		}
+		return jobs.Create(ctx, job)
+	})
+	// O índice único parcial barra o job que outra réplica criou depois da consulta acima
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return nil, ErrJobActive
+	}
+	if errors.Is(err, ErrDailyBackupLimit) || errors.Is(err, ErrConcurrentBackupLimit) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+	return s.register(job, nil), nil
+}
+
+// resume runs a job already claimed as queued, starting from its checkpoints
+func (s *BackupJobService) resume(ctx context.Context, job *models.BackupJob) (JobStatus, error) {
+	if s.factory == nil {
+		return JobStatus{}, errors.New("jobs cannot be resumed: no runner factory")
+	}
+
+	run, err := s.factory(ctx, job)
+	if err == nil {
+		var resumed map[string]ManifestEntry
+		if resumed, err = s.loadCheckpoints(ctx, job); err == nil {
+			// Os contadores recomeçam; os arquivos do checkpoint contam como concluídos
+			*job = models.BackupJob{
+				ID:         job.ID,
+				UserID:     job.UserID,
+				Source:     job.Source,
+				ProfileID:  job.ProfileID,
+				BackupType: job.BackupType,
+				Format:     job.Format,
+				Mode:       job.Mode,
+				ScheduleID: job.ScheduleID,
+				Status:     models.JobStatusQueued,
+				Attempts:   job.Attempts,
+				CreatedAt:  job.CreatedAt,
+			}
+			if err = s.repo.Update(ctx, job); err == nil {
+				progress := s.register(job, resumed)
+				go s.execute(progress, run)
+				return progress.Status(), nil
+			}
+		}
+	}
+
+	// Sem como retomar, o job não pode ficar na fila para sempre
+	now := time.Now()
+	job.Status = models.JobStatusFailed
+	job.Error = err.Error()
+	job.FinishedAt = &now
+	if saveErr := s.repo.Update(ctx, job); saveErr != nil {
+		logrus.WithError(saveErr).WithField("job", job.ID).Error("Failed to save backup job")
+	}
+	return NewJobStatus(job), err
+}
+
+func (s *BackupJobService) register(job *models.BackupJob, resumed map[string]ManifestEntry) *JobProgress {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	progress := &JobProgress{
+		job:         *job,
+		subscribers: map[chan JobStatus]struct{}{},
+		ctx:         ctx,
+		cancel:      cancel,
+		resumed:     resumed,
+	}
+	s.mu.Lock()
+	s.active[job.ID] = progress
+	s.mu.Unlock()
+	return progress
+}
+
+func (s *BackupJobService) lookup(userID, id uint) *JobProgress {
+	s.mu.Lock()
+	progress := s.active[id]
+	s.mu.Unlock()
+	if progress == nil || progress.job.UserID != userID {
+		return nil
+	}
+	return progress
+}
+
+// control pauses or cancels a job, locally when it runs on this replica and
+// otherwise through the database for the replica running it
+func (s *BackupJobService) control(ctx context.Context, userID, id uint, action string) error {
+	if progress := s.lookup(userID, id); progress != nil {
+		progress.stop(action)
+		return nil
+	}
+
+	requested, err := s.repo.RequestAction(ctx, userID, id, action)
+	if err != nil {
+		return fmt.Errorf("failed to request %s: %w", action, err)
+	}
+	if requested {
+		return nil
+	}
+	if _, err := s.repo.FindByID(ctx, userID, id); err != nil {
+		return err
+	}
+	return ErrJobNotActive
+}
+
+// execute waits for a free slot, runs the job and persists its final status.
+// The job runs detached from the request that submitted it; pausing or
+// canceling cancels its context.
+func (s *BackupJobService) execute(progress *JobProgress, run JobRunner) JobStatus {
+	ctx, cancel := progress.ctx, progress.cancel
+	defer cancel(nil)
+
+	stop := make(chan struct{})
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		s.flush(ctx, progress, stop)
+	}()
+
+	var (
+		result *JobResult
+		err    error
+		ran    bool
+	)
+	select {
+	case s.slots <- struct{}{}:
+		now := time.Now()
+		progress.update(func(job *models.BackupJob) {
+			job.Status = models.JobStatusRunning
+			job.StartedAt = &now
+		})
+		result, err = run(ctx, progress)
+		ran = true
+		<-s.slots
+	case <-ctx.Done():
+		// Pausado ou cancelado ainda na fila
+	}
+
+	close(stop)
+	<-flushed
+
+	finished := time.Now()
+	progress.mu.Lock()
+	job := &progress.job
+	job.FinishedAt = &finished
+	job.CurrentFile = ""
+	job.RequestedAction = ""
+	cause := context.Cause(ctx)
+	switch {
+	case ran && err == nil:
+		job.Status = models.JobStatusSucceeded
+		if result != nil {
+			job.SnapshotID = &result.SnapshotID
+			job.FilesUploaded = result.Uploaded
+			job.FilesUnchanged = result.Unchanged
+			job.FilesSkipped = result.Skipped
+			job.FilesDeleted = result.Deleted
+		}
+	case errors.Is(cause, errJobPaused):
+		job.Status = models.JobStatusPaused
+	case errors.Is(cause, errJobCanceled):
+		job.Status = models.JobStatusCanceled
+	default:
+		if err == nil {
+			err = cause
+		}
+		job.Status = models.JobStatusFailed
+		job.Error = err.Error()
+	}
+	final := *job
+	final.Errors = append([]string(nil), job.Errors...)
+	pending := progress.pending
+	progress.pending = nil
+	progress.cancel = nil
+	progress.notify()
+	for ch := range progress.subscribers {
+		close(ch)
+	}
+	progress.subscribers = nil
+	status := progress.status()
+	progress.mu.Unlock()
+
+	// Checkpoints só interessam enquanto o job pode ser retomado
+	bg := context.Background()
+	if final.Status == models.JobStatusSucceeded {
+		if err := s.repo.DeleteCheckpoints(bg, final.ID); err != nil {
+			logrus.WithError(err).WithField("job", final.ID).Warn("Failed to delete job checkpoints")
+		}
+	} else if err := s.writeCheckpoint(bg, &final, pending); err != nil {
+		logrus.WithError(err).WithField("job", final.ID).Warn("Failed to checkpoint backup job")
+	}
+	if err := s.repo.Update(bg, &final); err != nil {
+		logrus.WithError(err).WithField("job", final.ID).Error("Failed to save backup job")
+	}
+	s.mu.Lock()
+	delete(s.active, final.ID)
+	s.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"job":    final.ID,
+		"status": final.Status,
+		"files":  final.FilesDone,
+		"failed": final.FilesFailed,
+	}).Info("Backup job finished")
+	return status
+}
+
+// flush saves the progress and checkpoints of a job until stop is closed.
+// Saving also refreshes UpdatedAt, the heartbeat ResumeStale looks at, and
+// picks up pause or cancel requests made on other replicas.
+func (s *BackupJobService) flush(ctx context.Context, progress *JobProgress, stop <-chan struct{}) {
+	ticker := time.NewTicker(jobFlushInterval)
+	defer ticker.Stop()
+
+	bg := context.WithoutCancel(ctx)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			job, pending := progress.snapshot()
+			if err := s.writeCheckpoint(bg, &job, pending); err != nil {
+				// Os arquivos serão processados de novo se o job for retomado
+				logrus.WithError(err).WithField("job", job.ID).Warn("Failed to checkpoint backup job")
+			}
+			if err := s.repo.SaveProgress(bg, &job); err != nil {
+				logrus.WithError(err).WithField("job", job.ID).Warn("Failed to save backup job progress")
+			}
+			action, err := s.repo.RequestedAction(bg, job.ID)
+			if err != nil {
+				logrus.WithError(err).WithField("job", job.ID).Warn("Failed to read backup job actions")
+			} else if action != "" {
+				progress.stop(action)
+			}
+		}
+	}
+}
+
+// writeCheckpoint stores a batch of completed files sealed with the owner's key
+func (s *BackupJobService) writeCheckpoint(ctx context.Context, job *models.BackupJob, entries []ManifestEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	userKey, _, err := s.keys.UserKey(ctx, job.UserID)
+	if err != nil {
+		return err
+	}
+	sealed, err := utils.SealBytes(utils.DeriveSubkey(userKey, "checkpoints"), body, checkpointAAD(job.ID))
+	if err != nil {
+		return fmt.Errorf("failed to seal checkpoint: %w", err)
+	}
+	return s.repo.AddCheckpoint(ctx, &models.BackupCheckpoint{JobID: job.ID, Sealed: sealed})
+}
+
+// loadCheckpoints returns the files completed by earlier runs of a job
+func (s *BackupJobService) loadCheckpoints(ctx context.Context, job *models.BackupJob) (map[string]ManifestEntry, error) {
+	checkpoints, err := s.repo.Checkpoints(ctx, job.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoints: %w", err)
+	}
+	if len(checkpoints) == 0 {
+		return nil, nil
+	}
+
+	userKey, _, err := s.keys.UserKey(ctx, job.UserID)
+	if err != nil {
+		return nil, err
+	}
+	key := utils.DeriveSubkey(userKey, "checkpoints")
+	done := map[string]ManifestEntry{}
+	for _, checkpoint := range checkpoints {
+		body, err := utils.OpenBytes(key, checkpoint.Sealed, checkpointAAD(job.ID))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open checkpoint %d: %w", checkpoint.ID, err)
+		}
+		var entries []ManifestEntry
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, fmt.Errorf("failed to decode checkpoint %d: %w", checkpoint.ID, err)
+		}
+		for _, entry := range entries {
+			done[entry.Path] = entry
+		}
+	}
+	return done, nil
+}
+
+func checkpointAAD(jobID uint) []byte {
+	return []byte(fmt.Sprintf("job-checkpoint:%d", jobID))
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrDailyBackupLimit is returned when the user already used the backups of the day
+	ErrDailyBackupLimit = errors.New("daily backup limit exceeded")
+	// ErrSyncLimit is returned when the user already used the sync runs of the day
+	ErrSyncLimit = errors.New("daily sync limit exceeded")
+	// ErrUnknownAgentRun is returned for run IDs that were not issued to the user or were already committed
+	ErrUnknownAgentRun = errors.New("unknown or already committed agent run")
+	// ErrConcurrentBackupLimit is returned when the user has as many backups running as the plan allows
+	ErrConcurrentBackupLimit = errors.New("too many backups in progress")
+	// ErrRunSizeLimit is returned when a run selects more data than the plan allows
+	ErrRunSizeLimit = errors.New("backup run exceeds the size limit of the plan")
+	// ErrInvalidTimezone is returned for names that are not IANA time zones
+	ErrInvalidTimezone = errors.New("invalid timezone")
+)
+
+// BackupAllowance is what is left of the backup limits of a user's plan.
+// The daily window runs from midnight to midnight in the user's time zone.
+type BackupAllowance struct {
+	Plan           models.StoragePlan  `json:"plan"`
+	Limits         models.BackupLimits `json:"limits"`
+	Timezone       string              `json:"timezone"`
+	BackupsToday   int                 `json:"backups_today"`
+	RemainingToday int                 `json:"remaining_today"`
+	SyncRunsToday  int                 `json:"sync_runs_today"`
+	RemainingSync  int                 `json:"remaining_sync"`
+	ResetAt        time.Time           `json:"reset_at"`
+	ActiveRuns     int                 `json:"active_runs"`
+	RemainingRuns  int                 `json:"remaining_runs"`
+}
+
+// CheckDaily returns ErrDailyBackupLimit when no backup is left for the day
+func (a *BackupAllowance) CheckDaily() error {
+	if a.RemainingToday <= 0 {
+		return ErrDailyBackupLimit
+	}
+	return nil
+}
+
+// CheckSync returns ErrSyncLimit when no sync run is left for the day
+func (a *BackupAllowance) CheckSync() error {
+	if a.RemainingSync <= 0 {
+		return ErrSyncLimit
+	}
+	return nil
+}
+
+// CheckConcurrent returns ErrConcurrentBackupLimit when no other run may start now
+func (a *BackupAllowance) CheckConcurrent() error {
+	if a.RemainingRuns <= 0 {
+		return ErrConcurrentBackupLimit
+	}
+	return nil
+}
+
+// WriteHeaders reports the daily allowance in the X-RateLimit-* headers;
+// X-RateLimit-Reset is the Unix time the window restarts
+func (a *BackupAllowance) WriteHeaders(h http.Header) {
+	h.Set("X-RateLimit-Limit", strconv.Itoa(a.Limits.BackupsPerDay))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(a.RemainingToday))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(a.ResetAt.Unix(), 10))
+}
+
+// Consume accounts for a backup accepted after the allowance was read
+func (a *BackupAllowance) Consume() {
+	a.BackupsToday++
+	a.RemainingToday = max(a.RemainingToday-1, 0)
+}
+
+// ConsumeSync accounts for a sync run accepted after the allowance was read
+func (a *BackupAllowance) ConsumeSync() {
+	a.SyncRunsToday++
+	a.RemainingSync = max(a.RemainingSync-1, 0)
+}
+
+// BackupLimitService applies the backup limits of each plan. The daily limits
+// count accepted backup requests and sync runs; the concurrent limit counts
+// active jobs.
+type BackupLimitService struct {
+	users repositories.UserRepository
+	jobs  *repositories.JobRepository
+}
+
+func NewBackupLimitService(users repositories.UserRepository, jobs *repositories.JobRepository) *BackupLimitService {
+	return &BackupLimitService{users: users, jobs: jobs}
+}
+
+// Allowance returns what is left of the limits of the user's plan right now
+func (s *BackupLimitService) Allowance(ctx context.Context, user *models.OAuthUser) (*BackupAllowance, error) {
+	return s.allowance(ctx, s.jobs, user)
+}
+
+func (s *BackupLimitService) allowance(ctx context.Context, jobs *repositories.JobRepository, user *models.OAuthUser) (*BackupAllowance, error) {
+	plan := models.ParsePlan(user.Plan)
+	loc := userLocation(user.Timezone)
+	start, reset := DayWindow(time.Now(), loc)
+
+	count, err := jobs.CountSubmissionsSince(ctx, user.ID, start, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count backups: %w", err)
+	}
+	syncs, err := jobs.CountSubmissionsSince(ctx, user.ID, start, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sync runs: %w", err)
+	}
+	active, err := jobs.CountActive(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count active jobs: %w", err)
+	}
+
+	limits := plan.BackupLimits()
+	return &BackupAllowance{
+		Plan:           plan,
+		Limits:         limits,
+		Timezone:       loc.String(),
+		BackupsToday:   int(count),
+		RemainingToday: max(limits.BackupsPerDay-int(count), 0),
+		SyncRunsToday:  int(syncs),
+		RemainingSync:  max(limits.SyncRunsPerDay-int(syncs), 0),
+		ResetAt:        reset,
+		ActiveRuns:     int(active),
+		RemainingRuns:  max(limits.ConcurrentRuns-int(active), 0),
+	}, nil
+}
+
+// Admit checks the daily and concurrent limits for a new job and records its
+// submission. It runs in the transaction that creates the job, with the
+// user's row locked, so parallel submissions are counted one after the other.
+func (s *BackupLimitService) Admit(ctx context.Context, jobs *repositories.JobRepository, user *models.OAuthUser, job *models.BackupJob) error {
+	allowance, err := s.allowance(ctx, jobs, user)
+	if err != nil {
+		return err
+	}
+	if err := allowance.CheckDaily(); err != nil {
+		return err
+	}
+	if err := allowance.CheckConcurrent(); err != nil {
+		return err
+	}
+	return jobs.AddSubmission(ctx, &models.BackupSubmission{UserID: user.ID, Source: job.Source, Mode: job.Mode})
+}
+
+// ReserveAgentRun admits a run started by the agent and issues the ID its
+// snapshot is committed with. Agent runs are not server jobs, so only the
+// daily limits apply: regular runs count as backups and continuous sync runs
+// against the sync limit. The returned allowance already accounts for the run.
+func (s *BackupLimitService) ReserveAgentRun(ctx context.Context, userID uint, source, mode string) (*models.AgentRun, *BackupAllowance, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate run ID: %w", err)
+	}
+	run := &models.AgentRun{ID: hex.EncodeToString(id), UserID: userID, Source: source, Mode: mode}
+
+	var allowance *BackupAllowance
+	err := s.jobs.Reserve(ctx, userID, func(jobs *repositories.JobRepository, user *models.OAuthUser) error {
+		var err error
+		if allowance, err = s.allowance(ctx, jobs, user); err != nil {
+			return err
+		}
+		if mode == models.BackupModeSync {
+			err = allowance.CheckSync()
+		} else {
+			err = allowance.CheckDaily()
+		}
+		if err != nil {
+			return err
+		}
+		if err := jobs.AddSubmission(ctx, &models.BackupSubmission{UserID: userID, Source: source, Mode: mode}); err != nil {
+			return fmt.Errorf("failed to record backup: %w", err)
+		}
+		if err := jobs.AddAgentRun(ctx, run); err != nil {
+			return fmt.Errorf("failed to record agent run: %w", err)
+		}
+		if mode == models.BackupModeSync {
+			allowance.ConsumeSync()
+		} else {
+			allowance.Consume()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, allowance, err
+	}
+	return run, allowance, nil
+}
+
+// AgentRun returns the run with the given ID while its snapshot was not
+// committed. The run must have been issued to the user for source.
+func (s *BackupLimitService) AgentRun(ctx context.Context, userID uint, id, source string) (*models.AgentRun, error) {
+	run, err := s.jobs.FindAgentRun(ctx, userID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && run.Source != source) {
+		return nil, ErrUnknownAgentRun
+	}
+	return run, err
+}
+
+// ConsumeAgentRun marks the run as committed; a second commit of the same
+// run fails with ErrUnknownAgentRun
+func (s *BackupLimitService) ConsumeAgentRun(ctx context.Context, run *models.AgentRun) error {
+	ok, err := s.jobs.ConsumeAgentRun(ctx, run)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUnknownAgentRun
+	}
+	return nil
+}
+
+// ReleaseAgentRun lets the agent commit the run again after its snapshot
+// could not be stored
+func (s *BackupLimitService) ReleaseAgentRun(ctx context.Context, run *models.AgentRun) error {
+	return s.jobs.ReleaseAgentRun(ctx, run)
+}
+
+// UserAllowance is Allowance for a user known only by ID, as in scheduled runs
+func (s *BackupLimitService) UserAllowance(ctx context.Context, userID uint) (*BackupAllowance, error) {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	return s.Allowance(ctx, user)
+}
+
+// SetTimezone changes the time zone the daily limits of the user are computed in
+func (s *BackupLimitService) SetTimezone(ctx context.Context, user *models.OAuthUser, timezone string) error {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "" || timezone == "Local" {
+		return fmt.Errorf("%w: %q", ErrInvalidTimezone, timezone)
+	}
+	if err := s.users.UpdateTimezone(user.ID, loc.String()); err != nil {
+		return err
+	}
+	user.Timezone = loc.String()
+	return nil
+}
+
+// DayWindow returns the start of the day of now in loc and the start of the
+// next one. Days around daylight saving changes are 23 or 25 hours long.
+func DayWindow(now time.Time, loc *time.Location) (start, end time.Time) {
+	local := now.In(loc)
+	start = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// userLocation loads the time zone of a user, falling back to UTC for users
+// that never set one or whose zone is no longer known
+func userLocation(timezone string) *time.Location {
+	if timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -38,10 +38,6 @@ func (s *BackupService) GetBackupRepo() *repositories.BackupRepository {
 	return s.backupRepo
 }
 
-func (s *BackupService) CreateBackup(backup *models.Backup) error {
-	return s.backupRepo.CreateBackup(backup)
-}
-
 func (s *BackupService) CreateBackupHistory(history *models.BackupHistory) error {
 	return s.backupRepo.CreateBackupHistory(history)
 }
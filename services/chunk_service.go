@@ -0,0 +1,549 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"SafeBox/utils"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// StorageChunks marks, in the metadata of an uploaded file, that its content
+// is stored as a chunk list instead of a single object
+const StorageChunks = "chunks"
+
+const chunkSweepBatch = 500
+
+// ErrInvalidChunk is returned for chunks sent by a client that cannot be stored
+var ErrInvalidChunk = errors.New("invalid chunk")
+
+// ChunkRef locates one chunk of a file
+type ChunkRef struct {
+	ObjectID     string `json:"object_id"`
+	Size         int64  `json:"size"`
+	ObjectSHA256 string `json:"object_sha256"`
+}
+
+// ChunkStats counts what storing a file actually uploaded
+type ChunkStats struct {
+	Chunks    int
+	NewChunks int
+	NewBytes  int64 // bytes enviados ao storage, já comprimidos e cifrados
+}
+
+// ObjectUploader stores an object under the given ID
+type ObjectUploader func(object io.Reader, objectID string) (string, error)
+
+// ChunkService stores file content as content-defined chunks, each kept once
+// per user as its own encrypted object. Storing only uploads the chunks the
+// user does not have yet; Retain and Release keep the reference counts, and
+// Sweep removes chunks that stayed unreferenced for a grace period. Chunks
+// imported from the agent reserve storage quota, and swept chunks release it.
+type ChunkService struct {
+	repo     *repositories.ChunkRepository
+	keys     *KeyService
+	shredder *ShreddingService
+	quota    QuotaServiceInterface
+	upload   ObjectUploader
+	fetch    ObjectFetcher
+	opts     utils.ChunkOptions
+}
+
+func NewChunkService(repo *repositories.ChunkRepository, keys *KeyService, shredder *ShreddingService, quota QuotaServiceInterface, upload ObjectUploader, fetch ObjectFetcher) *ChunkService {
+	return &ChunkService{
+		repo:     repo,
+		keys:     keys,
+		shredder: shredder,
+		quota:    quota,
+		upload:   upload,
+		fetch:    fetch,
+		opts:     utils.DefaultChunkOptions,
+	}
+}
+
+// Store splits content into chunks and uploads the ones the user does not
+// have yet. The chunks are not referenced until the caller retains them, so
+// they must be retained within the sweep grace period.
+// observe, if set, receives the compression stats of every uploaded chunk.
+func (s *ChunkService) Store(ctx context.Context, userID uint, content io.Reader, compression utils.CompressionOptions, observe func(*utils.CompressionStats)) ([]ChunkRef, ChunkStats, error) {
+	var stats ChunkStats
+	indexKey, err := s.indexKey(ctx, userID)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	chunker, err := utils.NewChunker(content, s.opts)
+	if err != nil {
+		return nil, stats, err
+	}
+	var refs []ChunkRef
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, stats, err
+		}
+		data, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, stats, fmt.Errorf("failed to read content: %w", err)
+		}
+
+		sum := sha256.Sum256(data)
+		hash := utils.BlindIndex(indexKey, hex.EncodeToString(sum[:]))
+		chunk, created, err := s.storeChunk(ctx, userID, hash, data, compression, observe)
+		if err != nil {
+			return nil, stats, err
+		}
+
+		stats.Chunks++
+		if created {
+			stats.NewChunks++
+			stats.NewBytes += chunk.StoredSize
+		}
+		refs = append(refs, newChunkRef(chunk))
+	}
+	return refs, stats, nil
+}
+
+// storeChunk returns the existing chunk with the given hash or uploads a new one
+func (s *ChunkService) storeChunk(ctx context.Context, userID uint, hash string, data []byte, compression utils.CompressionOptions, observe func(*utils.CompressionStats)) (*models.Chunk, bool, error) {
+	existing, err := s.findChunk(ctx, userID, hash)
+	if err != nil || existing != nil {
+		return existing, false, err
+	}
+
+	compressed, compressionStats, err := utils.CompressAuto(bytes.NewReader(data), compression)
+	if err != nil {
+		return nil, false, fmt.Errorf("compression failed: %w", err)
+	}
+	defer compressed.Close()
+
+	encryptionKey, err := utils.GenerateEncryptionKey()
+	if err != nil {
+		return nil, false, fmt.Errorf("encryption key generation failed: %w", err)
+	}
+	encrypted, err := utils.EncryptReader(compressed, encryptionKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("encryption failed: %w", err)
+	}
+
+	chunk, created, err := s.saveChunk(ctx, userID, hash, int64(len(data)), encryptionKey, encrypted)
+	if err == nil && created && observe != nil {
+		observe(compressionStats)
+	}
+	return chunk, created, err
+}
+
+// findChunk returns the chunk of the user with the given hash, or nil if there
+// is none. A chunk found is protected from the sweep until the caller references it.
+func (s *ChunkService) findChunk(ctx context.Context, userID uint, hash string) (*models.Chunk, error) {
+	existing, err := s.repo.FindByHash(ctx, userID, hash)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up chunk: %w", err)
+	}
+	if err := s.repo.Touch(ctx, existing.ID); err != nil {
+		return nil, fmt.Errorf("failed to touch chunk: %w", err)
+	}
+	return existing, nil
+}
+
+// saveChunk uploads an encrypted chunk and records it with its key
+func (s *ChunkService) saveChunk(ctx context.Context, userID uint, hash string, size int64, encryptionKey []byte, encrypted io.Reader) (*models.Chunk, bool, error) {
+	objectID, err := utils.NewObjectID()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to generate object id: %w", err)
+	}
+
+	objectHash := sha256.New()
+	counter := &countingWriter{}
+	if _, err := s.upload(io.TeeReader(encrypted, io.MultiWriter(objectHash, counter)), objectID); err != nil {
+		return nil, false, fmt.Errorf("upload failed: %w", err)
+	}
+	if _, err := s.keys.StoreFileKey(ctx, userID, objectID, encryptionKey); err != nil {
+		return nil, false, fmt.Errorf("failed to store encryption key: %w", err)
+	}
+
+	chunk := &models.Chunk{
+		UserID:       userID,
+		Hash:         hash,
+		ObjectID:     objectID,
+		Size:         size,
+		StoredSize:   counter.n,
+		ObjectSHA256: hex.EncodeToString(objectHash.Sum(nil)),
+		LastUsedAt:   time.Now(),
+	}
+	if err := s.repo.Create(ctx, chunk); err != nil {
+		// Outro worker pode ter enviado o mesmo conteúdo ao mesmo tempo
+		winner, findErr := s.repo.FindByHash(ctx, userID, hash)
+		if findErr != nil {
+			return nil, false, fmt.Errorf("failed to store chunk: %w", err)
+		}
+		if _, err := s.shredder.DeleteObject(ctx, userID, objectID); err != nil {
+			logrus.WithError(err).WithField("object", objectID).Warn("Failed to release duplicate chunk")
+		}
+		if err := s.repo.Touch(ctx, winner.ID); err != nil {
+			return nil, false, fmt.Errorf("failed to touch chunk: %w", err)
+		}
+		return winner, false, nil
+	}
+	return chunk, true, nil
+}
+
+// Lookup returns the chunks the user already has among the given content
+// hashes (hex SHA-256 of the plain chunk), keyed by hash. Chunks found are
+// protected from the sweep like the ones reused by Store.
+func (s *ChunkService) Lookup(ctx context.Context, userID uint, contentHashes []string) (map[string]ChunkRef, error) {
+	indexKey, err := s.indexKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]ChunkRef{}
+	for _, contentHash := range contentHashes {
+		if !validContentHash(contentHash) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidChunk, contentHash)
+		}
+		chunk, err := s.findChunk(ctx, userID, utils.BlindIndex(indexKey, contentHash))
+		if err != nil {
+			return nil, err
+		}
+		if chunk != nil {
+			found[contentHash] = newChunkRef(chunk)
+		}
+	}
+	return found, nil
+}
+
+// Import stores a chunk that the client already compressed and encrypted with
+// encryptionKey. The server cannot check the content against contentHash, so
+// a client that lies only corrupts its own files: chunks are never shared
+// between users. Like Store, the chunk must be retained within the sweep grace period.
+// The encrypted bytes are reserved from the user's storage quota before they
+// are uploaded; ErrStorageLimitExceeded is returned when they do not fit.
+func (s *ChunkService) Import(ctx context.Context, userID uint, contentHash string, size int64, encryptionKey []byte, encrypted io.Reader) (ChunkRef, bool, error) {
+	if !validContentHash(contentHash) {
+		return ChunkRef{}, false, fmt.Errorf("%w: %q", ErrInvalidChunk, contentHash)
+	}
+	if size <= 0 || size > int64(s.opts.MaxSize) {
+		return ChunkRef{}, false, fmt.Errorf("%w: size %d", ErrInvalidChunk, size)
+	}
+	if len(encryptionKey) != 32 {
+		return ChunkRef{}, false, fmt.Errorf("%w: key must have 32 bytes", ErrInvalidChunk)
+	}
+
+	indexKey, err := s.indexKey(ctx, userID)
+	if err != nil {
+		return ChunkRef{}, false, err
+	}
+	hash := utils.BlindIndex(indexKey, contentHash)
+	existing, err := s.findChunk(ctx, userID, hash)
+	if err != nil {
+		return ChunkRef{}, false, err
+	}
+	if existing != nil {
+		return newChunkRef(existing), false, nil
+	}
+
+	// O chunk cifrado é lido antes do upload para reservar seu tamanho exato
+	data, err := io.ReadAll(encrypted)
+	if err != nil {
+		return ChunkRef{}, false, err
+	}
+	stored := int64(len(data))
+	if err := s.quota.ReserveSpace(ctx, userID, stored); err != nil {
+		return ChunkRef{}, false, err
+	}
+	chunk, created, err := s.saveChunk(ctx, userID, hash, size, encryptionKey, bytes.NewReader(data))
+	if err != nil || !created {
+		// Um upload que falhou ou perdeu a corrida não ocupa espaço
+		s.quota.ReleaseSpace(context.WithoutCancel(ctx), userID, stored)
+	}
+	if err != nil {
+		return ChunkRef{}, false, err
+	}
+	return newChunkRef(chunk), created, nil
+}
+
+// Missing returns the object IDs of refs that are not chunks of the user, or
+// whose size or hash differ from the stored chunk
+func (s *ChunkService) Missing(ctx context.Context, userID uint, refs []ChunkRef) ([]string, error) {
+	objectIDs := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		objectIDs = append(objectIDs, ref.ObjectID)
+	}
+	chunks, err := s.repo.FindByObjectIDs(ctx, userID, objectIDs)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]ChunkRef, len(chunks))
+	for i := range chunks {
+		stored[chunks[i].ObjectID] = newChunkRef(&chunks[i])
+	}
+
+	var missing []string
+	reported := map[string]bool{}
+	for _, ref := range refs {
+		if stored[ref.ObjectID] != ref && !reported[ref.ObjectID] {
+			reported[ref.ObjectID] = true
+			missing = append(missing, ref.ObjectID)
+		}
+	}
+	return missing, nil
+}
+
+// Reuse protects the chunks of refs from the sweep, as Store does for the
+// chunks it finds, and returns the object IDs of the ones already gone. It is
+// meant for refs recorded earlier, like the checkpoints of a job, that have
+// not been retained yet.
+func (s *ChunkService) Reuse(ctx context.Context, userID uint, refs []ChunkRef) ([]string, error) {
+	objectIDs := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		objectIDs = append(objectIDs, ref.ObjectID)
+	}
+	// Toca antes de conferir: um chunk presente agora já não pode ser varrido
+	if err := s.repo.TouchObjects(ctx, userID, objectIDs); err != nil {
+		return nil, fmt.Errorf("failed to touch chunks: %w", err)
+	}
+	return s.Missing(ctx, userID, refs)
+}
+
+// Releasable returns the stored size of the chunks that releasing refs would
+// leave without references, to be removed by the next sweep
+func (s *ChunkService) Releasable(ctx context.Context, userID uint, refs []ChunkRef) (int64, error) {
+	drops := map[string]int{}
+	objectIDs := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if drops[ref.ObjectID] == 0 {
+			objectIDs = append(objectIDs, ref.ObjectID)
+		}
+		drops[ref.ObjectID]++
+	}
+	chunks, err := s.repo.FindByObjectIDs(ctx, userID, objectIDs)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, chunk := range chunks {
+		if chunk.RefCount-drops[chunk.ObjectID] <= 0 {
+			total += chunk.StoredSize
+		}
+	}
+	return total, nil
+}
+
+// ChunkOptions returns the chunk sizes clients must use for their chunks to
+// deduplicate against the ones stored by the server
+func (s *ChunkService) ChunkOptions() utils.ChunkOptions {
+	return s.opts
+}
+
+func (s *ChunkService) indexKey(ctx context.Context, userID uint) ([]byte, error) {
+	userKey, _, err := s.keys.UserKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return utils.DeriveSubkey(userKey, "chunk-index"), nil
+}
+
+func newChunkRef(chunk *models.Chunk) ChunkRef {
+	return ChunkRef{ObjectID: chunk.ObjectID, Size: chunk.Size, ObjectSHA256: chunk.ObjectSHA256}
+}
+
+func validContentHash(contentHash string) bool {
+	decoded, err := hex.DecodeString(contentHash)
+	return err == nil && len(decoded) == sha256.Size && contentHash == strings.ToLower(contentHash)
+}
+
+// Retain adds one reference per occurrence of each chunk
+func (s *ChunkService) Retain(ctx context.Context, userID uint, refs []ChunkRef) error {
+	return s.addRefs(ctx, userID, refs, 1)
+}
+
+// Release removes the references added by Retain
+func (s *ChunkService) Release(ctx context.Context, userID uint, refs []ChunkRef) error {
+	return s.addRefs(ctx, userID, refs, -1)
+}
+
+func (s *ChunkService) addRefs(ctx context.Context, userID uint, refs []ChunkRef, delta int) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	deltas := map[string]int{}
+	for _, ref := range refs {
+		deltas[ref.ObjectID] += delta
+	}
+	return s.repo.AddRefs(ctx, userID, deltas)
+}
+
+// AttachFile records the chunk list of an uploaded file and references its chunks
+func (s *ChunkService) AttachFile(ctx context.Context, obj *models.FileObject, refs []ChunkRef) error {
+	chunks := make([]models.FileChunk, 0, len(refs))
+	for i, ref := range refs {
+		chunks = append(chunks, models.FileChunk{
+			FileObjectID: obj.ID,
+			Position:     i,
+			ObjectID:     ref.ObjectID,
+			Size:         ref.Size,
+			ObjectSHA256: ref.ObjectSHA256,
+		})
+	}
+	return s.repo.AttachFile(ctx, obj.UserID, chunks)
+}
+
+// FileChunks returns the chunk list of an uploaded file
+func (s *ChunkService) FileChunks(ctx context.Context, obj *models.FileObject) ([]ChunkRef, error) {
+	chunks, err := s.repo.FileChunks(ctx, obj.ID)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]ChunkRef, 0, len(chunks))
+	for _, chunk := range chunks {
+		refs = append(refs, ChunkRef{ObjectID: chunk.ObjectID, Size: chunk.Size, ObjectSHA256: chunk.ObjectSHA256})
+	}
+	return refs, nil
+}
+
+// DetachFile releases the chunks of an uploaded file that is being deleted
+func (s *ChunkService) DetachFile(ctx context.Context, obj *models.FileObject) error {
+	return s.repo.DetachFile(ctx, obj.UserID, obj.ID)
+}
+
+// Open returns the content of a chunk list. Each chunk is decrypted and
+// decompressed only when the reader reaches it.
+func (s *ChunkService) Open(ctx context.Context, userID uint, refs []ChunkRef) io.ReadCloser {
+	return &chunkReader{ctx: ctx, service: s, userID: userID, refs: refs}
+}
+
+// Sweep crypto-shreds the chunks that have had no references since before
+// now minus grace. A chunk found again by a backup in the meantime is kept.
+func (s *ChunkService) Sweep(ctx context.Context, grace time.Duration) (int, error) {
+	before := time.Now().Add(-grace)
+	chunks, err := s.repo.Unreferenced(ctx, before, chunkSweepBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for i := range chunks {
+		chunk := &chunks[i]
+		deleted, err := s.repo.DeleteUnreferenced(ctx, chunk.ID, before)
+		if err != nil {
+			return removed, err
+		}
+		if !deleted {
+			continue
+		}
+		if _, err := s.shredder.DeleteObject(ctx, chunk.UserID, chunk.ObjectID); err != nil {
+			logrus.WithError(err).WithField("object", chunk.ObjectID).Error("Failed to release chunk")
+			continue
+		}
+		// O espaço do chunk removido volta para a cota do usuário
+		s.quota.ReleaseSpace(ctx, chunk.UserID, chunk.StoredSize)
+		removed++
+	}
+	return removed, nil
+}
+
+// openChunk downloads, decrypts and decompresses a single chunk
+func (s *ChunkService) openChunk(ctx context.Context, userID uint, ref ChunkRef) (io.Reader, func() error, error) {
+	key, err := s.keys.FileKey(ctx, userID, ref.ObjectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	object, err := s.fetch(ref.ObjectID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to download chunk: %w", err)
+	}
+	plain, err := utils.DecryptReader(object, key)
+	if err != nil {
+		object.Close()
+		return nil, nil, fmt.Errorf("failed to decrypt chunk: %w", err)
+	}
+	dec, err := utils.DecompressAuto(plain)
+	if err != nil {
+		object.Close()
+		return nil, nil, fmt.Errorf("failed to decompress chunk: %w", err)
+	}
+	return dec, func() error {
+		dec.Close()
+		return object.Close()
+	}, nil
+}
+
+// chunkReader reads the chunks of a file one after the other, failing when
+// a chunk does not have its recorded size
+type chunkReader struct {
+	ctx     context.Context
+	service *ChunkService
+	userID  uint
+	refs    []ChunkRef
+	current io.Reader
+	close   func() error
+	n       int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.refs) == 0 {
+				return 0, io.EOF
+			}
+			if err := r.ctx.Err(); err != nil {
+				return 0, err
+			}
+			current, closeChunk, err := r.service.openChunk(r.ctx, r.userID, r.refs[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current, r.close, r.n = current, closeChunk, 0
+		}
+
+		n, err := r.current.Read(p)
+		r.n += int64(n)
+		if r.n > r.refs[0].Size {
+			return n, fmt.Errorf("chunk %s is larger than recorded", r.refs[0].ObjectID)
+		}
+		if err == io.EOF {
+			if r.n != r.refs[0].Size {
+				return n, fmt.Errorf("chunk %s is smaller than recorded", r.refs[0].ObjectID)
+			}
+			r.close()
+			r.current, r.close = nil, nil
+			r.refs = r.refs[1:]
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *chunkReader) Close() error {
+	if r.close != nil {
+		return r.close()
+	}
+	return nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
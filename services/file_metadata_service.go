@@ -0,0 +1,184 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"SafeBox/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+
+	"gorm.io/gorm"
+)
+
+// ErrFileExists is returned when the folder already holds a file with that name
+var ErrFileExists = errors.New("file already exists")
+
+// FileMetadata is the decrypted logical view of a stored object
+type FileMetadata struct {
+	ObjectID string            `json:"id"`
+	Folder   string            `json:"folder"`
+	Name     string            `json:"name"`
+	Size     int64             `json:"size"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// FileMetadataService encrypts file names, folder paths and custom metadata
+// with per-user keys and assigns opaque object IDs for the storage backends
+type FileMetadataService struct {
+	keys    *KeyService
+	objRepo *repositories.FileObjectRepository
+}
+
+func NewFileMetadataService(keys *KeyService, objRepo *repositories.FileObjectRepository) *FileMetadataService {
+	return &FileMetadataService{
+		keys:    keys,
+		objRepo: objRepo,
+	}
+}
+
+// Register encrypts the logical name of a new file and returns its object record.
+// The returned ObjectID is the only name the storage backends ever see.
+func (s *FileMetadataService) Register(ctx context.Context, userID uint, folder, name string, metadata map[string]string, size int64) (*models.FileObject, error) {
+	userKey, keyID, err := s.keys.UserKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	objectID, err := utils.NewObjectID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate object id: %w", err)
+	}
+
+	obj := &models.FileObject{
+		UserID:    userID,
+		ObjectID:  objectID,
+		NameIndex: nameIndex(userKey, folder, name),
+		KeyID:     keyID,
+		Size:      size,
+	}
+	if err := sealFileObject(userKey, obj, folder, name, metadata); err != nil {
+		return nil, err
+	}
+
+	if err := s.objRepo.Create(ctx, obj); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrFileExists
+		}
+		return nil, fmt.Errorf("failed to store file object: %w", err)
+	}
+	return obj, nil
+}
+
+// FindByName looks up a file by its exact folder and name using the blind index.
+// It returns nil without error when no such file exists.
+func (s *FileMetadataService) FindByName(ctx context.Context, userID uint, folder, name string) (*models.FileObject, error) {
+	userKey, _, err := s.keys.UserKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.objRepo.FindByNameIndex(ctx, userID, nameIndex(userKey, folder, name))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up file: %w", err)
+	}
+	return obj, nil
+}
+
+// FindByObjectID returns the object record owned by the user
+func (s *FileMetadataService) FindByObjectID(ctx context.Context, userID uint, objectID string) (*models.FileObject, error) {
+	return s.objRepo.FindByObjectID(ctx, userID, objectID)
+}
+
+// Decrypt returns the logical name, folder and metadata of an object
+func (s *FileMetadataService) Decrypt(ctx context.Context, obj *models.FileObject) (*FileMetadata, error) {
+	userKey, _, err := s.keys.UserKey(ctx, obj.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	metaKey := utils.DeriveSubkey(userKey, "metadata")
+	aad := []byte(obj.ObjectID)
+
+	name, err := utils.OpenBytes(metaKey, obj.EncryptedName, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file name: %w", err)
+	}
+	folder, err := utils.OpenBytes(metaKey, obj.EncryptedPath, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt folder path: %w", err)
+	}
+
+	result := &FileMetadata{
+		ObjectID: obj.ObjectID,
+		Folder:   string(folder),
+		Name:     string(name),
+		Size:     obj.Size,
+	}
+	if len(obj.EncryptedMetadata) > 0 {
+		raw, err := utils.OpenBytes(metaKey, obj.EncryptedMetadata, aad)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt metadata: %w", err)
+		}
+		if err := json.Unmarshal(raw, &result.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode metadata: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// List returns the decrypted view of every file owned by the user
+func (s *FileMetadataService) List(ctx context.Context, userID uint) ([]*FileMetadata, error) {
+	objs, err := s.objRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	files := make([]*FileMetadata, 0, len(objs))
+	for i := range objs {
+		meta, err := s.Decrypt(ctx, &objs[i])
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, meta)
+	}
+	return files, nil
+}
+
+// Remove deletes the object record of a file
+func (s *FileMetadataService) Remove(ctx context.Context, obj *models.FileObject) error {
+	return s.objRepo.Delete(ctx, obj)
+}
+
+func sealFileObject(userKey []byte, obj *models.FileObject, folder, name string, metadata map[string]string) error {
+	metaKey := utils.DeriveSubkey(userKey, "metadata")
+	aad := []byte(obj.ObjectID)
+
+	var err error
+	if obj.EncryptedName, err = utils.SealBytes(metaKey, []byte(name), aad); err != nil {
+		return fmt.Errorf("failed to encrypt file name: %w", err)
+	}
+	if obj.EncryptedPath, err = utils.SealBytes(metaKey, []byte(folder), aad); err != nil {
+		return fmt.Errorf("failed to encrypt folder path: %w", err)
+	}
+
+	if len(metadata) > 0 {
+		raw, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata: %w", err)
+		}
+		if obj.EncryptedMetadata, err = utils.SealBytes(metaKey, raw, aad); err != nil {
+			return fmt.Errorf("failed to encrypt metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+func nameIndex(userKey []byte, folder, name string) string {
+	return utils.BlindIndex(utils.DeriveSubkey(userKey, "name-index"), path.Join("/", folder, name))
+}
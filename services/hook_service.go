@@ -0,0 +1,252 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"SafeBox/utils"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxHooksPerPhase is how many hooks a profile may run before, or after, a backup
+	MaxHooksPerPhase = 8
+	// DefaultHookTimeout applies to approved commands that set no limit
+	DefaultHookTimeout = 5 * time.Minute
+	// MaxHookTimeout is the longest any hook may run
+	MaxHookTimeout = time.Hour
+	// hookPath is the only search path hooks inherit from the server
+	hookPath = "PATH=/usr/local/bin:/usr/bin:/bin"
+)
+
+var (
+	// ErrInvalidHook is returned when a hook or an approved command fails validation
+	ErrInvalidHook = errors.New("invalid hook")
+	// ErrHookCommandExists is returned when approving a name already in use
+	ErrHookCommandExists = errors.New("hook command already approved")
+	// ErrHookFailed is returned when a hook set to abort fails
+	ErrHookFailed = errors.New("backup hook failed")
+)
+
+var hookNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// HookService keeps the commands administrators approved for the hooks of
+// server-side backups and runs the hooks of each profile. Profiles only name
+// approved commands, so users never choose what executes on the server.
+type HookService struct {
+	repo *repositories.HookRepository
+}
+
+func NewHookService(repo *repositories.HookRepository) *HookService {
+	return &HookService{repo: repo}
+}
+
+// List returns every approved command
+func (s *HookService) List(ctx context.Context) ([]models.HookCommand, error) {
+	return s.repo.List(ctx)
+}
+
+// Approve validates and stores a command that profiles may use as a hook
+func (s *HookService) Approve(ctx context.Context, adminID uint, command *models.HookCommand) error {
+	command.Name = strings.TrimSpace(command.Name)
+	if !hookNamePattern.MatchString(command.Name) {
+		return fmt.Errorf("%w: name must be lowercase letters, digits, '.', '_' or '-'", ErrInvalidHook)
+	}
+	if !filepath.IsAbs(command.Path) || filepath.Clean(command.Path) != command.Path {
+		return fmt.Errorf("%w: path must be absolute and clean", ErrInvalidHook)
+	}
+	info, err := os.Stat(command.Path)
+	if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%w: %s is not an executable file", ErrInvalidHook, command.Path)
+	}
+	if command.MaxTimeout == 0 {
+		command.MaxTimeout = int(DefaultHookTimeout / time.Second)
+	}
+	if command.MaxTimeout < 0 || time.Duration(command.MaxTimeout)*time.Second > MaxHookTimeout {
+		return fmt.Errorf("%w: max timeout must be between 1 and %d seconds", ErrInvalidHook, int(MaxHookTimeout/time.Second))
+	}
+	for _, name := range command.AllowedEnv {
+		if !utils.ValidHookEnv(name + "=") {
+			return fmt.Errorf("%w: %q is not a valid environment variable name", ErrInvalidHook, name)
+		}
+		if reservedHookEnv(name) {
+			return fmt.Errorf("%w: environment variable %s cannot be allowed", ErrInvalidHook, name)
+		}
+	}
+
+	if _, err := s.repo.FindByName(ctx, command.Name); err == nil {
+		return fmt.Errorf("%w: %q", ErrHookCommandExists, command.Name)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to check hook command: %w", err)
+	}
+
+	command.ID = 0
+	command.ApprovedBy = adminID
+	if err := s.repo.Create(ctx, command); err != nil {
+		return fmt.Errorf("failed to approve hook command: %w", err)
+	}
+	return nil
+}
+
+// Revoke removes an approved command. Profiles that still name it keep the
+// hook, which fails at run time until the command is approved again.
+func (s *HookService) Revoke(ctx context.Context, name string) error {
+	return s.repo.Delete(ctx, name)
+}
+
+// ValidateHooks checks the hooks of one phase of a profile against the
+// approved commands and fills in the default failure policy
+func (s *HookService) ValidateHooks(ctx context.Context, hooks []models.BackupHook) error {
+	if len(hooks) > MaxHooksPerPhase {
+		return fmt.Errorf("%w: at most %d hooks per phase", ErrInvalidHook, MaxHooksPerPhase)
+	}
+	for i := range hooks {
+		hook := &hooks[i]
+		command, err := s.repo.FindByName(ctx, hook.Command)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %q is not an approved command", ErrInvalidHook, hook.Command)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load hook command: %w", err)
+		}
+
+		if len(hook.Args) > 0 && !command.AllowArgs {
+			return fmt.Errorf("%w: %q does not accept arguments", ErrInvalidHook, hook.Command)
+		}
+		if hook.TimeoutSeconds < 0 || hook.TimeoutSeconds > command.MaxTimeout {
+			return fmt.Errorf("%w: timeout of %q must be at most %d seconds", ErrInvalidHook, hook.Command, command.MaxTimeout)
+		}
+		switch hook.OnFailure {
+		case "":
+			hook.OnFailure = models.HookOnFailureAbort
+		case models.HookOnFailureAbort, models.HookOnFailureContinue:
+		default:
+			return fmt.Errorf("%w: on_failure must be %q or %q", ErrInvalidHook, models.HookOnFailureAbort, models.HookOnFailureContinue)
+		}
+		for _, entry := range hook.Env {
+			if !utils.ValidHookEnv(entry) {
+				return fmt.Errorf("%w: environment entry %q must be NAME=value", ErrInvalidHook, entry)
+			}
+			if name, _, _ := strings.Cut(entry, "="); !allowedHookEnv(command, name) {
+				return fmt.Errorf("%w: %q does not allow the environment variable %s", ErrInvalidHook, hook.Command, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Run executes the hooks of one phase in order. env holds the SAFEBOX_*
+// variables describing the run; each hook also gets a minimal PATH and its
+// own environment, never the one of the server. Commands are looked up again
+// so revoked ones no longer run. Before a backup, a failing hook set to
+// abort stops the phase; after it, every hook runs so cleanups are not
+// skipped. Either way ErrHookFailed reports that one of them failed.
+func (s *HookService) Run(ctx context.Context, phase string, hooks []models.BackupHook, env []string) ([]models.HookResult, error) {
+	var (
+		results []models.HookResult
+		failed  error
+	)
+	for _, hook := range hooks {
+		result, err := s.runHook(ctx, phase, hook, env)
+		results = append(results, result)
+		if err == nil || hook.OnFailure == models.HookOnFailureContinue {
+			continue
+		}
+		results[len(results)-1].Aborted = true
+		if failed == nil {
+			failed = fmt.Errorf("%w: %s hook %q: %v", ErrHookFailed, phase, hook.Command, err)
+		}
+		if phase == models.HookPhasePre {
+			break
+		}
+	}
+	return results, failed
+}
+
+func (s *HookService) runHook(ctx context.Context, phase string, hook models.BackupHook, env []string) (models.HookResult, error) {
+	result := models.HookResult{Phase: phase, Command: hook.Command, ExitCode: -1}
+	command, err := s.Command(ctx, hook, env)
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+
+	output, err := utils.RunHook(ctx, command)
+	result.ExitCode = output.ExitCode
+	result.Output = output.Output
+	result.Truncated = output.Truncated
+	result.TimedOut = output.TimedOut
+	result.DurationMs = output.Duration.Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result, err
+}
+
+// Command resolves a hook into what runs on the server: the approved
+// executable with its fixed arguments followed by the hook's, a timeout
+// capped by the approval, and an environment built from scratch
+func (s *HookService) Command(ctx context.Context, hook models.BackupHook, env []string) (utils.HookCommand, error) {
+	command, err := s.repo.FindByName(ctx, hook.Command)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return utils.HookCommand{}, fmt.Errorf("command %q is no longer approved", hook.Command)
+	}
+	if err != nil {
+		return utils.HookCommand{}, err
+	}
+
+	timeout := time.Duration(command.MaxTimeout) * time.Second
+	if hook.TimeoutSeconds > 0 && hook.TimeoutSeconds < command.MaxTimeout {
+		timeout = time.Duration(hook.TimeoutSeconds) * time.Second
+	}
+	// O ambiente é montado do zero; das variáveis do perfil só entram as que a
+	// aprovação atual do comando permite
+	processEnv := append([]string{hookPath}, env...)
+	for _, entry := range hook.Env {
+		if name, _, _ := strings.Cut(entry, "="); allowedHookEnv(command, name) {
+			processEnv = append(processEnv, entry)
+		}
+	}
+	return utils.HookCommand{
+		Path:    command.Path,
+		Args:    append(append([]string(nil), command.Args...), hook.Args...),
+		Env:     processEnv,
+		Timeout: timeout,
+	}, nil
+}
+
+// allowedHookEnv reports whether profiles may set the variable for command.
+// Interpreters read many variables that change what they execute (PERL5OPT,
+// PYTHONPATH, NODE_OPTIONS, GIT_SSH_COMMAND...), so only the names an
+// administrator approved for the command are accepted.
+func allowedHookEnv(command *models.HookCommand, name string) bool {
+	if reservedHookEnv(name) {
+		return false
+	}
+	for _, allowed := range command.AllowedEnv {
+		if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedHookEnv reports whether the variable may never be approved: the
+// search path, variables read by the dynamic loader or by shells on start,
+// and the SAFEBOX_* variables describing the run
+func reservedHookEnv(name string) bool {
+	upper := strings.ToUpper(name)
+	switch upper {
+	case "PATH", "IFS", "ENV", "BASH_ENV", "SHELLOPTS", "PS4":
+		return true
+	}
+	return strings.HasPrefix(upper, "LD_") || strings.HasPrefix(upper, "DYLD_") || strings.HasPrefix(upper, "SAFEBOX_")
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"SafeBox/utils"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrKeyDestroyed is returned when the key of an object was crypto-shredded
+	ErrKeyDestroyed = errors.New("encryption key has been destroyed")
+	// ErrNoRecoveryKey is returned when exporting for a user who never set up a recovery key
+	ErrNoRecoveryKey = errors.New("no recovery key has been set up")
+)
+
+// KeyService manages per-user root keys wrapped with the server master key
+type KeyService struct {
+	keyRepo   *repositories.KeyRepository
+	masterKey []byte
+}
+
+func NewKeyService(keyRepo *repositories.KeyRepository, masterKey []byte) *KeyService {
+	return &KeyService{
+		keyRepo:   keyRepo,
+		masterKey: masterKey,
+	}
+}
+
+// UserKey returns the unwrapped root key of the user and its key ID,
+// generating and storing a new one on first use
+func (s *KeyService) UserKey(ctx context.Context, userID uint) ([]byte, string, error) {
+	record, err := s.keyRepo.FindUserKey(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		key, keyID, created, err := s.createUserKey(ctx, userID)
+		if err != nil || created {
+			return key, keyID, err
+		}
+		// Outra requisição criou a chave primeiro; usa a dela
+		record, err = s.keyRepo.FindUserKey(ctx, userID)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load user key: %w", err)
+	}
+
+	key, err := utils.OpenBytes(s.masterKey, record.WrappedKey, userKeyAAD(userID))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to unwrap user key: %w", err)
+	}
+	return key, record.KeyID, nil
+}
+
+// StoreFileKey wraps the data key of an object with the user's root key and stores it
+func (s *KeyService) StoreFileKey(ctx context.Context, userID uint, objectID string, key []byte) (string, error) {
+	userKey, _, err := s.UserKey(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := utils.WrapFileKey(userKey, key, objectID)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap file key: %w", err)
+	}
+
+	keyID, err := utils.NewObjectID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key id: %w", err)
+	}
+
+	record := &models.EncryptionKey{
+		UserID:   userID,
+		KeyID:    keyID,
+		FilePath: objectID,
+		Key:      base64.StdEncoding.EncodeToString(wrapped),
+	}
+	if err := s.keyRepo.SaveFileKey(ctx, record); err != nil {
+		return "", fmt.Errorf("failed to store file key: %w", err)
+	}
+	return keyID, nil
+}
+
+// FileKey returns the unwrapped data key of an object
+func (s *KeyService) FileKey(ctx context.Context, userID uint, objectID string) ([]byte, error) {
+	wrapped, err := s.WrappedFileKey(ctx, userID, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	userKey, _, err := s.UserKey(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := utils.UnwrapFileKey(userKey, wrapped, objectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap file key: %w", err)
+	}
+	return key, nil
+}
+
+// CreateRecoveryKey generates a recovery key for the user and keeps a copy of
+// the root key wrapped with it. Only the printable key is returned and it is
+// not stored, so the user must write it down. A new key replaces the previous
+// one; exports made before keep needing the key they were made with.
+func (s *KeyService) CreateRecoveryKey(ctx context.Context, userID uint) (string, string, error) {
+	rootKey, _, err := s.UserKey(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	record, err := s.keyRepo.FindUserKey(ctx, userID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load user key: %w", err)
+	}
+
+	recoveryKey, printable, err := utils.NewRecoveryKey()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate recovery key: %w", err)
+	}
+	wrapped, err := utils.WrapRootKey(recoveryKey, rootKey, userID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to wrap user key: %w", err)
+	}
+
+	now := time.Now()
+	record.RecoveryKeyID = utils.RecoveryKeyID(recoveryKey)
+	record.RecoveryWrappedKey = wrapped
+	record.RecoveryCreatedAt = &now
+	if err := s.keyRepo.SaveRecoveryKey(ctx, record); err != nil {
+		return "", "", fmt.Errorf("failed to store recovery key: %w", err)
+	}
+	return printable, record.RecoveryKeyID, nil
+}
+
+// RecoveryKey returns the user key record holding the root key wrapped with
+// the user's recovery key
+func (s *KeyService) RecoveryKey(ctx context.Context, userID uint) (*models.UserKey, error) {
+	record, err := s.keyRepo.FindUserKey(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoRecoveryKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user key: %w", err)
+	}
+	if len(record.RecoveryWrappedKey) == 0 {
+		return nil, ErrNoRecoveryKey
+	}
+	return record, nil
+}
+
+// WrappedFileKey returns the data key of an object still wrapped with the
+// user's root key, as stored
+func (s *KeyService) WrappedFileKey(ctx context.Context, userID uint, objectID string) ([]byte, error) {
+	record, err := s.keyRepo.FindFileKey(ctx, userID, objectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load file key: %w", err)
+	}
+	if record.DestroyedAt != nil {
+		return nil, ErrKeyDestroyed
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(record.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped file key: %w", err)
+	}
+	return wrapped, nil
+}
+
+// createUserKey generates and stores a root key for the user. It reports
+// false, and stores nothing, when another request created one first.
+func (s *KeyService) createUserKey(ctx context.Context, userID uint) ([]byte, string, bool, error) {
+	key, err := utils.GenerateEncryptionKey()
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to generate user key: %w", err)
+	}
+
+	wrapped, err := utils.SealBytes(s.masterKey, key, userKeyAAD(userID))
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to wrap user key: %w", err)
+	}
+
+	keyID, err := utils.NewObjectID()
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to generate key id: %w", err)
+	}
+
+	record := &models.UserKey{
+		UserID:     userID,
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+	}
+	created, err := s.keyRepo.CreateUserKey(ctx, record)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to store user key: %w", err)
+	}
+	return key, keyID, created, nil
+}
+
+func userKeyAAD(userID uint) []byte {
+	return []byte(fmt.Sprintf("user:%d", userID))
+}
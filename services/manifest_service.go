@@ -0,0 +1,407 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"SafeBox/utils"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const manifestVersion = 1
+
+var (
+	// ErrManifestUnsigned is returned for zero-knowledge manifests still waiting for the user's signature
+	ErrManifestUnsigned = errors.New("manifest has not been signed")
+	// ErrManifestTampered is returned when the manifest body, its signature or an object does not match
+	ErrManifestTampered = errors.New("manifest verification failed")
+)
+
+// ManifestEntry describes one file of a backup
+type ManifestEntry struct {
+	Path         string                     `json:"path"`
+	ObjectID     string                     `json:"object_id"`
+	Size         int64                      `json:"size"`
+	SHA256       string                     `json:"sha256"`        // hash do conteúdo original
+	ObjectSHA256 string                     `json:"object_sha256"` // hash do objeto armazenado (comprimido e cifrado)
+	KeyID        string                     `json:"key_id"`
+	Compression  utils.CompressionAlgorithm `json:"compression"`
+	ArchivePath  string                     `json:"archive_path,omitempty"` // caminho dentro do objeto quando ele é um arquivo tar
+	ArchiveLink  string                     `json:"archive_link,omitempty"` // hard link: caminho no tar do arquivo que guarda o conteúdo
+	Chunks       []ChunkRef                 `json:"chunks,omitempty"`       // conteúdo em chunks; ObjectID fica vazio
+	ModTime      time.Time                  `json:"mod_time,omitempty"`
+}
+
+// ArchiveSource is the path inside the tar archive whose data holds the
+// content of the entry: its own, or that of the file it is a hard link to
+func (e ManifestEntry) ArchiveSource() string {
+	if e.ArchiveLink != "" {
+		return e.ArchiveLink
+	}
+	return e.ArchivePath
+}
+
+// Chunked reports whether the entry is stored as a chunk list.
+// An empty file stored as chunks has no chunks at all.
+func (e ManifestEntry) Chunked() bool {
+	return e.ObjectID == ""
+}
+
+// Manifest lists every file present when a backup run finished.
+// Incremental runs reuse the objects of unchanged files from their parent,
+// so each manifest is a complete snapshot on its own; Deleted lists the paths
+// that existed in the parent but are gone in this run.
+type Manifest struct {
+	Version   int             `json:"version"`
+	UserID    uint            `json:"user_id"`
+	AppName   string          `json:"app_name"`
+	ParentID  uint            `json:"parent_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	Entries   []ManifestEntry `json:"entries"`
+	Deleted   []string        `json:"deleted,omitempty"`
+}
+
+// ObjectFetcher opens a stored object by its ID
+type ObjectFetcher func(objectID string) (io.ReadCloser, error)
+
+// ObjectCheck is the verification result of a single manifest entry
+type ObjectCheck struct {
+	Path  string `json:"path"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// ManifestService builds, signs and verifies backup manifests.
+// Manifests are signed with the server Ed25519 key unless the user registered
+// a signing key, in which case the client signs them (zero-knowledge mode).
+type ManifestService struct {
+	repo       *repositories.ManifestRepository
+	keys       *KeyService
+	signingKey ed25519.PrivateKey
+}
+
+func NewManifestService(repo *repositories.ManifestRepository, keys *KeyService, signingKey ed25519.PrivateKey) *ManifestService {
+	return &ManifestService{
+		repo:       repo,
+		keys:       keys,
+		signingKey: signingKey,
+	}
+}
+
+// Build creates the manifest of a backup run and returns its record together
+// with the encrypted body to upload under record.ObjectID.
+// parentID is the manifest the run was compared against, if any.
+// The record must be persisted with Save once the upload succeeded.
+func (s *ManifestService) Build(ctx context.Context, userID uint, appName string, parentID *uint, entries []ManifestEntry, deleted []string) (*models.BackupManifest, []byte, error) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	sort.Strings(deleted)
+
+	manifest := Manifest{
+		Version:   manifestVersion,
+		UserID:    userID,
+		AppName:   appName,
+		CreatedAt: time.Now().UTC(),
+		Entries:   entries,
+		Deleted:   deleted,
+	}
+	if parentID != nil {
+		manifest.ParentID = *parentID
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	objectID, err := utils.NewObjectID()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate object id: %w", err)
+	}
+
+	record := &models.BackupManifest{
+		UserID:    userID,
+		AppName:   appName,
+		ParentID:  parentID,
+		ObjectID:  objectID,
+		Digest:    digest(body),
+		FileCount: len(entries),
+	}
+	for _, entry := range entries {
+		record.TotalSize += entry.Size
+	}
+
+	if err := s.sign(ctx, record); err != nil {
+		return nil, nil, err
+	}
+
+	key, err := utils.GenerateEncryptionKey()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate manifest key: %w", err)
+	}
+	var sealed bytes.Buffer
+	if err := utils.EncryptStream(bytes.NewReader(body), &sealed, key); err != nil {
+		return nil, nil, fmt.Errorf("failed to encrypt manifest: %w", err)
+	}
+	if _, err := s.keys.StoreFileKey(ctx, userID, objectID, key); err != nil {
+		return nil, nil, err
+	}
+
+	return record, sealed.Bytes(), nil
+}
+
+// Save persists a manifest record
+func (s *ManifestService) Save(ctx context.Context, record *models.BackupManifest) error {
+	return s.repo.Create(ctx, record)
+}
+
+// Find returns a manifest record owned by the user
+func (s *ManifestService) Find(ctx context.Context, userID, id uint) (*models.BackupManifest, error) {
+	return s.repo.FindByID(ctx, userID, id)
+}
+
+// List returns the manifests of a user, newest first; an empty appName lists every source
+func (s *ManifestService) List(ctx context.Context, userID uint, appName string) ([]models.BackupManifest, error) {
+	return s.repo.ListByUser(ctx, userID, appName)
+}
+
+// ListBySchedule returns the manifests produced by a schedule
+func (s *ManifestService) ListBySchedule(ctx context.Context, userID, scheduleID uint) ([]models.BackupManifest, error) {
+	return s.repo.ListBySchedule(ctx, userID, scheduleID)
+}
+
+// ListForProfile returns the manifests of a profile left to its own retention policy
+func (s *ManifestService) ListForProfile(ctx context.Context, userID uint, appName string) ([]models.BackupManifest, error) {
+	return s.repo.ListForProfile(ctx, userID, appName)
+}
+
+// Forget deletes a manifest record. Its objects must be released separately.
+func (s *ManifestService) Forget(ctx context.Context, record *models.BackupManifest) error {
+	return s.repo.Delete(ctx, record)
+}
+
+// Latest returns the most recent signed manifest of an app, or nil if there is none
+func (s *ManifestService) Latest(ctx context.Context, userID uint, appName string) (*models.BackupManifest, error) {
+	record, err := s.repo.FindLatest(ctx, userID, appName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return record, err
+}
+
+// Open decrypts the manifest body, checks it against the recorded digest and
+// verifies the signature. Nothing should be restored from an unopened manifest.
+func (s *ManifestService) Open(ctx context.Context, record *models.BackupManifest, sealed io.Reader) (*Manifest, error) {
+	if len(record.Signature) == 0 {
+		return nil, ErrManifestUnsigned
+	}
+	if err := s.verifySignature(ctx, record); err != nil {
+		return nil, err
+	}
+	return s.decode(ctx, record, sealed)
+}
+
+// References decrypts a manifest and checks its digest without requiring a
+// signature. It is only meant for finding which objects a manifest still
+// uses, e.g. while pruning, never for restoring.
+func (s *ManifestService) References(ctx context.Context, record *models.BackupManifest, fetch ObjectFetcher) (*Manifest, error) {
+	sealed, err := fetch(record.ObjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download manifest: %w", err)
+	}
+	defer sealed.Close()
+
+	return s.decode(ctx, record, sealed)
+}
+
+func (s *ManifestService) decode(ctx context.Context, record *models.BackupManifest, sealed io.Reader) (*Manifest, error) {
+	key, err := s.keys.FileKey(ctx, record.UserID, record.ObjectID)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if err := utils.DecryptStream(sealed, &body, key); err != nil {
+		return nil, fmt.Errorf("failed to decrypt manifest: %w", err)
+	}
+	if digest(body.Bytes()) != record.Digest {
+		return nil, fmt.Errorf("%w: digest mismatch", ErrManifestTampered)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(body.Bytes(), &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if manifest.UserID != record.UserID {
+		return nil, fmt.Errorf("%w: owner mismatch", ErrManifestTampered)
+	}
+	return &manifest, nil
+}
+
+// VerifyObjects reads every object of the manifest through fetch and compares
+// its hash with the recorded one. An entry stored as chunks passes when all
+// of its chunks do.
+func (s *ManifestService) VerifyObjects(manifest *Manifest, fetch ObjectFetcher) ([]ObjectCheck, bool) {
+	checks := make([]ObjectCheck, 0, len(manifest.Entries))
+	allOK := true
+	// Arquivos tar e chunks repetidos compartilham objetos; cada objeto é lido uma vez
+	verified := map[string]error{}
+	verify := func(objectID, objectSHA256 string) error {
+		err, done := verified[objectID]
+		if !done {
+			err = verifyObject(objectID, objectSHA256, fetch)
+			verified[objectID] = err
+		}
+		return err
+	}
+	for _, entry := range manifest.Entries {
+		check := ObjectCheck{Path: entry.Path}
+		var err error
+		if entry.Chunked() {
+			for _, chunk := range entry.Chunks {
+				if err = verify(chunk.ObjectID, chunk.ObjectSHA256); err != nil {
+					err = fmt.Errorf("chunk %s: %w", chunk.ObjectID, err)
+					break
+				}
+			}
+		} else {
+			err = verify(entry.ObjectID, entry.ObjectSHA256)
+		}
+		if err != nil {
+			check.Error = err.Error()
+			allOK = false
+		} else {
+			check.OK = true
+		}
+		checks = append(checks, check)
+	}
+	return checks, allOK
+}
+
+// SigningPayload returns the bytes a zero-knowledge client must sign
+func SigningPayload(record *models.BackupManifest) []byte {
+	return []byte("safebox-manifest-v1:" + record.Digest)
+}
+
+// AttachUserSignature stores a client-side signature after checking it
+// against the user's registered public key
+func (s *ManifestService) AttachUserSignature(ctx context.Context, record *models.BackupManifest, signature []byte) error {
+	key, err := s.repo.FindSigningKey(ctx, record.UserID)
+	if err != nil {
+		return fmt.Errorf("no signing key registered: %w", err)
+	}
+	if !ed25519.Verify(key.PublicKey, SigningPayload(record), signature) {
+		return fmt.Errorf("%w: invalid signature", ErrManifestTampered)
+	}
+
+	now := time.Now()
+	record.Signature = signature
+	record.SignedAt = &now
+	return s.repo.Update(ctx, record)
+}
+
+// RegisterSigningKey enables zero-knowledge signing for the user
+func (s *ManifestService) RegisterSigningKey(ctx context.Context, userID uint, publicKey []byte) error {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("public key must be %d bytes", ed25519.PublicKeySize)
+	}
+	return s.repo.SaveSigningKey(ctx, &models.UserSigningKey{UserID: userID, PublicKey: publicKey})
+}
+
+func (s *ManifestService) sign(ctx context.Context, record *models.BackupManifest) error {
+	userKey, err := s.repo.FindSigningKey(ctx, record.UserID)
+	if err == nil {
+		record.SignerType = models.ManifestSignerUser
+		record.SignerKeyID = keyFingerprint(userKey.PublicKey)
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to load signing key: %w", err)
+	}
+
+	now := time.Now()
+	record.SignerType = models.ManifestSignerServer
+	record.SignerKeyID = keyFingerprint(s.signingKey.Public().(ed25519.PublicKey))
+	record.Signature = ed25519.Sign(s.signingKey, SigningPayload(record))
+	record.SignedAt = &now
+	return nil
+}
+
+// SignerPublicKey returns the public key the manifest must be signed with
+func (s *ManifestService) SignerPublicKey(ctx context.Context, record *models.BackupManifest) (ed25519.PublicKey, error) {
+	switch record.SignerType {
+	case models.ManifestSignerServer:
+		return s.signingKey.Public().(ed25519.PublicKey), nil
+	case models.ManifestSignerUser:
+		key, err := s.repo.FindSigningKey(ctx, record.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signing key: %w", err)
+		}
+		return key.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown signer %q", ErrManifestTampered, record.SignerType)
+	}
+}
+
+// SigningKeys returns every key the manifests of the user may be signed
+// with: the server key and, in zero-knowledge mode, the user's own key
+func (s *ManifestService) SigningKeys(ctx context.Context, userID uint) ([]ed25519.PublicKey, error) {
+	keys := []ed25519.PublicKey{s.signingKey.Public().(ed25519.PublicKey)}
+	userKey, err := s.repo.FindSigningKey(ctx, userID)
+	if err == nil {
+		keys = append(keys, userKey.PublicKey)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load signing key: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *ManifestService) verifySignature(ctx context.Context, record *models.BackupManifest) error {
+	publicKey, err := s.SignerPublicKey(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	if keyFingerprint(publicKey) != record.SignerKeyID {
+		return fmt.Errorf("%w: signing key changed", ErrManifestTampered)
+	}
+	if !ed25519.Verify(publicKey, SigningPayload(record), record.Signature) {
+		return fmt.Errorf("%w: invalid signature", ErrManifestTampered)
+	}
+	return nil
+}
+
+func verifyObject(objectID, objectSHA256 string, fetch ObjectFetcher) error {
+	object, err := fetch(objectID)
+	if err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	defer object.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, object); err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != objectSHA256 {
+		return errors.New("object hash mismatch")
+	}
+	return nil
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func keyFingerprint(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,540 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"SafeBox/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxStaleAfterDays is the longest a source may be set to go without a successful backup
+	MaxStaleAfterDays = 365
+	// maxNotifiedFiles is how many failed files a notification lists
+	maxNotifiedFiles = 50
+	// digestWeekday and digestHour are when, in the user's time zone, the weekly digest goes out
+	digestWeekday = time.Monday
+	digestHour    = 8
+)
+
+// ErrInvalidNotification is returned when notification settings fail validation
+var ErrInvalidNotification = errors.New("invalid notification settings")
+
+// errDigestClaimed is returned when another replica already sent the digest
+var errDigestClaimed = errors.New("digest already claimed")
+
+// BackupOutcome is how a backup run ended, as reported to its owner
+type BackupOutcome struct {
+	UserID       uint
+	Source       string
+	Mode         string
+	Status       string
+	SnapshotID   uint
+	FilesOK      int
+	FilesFailed  int
+	FilesSkipped int
+	Bytes        int64
+	Duration     time.Duration
+	Error        string
+	FailedFiles  []FailedFile
+}
+
+// FailedFile is a file a backup run could not store
+type FailedFile struct {
+	Path  string
+	Error string
+}
+
+// NotificationInput is what a user may change in the notification settings
+type NotificationInput struct {
+	Email          string `json:"email"`
+	OnSuccess      bool   `json:"on_success"`
+	OnPartial      bool   `json:"on_partial"`
+	OnFailure      bool   `json:"on_failure"`
+	StaleAfterDays int    `json:"stale_after_days"`
+	WeeklyDigest   bool   `json:"weekly_digest"`
+}
+
+// notifyChoices are the settings in effect for the runs of one source
+type notifyChoices struct {
+	onSuccess      bool
+	onPartial      bool
+	onFailure      bool
+	staleAfterDays int
+}
+
+// NotificationService mails users about their backups: the outcome of each
+// run, sources that went too long without a successful backup, and a weekly
+// digest. What is sent follows the user's settings, overridden by those of
+// the profile that ran.
+type NotificationService struct {
+	repo     *repositories.NotificationRepository
+	users    repositories.UserRepository
+	profiles *repositories.ProfileRepository
+	backups  *repositories.BackupRepository
+	send     func(to, subject, body string) error
+}
+
+func NewNotificationService(repo *repositories.NotificationRepository, users repositories.UserRepository, profiles *repositories.ProfileRepository, backups *repositories.BackupRepository) *NotificationService {
+	return &NotificationService{
+		repo:     repo,
+		users:    users,
+		profiles: profiles,
+		backups:  backups,
+		send:     utils.SendEmail,
+	}
+}
+
+// Settings returns the notification settings of the user, or the defaults
+// if they were never changed
+func (s *NotificationService) Settings(ctx context.Context, userID uint) (*models.NotificationSettings, error) {
+	settings, err := s.repo.FindSettings(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.DefaultNotificationSettings(userID), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification settings: %w", err)
+	}
+	return settings, nil
+}
+
+// UpdateSettings validates and stores the notification settings of the user
+func (s *NotificationService) UpdateSettings(ctx context.Context, userID uint, input NotificationInput) (*models.NotificationSettings, error) {
+	input.Email = strings.TrimSpace(input.Email)
+	if input.Email != "" {
+		if address, err := mail.ParseAddress(input.Email); err != nil || address.Address != input.Email {
+			return nil, fmt.Errorf("%w: %q is not an e-mail address", ErrInvalidNotification, input.Email)
+		}
+	}
+	if err := validateStaleDays(input.StaleAfterDays); err != nil {
+		return nil, err
+	}
+
+	settings, err := s.Settings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	settings.Email = input.Email
+	settings.OnSuccess = input.OnSuccess
+	settings.OnPartial = input.OnPartial
+	settings.OnFailure = input.OnFailure
+	settings.StaleAfterDays = input.StaleAfterDays
+	settings.WeeklyDigest = input.WeeklyDigest
+	if err := s.repo.SaveSettings(ctx, settings); err != nil {
+		return nil, fmt.Errorf("failed to save notification settings: %w", err)
+	}
+	return settings, nil
+}
+
+// BackupFinished mails the outcome of a backup run when the settings ask for
+// it. Interrupted runs are never reported, and neither are the successful
+// commits of continuous sync, which would mail every few minutes.
+func (s *NotificationService) BackupFinished(ctx context.Context, outcome BackupOutcome) error {
+	settings, err := s.Settings(ctx, outcome.UserID)
+	if err != nil {
+		return err
+	}
+	profile, err := s.profile(ctx, outcome.UserID, outcome.Source)
+	if err != nil {
+		return err
+	}
+	choices := effectiveChoices(settings, profile)
+
+	switch outcome.Status {
+	case models.HistoryStatusSuccess:
+		if !choices.onSuccess || outcome.Mode == models.BackupModeSync {
+			return nil
+		}
+	case models.HistoryStatusPartial:
+		if !choices.onPartial {
+			return nil
+		}
+	case models.HistoryStatusFailed:
+		if !choices.onFailure {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	user, err := s.users.FindByID(outcome.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	subject, body := outcomeMessage(outcome)
+	return s.send(recipient(settings, user), subject, body)
+}
+
+// CheckStale warns every user whose sources went longer than their limit
+// without a successful backup. Each source is reported once until it backs
+// up successfully again; sources that never did count from their first run,
+// or from the creation of their profile. Each warning is claimed before it is
+// sent, so replicas running the check together send it once. Returns how many
+// e-mails were sent.
+func (s *NotificationService) CheckStale(ctx context.Context, now time.Time) (int, error) {
+	users, err := s.users.ListAllUsers()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list users: %w", err)
+	}
+	sent := 0
+	var errs []error
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		ok, err := s.checkUserStale(ctx, user, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %w", user.ID, err))
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, errors.Join(errs...)
+}
+
+// staleSource is a source without a successful backup for too long
+type staleSource struct {
+	name        string
+	lastSuccess *time.Time
+	days        int
+}
+
+func (s *NotificationService) checkUserStale(ctx context.Context, user *models.OAuthUser, now time.Time) (bool, error) {
+	settings, err := s.Settings(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	profiles, err := s.profiles.ListByUser(ctx, user.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to list profiles: %w", err)
+	}
+	activity, err := s.backups.SourceActivity(ctx, user.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read backup history: %w", err)
+	}
+
+	// Cada origem conta do último backup bem-sucedido ou, sem nenhum, da primeira execução
+	since := map[string]time.Time{}
+	lastSuccess := map[string]*time.Time{}
+	for _, source := range activity {
+		since[source.AppName] = source.FirstRun
+		if source.LastSuccess != nil {
+			since[source.AppName] = *source.LastSuccess
+			lastSuccess[source.AppName] = source.LastSuccess
+		}
+	}
+	byName := map[string]*models.BackupProfile{}
+	for i := range profiles {
+		byName[profiles[i].Name] = &profiles[i]
+		if _, ok := since[profiles[i].Name]; !ok {
+			since[profiles[i].Name] = profiles[i].CreatedAt
+		}
+	}
+
+	var stale []staleSource
+	for name, from := range since {
+		days := effectiveChoices(settings, byName[name]).staleAfterDays
+		if days == 0 || now.Sub(from) < time.Duration(days)*24*time.Hour {
+			continue
+		}
+		if warned, ok := settings.StaleWarnings[name]; ok && !warned.Before(from) {
+			continue
+		}
+		stale = append(stale, staleSource{name: name, lastSuccess: lastSuccess[name], days: days})
+	}
+	if len(stale) == 0 {
+		return false, nil
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].name < stale[j].name })
+
+	// Registra o aviso antes de enviar: só a réplica que ganhar envia
+	previous := settings.StaleWarnings
+	warnings := make(map[string]time.Time, len(previous)+len(stale))
+	for name, at := range previous {
+		warnings[name] = at
+	}
+	for _, source := range stale {
+		warnings[source.name] = now
+	}
+	claimed, err := s.repo.ClaimStaleWarnings(ctx, settings, warnings)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim stale warning: %w", err)
+	}
+	if !claimed {
+		return false, nil
+	}
+
+	subject, body := staleMessage(stale, userLocation(user.Timezone))
+	if err := s.send(recipient(settings, user), subject, body); err != nil {
+		// Devolve o aviso para a próxima verificação tentar de novo
+		if _, releaseErr := s.repo.ClaimStaleWarnings(ctx, settings, previous); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+		return false, fmt.Errorf("failed to send stale warning: %w", err)
+	}
+	return true, nil
+}
+
+// SendDigests mails the weekly digest to the users who asked for it. The
+// digest goes out on Monday morning in the user's time zone and covers the
+// seven days before; like stale warnings, each digest is claimed before it is
+// sent. Returns how many digests were sent.
+func (s *NotificationService) SendDigests(ctx context.Context, now time.Time) (int, error) {
+	subscribers, err := s.repo.ListWeeklyDigests(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list digest subscribers: %w", err)
+	}
+	sent := 0
+	var errs []error
+	for i := range subscribers {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		settings := &subscribers[i]
+		if settings.DigestSentAt != nil && now.Sub(*settings.DigestSentAt) < 6*24*time.Hour {
+			continue
+		}
+		user, err := s.users.FindByID(settings.UserID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %d: failed to load user: %w", settings.UserID, err))
+			continue
+		}
+		loc := userLocation(user.Timezone)
+		if local := now.In(loc); local.Weekday() != digestWeekday || local.Hour() < digestHour {
+			continue
+		}
+
+		err = s.sendDigest(ctx, settings, user, now, loc)
+		if errors.Is(err, errDigestClaimed) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %w", user.ID, err))
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
+
+func (s *NotificationService) sendDigest(ctx context.Context, settings *models.NotificationSettings, user *models.OAuthUser, now time.Time, loc *time.Location) error {
+	from := now.AddDate(0, 0, -7)
+	runs, err := s.backups.ListRunsSince(ctx, user.ID, from)
+	if err != nil {
+		return fmt.Errorf("failed to read backup history: %w", err)
+	}
+	activity, err := s.backups.SourceActivity(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read backup history: %w", err)
+	}
+
+	previous := settings.DigestSentAt
+	claimed, err := s.repo.ClaimDigest(ctx, settings, &now)
+	if err != nil {
+		return fmt.Errorf("failed to claim digest: %w", err)
+	}
+	if !claimed {
+		return errDigestClaimed
+	}
+
+	subject, body := digestMessage(runs, activity, from, now, loc)
+	if err := s.send(recipient(settings, user), subject, body); err != nil {
+		if _, releaseErr := s.repo.ClaimDigest(ctx, settings, previous); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+		return fmt.Errorf("failed to send digest: %w", err)
+	}
+	return nil
+}
+
+// profile returns the profile of the user named after the source, or nil
+// for sources without one, such as those of the agent
+func (s *NotificationService) profile(ctx context.Context, userID uint, source string) (*models.BackupProfile, error) {
+	profile, err := s.profiles.FindByName(ctx, userID, source)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profile: %w", err)
+	}
+	return profile, nil
+}
+
+// effectiveChoices applies the overrides of a profile, if any, to the user's settings
+func effectiveChoices(settings *models.NotificationSettings, profile *models.BackupProfile) notifyChoices {
+	choices := notifyChoices{
+		onSuccess:      settings.OnSuccess,
+		onPartial:      settings.OnPartial,
+		onFailure:      settings.OnFailure,
+		staleAfterDays: settings.StaleAfterDays,
+	}
+	if profile == nil || profile.Notifications == nil {
+		return choices
+	}
+	override := profile.Notifications
+	if override.OnSuccess != nil {
+		choices.onSuccess = *override.OnSuccess
+	}
+	if override.OnPartial != nil {
+		choices.onPartial = *override.OnPartial
+	}
+	if override.OnFailure != nil {
+		choices.onFailure = *override.OnFailure
+	}
+	if override.StaleAfterDays != nil {
+		choices.staleAfterDays = *override.StaleAfterDays
+	}
+	return choices
+}
+
+// validateProfileNotifications checks the overrides of a profile
+func validateProfileNotifications(notifications *models.ProfileNotifications) error {
+	if notifications == nil || notifications.StaleAfterDays == nil {
+		return nil
+	}
+	return validateStaleDays(*notifications.StaleAfterDays)
+}
+
+func validateStaleDays(days int) error {
+	if days < 0 || days > MaxStaleAfterDays {
+		return fmt.Errorf("%w: stale_after_days must be between 0 and %d", ErrInvalidNotification, MaxStaleAfterDays)
+	}
+	return nil
+}
+
+// recipient is the address set in the settings or, without one, the account's
+func recipient(settings *models.NotificationSettings, user *models.OAuthUser) string {
+	if settings.Email != "" {
+		return settings.Email
+	}
+	return user.Email
+}
+
+func outcomeMessage(outcome BackupOutcome) (string, string) {
+	var subject string
+	switch outcome.Status {
+	case models.HistoryStatusSuccess:
+		subject = fmt.Sprintf("SafeBox: backup de %s concluído", outcome.Source)
+	case models.HistoryStatusPartial:
+		subject = fmt.Sprintf("SafeBox: backup de %s concluído com falhas", outcome.Source)
+	default:
+		subject = fmt.Sprintf("SafeBox: falha no backup de %s", outcome.Source)
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Origem: %s\nModo: %s\n", outcome.Source, outcome.Mode)
+	if outcome.SnapshotID != 0 {
+		fmt.Fprintf(&body, "Snapshot: %d\n", outcome.SnapshotID)
+	}
+	fmt.Fprintf(&body, "Arquivos salvos: %d\nArquivos com falha: %d\nArquivos ignorados: %d\nTamanho: %s\n",
+		outcome.FilesOK, outcome.FilesFailed, outcome.FilesSkipped, formatBytes(outcome.Bytes))
+	if outcome.Duration > 0 {
+		fmt.Fprintf(&body, "Duração: %s\n", outcome.Duration.Round(time.Second))
+	}
+	if outcome.Error != "" {
+		fmt.Fprintf(&body, "\nErro: %s\n", outcome.Error)
+	}
+	if len(outcome.FailedFiles) > 0 {
+		body.WriteString("\nArquivos com falha:\n")
+		for i, file := range outcome.FailedFiles {
+			if i == maxNotifiedFiles {
+				fmt.Fprintf(&body, "... e mais %d\n", len(outcome.FailedFiles)-maxNotifiedFiles)
+				break
+			}
+			fmt.Fprintf(&body, "- %s: %s\n", file.Path, file.Error)
+		}
+	}
+	return subject, body.String()
+}
+
+func staleMessage(stale []staleSource, loc *time.Location) (string, string) {
+	subject := fmt.Sprintf("SafeBox: %s sem backup bem-sucedido", stale[0].name)
+	if len(stale) > 1 {
+		subject = fmt.Sprintf("SafeBox: %d origens sem backup bem-sucedido", len(stale))
+	}
+
+	var body strings.Builder
+	body.WriteString("As origens abaixo passaram do prazo sem nenhum backup bem-sucedido:\n\n")
+	for _, source := range stale {
+		last := "nunca"
+		if source.lastSuccess != nil {
+			last = source.lastSuccess.In(loc).Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(&body, "- %s: último sucesso %s (limite de %d dias)\n", source.name, last, source.days)
+	}
+	return subject, body.String()
+}
+
+// digestSource totals the runs of one source in a digest
+type digestSource struct {
+	runs, success, partial, failed int
+	files                          int
+	bytes                          int64
+	lastStatus                     string
+}
+
+func digestMessage(runs []models.BackupHistory, activity []repositories.SourceActivity, from, to time.Time, loc *time.Location) (string, string) {
+	sources := map[string]*digestSource{}
+	for _, run := range runs {
+		source := sources[run.AppName]
+		if source == nil {
+			source = &digestSource{}
+			sources[run.AppName] = source
+		}
+		source.runs++
+		switch run.Status {
+		case models.HistoryStatusSuccess:
+			source.success++
+		case models.HistoryStatusPartial:
+			source.partial++
+		case models.HistoryStatusFailed:
+			source.failed++
+		}
+		source.files += run.FilesOK
+		source.bytes += run.BytesTotal
+		source.lastStatus = run.Status
+	}
+
+	subject := fmt.Sprintf("SafeBox: resumo semanal dos backups (%s)", to.In(loc).Format("2006-01-02"))
+	var body strings.Builder
+	fmt.Fprintf(&body, "Backups de %s a %s\n\n", from.In(loc).Format("2006-01-02"), to.In(loc).Format("2006-01-02"))
+	for _, source := range activity {
+		totals := sources[source.AppName]
+		if totals == nil {
+			totals = &digestSource{lastStatus: "nenhuma execução"}
+		}
+		last := "nunca"
+		if source.LastSuccess != nil {
+			last = source.LastSuccess.In(loc).Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(&body, "%s\n  execuções: %d (%d ok, %d parciais, %d falhas)\n  arquivos salvos: %d, %s\n  última execução: %s\n  último sucesso: %s\n\n",
+			source.AppName, totals.runs, totals.success, totals.partial, totals.failed,
+			totals.files, formatBytes(totals.bytes), totals.lastStatus, last)
+	}
+	if len(activity) == 0 {
+		body.WriteString("Nenhum backup registrado.\n")
+	}
+	return subject, body.String()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for value := n / unit; value >= unit; value /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,406 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"SafeBox/utils"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// MaxProfileFileSize is the largest file a profile may back up
+const MaxProfileFileSize = 25 * 1024 * 1024 * 1024 // 25 GB
+
+var (
+	// ErrInvalidProfile is returned when a profile fails validation
+	ErrInvalidProfile = errors.New("invalid profile")
+	// ErrProfileInUse is returned when deleting a profile that schedules still run
+	ErrProfileInUse = errors.New("profile is used by a schedule")
+)
+
+// profileTemplates are the built-in profiles every user can run or copy.
+// Their names are reserved so snapshot sources stay unambiguous.
+var profileTemplates = map[string]models.BackupProfile{
+	"gallery": {
+		Name:             "gallery",
+		Sources:          []string{"gallery"},
+		Include:          []string{"*.jpg", "*.jpeg", "*.png", "*.gif", "*.JPG", "*.JPEG", "*.PNG", "*.GIF"},
+		MaxFileSize:      MaxProfileFileSize,
+		Compression:      string(utils.CompressionZstd),
+		CompressionLevel: 1,
+		Format:           models.ArchiveFormatFiles,
+		Encryption:       models.EncryptionAES256CTR,
+	},
+	"documents": {
+		Name:             "documents",
+		Sources:          []string{"documents"},
+		Include:          []string{"*.pdf", "*.doc", "*.docx", "*.txt", "*.PDF", "*.DOC", "*.DOCX", "*.TXT"},
+		MaxFileSize:      MaxProfileFileSize,
+		Compression:      string(utils.DefaultCompression.Algorithm),
+		CompressionLevel: utils.DefaultCompression.Level,
+		Format:           models.ArchiveFormatFiles,
+		Encryption:       models.EncryptionAES256CTR,
+	},
+}
+
+// ProfileInput holds the user-editable fields of a backup profile.
+// When Template is set, fields left empty are taken from that template.
+type ProfileInput struct {
+	Name             string                       `json:"name"`
+	Template         string                       `json:"template"`
+	Sources          []string                     `json:"sources"`
+	Include          []string                     `json:"include"`
+	Exclude          []string                     `json:"exclude"`
+	AppSources       []models.ProfileSource       `json:"app_sources"`
+	MaxFileSize      int64                        `json:"max_file_size"`
+	FollowSymlinks   bool                         `json:"follow_symlinks"`
+	OneFileSystem    bool                         `json:"one_file_system"`
+	Compression      string                       `json:"compression"`
+	CompressionLevel int                          `json:"compression_level"`
+	Format           string                       `json:"format"`
+	Encryption       string                       `json:"encryption"`
+	PreHooks         []models.BackupHook          `json:"pre_hooks"`
+	PostHooks        []models.BackupHook          `json:"post_hooks"`
+	Notifications    *models.ProfileNotifications `json:"notifications"`
+	KeepLast         int                          `json:"keep_last"`
+	KeepDaily        int                          `json:"keep_daily"`
+	KeepWeekly       int                          `json:"keep_weekly"`
+	KeepMonthly      int                          `json:"keep_monthly"`
+	KeepYearly       int                          `json:"keep_yearly"`
+	KeepWithin       string                       `json:"keep_within"`
+}
+
+// ProfileService manages the backup profiles of each user
+type ProfileService struct {
+	repo  *repositories.ProfileRepository
+	hooks *HookService
+}
+
+func NewProfileService(repo *repositories.ProfileRepository, hooks *HookService) *ProfileService {
+	return &ProfileService{repo: repo, hooks: hooks}
+}
+
+// Templates returns the built-in profiles sorted by name
+func (s *ProfileService) Templates() []models.BackupProfile {
+	templates := make([]models.BackupProfile, 0, len(profileTemplates))
+	for _, template := range profileTemplates {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// Template returns a copy of a built-in profile
+func (s *ProfileService) Template(name string) (*models.BackupProfile, error) {
+	template, ok := profileTemplates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown template %q", ErrInvalidProfile, name)
+	}
+	template.Template = name
+	// As listas são copiadas para que o chamador não altere o modelo
+	template.Sources = append([]string(nil), template.Sources...)
+	template.Include = append([]string(nil), template.Include...)
+	template.Exclude = append([]string(nil), template.Exclude...)
+	return &template, nil
+}
+
+// Create validates and stores a new profile
+func (s *ProfileService) Create(ctx context.Context, userID uint, input ProfileInput) (*models.BackupProfile, error) {
+	profile := &models.BackupProfile{UserID: userID}
+	if err := s.apply(ctx, profile, input); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, profile); err != nil {
+		return nil, fmt.Errorf("failed to create profile: %w", err)
+	}
+	return profile, nil
+}
+
+// Update replaces the editable fields of a profile.
+// Snapshots keep the profile name as their source, so renaming a profile
+// starts a new snapshot chain.
+func (s *ProfileService) Update(ctx context.Context, userID, id uint, input ProfileInput) (*models.BackupProfile, error) {
+	profile, err := s.repo.FindByID(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.apply(ctx, profile, input); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Update(ctx, profile); err != nil {
+		return nil, fmt.Errorf("failed to update profile: %w", err)
+	}
+	return profile, nil
+}
+
+// Find returns a profile owned by the user
+func (s *ProfileService) Find(ctx context.Context, userID, id uint) (*models.BackupProfile, error) {
+	return s.repo.FindByID(ctx, userID, id)
+}
+
+// List returns every profile of the user
+func (s *ProfileService) List(ctx context.Context, userID uint) ([]models.BackupProfile, error) {
+	return s.repo.ListByUser(ctx, userID)
+}
+
+// Delete removes a profile that no schedule runs; its snapshots are kept
+func (s *ProfileService) Delete(ctx context.Context, userID, id uint) error {
+	count, err := s.repo.CountSchedules(ctx, userID, id)
+	if err != nil {
+		return fmt.Errorf("failed to check schedules: %w", err)
+	}
+	if count > 0 {
+		return ErrProfileInUse
+	}
+	return s.repo.Delete(ctx, userID, id)
+}
+
+func (s *ProfileService) apply(ctx context.Context, profile *models.BackupProfile, input ProfileInput) error {
+	if input.Template != "" {
+		template, err := s.Template(input.Template)
+		if err != nil {
+			return err
+		}
+		if input.Sources == nil {
+			input.Sources = template.Sources
+		}
+		if input.Include == nil {
+			input.Include = template.Include
+		}
+		if input.Exclude == nil {
+			input.Exclude = template.Exclude
+		}
+		if input.MaxFileSize == 0 {
+			input.MaxFileSize = template.MaxFileSize
+		}
+		if input.Compression == "" {
+			input.Compression = template.Compression
+			input.CompressionLevel = template.CompressionLevel
+		}
+		if input.Format == "" {
+			input.Format = template.Format
+		}
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidProfile)
+	}
+	// O nome vai para o assunto dos e-mails de notificação
+	if utils.ContainsControl(input.Name) {
+		return fmt.Errorf("%w: name must not contain control characters", ErrInvalidProfile)
+	}
+	if _, reserved := profileTemplates[input.Name]; reserved {
+		return fmt.Errorf("%w: %q is a built-in template name", ErrInvalidProfile, input.Name)
+	}
+	taken, err := s.repo.NameTaken(ctx, profile.UserID, input.Name, profile.ID)
+	if err != nil {
+		return fmt.Errorf("failed to check profile name: %w", err)
+	}
+	if taken {
+		return fmt.Errorf("%w: a profile named %q already exists", ErrInvalidProfile, input.Name)
+	}
+
+	if input.MaxFileSize == 0 {
+		input.MaxFileSize = MaxProfileFileSize
+	}
+	if input.Compression == "" {
+		input.Compression = string(utils.DefaultCompression.Algorithm)
+		input.CompressionLevel = utils.DefaultCompression.Level
+	}
+	if input.Format == "" {
+		input.Format = models.ArchiveFormatFiles
+	}
+	if input.Encryption == "" {
+		input.Encryption = models.EncryptionAES256CTR
+	}
+
+	candidate := models.BackupProfile{
+		Name:             input.Name,
+		Template:         input.Template,
+		Sources:          input.Sources,
+		Include:          input.Include,
+		Exclude:          input.Exclude,
+		AppSources:       input.AppSources,
+		MaxFileSize:      input.MaxFileSize,
+		FollowSymlinks:   input.FollowSymlinks,
+		OneFileSystem:    input.OneFileSystem,
+		Compression:      input.Compression,
+		CompressionLevel: input.CompressionLevel,
+		Format:           input.Format,
+		Encryption:       input.Encryption,
+		PreHooks:         input.PreHooks,
+		PostHooks:        input.PostHooks,
+		Notifications:    input.Notifications,
+		Retention: models.RetentionPolicy{
+			KeepLast:    input.KeepLast,
+			KeepDaily:   input.KeepDaily,
+			KeepWeekly:  input.KeepWeekly,
+			KeepMonthly: input.KeepMonthly,
+			KeepYearly:  input.KeepYearly,
+			KeepWithin:  input.KeepWithin,
+		},
+	}
+	if err := ValidateProfile(&candidate); err != nil {
+		return err
+	}
+	if err := validateProfileNotifications(candidate.Notifications); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	// Hooks e origens de comando só podem usar comandos aprovados por um administrador
+	for _, hooks := range [][]models.BackupHook{candidate.PreHooks, candidate.PostHooks, commandHooks(candidate.AppSources)} {
+		if err := s.hooks.ValidateHooks(ctx, hooks); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+		}
+	}
+
+	candidate.ID = profile.ID
+	candidate.UserID = profile.UserID
+	candidate.CreatedAt = profile.CreatedAt
+	*profile = candidate
+	return nil
+}
+
+// ValidateProfile checks the sources, patterns and settings of a profile and
+// normalizes its source paths
+func ValidateProfile(profile *models.BackupProfile) error {
+	if len(profile.Sources) == 0 && len(profile.AppSources) == 0 {
+		return fmt.Errorf("%w: at least one source is required", ErrInvalidProfile)
+	}
+	seen := map[string]bool{}
+	top := map[string]bool{} // primeiro componente de cada diretório, ocupado no snapshot
+	for i, source := range profile.Sources {
+		source, ok := sourcePath(source)
+		if !ok {
+			return fmt.Errorf("%w: source %q must be a directory inside the backup root", ErrInvalidProfile, profile.Sources[i])
+		}
+		if seen[source] {
+			return fmt.Errorf("%w: duplicate source %q", ErrInvalidProfile, source)
+		}
+		seen[source] = true
+		top[strings.SplitN(source, "/", 2)[0]] = true
+		profile.Sources[i] = source
+	}
+	if err := validateAppSources(profile.AppSources, top); err != nil {
+		return err
+	}
+
+	for _, pattern := range append(append([]string{}, profile.Include...), profile.Exclude...) {
+		if err := utils.ValidateGlob(pattern); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+		}
+	}
+
+	if profile.MaxFileSize < 0 || profile.MaxFileSize > MaxProfileFileSize {
+		return fmt.Errorf("%w: max file size must be 0 (default) or between 1 and %d bytes", ErrInvalidProfile, int64(MaxProfileFileSize))
+	}
+
+	switch utils.CompressionAlgorithm(profile.Compression) {
+	case utils.CompressionZstd:
+		if profile.CompressionLevel < 0 || profile.CompressionLevel > 22 {
+			return fmt.Errorf("%w: zstd level must be 0 (default) or between 1 and 22", ErrInvalidProfile)
+		}
+	case utils.CompressionGzip:
+		if profile.CompressionLevel < 0 || profile.CompressionLevel > 9 {
+			return fmt.Errorf("%w: gzip level must be 0 (default) or between 1 and 9", ErrInvalidProfile)
+		}
+	case utils.CompressionNone:
+		profile.CompressionLevel = 0
+	default:
+		return fmt.Errorf("%w: unsupported compression %q", ErrInvalidProfile, profile.Compression)
+	}
+
+	switch profile.Format {
+	case models.ArchiveFormatFiles, models.ArchiveFormatTarZstd:
+	default:
+		return fmt.Errorf("%w: unsupported archive format %q", ErrInvalidProfile, profile.Format)
+	}
+
+	if profile.Encryption != models.EncryptionAES256CTR {
+		return fmt.Errorf("%w: unsupported encryption %q", ErrInvalidProfile, profile.Encryption)
+	}
+
+	retention := profile.Retention
+	if retention.KeepLast < 0 || retention.KeepDaily < 0 || retention.KeepWeekly < 0 || retention.KeepMonthly < 0 || retention.KeepYearly < 0 {
+		return fmt.Errorf("%w: retention counts cannot be negative", ErrInvalidProfile)
+	}
+	if _, err := ParseKeepWithin(retention.KeepWithin); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	return nil
+}
+
+// validateAppSources checks the application-aware sources of a profile and
+// names the file of those that left it empty. Their files sit at the top of
+// the snapshot, so they cannot clash with each other or with a directory.
+func validateAppSources(sources []models.ProfileSource, top map[string]bool) error {
+	for i := range sources {
+		source := &sources[i]
+		switch source.Kind {
+		case models.SourceKindSQLite, models.SourceKindGit:
+			p, ok := sourcePath(source.Path)
+			if !ok {
+				return fmt.Errorf("%w: %s source %q must be inside the backup root", ErrInvalidProfile, source.Kind, source.Path)
+			}
+			if source.Command != "" || len(source.Args) > 0 || len(source.Env) > 0 {
+				return fmt.Errorf("%w: %s sources do not run commands", ErrInvalidProfile, source.Kind)
+			}
+			source.Path = p
+			if source.File == "" {
+				source.File = path.Base(p)
+				if source.Kind == models.SourceKindGit {
+					source.File += ".bundle"
+				}
+			}
+		case models.SourceKindCommand:
+			if source.Command == "" {
+				return fmt.Errorf("%w: command sources need an approved command", ErrInvalidProfile)
+			}
+			if source.Path != "" {
+				return fmt.Errorf("%w: command sources have no path", ErrInvalidProfile)
+			}
+			if source.File == "" {
+				return fmt.Errorf("%w: command source %q needs the file its output is stored as", ErrInvalidProfile, source.Command)
+			}
+		default:
+			return fmt.Errorf("%w: unsupported source kind %q", ErrInvalidProfile, source.Kind)
+		}
+
+		if source.TimeoutSeconds < 0 {
+			return fmt.Errorf("%w: source timeout cannot be negative", ErrInvalidProfile)
+		}
+		if !utils.ValidSourceFile(source.File) {
+			return fmt.Errorf("%w: source file %q must be a plain file name", ErrInvalidProfile, source.File)
+		}
+		if top[source.File] {
+			return fmt.Errorf("%w: source file %q is already used in the snapshot", ErrInvalidProfile, source.File)
+		}
+		top[source.File] = true
+	}
+	return nil
+}
+
+// sourcePath normalizes a path relative to the backup source root, refusing
+// the root itself, paths that leave it and control characters
+func sourcePath(p string) (string, bool) {
+	p = path.Clean(strings.ReplaceAll(strings.TrimSpace(p), "\\", "/"))
+	if p == "." || path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") || utils.ContainsControl(p) {
+		return "", false
+	}
+	return p, true
+}
+
+// commandHooks returns the commands of the command sources, checked like hooks
+func commandHooks(sources []models.ProfileSource) []models.BackupHook {
+	var hooks []models.BackupHook
+	for _, source := range sources {
+		if source.Kind == models.SourceKindCommand {
+			hooks = append(hooks, source.Hook())
+		}
+	}
+	return hooks
+}
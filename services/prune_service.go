@@ -0,0 +1,194 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PruneReport describes what a prune run removed, or would remove in dry-run mode.
+// ReclaimedBytes counts the original (uncompressed) size of the released
+// objects plus the stored size of the chunks left without references.
+// Chunks are shared by reference count, so ReleasedChunks only counts the
+// references dropped; chunks left without any are removed by the chunk sweep.
+type PruneReport struct {
+	ScheduleID     uint                `json:"schedule_id,omitempty"`
+	ProfileID      uint                `json:"profile_id,omitempty"`
+	DryRun         bool                `json:"dry_run"`
+	Keep           []RetentionDecision `json:"keep"`
+	Forget         []RetentionDecision `json:"forget"`
+	DeletedObjects []string            `json:"deleted_objects"`
+	ReclaimedBytes int64               `json:"reclaimed_bytes"`
+	ReleasedChunks int                 `json:"released_chunks"`
+}
+
+// PruneService applies retention policies: it forgets the snapshots a policy
+// no longer selects and releases the objects that no remaining snapshot uses.
+// Objects are crypto-shredded and removed later by the garbage collection job.
+type PruneService struct {
+	manifests *ManifestService
+	shredder  *ShreddingService
+	chunks    *ChunkService
+	users     repositories.UserRepository
+	fetch     ObjectFetcher
+}
+
+func NewPruneService(manifests *ManifestService, shredder *ShreddingService, chunks *ChunkService, users repositories.UserRepository, fetch ObjectFetcher) *PruneService {
+	return &PruneService{
+		manifests: manifests,
+		shredder:  shredder,
+		chunks:    chunks,
+		users:     users,
+		fetch:     fetch,
+	}
+}
+
+// PruneSchedule applies the retention policy of a schedule to the snapshots it produced
+func (s *PruneService) PruneSchedule(ctx context.Context, schedule *models.BackupSchedule, dryRun bool) (*PruneReport, error) {
+	report := &PruneReport{ScheduleID: schedule.ID, DryRun: dryRun, DeletedObjects: []string{}}
+
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unknown timezone %q", ErrInvalidSchedule, schedule.Timezone)
+	}
+	snapshots, err := s.manifests.ListBySchedule(ctx, schedule.UserID, schedule.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	return s.prune(ctx, schedule.UserID, snapshots, schedule.Retention, loc, report)
+}
+
+// PruneProfile applies the retention policy of a profile to its snapshots
+// that no schedule policy covers: manual runs and runs of schedules without
+// a policy of their own. Calendar buckets use the owner's time zone.
+func (s *PruneService) PruneProfile(ctx context.Context, profile *models.BackupProfile, dryRun bool) (*PruneReport, error) {
+	report := &PruneReport{ProfileID: profile.ID, DryRun: dryRun, DeletedObjects: []string{}}
+
+	user, err := s.users.FindByID(profile.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find profile owner: %w", err)
+	}
+	snapshots, err := s.manifests.ListForProfile(ctx, profile.UserID, profile.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	return s.prune(ctx, profile.UserID, snapshots, profile.Retention, userLocation(user.Timezone), report)
+}
+
+// prune forgets the snapshots the policy does not keep and releases the
+// objects and chunk references only they used
+func (s *PruneService) prune(ctx context.Context, userID uint, snapshots []models.BackupManifest, policy models.RetentionPolicy, loc *time.Location, report *PruneReport) (*PruneReport, error) {
+	var err error
+	report.Keep, report.Forget, err = ApplyRetention(snapshots, policy, loc)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Forget) == 0 {
+		return report, nil
+	}
+
+	forget := make(map[uint]bool, len(report.Forget))
+	for _, decision := range report.Forget {
+		forget[decision.SnapshotID] = true
+	}
+
+	// Objetos ainda usados por qualquer snapshot restante do usuário, de qualquer origem
+	all, err := s.manifests.List(ctx, userID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	referenced := map[string]bool{}
+	var forgotten []models.BackupManifest
+	for i := range all {
+		record := &all[i]
+		if forget[record.ID] {
+			forgotten = append(forgotten, *record)
+			continue
+		}
+		manifest, err := s.manifests.References(ctx, record, s.fetch)
+		if err != nil {
+			// Sem saber o que o snapshot usa, nada pode ser removido com segurança
+			return nil, fmt.Errorf("failed to read snapshot %d: %w", record.ID, err)
+		}
+		for _, entry := range manifest.Entries {
+			referenced[entry.ObjectID] = true
+		}
+	}
+
+	// Um objeto pode aparecer em vários snapshots esquecidos; é contado pelo
+	// primeiro deles, somando todas as entradas quando é um arquivo tar
+	releasedBy := map[string]uint{}
+	var (
+		objects []string
+		chunks  []ChunkRef
+	)
+	for i := range forgotten {
+		record := &forgotten[i]
+		manifest, err := s.manifests.References(ctx, record, s.fetch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %d: %w", record.ID, err)
+		}
+		for _, entry := range manifest.Entries {
+			if entry.Chunked() {
+				// Cada snapshot guardou uma referência por ocorrência do chunk
+				chunks = append(chunks, entry.Chunks...)
+				continue
+			}
+			if referenced[entry.ObjectID] {
+				continue
+			}
+			owner, seen := releasedBy[entry.ObjectID]
+			if !seen {
+				releasedBy[entry.ObjectID] = record.ID
+				objects = append(objects, entry.ObjectID)
+			} else if owner != record.ID {
+				continue
+			}
+			report.ReclaimedBytes += entry.Size
+		}
+		objects = append(objects, record.ObjectID)
+	}
+	report.ReleasedChunks = len(chunks)
+	freed, err := s.chunks.Releasable(ctx, userID, chunks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to size released chunks: %w", err)
+	}
+	report.ReclaimedBytes += freed
+
+	if report.DryRun {
+		report.DeletedObjects = objects
+		return report, nil
+	}
+
+	// Os snapshots saem primeiro: uma falha no meio deixa objetos órfãos, nunca
+	// um snapshot apontando para objetos já destruídos
+	for i := range forgotten {
+		if err := s.manifests.Forget(ctx, &forgotten[i]); err != nil {
+			return nil, fmt.Errorf("failed to forget snapshot %d: %w", forgotten[i].ID, err)
+		}
+	}
+	if err := s.chunks.Release(ctx, userID, chunks); err != nil {
+		// Referências a mais só atrasam a limpeza; nunca removem dados em uso
+		logrus.WithError(err).WithFields(logrus.Fields{"schedule": report.ScheduleID, "profile": report.ProfileID}).Error("Failed to release pruned chunks")
+		report.ReleasedChunks = 0
+	}
+	for _, objectID := range objects {
+		if _, err := s.shredder.DeleteObject(ctx, userID, objectID); err != nil {
+			logrus.WithError(err).WithField("object", objectID).Error("Failed to release pruned object")
+			continue
+		}
+		report.DeletedObjects = append(report.DeletedObjects, objectID)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"schedule":  report.ScheduleID,
+		"profile":   report.ProfileID,
+		"snapshots": len(forgotten),
+		"objects":   len(report.DeletedObjects),
+	}).Info("Pruned snapshots")
+	return report, nil
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
 )
 
 type QuotaServiceInterface interface {
@@ -16,6 +17,8 @@ type QuotaServiceInterface interface {
 	CheckAndReserveSpace(ctx context.Context, userID uint, fileSize int64) error
 	CommitSpaceUsage(ctx context.Context, userID uint, fileSize int64) error
 	RollbackSpaceReservation(ctx context.Context, userID uint, fileSize int64)
+	ReserveSpace(ctx context.Context, userID uint, size int64) error
+	ReleaseSpace(ctx context.Context, userID uint, size int64)
 	GetLimit(userID uint) int64
 }
 
@@ -97,6 +100,39 @@ func (qs *QuotaService) RollbackSpaceReservation(ctx context.Context, userID uin
 	qs.redisClient.DecrBy(ctx, tempKey, fileSize)
 }
 
+// ReserveSpace adds size to the recorded usage of the user, failing with
+// ErrStorageLimitExceeded when it would pass the limit. Unlike
+// CheckAndReserveSpace, the check and the reservation are one update, so
+// parallel uploads of the same user cannot pass the limit together.
+func (qs *QuotaService) ReserveSpace(ctx context.Context, userID uint, size int64) error {
+	reserved, err := qs.repo.ReserveUsage(ctx, userID, size)
+	if err != nil {
+		return fmt.Errorf("quota reservation failed: %w", err)
+	}
+	if !reserved {
+		// Sem linha de cota o usuário não pode reservar nada
+		if _, err := qs.repo.GetUserQuota(ctx, userID); err != nil {
+			return fmt.Errorf("quota lookup failed: %w", err)
+		}
+		return models.ErrStorageLimitExceeded
+	}
+
+	cacheKey := fmt.Sprintf("quota:%d:used", userID)
+	qs.redisClient.IncrBy(ctx, cacheKey, size)
+	return nil
+}
+
+// ReleaseSpace gives back space reserved with ReserveSpace or taken by stored
+// objects that were removed
+func (qs *QuotaService) ReleaseSpace(ctx context.Context, userID uint, size int64) {
+	if err := qs.repo.ReleaseUsage(ctx, userID, size); err != nil {
+		logrus.WithError(err).WithField("user", userID).Warn("Failed to release quota")
+		return
+	}
+	cacheKey := fmt.Sprintf("quota:%d:used", userID)
+	qs.redisClient.DecrBy(ctx, cacheKey, size)
+}
+
 func (qs *QuotaService) GetLimit(userID uint) int64 {
 	quota, _ := qs.repo.GetUserQuota(context.Background(), userID)
 	return quota.Limit
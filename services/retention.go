@@ -0,0 +1,128 @@
+package services
+
+import (
+	"SafeBox/models"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionDecision tells whether a snapshot is kept and which rules selected it
+type RetentionDecision struct {
+	SnapshotID uint      `json:"snapshot_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Size       int64     `json:"size"`
+	Reasons    []string  `json:"reasons,omitempty"`
+}
+
+// ParseKeepWithin parses a keep-within duration. Besides Go durations
+// ("36h") it accepts days, weeks, months and years ("7d", "2w", "6m", "1y").
+func ParseKeepWithin(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return 0, fmt.Errorf("keep-within cannot be negative: %q", value)
+		}
+		return d, nil
+	}
+
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+		"m": 30 * 24 * time.Hour,
+		"y": 365 * 24 * time.Hour,
+	}
+	unit := value[len(value)-1:]
+	n, err := strconv.Atoi(strings.TrimSuffix(value, unit))
+	if err != nil || n < 0 || units[unit] == 0 {
+		return 0, fmt.Errorf("invalid keep-within duration: %q", value)
+	}
+	return time.Duration(n) * units[unit], nil
+}
+
+// ApplyRetention splits snapshots into kept and forgotten ones.
+// Calendar buckets (day, ISO week, month, year) are computed in loc; for each
+// bucket only the newest snapshot counts, and each rule keeps that many
+// distinct buckets. keep-within is measured from the newest snapshot, so an
+// idle source never loses its last backups just because time passes.
+func ApplyRetention(snapshots []models.BackupManifest, policy models.RetentionPolicy, loc *time.Location) (keep, forget []RetentionDecision, err error) {
+	sorted := make([]models.BackupManifest, len(snapshots))
+	copy(sorted, snapshots)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].CreatedAt.Equal(sorted[j].CreatedAt) {
+			return sorted[i].ID > sorted[j].ID
+		}
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	if policy.IsEmpty() {
+		for _, snapshot := range sorted {
+			keep = append(keep, newDecision(snapshot, "no policy"))
+		}
+		return keep, nil, nil
+	}
+
+	within, err := ParseKeepWithin(policy.KeepWithin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buckets := []struct {
+		name  string
+		limit int
+		key   func(time.Time) string
+		seen  map[string]bool
+	}{
+		{"daily", policy.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }, map[string]bool{}},
+		{"weekly", policy.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}, map[string]bool{}},
+		{"monthly", policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }, map[string]bool{}},
+		{"yearly", policy.KeepYearly, func(t time.Time) string { return t.Format("2006") }, map[string]bool{}},
+	}
+
+	for i, snapshot := range sorted {
+		var reasons []string
+		if i < policy.KeepLast {
+			reasons = append(reasons, "last")
+		}
+		if within > 0 && sorted[0].CreatedAt.Sub(snapshot.CreatedAt) <= within {
+			reasons = append(reasons, "within")
+		}
+
+		local := snapshot.CreatedAt.In(loc)
+		for b := range buckets {
+			bucket := &buckets[b]
+			if bucket.limit == 0 {
+				continue
+			}
+			key := bucket.key(local)
+			if bucket.seen[key] || len(bucket.seen) >= bucket.limit {
+				continue
+			}
+			bucket.seen[key] = true
+			reasons = append(reasons, bucket.name)
+		}
+
+		if len(reasons) > 0 {
+			keep = append(keep, newDecision(snapshot, reasons...))
+		} else {
+			forget = append(forget, newDecision(snapshot))
+		}
+	}
+	return keep, forget, nil
+}
+
+func newDecision(snapshot models.BackupManifest, reasons ...string) RetentionDecision {
+	return RetentionDecision{
+		SnapshotID: snapshot.ID,
+		CreatedAt:  snapshot.CreatedAt,
+		Size:       snapshot.TotalSize,
+		Reasons:    reasons,
+	}
+}
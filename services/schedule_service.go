@@ -0,0 +1,173 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ErrInvalidSchedule is returned when a schedule cannot be evaluated
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// ScheduleInput holds the user-editable fields of a backup schedule
+type ScheduleInput struct {
+	Name            string `json:"name"`
+	CronExpr        string `json:"cron_expr"`
+	Timezone        string `json:"timezone"`
+	BackupType      string `json:"backup_type"`
+	ProfileID       *uint  `json:"profile_id"`
+	Format          string `json:"format"`
+	Action          string `json:"action"`
+	VerifyExtract   bool   `json:"verify_extract"`
+	MissedRunPolicy string `json:"missed_run_policy"`
+	KeepLast        int    `json:"keep_last"`
+	KeepDaily       int    `json:"keep_daily"`
+	KeepWeekly      int    `json:"keep_weekly"`
+	KeepMonthly     int    `json:"keep_monthly"`
+	KeepYearly      int    `json:"keep_yearly"`
+	KeepWithin      string `json:"keep_within"`
+	Enabled         *bool  `json:"enabled"`
+}
+
+// ScheduleService manages the backup schedules of each user
+type ScheduleService struct {
+	repo *repositories.ScheduleRepository
+}
+
+func NewScheduleService(repo *repositories.ScheduleRepository) *ScheduleService {
+	return &ScheduleService{repo: repo}
+}
+
+// Create validates and stores a new schedule
+func (s *ScheduleService) Create(ctx context.Context, userID uint, input ScheduleInput) (*models.BackupSchedule, error) {
+	schedule := &models.BackupSchedule{UserID: userID, Enabled: true}
+	if err := applyScheduleInput(schedule, input); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, schedule); err != nil {
+		return nil, fmt.Errorf("failed to create schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+// Update replaces the editable fields of a schedule and recomputes its next run
+func (s *ScheduleService) Update(ctx context.Context, userID, id uint, input ScheduleInput) (*models.BackupSchedule, error) {
+	schedule, err := s.repo.FindByID(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyScheduleInput(schedule, input); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Update(ctx, schedule); err != nil {
+		return nil, fmt.Errorf("failed to update schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+// Find returns a schedule owned by the user
+func (s *ScheduleService) Find(ctx context.Context, userID, id uint) (*models.BackupSchedule, error) {
+	return s.repo.FindByID(ctx, userID, id)
+}
+
+// List returns every schedule of the user
+func (s *ScheduleService) List(ctx context.Context, userID uint) ([]models.BackupSchedule, error) {
+	return s.repo.ListByUser(ctx, userID)
+}
+
+// Delete removes a schedule; backups it already produced are kept
+func (s *ScheduleService) Delete(ctx context.Context, userID, id uint) error {
+	return s.repo.Delete(ctx, userID, id)
+}
+
+// NextRun returns the first occurrence of a cron expression after the given
+// time, evaluated in timezone so that daylight saving changes are respected
+func NextRun(expr, timezone string, after time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: unknown timezone %q", ErrInvalidSchedule, timezone)
+	}
+	spec, err := cron.ParseStandard(expr)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+
+	next := spec.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("%w: %q never fires", ErrInvalidSchedule, expr)
+	}
+	return next.UTC(), nil
+}
+
+func applyScheduleInput(schedule *models.BackupSchedule, input ScheduleInput) error {
+	if input.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidSchedule)
+	}
+	if (input.BackupType == "") == (input.ProfileID == nil) {
+		return fmt.Errorf("%w: either a backup type or a profile is required", ErrInvalidSchedule)
+	}
+	if input.Timezone == "" {
+		input.Timezone = "UTC"
+	}
+	switch input.MissedRunPolicy {
+	case "":
+		input.MissedRunPolicy = models.MissedRunCatchUp
+	case models.MissedRunCatchUp, models.MissedRunSkip:
+	default:
+		return fmt.Errorf("%w: unknown missed run policy %q", ErrInvalidSchedule, input.MissedRunPolicy)
+	}
+	if input.KeepLast < 0 || input.KeepDaily < 0 || input.KeepWeekly < 0 || input.KeepMonthly < 0 || input.KeepYearly < 0 {
+		return fmt.Errorf("%w: retention counts cannot be negative", ErrInvalidSchedule)
+	}
+	if _, err := ParseKeepWithin(input.KeepWithin); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+	}
+	switch input.Action {
+	case "":
+		input.Action = models.ScheduleActionBackup
+	case models.ScheduleActionBackup:
+	case models.ScheduleActionVerify:
+		// Uma verificação não produz snapshots, então não há o que reter
+		if input.KeepLast > 0 || input.KeepDaily > 0 || input.KeepWeekly > 0 || input.KeepMonthly > 0 || input.KeepYearly > 0 || input.KeepWithin != "" {
+			return fmt.Errorf("%w: verify schedules cannot have a retention policy", ErrInvalidSchedule)
+		}
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidSchedule, input.Action)
+	}
+	if input.VerifyExtract && input.Action != models.ScheduleActionVerify {
+		return fmt.Errorf("%w: verify_extract requires the verify action", ErrInvalidSchedule)
+	}
+
+	next, err := NextRun(input.CronExpr, input.Timezone, time.Now())
+	if err != nil {
+		return err
+	}
+
+	schedule.Name = input.Name
+	schedule.CronExpr = input.CronExpr
+	schedule.Timezone = input.Timezone
+	schedule.BackupType = input.BackupType
+	schedule.ProfileID = input.ProfileID
+	schedule.Format = input.Format
+	schedule.Action = input.Action
+	schedule.VerifyExtract = input.VerifyExtract
+	schedule.MissedRunPolicy = input.MissedRunPolicy
+	schedule.Retention = models.RetentionPolicy{
+		KeepLast:    input.KeepLast,
+		KeepDaily:   input.KeepDaily,
+		KeepWeekly:  input.KeepWeekly,
+		KeepMonthly: input.KeepMonthly,
+		KeepYearly:  input.KeepYearly,
+		KeepWithin:  input.KeepWithin,
+	}
+	schedule.NextRunAt = next
+	if input.Enabled != nil {
+		schedule.Enabled = *input.Enabled
+	}
+	return nil
+}
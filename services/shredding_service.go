@@ -0,0 +1,109 @@
+package services
+
+import (
+	"SafeBox/models"
+	"SafeBox/repositories"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// DestructionLogStatus is the result of verifying the key destruction chain
+type DestructionLogStatus struct {
+	Valid     bool   `json:"valid"`
+	Entries   int    `json:"entries"`
+	BrokenAt  uint   `json:"broken_at,omitempty"`
+	FinalHash string `json:"final_hash,omitempty"`
+}
+
+// ShreddingService deletes data by destroying its keys first, so any
+// ciphertext left behind in R2 or on P2P replicas becomes unreadable.
+// The physical objects are removed later by the garbage collection job.
+type ShreddingService struct {
+	repo     *repositories.ShreddingRepository
+	metadata *FileMetadataService
+}
+
+func NewShreddingService(repo *repositories.ShreddingRepository, metadata *FileMetadataService) *ShreddingService {
+	return &ShreddingService{
+		repo:     repo,
+		metadata: metadata,
+	}
+}
+
+// DeleteFile crypto-shreds a single object of the user
+func (s *ShreddingService) DeleteFile(ctx context.Context, userID uint, objectID string) (*models.KeyDestruction, error) {
+	obj, err := s.metadata.FindByObjectID(ctx, userID, objectID)
+	if err != nil {
+		return nil, fmt.Errorf("file not found: %w", err)
+	}
+
+	record, err := s.repo.ShredFile(ctx, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to shred file: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"user":   userID,
+		"object": objectID,
+	}).Info("File key destroyed, object queued for deletion")
+	return record, nil
+}
+
+// DeleteObject crypto-shreds an object that may not have registered metadata,
+// such as the encrypted body of a backup manifest
+func (s *ShreddingService) DeleteObject(ctx context.Context, userID uint, objectID string) (*models.KeyDestruction, error) {
+	obj, err := s.metadata.FindByObjectID(ctx, userID, objectID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		obj = &models.FileObject{UserID: userID, ObjectID: objectID}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up object: %w", err)
+	}
+
+	record, err := s.repo.ShredFile(ctx, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to shred object: %w", err)
+	}
+	return record, nil
+}
+
+// DeleteUser crypto-shreds every key of the user and removes the account
+func (s *ShreddingService) DeleteUser(ctx context.Context, userID uint) (*models.KeyDestruction, error) {
+	record, err := s.repo.ShredUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to shred user: %w", err)
+	}
+
+	logrus.WithField("user", userID).Info("User keys destroyed, objects queued for deletion")
+	return record, nil
+}
+
+// UserDestructions returns the destruction records of the user
+func (s *ShreddingService) UserDestructions(ctx context.Context, userID uint) ([]models.KeyDestruction, error) {
+	return s.repo.ListUserDestructions(ctx, userID)
+}
+
+// VerifyLog recomputes the destruction hash chain and reports the first broken entry
+func (s *ShreddingService) VerifyLog(ctx context.Context) (*DestructionLogStatus, error) {
+	records, err := s.repo.ListDestructions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read destruction log: %w", err)
+	}
+
+	status := &DestructionLogStatus{Valid: true, Entries: len(records)}
+	prev := ""
+	for i := range records {
+		record := &records[i]
+		if record.PrevHash != prev || record.ComputeHash() != record.Hash {
+			status.Valid = false
+			status.BrokenAt = record.ID
+			return status, nil
+		}
+		prev = record.Hash
+	}
+	status.FinalHash = prev
+	return status, nil
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"SafeBox/models"
+	"errors"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrSnapshotPathNotFound is returned when browsing a directory that is not in the snapshot
+var ErrSnapshotPathNotFound = errors.New("path not found in snapshot")
+
+// Snapshot is the summary of a backup run as exposed by the API
+type Snapshot struct {
+	ID         uint      `json:"id"`
+	Source     string    `json:"source"`
+	ParentID   *uint     `json:"parent_id,omitempty"`
+	ScheduleID *uint     `json:"schedule_id,omitempty"`
+	Mode       string    `json:"mode"`
+	FileCount  int       `json:"file_count"`
+	TotalSize  int64     `json:"total_size"`
+	Signed     bool      `json:"signed"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TreeNode is one child of a directory inside a snapshot
+type TreeNode struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Type    string    `json:"type"` // "dir" ou "file"
+	Size    int64     `json:"size"`
+	Files   int       `json:"files,omitempty"` // arquivos abaixo de um diretório
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// DiffEntry is a file that differs between two snapshots
+type DiffEntry struct {
+	Path    string `json:"path"`
+	OldSize int64  `json:"old_size,omitempty"`
+	NewSize int64  `json:"new_size,omitempty"`
+	OldHash string `json:"old_sha256,omitempty"`
+	NewHash string `json:"new_sha256,omitempty"`
+}
+
+// SnapshotDiff lists the files added, removed and modified from one snapshot to another
+type SnapshotDiff struct {
+	From      uint        `json:"from"`
+	To        uint        `json:"to"`
+	Added     []DiffEntry `json:"added"`
+	Removed   []DiffEntry `json:"removed"`
+	Modified  []DiffEntry `json:"modified"`
+	Unchanged int         `json:"unchanged"`
+}
+
+// NewSnapshot returns the API view of a manifest record
+func NewSnapshot(record *models.BackupManifest) Snapshot {
+	return Snapshot{
+		ID:         record.ID,
+		Source:     record.AppName,
+		ParentID:   record.ParentID,
+		ScheduleID: record.ScheduleID,
+		Mode:       record.Mode,
+		FileCount:  record.FileCount,
+		TotalSize:  record.TotalSize,
+		Signed:     len(record.Signature) > 0,
+		CreatedAt:  record.CreatedAt,
+	}
+}
+
+// SnapshotPath returns the path of an entry relative to the root of its snapshot
+func SnapshotPath(manifest *Manifest, entry ManifestEntry) string {
+	p := filepath.ToSlash(entry.Path)
+	root := path.Join("backups", manifest.AppName) + "/"
+	return strings.TrimPrefix(p, root)
+}
+
+// BrowseTree lists the direct children of dir in a snapshot, directories first.
+// An empty dir lists the snapshot root.
+func BrowseTree(manifest *Manifest, dir string) ([]TreeNode, error) {
+	dir = strings.Trim(path.Clean("/"+filepath.ToSlash(dir)), "/")
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	dirs := map[string]*TreeNode{}
+	var nodes []TreeNode
+	found := dir == ""
+	for _, entry := range manifest.Entries {
+		p := SnapshotPath(manifest, entry)
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		found = true
+
+		rest := strings.TrimPrefix(p, prefix)
+		name, _, isDir := strings.Cut(rest, "/")
+		if !isDir {
+			nodes = append(nodes, TreeNode{
+				Name:    name,
+				Path:    p,
+				Type:    "file",
+				Size:    entry.Size,
+				ModTime: entry.ModTime,
+				SHA256:  entry.SHA256,
+			})
+			continue
+		}
+
+		node, ok := dirs[name]
+		if !ok {
+			node = &TreeNode{Name: name, Path: prefix + name, Type: "dir"}
+			dirs[name] = node
+		}
+		node.Size += entry.Size
+		node.Files++
+		if entry.ModTime.After(node.ModTime) {
+			node.ModTime = entry.ModTime
+		}
+	}
+	if !found {
+		return nil, ErrSnapshotPathNotFound
+	}
+
+	children := make([]TreeNode, 0, len(dirs)+len(nodes))
+	for _, node := range dirs {
+		children = append(children, *node)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return append(children, nodes...), nil
+}
+
+// DiffSnapshots compares two snapshots by path and content hash
+func DiffSnapshots(fromID uint, from *Manifest, toID uint, to *Manifest) SnapshotDiff {
+	diff := SnapshotDiff{
+		From:     fromID,
+		To:       toID,
+		Added:    []DiffEntry{},
+		Removed:  []DiffEntry{},
+		Modified: []DiffEntry{},
+	}
+
+	old := make(map[string]ManifestEntry, len(from.Entries))
+	for _, entry := range from.Entries {
+		old[SnapshotPath(from, entry)] = entry
+	}
+
+	for _, entry := range to.Entries {
+		p := SnapshotPath(to, entry)
+		prev, ok := old[p]
+		delete(old, p)
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, DiffEntry{Path: p, NewSize: entry.Size, NewHash: entry.SHA256})
+		case prev.SHA256 != entry.SHA256 || prev.Size != entry.Size:
+			diff.Modified = append(diff.Modified, DiffEntry{
+				Path:    p,
+				OldSize: prev.Size,
+				NewSize: entry.Size,
+				OldHash: prev.SHA256,
+				NewHash: entry.SHA256,
+			})
+		default:
+			diff.Unchanged++
+		}
+	}
+	for p, entry := range old {
+		diff.Removed = append(diff.Removed, DiffEntry{Path: p, OldSize: entry.Size, OldHash: entry.SHA256})
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Path < diff.Added[j].Path })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Path < diff.Removed[j].Path })
+	sort.Slice(diff.Modified, func(i, j int) bool { return diff.Modified[i].Path < diff.Modified[j].Path })
+	return diff
+}
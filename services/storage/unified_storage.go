@@ -14,6 +14,8 @@ const (
 	R2
 )
 
+// StorageRepository stores objects per user. fileName is the opaque object ID
+// assigned by FileMetadataService, never the user's original file name.
 type StorageRepository interface {
 	Save(ctx context.Context, file io.Reader, userID uint, fileName string) error
 	GetTotalUsage(ctx context.Context, userID uint) (int64, error)
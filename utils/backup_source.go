@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// Tipos de origem de backup
+const (
+	SourceDirectory = "directory"
+	SourceSQLite    = "sqlite"
+	SourceGit       = "git"
+	SourceCommand   = "command"
+)
+
+const (
+	// sqliteBackupPages is how many pages each step of a SQLite backup copies
+	sqliteBackupPages = 1024
+	// sqliteBusyRetries is how many times a step blocked by a writer is retried
+	sqliteBusyRetries = 50
+	// DefaultSourceTimeout bounds git bundles and commands that set no timeout
+	DefaultSourceTimeout = time.Hour
+)
+
+// BackupSource is something a backup run can copy. Open makes its content
+// available as a directory tree, which the run then walks, compresses,
+// encrypts and stores like any other. Sources that are not plain files write
+// a consistent copy into staging, an empty directory owned by the run and
+// removed once it ends.
+type BackupSource interface {
+	Kind() string
+	Open(ctx context.Context, staging string) (root string, err error)
+}
+
+// DirectorySource is a directory tree backed up as it is on disk
+type DirectorySource struct {
+	Root string
+}
+
+func (s DirectorySource) Kind() string { return SourceDirectory }
+
+func (s DirectorySource) Open(ctx context.Context, staging string) (string, error) {
+	info, err := os.Stat(s.Root)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", s.Root)
+	}
+	return s.Root, nil
+}
+
+// SQLiteSource copies a SQLite database through the online backup API, so
+// the copy is consistent even while other connections write to it. The copy
+// is stored as File.
+type SQLiteSource struct {
+	Path string
+	File string
+}
+
+func (s SQLiteSource) Kind() string { return SourceSQLite }
+
+func (s SQLiteSource) Open(ctx context.Context, staging string) (string, error) {
+	if info, err := os.Stat(s.Path); err != nil {
+		return "", err
+	} else if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a file", s.Path)
+	}
+
+	// Somente leitura: a origem nunca é criada nem alterada
+	source := url.URL{Scheme: "file", OmitHost: true, Path: filepath.ToSlash(s.Path), RawQuery: "mode=ro&_pragma=busy_timeout(5000)"}
+	db, err := sql.Open("sqlite", source.String())
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", s.Path, err)
+	}
+	defer conn.Close()
+
+	dst := filepath.Join(staging, s.File)
+	err = conn.Raw(func(driverConn interface{}) error {
+		backuper, ok := driverConn.(interface {
+			NewBackup(dstUri string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return errors.New("sqlite driver does not support online backups")
+		}
+		backup, err := backuper.NewBackup(dst)
+		if err != nil {
+			return err
+		}
+		return copySQLite(ctx, backup)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to back up %s: %w", s.Path, err)
+	}
+	return staging, nil
+}
+
+// copySQLite runs a backup to the end in small steps, so writers are only
+// blocked briefly; a step that finds the database locked is retried
+func copySQLite(ctx context.Context, backup *sqlite.Backup) error {
+	busy := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			backup.Finish()
+			return err
+		}
+		more, err := backup.Step(sqliteBackupPages)
+		if err != nil {
+			message := err.Error()
+			if busy < sqliteBusyRetries && (strings.Contains(message, "SQLITE_BUSY") || strings.Contains(message, "SQLITE_LOCKED")) {
+				busy++
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			backup.Finish()
+			return err
+		}
+		if !more {
+			return backup.Finish()
+		}
+		busy = 0
+	}
+}
+
+// gitEnv is the environment git runs with besides HOME: no variable of the
+// server reaches it, and only the repository's own configuration is read
+var gitEnv = []string{
+	"PATH=/usr/local/bin:/usr/bin:/bin",
+	"GIT_TERMINAL_PROMPT=0",
+	"GIT_CONFIG_NOSYSTEM=1",
+	"GIT_CONFIG_GLOBAL=" + os.DevNull,
+}
+
+// GitSource stores a git repository as a bundle with every ref, from which
+// it can be cloned again. The bundle is stored as File.
+type GitSource struct {
+	Repo    string
+	File    string
+	Timeout time.Duration
+}
+
+func (s GitSource) Kind() string { return SourceGit }
+
+func (s GitSource) Open(ctx context.Context, staging string) (string, error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSourceTimeout
+	}
+	dst := filepath.Join(staging, s.File)
+	// A configuração do repositório não pode executar comandos durante o bundle,
+	// e o git não herda o ambiente do servidor, como faz um hook
+	command := HookCommand{
+		Path:    "git",
+		Args:    []string{"-c", "core.fsmonitor=false", "-C", s.Repo, "bundle", "create", dst, "--all"},
+		Env:     append([]string{"HOME=" + staging}, gitEnv...),
+		Timeout: timeout,
+	}
+	if output, err := runCommand(ctx, command, &hookBuffer{}, &hookBuffer{}); err != nil {
+		return "", commandError("git bundle", err, output)
+	}
+	return staging, nil
+}
+
+// CommandSource stores the standard output of a command, such as pg_dump, as
+// File. A command that fails or times out fails the source.
+type CommandSource struct {
+	Command HookCommand
+	File    string
+}
+
+func (s CommandSource) Kind() string { return SourceCommand }
+
+func (s CommandSource) Open(ctx context.Context, staging string) (string, error) {
+	if s.Command.Timeout <= 0 {
+		s.Command.Timeout = DefaultSourceTimeout
+	}
+	file, err := os.OpenFile(filepath.Join(staging, s.File), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	output, err := runCommand(ctx, s.Command, file, &hookBuffer{})
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		return "", closeErr
+	}
+	if err != nil {
+		return "", commandError(filepath.Base(s.Command.Path), err, output)
+	}
+	return staging, nil
+}
+
+// ValidSourceFile reports whether name can be the file a source is stored as
+func ValidSourceFile(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// commandError adds the last line written to stderr to the error of a command
+func commandError(name string, err error, output HookOutput) error {
+	if line := lastLine(output.Output); line != "" {
+		return fmt.Errorf("%s failed: %w: %s", name, err, line)
+	}
+	return fmt.Errorf("%s failed: %w", name, err)
+}
+
+func lastLine(output string) string {
+	output = strings.TrimSpace(output)
+	if i := strings.LastIndexByte(output, '\n'); i >= 0 {
+		return strings.TrimSpace(output[i+1:])
+	}
+	return output
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// ChunkOptions bounds the size of the chunks produced by a Chunker.
+// AvgSize must be a power of two.
+type ChunkOptions struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+// DefaultChunkOptions is used for backups and uploads. Changing it, or the
+// gear table, moves every cut point and disables deduplication against
+// chunks stored before the change.
+var DefaultChunkOptions = ChunkOptions{
+	MinSize: 256 * 1024,
+	AvgSize: 1024 * 1024,
+	MaxSize: 4 * 1024 * 1024,
+}
+
+// gearTable holds the random values of the gear rolling hash. It is derived
+// from a fixed seed so cut points are stable across builds and replicas.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x5afeb0c5afeb0c5a)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker splits a stream into content-defined chunks using FastCDC with
+// normalized chunking: cut points depend only on the bytes around them, so
+// an edit inside a large file only changes the chunks it touches.
+type Chunker struct {
+	src          io.Reader
+	opts         ChunkOptions
+	maskS, maskL uint64
+	buf          []byte
+	start, end   int
+	eof          bool
+}
+
+// NewChunker returns a chunker reading from src
+func NewChunker(src io.Reader, opts ChunkOptions) (*Chunker, error) {
+	if opts.MinSize <= 0 || opts.AvgSize <= opts.MinSize || opts.MaxSize <= opts.AvgSize {
+		return nil, errors.New("chunk sizes must satisfy 0 < min < avg < max")
+	}
+	if opts.AvgSize&(opts.AvgSize-1) != 0 {
+		return nil, errors.New("average chunk size must be a power of two")
+	}
+	// Abaixo da média o corte é mais difícil, acima dele mais fácil, o que
+	// concentra os tamanhos em torno da média (normalização nível 2)
+	avgBits := bits.TrailingZeros(uint(opts.AvgSize))
+	return &Chunker{
+		src:   src,
+		opts:  opts,
+		maskS: highMask(avgBits + 2),
+		maskL: highMask(avgBits - 2),
+		buf:   make([]byte, 2*opts.MaxSize),
+	}, nil
+}
+
+// Next returns the next chunk, or io.EOF after the last one.
+// The returned slice is only valid until the following call.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.opts.MaxSize && !c.eof {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill moves the unread bytes to the front of the buffer and reads until it
+// is full or the source ends
+func (c *Chunker) fill() error {
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	for c.end < len(c.buf) {
+		n, err := c.src.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.opts.MinSize {
+		return n
+	}
+	if n > c.opts.MaxSize {
+		n = c.opts.MaxSize
+	}
+	normal := c.opts.AvgSize
+	if n < normal {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.opts.MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// highMask sets the n most significant bits, which depend on the last 64 bytes
+// seen by the gear hash rather than only the most recent ones
+func highMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
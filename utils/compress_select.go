@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CompressionNone stores data as-is; used for content that does not compress
+const CompressionNone CompressionAlgorithm = "none"
+
+const (
+	// sampleSize is how much of the input is inspected before choosing a codec
+	sampleSize = 64 * 1024
+	// Acima destes limites (bits por byte) a compressão não compensa ou compensa pouco
+	storeEntropy = 7.5
+	fastEntropy  = 6.0
+)
+
+var headerMagic = []byte("SBZ1")
+
+var headerCodecs = map[CompressionAlgorithm]byte{
+	CompressionNone: 0,
+	CompressionZstd: 1,
+	CompressionGzip: 2,
+}
+
+// incompressibleTypes are content types that are already compressed
+var incompressibleTypes = []string{
+	"image/jpeg", "image/png", "image/gif", "image/webp",
+	"video/", "audio/",
+	"application/zip", "application/x-gzip", "application/x-rar-compressed",
+	"application/x-7z-compressed", "application/pdf",
+}
+
+// CompressionStats describes a finished compression stream
+type CompressionStats struct {
+	Options     CompressionOptions
+	ContentType string
+	Entropy     float64
+	InputBytes  int64
+	OutputBytes int64
+	Duration    time.Duration
+}
+
+// Ratio returns output size over input size
+func (s *CompressionStats) Ratio() float64 {
+	if s.InputBytes == 0 {
+		return 1
+	}
+	return float64(s.OutputBytes) / float64(s.InputBytes)
+}
+
+// SelectCompression picks store, fast or strong compression for a sample of
+// the content. base is the strong setting used for compressible data.
+func SelectCompression(sample []byte, base CompressionOptions) (CompressionOptions, string, float64) {
+	contentType := http.DetectContentType(sample)
+	entropy := ShannonEntropy(sample)
+
+	for _, t := range incompressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return CompressionOptions{Algorithm: CompressionNone}, contentType, entropy
+		}
+	}
+
+	switch {
+	case entropy >= storeEntropy:
+		return CompressionOptions{Algorithm: CompressionNone}, contentType, entropy
+	case entropy >= fastEntropy:
+		return CompressionOptions{Algorithm: base.Algorithm, Level: 1}, contentType, entropy
+	default:
+		return base, contentType, entropy
+	}
+}
+
+// ShannonEntropy returns the entropy of data in bits per byte (0 to 8)
+func ShannonEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	entropy := 0.0
+	total := float64(len(data))
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / total
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// CompressAuto sniffs the first block of src, selects a codec with
+// SelectCompression and returns a stream prefixed with a header recording that
+// codec, so DecompressAuto needs no out-of-band information.
+// The returned stats are complete once the stream has been read to EOF.
+func CompressAuto(src io.Reader, base CompressionOptions) (io.ReadCloser, *CompressionStats, error) {
+	buffered := bufio.NewReaderSize(src, sampleSize)
+	sample, err := buffered.Peek(sampleSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, nil, err
+	}
+
+	opts, contentType, entropy := SelectCompression(sample, base)
+	stats := &CompressionStats{Options: opts, ContentType: contentType, Entropy: entropy}
+	stream, err := compressWithHeader(buffered, stats)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stream, stats, nil
+}
+
+// CompressWithHeader compresses src with the given codec, skipping content
+// detection, and writes the same header as CompressAuto. Used for archives,
+// whose first block says little about the rest of the stream.
+func CompressWithHeader(src io.Reader, opts CompressionOptions) (io.ReadCloser, *CompressionStats, error) {
+	stats := &CompressionStats{Options: opts}
+	stream, err := compressWithHeader(src, stats)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stream, stats, nil
+}
+
+func compressWithHeader(src io.Reader, stats *CompressionStats) (io.ReadCloser, error) {
+	opts := stats.Options
+	if opts.Algorithm == "" {
+		opts.Algorithm = CompressionZstd
+		stats.Options = opts
+	}
+	codec, ok := headerCodecs[opts.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compression algorithm: %s", opts.Algorithm)
+	}
+
+	input := &countingReader{r: src}
+	var (
+		body io.ReadCloser
+		err  error
+	)
+	if opts.Algorithm == CompressionNone {
+		body = io.NopCloser(input)
+	} else if body, err = CompressStream(input, opts); err != nil {
+		return nil, err
+	}
+
+	header := append(append([]byte{}, headerMagic...), codec, byte(opts.Level))
+	return &statsReader{
+		r:     io.MultiReader(bytes.NewReader(header), body),
+		body:  body,
+		input: input,
+		stats: stats,
+		start: time.Now(),
+	}, nil
+}
+
+// DecompressAuto reads the header written by CompressAuto and returns the
+// decompressed stream
+func DecompressAuto(src io.Reader) (io.ReadCloser, error) {
+	header := make([]byte, len(headerMagic)+2)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, fmt.Errorf("failed to read compression header: %w", err)
+	}
+	if string(header[:len(headerMagic)]) != string(headerMagic) {
+		return nil, errors.New("invalid compression header")
+	}
+
+	codec := header[len(headerMagic)]
+	for algorithm, id := range headerCodecs {
+		if id != codec {
+			continue
+		}
+		if algorithm == CompressionNone {
+			return io.NopCloser(src), nil
+		}
+		return DecompressStream(src, algorithm)
+	}
+	return nil, fmt.Errorf("unknown compression codec %d", codec)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// statsReader fills CompressionStats as the compressed stream is consumed
+type statsReader struct {
+	r     io.Reader
+	body  io.Closer
+	input *countingReader
+	stats *CompressionStats
+	start time.Time
+	done  bool
+}
+
+func (s *statsReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.stats.OutputBytes += int64(n)
+	if errors.Is(err, io.EOF) && !s.done {
+		s.done = true
+		s.stats.InputBytes = atomic.LoadInt64(&s.input.n)
+		s.stats.Duration = time.Since(s.start)
+	}
+	return n, err
+}
+
+func (s *statsReader) Close() error {
+	return s.body.Close()
+}
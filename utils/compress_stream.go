@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type CompressionAlgorithm string
+
+const (
+	CompressionZstd CompressionAlgorithm = "zstd"
+	CompressionGzip CompressionAlgorithm = "gzip"
+)
+
+// CompressionOptions selects the codec and level of a compression stream.
+// Level follows the codec's own scale (1-22 for zstd, 1-9 for gzip); zero means default.
+type CompressionOptions struct {
+	Algorithm CompressionAlgorithm
+	Level     int
+}
+
+// DefaultCompression is zstd at its default level
+var DefaultCompression = CompressionOptions{Algorithm: CompressionZstd, Level: 3}
+
+// CompressStream returns a reader yielding the compressed form of src.
+// Compression runs in a goroutine over a pipe, so memory stays bounded by the
+// codec window regardless of the input size. Errors surface on Read; closing
+// the reader early stops the goroutine.
+func CompressStream(src io.Reader, opts CompressionOptions) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+
+	var (
+		enc io.WriteCloser
+		err error
+	)
+	switch opts.Algorithm {
+	case CompressionZstd, "":
+		level := zstd.SpeedDefault
+		if opts.Level > 0 {
+			level = zstd.EncoderLevelFromZstd(opts.Level)
+		}
+		enc, err = zstd.NewWriter(pw, zstd.WithEncoderLevel(level))
+	case CompressionGzip:
+		level := gzip.DefaultCompression
+		if opts.Level > 0 {
+			level = opts.Level
+		}
+		enc, err = gzip.NewWriterLevel(pw, level)
+	default:
+		err = fmt.Errorf("unsupported compression algorithm: %s", opts.Algorithm)
+	}
+	if err != nil {
+		pw.Close()
+		return nil, err
+	}
+
+	go func() {
+		if _, err := io.Copy(enc, src); err != nil {
+			enc.Close()
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(enc.Close())
+	}()
+
+	return pr, nil
+}
+
+// DecompressStream returns a reader yielding the decompressed form of src
+func DecompressStream(src io.Reader, algorithm CompressionAlgorithm) (io.ReadCloser, error) {
+	switch algorithm {
+	case CompressionZstd, "":
+		dec, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case CompressionGzip:
+		return gzip.NewReader(src)
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm: %s", algorithm)
+	}
+}
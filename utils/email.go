@@ -3,8 +3,13 @@ package utils
 import (
 	"net/smtp"
 	"os"
+	"strings"
+	"unicode"
 )
 
+// headerBreaks removes the line breaks that would let a value start a new header
+var headerBreaks = strings.NewReplacer("\r", "", "\n", "")
+
 // SendEmail sends an email to the specified recipient
 func SendEmail(to, subject, body string) error {
 	from := os.Getenv("EMAIL_FROM")
@@ -12,11 +17,21 @@ func SendEmail(to, subject, body string) error {
 	smtpHost := os.Getenv("SMTP_HOST")
 	smtpPort := os.Getenv("SMTP_PORT")
 
-	msg := "From: " + from + "\n" +
-		"To: " + to + "\n" +
-		"Subject: " + subject + "\n\n" +
+	auth := smtp.PlainAuth("", from, password, smtpHost)
+	return smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, []byte(emailMessage(from, to, subject, body)))
+}
+
+// emailMessage builds the message sent by SendEmail. Header values are
+// stripped of CR and LF, so a name in the subject cannot add headers.
+func emailMessage(from, to, subject, body string) string {
+	return "From: " + headerBreaks.Replace(from) + "\n" +
+		"To: " + headerBreaks.Replace(to) + "\n" +
+		"Subject: " + headerBreaks.Replace(subject) + "\n\n" +
 		body
+}
 
-	auth := smtp.PlainAuth("", from, password, smtpHost)
-	return smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, []byte(msg))
+// ContainsControl reports whether s holds a control character. Names that
+// end up in e-mail subjects or logs are rejected when it does.
+func ContainsControl(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
 }
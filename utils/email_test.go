@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailMessageStripsHeaderBreaks(t *testing.T) {
+	msg := emailMessage("safebox@example.com", "user@example.com\r\nBcc: evil@example.com",
+		"Backup of photos\r\nBcc: evil@example.com", "line 1\nline 2")
+
+	headers, body, _ := strings.Cut(msg, "\n\n")
+	assert.Equal(t, []string{
+		"From: safebox@example.com",
+		"To: user@example.comBcc: evil@example.com",
+		"Subject: Backup of photosBcc: evil@example.com",
+	}, strings.Split(headers, "\n"))
+	assert.Equal(t, "line 1\nline 2", body)
+}
+
+func TestContainsControl(t *testing.T) {
+	assert.False(t, ContainsControl("fotos de família"))
+	for _, name := range []string{"a\rb", "a\nb", "a\tb", "a\x00b", "a\u0085b"} {
+		assert.True(t, ContainsControl(name), "%q", name)
+	}
+}
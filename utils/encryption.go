@@ -1,9 +1,14 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 )
 
@@ -61,3 +66,99 @@ func DecryptStream(ciphertext io.Reader, plaintext io.Writer, key []byte) error
 
 	return nil
 }
+
+// NewObjectID returns a random opaque identifier used as the storage key of an object
+func NewObjectID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// SealBytes encrypts and authenticates a small value with AES-GCM.
+// The random nonce is prepended to the returned ciphertext.
+func SealBytes(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// OpenBytes decrypts a value produced by SealBytes
+func OpenBytes(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// DeriveSubkey derives an independent 256-bit key for the given purpose
+func DeriveSubkey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// BlindIndex returns a deterministic keyed digest of value, allowing exact
+// lookups without storing the value in clear text
+func BlindIndex(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptReader returns a reader yielding the IV followed by the AES-CTR
+// ciphertext of plaintext, in the same format as EncryptStream
+func EncryptReader(plaintext io.Reader, key []byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+
+	stream := cipher.NewCTR(block, iv)
+	return io.MultiReader(bytes.NewReader(iv), &cipher.StreamReader{S: stream, R: plaintext}), nil
+}
+
+// DecryptReader returns a reader yielding the plaintext of a stream produced
+// by EncryptStream or EncryptReader
+func DecryptReader(ciphertext io.Reader, key []byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(ciphertext, iv); err != nil {
+		return nil, err
+	}
+
+	stream := cipher.NewCTR(block, iv)
+	return &cipher.StreamReader{S: stream, R: ciphertext}, nil
+}
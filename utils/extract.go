@@ -0,0 +1,367 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsafePath       = errors.New("archive entry escapes the destination directory")
+	ErrArchiveTooLarge  = errors.New("archive exceeds the extraction size limit")
+	ErrCompressionRatio = errors.New("archive entry exceeds the compression ratio limit")
+	ErrTooManyEntries   = errors.New("archive has too many entries")
+)
+
+// ExtractOptions bounds what an extraction may write, protecting against
+// decompression bombs. Zero values disable the corresponding limit.
+type ExtractOptions struct {
+	MaxTotalSize  int64   // total de bytes descomprimidos
+	MaxFileSize   int64   // bytes descomprimidos por entrada
+	MaxRatio      float64 // tamanho descomprimido / comprimido por entrada
+	MaxEntries    int
+	AllowSymlinks bool // links simbólicos só são criados se apontarem para dentro do destino
+}
+
+// DefaultExtractOptions matches the limits used when creating backups
+var DefaultExtractOptions = ExtractOptions{
+	MaxTotalSize: 100 * 1024 * 1024 * 1024, // 100 GB
+	MaxFileSize:  25 * 1024 * 1024 * 1024,  // 25 GB
+	MaxRatio:     200,
+	MaxEntries:   1_000_000,
+}
+
+// Decompress extracts zip data produced by Compress into destDir
+func Decompress(data []byte, destDir string, opts ExtractOptions) error {
+	return ExtractZip(bytes.NewReader(data), int64(len(data)), destDir, opts)
+}
+
+// ExtractZipFile extracts a zip file, such as one written by ZipDirectory, into destDir
+func ExtractZipFile(zipPath, destDir string, opts ExtractOptions) error {
+	f, err := os.Open(zipPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return ExtractZip(f, info.Size(), destDir, opts)
+}
+
+// ExtractZip safely extracts a zip archive into destDir.
+// Entries whose names or symlink targets resolve outside destDir are rejected,
+// files are never written through existing symlinks, sizes and compression
+// ratios are enforced on the bytes actually produced, and modification times
+// are preserved.
+func ExtractZip(r io.ReaderAt, size int64, destDir string, opts ExtractOptions) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+	if opts.MaxEntries > 0 && len(zr.File) > opts.MaxEntries {
+		return ErrTooManyEntries
+	}
+
+	root, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	var (
+		total    int64
+		dirTimes = map[string]time.Time{}
+		links    = NewLinkGuard(root)
+	)
+	for _, entry := range zr.File {
+		target, err := SafeJoin(root, entry.Name)
+		if err != nil {
+			return err
+		}
+		if err := CheckParents(root, target); err != nil {
+			return err
+		}
+
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirTimes[target] = entry.Modified
+			continue
+		case mode&os.ModeSymlink != 0:
+			if err := extractSymlink(links, target, entry, opts); err != nil {
+				return err
+			}
+			continue
+		case !mode.IsRegular():
+			continue // dispositivos, pipes etc. nunca são restaurados
+		}
+
+		written, err := extractZipEntry(target, entry, opts, remaining(opts.MaxTotalSize, total))
+		total += written
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %w", entry.Name, err)
+		}
+	}
+
+	// Diretórios por último, pois criar arquivos altera o mtime do diretório pai
+	for dir, modTime := range dirTimes {
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtractStream decompresses a stream written by CompressAuto into a single
+// file at destPath. The file is written to a temporary name first and only
+// renamed into place once it was fully decompressed within the size limit.
+func ExtractStream(src io.Reader, destPath string, modTime time.Time, opts ExtractOptions) error {
+	dec, err := DecompressAuto(src)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+
+	limit := opts.MaxFileSize
+	if opts.MaxTotalSize > 0 && (limit == 0 || opts.MaxTotalSize < limit) {
+		limit = opts.MaxTotalSize
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := copyLimited(tmp, dec, limit); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), destPath); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		return os.Chtimes(destPath, modTime, modTime)
+	}
+	return nil
+}
+
+func extractZipEntry(target string, entry *zip.File, opts ExtractOptions, totalLeft int64) (int64, error) {
+	if totalLeft == 0 {
+		return 0, ErrArchiveTooLarge
+	}
+	limit := opts.MaxFileSize
+	if totalLeft >= 0 && (limit == 0 || totalLeft < limit) {
+		limit = totalLeft
+	}
+	if opts.MaxRatio > 0 && entry.CompressedSize64 > 0 {
+		ratioLimit := int64(float64(entry.CompressedSize64) * opts.MaxRatio)
+		if limit == 0 || ratioLimit < limit {
+			limit = ratioLimit
+		}
+	}
+
+	src, err := entry.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+	// O_EXCL evita sobrescrever um link simbólico criado por uma entrada anterior
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, entry.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := copyLimited(dst, src, limit)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if errors.Is(err, ErrArchiveTooLarge) && opts.MaxRatio > 0 && entry.CompressedSize64 > 0 &&
+			float64(written) >= float64(entry.CompressedSize64)*opts.MaxRatio {
+			err = ErrCompressionRatio
+		}
+		os.Remove(target)
+		return written, err
+	}
+	return written, os.Chtimes(target, entry.Modified, entry.Modified)
+}
+
+func extractSymlink(links *LinkGuard, target string, entry *zip.File, opts ExtractOptions) error {
+	if !opts.AllowSymlinks {
+		return nil
+	}
+
+	src, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	link, err := io.ReadAll(io.LimitReader(src, 4096))
+	if err != nil {
+		return err
+	}
+	if err := links.CheckLinkTarget(target, string(link)); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(string(link), target)
+}
+
+// LinkGuard checks the targets of the symlinks created by one extraction.
+// Besides the text of each target, it rejects targets that pass through a
+// symlink, and links placed where an earlier target passes, so a chain of
+// relative links (d/l1 -> .., l2 -> d/l1/..) cannot reach outside root in
+// whatever order the entries come.
+type LinkGuard struct {
+	root      string
+	traversed map[string]bool // diretórios atravessados por alvos já aceitos
+}
+
+// NewLinkGuard returns a guard for the symlinks extracted below root
+func NewLinkGuard(root string) *LinkGuard {
+	return &LinkGuard{root: root, traversed: map[string]bool{}}
+}
+
+// CheckLinkTarget rejects link targets that are absolute, resolve outside
+// root at any step or go through another symlink
+func (g *LinkGuard) CheckLinkTarget(linkPath, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("%w: absolute symlink %s", ErrUnsafePath, target)
+	}
+	if g.traversed[linkPath] {
+		return fmt.Errorf("%w: symlink %s is on the path of another link", ErrUnsafePath, linkPath)
+	}
+
+	// Sem Clean: "l1/.." precisa passar por l1, não ser reduzido a nada
+	parts := strings.Split(filepath.FromSlash(target), string(filepath.Separator))
+	current := filepath.Dir(linkPath)
+	var through []string
+	for i, part := range parts {
+		switch part {
+		case ".", "":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+		default:
+			current = filepath.Join(current, part)
+		}
+		if !within(g.root, current) {
+			return fmt.Errorf("%w: symlink %s -> %s", ErrUnsafePath, linkPath, target)
+		}
+		if i == len(parts)-1 {
+			break
+		}
+		info, err := os.Lstat(current)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: symlink %s -> %s goes through %s", ErrUnsafePath, linkPath, target, current)
+		}
+		through = append(through, current)
+	}
+	for _, dir := range through {
+		g.traversed[dir] = true
+	}
+	return nil
+}
+
+// SafeJoin resolves a relative entry name under root, rejecting traversal
+func SafeJoin(root, name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	target := filepath.Join(root, filepath.FromSlash(name))
+	if !within(root, target) {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	return target, nil
+}
+
+// CheckParents makes sure no existing component between root and target is a symlink
+func CheckParents(root, target string) error {
+	rel, err := filepath.Rel(root, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is a symlink", ErrUnsafePath, current)
+		}
+	}
+	return nil
+}
+
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// copyLimited copies at most limit bytes, failing if src holds more.
+// A negative or zero limit means unlimited.
+func copyLimited(dst io.Writer, src io.Reader, limit int64) (int64, error) {
+	if limit <= 0 {
+		return io.Copy(dst, src)
+	}
+
+	written, err := io.Copy(dst, io.LimitReader(src, limit))
+	if err != nil {
+		return written, err
+	}
+	// Um byte além do limite indica que o conteúdo é maior do que o permitido
+	var probe [1]byte
+	if n, _ := io.ReadFull(src, probe[:]); n > 0 {
+		return written, ErrArchiveTooLarge
+	}
+	return written, nil
+}
+
+func remaining(max, used int64) int64 {
+	if max <= 0 {
+		return -1
+	}
+	if used >= max {
+		return 0
+	}
+	return max - used
+}
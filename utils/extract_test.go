@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type zipEntry struct {
+	name    string
+	body    string
+	symlink bool
+}
+
+func buildZip(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.symlink {
+			header.SetMode(os.ModeSymlink | 0777)
+		} else {
+			header.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = io.WriteString(w, e.body)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func extractZipBytes(t *testing.T, data []byte, opts ExtractOptions) (string, error) {
+	t.Helper()
+	dest := filepath.Join(t.TempDir(), "out")
+	return dest, ExtractZip(bytes.NewReader(data), int64(len(data)), dest, opts)
+}
+
+func TestExtractZipRejectsUnsafeNames(t *testing.T) {
+	for _, name := range []string{"../evil.txt", "a/../../evil.txt", "/etc/evil.txt", "..\\evil.txt"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := extractZipBytes(t, buildZip(t, zipEntry{name: name, body: "x"}), DefaultExtractOptions)
+			assert.ErrorIs(t, err, ErrUnsafePath)
+		})
+	}
+}
+
+func TestExtractZipSymlinks(t *testing.T) {
+	allow := DefaultExtractOptions
+	allow.AllowSymlinks = true
+
+	cases := []struct {
+		name    string
+		entries []zipEntry
+	}{
+		{"absolute", []zipEntry{{name: "l", body: "/etc/passwd", symlink: true}}},
+		{"parent", []zipEntry{{name: "d/l", body: "../../etc", symlink: true}}},
+		{"chain", []zipEntry{
+			{name: "d/l1", body: "..", symlink: true},
+			{name: "l2", body: "d/l1/..", symlink: true},
+		}},
+		{"chain reversed", []zipEntry{
+			{name: "l2", body: "d/l1/..", symlink: true},
+			{name: "d/l1", body: "..", symlink: true},
+		}},
+		{"write through link", []zipEntry{
+			{name: "sub/", body: ""},
+			{name: "l", body: "sub", symlink: true},
+			{name: "l/file.txt", body: "x"},
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := extractZipBytes(t, buildZip(t, tc.entries...), allow)
+			assert.ErrorIs(t, err, ErrUnsafePath)
+		})
+	}
+
+	t.Run("inside root", func(t *testing.T) {
+		dest, err := extractZipBytes(t, buildZip(t,
+			zipEntry{name: "d/file.txt", body: "x"},
+			zipEntry{name: "d/l", body: "file.txt", symlink: true},
+			zipEntry{name: "top", body: "d/l", symlink: true},
+		), allow)
+		require.NoError(t, err)
+		link, err := os.Readlink(filepath.Join(dest, "top"))
+		require.NoError(t, err)
+		assert.Equal(t, "d/l", link)
+	})
+
+	t.Run("skipped by default", func(t *testing.T) {
+		dest, err := extractZipBytes(t, buildZip(t, zipEntry{name: "l", body: "x", symlink: true}), DefaultExtractOptions)
+		require.NoError(t, err)
+		_, err = os.Lstat(filepath.Join(dest, "l"))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestExtractZipLimits(t *testing.T) {
+	big := strings.Repeat("a", 1<<20)
+
+	t.Run("ratio", func(t *testing.T) {
+		dest, err := extractZipBytes(t, buildZip(t, zipEntry{name: "bomb", body: big}), ExtractOptions{MaxRatio: 10})
+		assert.ErrorIs(t, err, ErrCompressionRatio)
+		_, statErr := os.Stat(filepath.Join(dest, "bomb"))
+		assert.True(t, os.IsNotExist(statErr), "partial file must be removed")
+	})
+	t.Run("file size", func(t *testing.T) {
+		_, err := extractZipBytes(t, buildZip(t, zipEntry{name: "f", body: big}), ExtractOptions{MaxFileSize: 1024})
+		assert.ErrorIs(t, err, ErrArchiveTooLarge)
+	})
+	t.Run("total size", func(t *testing.T) {
+		_, err := extractZipBytes(t, buildZip(t,
+			zipEntry{name: "a", body: big},
+			zipEntry{name: "b", body: big},
+		), ExtractOptions{MaxTotalSize: 3 << 19})
+		assert.ErrorIs(t, err, ErrArchiveTooLarge)
+	})
+	t.Run("entries", func(t *testing.T) {
+		_, err := extractZipBytes(t, buildZip(t,
+			zipEntry{name: "a", body: "1"},
+			zipEntry{name: "b", body: "2"},
+		), ExtractOptions{MaxEntries: 1})
+		assert.ErrorIs(t, err, ErrTooManyEntries)
+	})
+	t.Run("within limits", func(t *testing.T) {
+		dest, err := extractZipBytes(t, buildZip(t, zipEntry{name: "f", body: "hello"}), DefaultExtractOptions)
+		require.NoError(t, err)
+		body, err := os.ReadFile(filepath.Join(dest, "f"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+	})
+}
+
+func TestExtractTarArchiveSymlinkChain(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range []*tar.Header{
+		{Name: "l2", Typeflag: tar.TypeSymlink, Linkname: "d/l1/.."},
+		{Name: "d/l1", Typeflag: tar.TypeSymlink, Linkname: ".."},
+	} {
+		h.Mode = 0777
+		require.NoError(t, tw.WriteHeader(h))
+	}
+	require.NoError(t, tw.Close())
+
+	stream, _, err := CompressWithHeader(&buf, CompressionOptions{Algorithm: CompressionNone})
+	require.NoError(t, err)
+	defer stream.Close()
+
+	opts := DefaultExtractOptions
+	opts.AllowSymlinks = true
+	err = ExtractTarArchive(stream, filepath.Join(t.TempDir(), "out"), opts)
+	assert.ErrorIs(t, err, ErrUnsafePath)
+}
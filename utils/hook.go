@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxHookOutput is how much of the combined output of a hook is kept
+const MaxHookOutput = 64 * 1024
+
+// hookWaitDelay is how long a hook that timed out may hold its output pipes,
+// for instance through a child process, before they are closed
+const hookWaitDelay = 5 * time.Second
+
+// HookCommand is a command run before or after a backup
+type HookCommand struct {
+	Path    string
+	Args    []string
+	Env     []string // KEY=value; é o ambiente completo do processo
+	Timeout time.Duration
+}
+
+// HookOutput is what a hook left behind
+type HookOutput struct {
+	ExitCode  int // -1 quando o processo não chegou a terminar
+	Output    string
+	Truncated bool
+	TimedOut  bool
+	Duration  time.Duration
+}
+
+// RunHook runs a command, capturing stdout and stderr together up to
+// MaxHookOutput bytes. It is killed once its timeout expires. A command that
+// cannot start, exits with a non-zero status or times out returns an error,
+// with the output captured so far.
+func RunHook(ctx context.Context, hook HookCommand) (HookOutput, error) {
+	output := &hookBuffer{}
+	return runCommand(ctx, hook, output, output)
+}
+
+// runCommand runs a command writing stdout to stdout; what it writes to
+// stderr is returned as its output
+func runCommand(ctx context.Context, hook HookCommand, stdout io.Writer, stderr *hookBuffer) (HookOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Path, hook.Args...)
+	cmd.Env = hook.Env
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = hookWaitDelay
+	killProcessGroup(cmd)
+
+	started := time.Now()
+	err := cmd.Run()
+	result := HookOutput{
+		ExitCode: -1,
+		Duration: time.Since(started),
+	}
+	result.Output, result.Truncated = stderr.result()
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.TimedOut = true
+		return result, fmt.Errorf("timed out after %s", hook.Timeout)
+	case err != nil:
+		return result, err
+	}
+	return result, nil
+}
+
+// ValidHookEnv reports whether entry is a KEY=value pair with a portable
+// variable name
+func ValidHookEnv(entry string) bool {
+	name, _, ok := strings.Cut(entry, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// hookBuffer keeps the first MaxHookOutput bytes written by both output
+// streams of a hook
+type hookBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *hookBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := MaxHookOutput - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+	} else {
+		b.buf.Write(p)
+	}
+	// O restante é descartado sem interromper o comando
+	return len(p), nil
+}
+
+func (b *hookBuffer) result() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.ToValidUTF8(b.buf.String(), "�"), b.truncated
+}
@@ -0,0 +1,91 @@
+//go:build linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileIdentity returns the device/inode pair and link count of a file
+func fileIdentity(info os.FileInfo) (fileID, uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: st.Ino}, uint64(st.Nlink), true
+}
+
+// isSparse reports whether fewer blocks are allocated than the file size needs
+func isSparse(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Blocks*512 < st.Size
+}
+
+// readXattrs returns the extended attributes of path without following symlinks
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size == 0 {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]string{}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		vsize, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, vsize)
+		if vsize, err = unix.Lgetxattr(path, name, value); err != nil {
+			continue
+		}
+		attrs[name] = string(value[:vsize])
+	}
+	return attrs, nil
+}
+
+// writeXattrs restores extended attributes, ignoring namespaces the process may not write
+func writeXattrs(path string, attrs map[string]string) error {
+	for name, value := range attrs {
+		err := unix.Lsetxattr(path, name, []byte(value), 0)
+		if err != nil && !errors.Is(err, unix.EPERM) && !errors.Is(err, unix.ENOTSUP) {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreOwner sets uid/gid when running with enough privileges
+func restoreOwner(path string, uid, gid int) error {
+	err := os.Lchown(path, uid, gid)
+	if errors.Is(err, os.ErrPermission) {
+		return nil
+	}
+	return err
+}
+
+// killProcessGroup runs cmd in its own process group and, on cancellation,
+// kills the whole group so children of a hook do not outlive it
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
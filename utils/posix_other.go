@@ -0,0 +1,30 @@
+//go:build !linux
+
+package utils
+
+import (
+	"os"
+	"os/exec"
+)
+
+func fileIdentity(info os.FileInfo) (fileID, uint64, bool) {
+	return fileID{}, 0, false
+}
+
+func isSparse(info os.FileInfo) bool {
+	return false
+}
+
+func readXattrs(path string) (map[string]string, error) {
+	return nil, nil
+}
+
+func writeXattrs(path string, attrs map[string]string) error {
+	return nil
+}
+
+func restoreOwner(path string, uid, gid int) error {
+	return nil
+}
+
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// recoveryKeySize is the size of a recovery key, checksum excluded
+	recoveryKeySize = 32
+	// recoveryGroup is how many characters each dash-separated group has
+	recoveryGroup = 5
+)
+
+// ErrInvalidRecoveryKey is returned when a recovery key is mistyped or truncated
+var ErrInvalidRecoveryKey = errors.New("invalid recovery key")
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewRecoveryKey returns a random recovery key and its printable form.
+// The printable form is base32 with a short checksum, split in groups so it
+// can be written down; only ParseRecoveryKey reads it back.
+func NewRecoveryKey() ([]byte, string, error) {
+	key, err := GenerateEncryptionKey()
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(key)
+	encoded := recoveryEncoding.EncodeToString(append(append([]byte{}, key...), sum[:2]...))
+
+	var groups []string
+	for len(encoded) > recoveryGroup {
+		groups = append(groups, encoded[:recoveryGroup])
+		encoded = encoded[recoveryGroup:]
+	}
+	groups = append(groups, encoded)
+	return key, strings.Join(groups, "-"), nil
+}
+
+// ParseRecoveryKey reads the printable form of a recovery key. Case, dashes
+// and spaces are ignored.
+func ParseRecoveryKey(text string) ([]byte, error) {
+	text = strings.ToUpper(strings.NewReplacer("-", "", " ", "", "\n", "", "\t", "").Replace(text))
+	data, err := recoveryEncoding.DecodeString(text)
+	if err != nil || len(data) != recoveryKeySize+2 {
+		return nil, ErrInvalidRecoveryKey
+	}
+	key := data[:recoveryKeySize]
+	sum := sha256.Sum256(key)
+	if !bytes.Equal(sum[:2], data[recoveryKeySize:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidRecoveryKey)
+	}
+	return key, nil
+}
+
+// RecoveryKeyID identifies a recovery key without revealing it
+func RecoveryKeyID(key []byte) string {
+	return hex.EncodeToString(DeriveSubkey(key, "recovery-id")[:8])
+}
+
+// WrapRootKey encrypts the root key of a user with a recovery key
+func WrapRootKey(recoveryKey, rootKey []byte, userID uint) ([]byte, error) {
+	return SealBytes(DeriveSubkey(recoveryKey, "root-key"), rootKey, recoveryAAD(userID))
+}
+
+// UnwrapRootKey decrypts a root key wrapped by WrapRootKey
+func UnwrapRootKey(recoveryKey, wrapped []byte, userID uint) ([]byte, error) {
+	return OpenBytes(DeriveSubkey(recoveryKey, "root-key"), wrapped, recoveryAAD(userID))
+}
+
+func recoveryAAD(userID uint) []byte {
+	return []byte(fmt.Sprintf("recovery:%d", userID))
+}
+
+// WrapFileKey encrypts the data key of an object with the root key of its owner
+func WrapFileKey(rootKey, key []byte, objectID string) ([]byte, error) {
+	return SealBytes(DeriveSubkey(rootKey, "file-keys"), key, []byte(objectID))
+}
+
+// UnwrapFileKey decrypts a data key wrapped by WrapFileKey
+func UnwrapFileKey(rootKey, wrapped []byte, objectID string) ([]byte, error) {
+	return OpenBytes(DeriveSubkey(rootKey, "file-keys"), wrapped, []byte(objectID))
+}
@@ -0,0 +1,350 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WalkOptions selects the files of a backup source
+type WalkOptions struct {
+	// Include keeps only files matching at least one pattern; empty keeps everything
+	Include []string
+	// Exclude skips matching files and prunes matching directories
+	Exclude []string
+	// MaxFileSize skips larger files; zero means no limit
+	MaxFileSize int64
+	// FollowSymlinks backs up the targets of symbolic links instead of skipping them
+	FollowSymlinks bool
+	// OneFileSystem does not descend into directories on other devices
+	OneFileSystem bool
+	// Within, when set, confines FollowSymlinks: links whose target resolves
+	// outside this directory are skipped
+	Within string
+}
+
+// WalkFunc is called for every regular file selected by WalkSource.
+// rel is the slash-separated path of the file relative to the source root.
+type WalkFunc func(filePath, rel string, info os.FileInfo) error
+
+// SkipFunc is told about files left out by the size limit or because they
+// are not regular files
+type SkipFunc func(rel, reason string)
+
+// ValidateGlob checks that a pattern can be used in WalkOptions.
+// Patterns use path.Match syntax per segment; "**" matches any number of
+// directories. A pattern without "/" matches the name at any depth.
+func ValidateGlob(pattern string) error {
+	if strings.TrimSpace(pattern) == "" {
+		return errors.New("empty pattern")
+	}
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// MatchGlob reports whether the slash-separated relative path matches pattern
+func MatchGlob(pattern, rel string) bool {
+	if !strings.Contains(strings.TrimSuffix(pattern, "/"), "/") {
+		ok, _ := path.Match(strings.TrimSuffix(pattern, "/"), path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if MatchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceFilter applies WalkOptions to the entries below a root directory
+type sourceFilter struct {
+	opts    WalkOptions
+	rootDev uint64
+	hasDev  bool
+	within  string // Within já resolvido
+}
+
+func newSourceFilter(root string, opts WalkOptions) (*sourceFilter, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	f := &sourceFilter{opts: opts}
+	if id, _, ok := fileIdentity(info); ok {
+		f.rootDev, f.hasDev = id.dev, true
+	}
+	if opts.Within != "" {
+		if f.within, err = realPath(opts.Within); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// escapes reports whether a followed symlink resolves outside Within
+func (f *sourceFilter) escapes(filePath string) bool {
+	if f.within == "" {
+		return false
+	}
+	real, err := realPath(filePath)
+	return err != nil || !within(f.within, real)
+}
+
+// excluded reports whether an entry, file or directory, is excluded by pattern
+func (f *sourceFilter) excluded(rel string) bool {
+	return matchAny(f.opts.Exclude, rel)
+}
+
+// included reports whether a regular file passes the include patterns
+func (f *sourceFilter) included(rel string) bool {
+	return len(f.opts.Include) == 0 || matchAny(f.opts.Include, rel)
+}
+
+// tooLarge reports whether a regular file exceeds the size limit
+func (f *sourceFilter) tooLarge(info os.FileInfo) bool {
+	return f.opts.MaxFileSize > 0 && info.Size() > f.opts.MaxFileSize
+}
+
+// otherDevice reports whether a directory lives on another file system than the root
+func (f *sourceFilter) otherDevice(info os.FileInfo) bool {
+	if !f.opts.OneFileSystem || !f.hasDev {
+		return false
+	}
+	id, _, ok := fileIdentity(info)
+	return ok && id.dev != f.rootDev
+}
+
+// UserSourceRoot is the directory below base holding the backup sources of a
+// user. Every path of a user's profiles resolves inside it.
+func UserSourceRoot(base string, userID uint) string {
+	return filepath.Join(base, fmt.Sprintf("user_%d", userID))
+}
+
+// ResolveSource joins a relative source path to root and checks that the
+// path, after following every symbolic link on the way, still lies inside
+// root. The source must exist.
+func ResolveSource(root, name string) (string, error) {
+	target, err := SafeJoin(root, name)
+	if err != nil {
+		return "", err
+	}
+	realRoot, err := realPath(root)
+	if err != nil {
+		return "", err
+	}
+	real, err := realPath(target)
+	if err != nil {
+		return "", err
+	}
+	if !within(realRoot, real) {
+		return "", fmt.Errorf("%w: %s resolves outside the source root", ErrUnsafePath, name)
+	}
+	return target, nil
+}
+
+// realPath is the absolute path of p with every symbolic link resolved
+func realPath(p string) (string, error) {
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(real)
+}
+
+// WalkSource visits the regular files below root selected by opts, in lexical
+// order. Symbolic links are followed only with FollowSymlinks; directories
+// reached through links are visited once, so link cycles terminate.
+func WalkSource(root string, opts WalkOptions, fn WalkFunc, skip SkipFunc) error {
+	filter, err := newSourceFilter(root, opts)
+	if err != nil {
+		return err
+	}
+	if skip == nil {
+		skip = func(string, string) {}
+	}
+
+	visited := map[string]bool{}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		visited[real] = true
+	}
+	return walkSourceDir(filter, root, "", visited, fn, skip)
+}
+
+// WalkSourcePath visits the files WalkSource would select at or below rel,
+// a slash-separated path inside root, with the same filters applied to the
+// same relative paths. A path that no longer exists selects nothing.
+func WalkSourcePath(root, rel string, opts WalkOptions, fn WalkFunc, skip SkipFunc) error {
+	if rel == "" || rel == "." {
+		return WalkSource(root, opts, fn, skip)
+	}
+	filter, err := newSourceFilter(root, opts)
+	if err != nil {
+		return err
+	}
+	if skip == nil {
+		skip = func(string, string) {}
+	}
+
+	// Um diretório excluído no caminho exclui tudo abaixo dele
+	segments := strings.Split(rel, "/")
+	for i := range segments {
+		if filter.excluded(path.Join(segments[:i+1]...)) {
+			return nil
+		}
+	}
+
+	filePath := filepath.Join(root, filepath.FromSlash(rel))
+	info, err := os.Lstat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("walk error at %s: %w", filePath, err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !filter.opts.FollowSymlinks {
+			skip(rel, "symlink")
+			return nil
+		}
+		if info, err = os.Stat(filePath); err != nil {
+			skip(rel, "broken symlink")
+			return nil
+		}
+		if filter.escapes(filePath) {
+			skip(rel, "symlink outside the source root")
+			return nil
+		}
+	}
+
+	switch {
+	case info.IsDir():
+		if filter.otherDevice(info) {
+			skip(rel, "other file system")
+			return nil
+		}
+		visited := map[string]bool{}
+		if real, err := filepath.EvalSymlinks(filePath); err == nil {
+			visited[real] = true
+		}
+		return walkSourceDir(filter, filePath, rel, visited, fn, skip)
+	case info.Mode().IsRegular():
+		if !filter.included(rel) {
+			return nil
+		}
+		if filter.tooLarge(info) {
+			skip(rel, "larger than max file size")
+			return nil
+		}
+		return fn(filePath, rel, info)
+	default:
+		skip(rel, "not a regular file")
+		return nil
+	}
+}
+
+func walkSourceDir(filter *sourceFilter, dir, relDir string, visited map[string]bool, fn WalkFunc, skip SkipFunc) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("walk error at %s: %w", dir, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, dirEntry := range entries {
+		filePath := filepath.Join(dir, dirEntry.Name())
+		rel := path.Join(relDir, dirEntry.Name())
+		if filter.excluded(rel) {
+			continue
+		}
+
+		info, err := os.Lstat(filePath)
+		if err != nil {
+			return fmt.Errorf("walk error at %s: %w", filePath, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !filter.opts.FollowSymlinks {
+				skip(rel, "symlink")
+				continue
+			}
+			if info, err = os.Stat(filePath); err != nil {
+				skip(rel, "broken symlink")
+				continue
+			}
+			if filter.escapes(filePath) {
+				skip(rel, "symlink outside the source root")
+				continue
+			}
+		}
+
+		switch {
+		case info.IsDir():
+			if filter.otherDevice(info) {
+				skip(rel, "other file system")
+				continue
+			}
+			real, err := filepath.EvalSymlinks(filePath)
+			if err != nil {
+				return fmt.Errorf("walk error at %s: %w", filePath, err)
+			}
+			if visited[real] {
+				continue
+			}
+			visited[real] = true
+			if err := walkSourceDir(filter, filePath, rel, visited, fn, skip); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if !filter.included(rel) {
+				continue
+			}
+			if filter.tooLarge(info) {
+				skip(rel, "larger than max file size")
+				continue
+			}
+			if err := fn(filePath, rel, info); err != nil {
+				return err
+			}
+		default:
+			skip(rel, "not a regular file")
+		}
+	}
+	return nil
+}
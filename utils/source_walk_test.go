@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// userRoots creates the source roots of users 1 and 2 below a temporary base;
+// user 2 keeps a private file in docs/
+func userRoots(t *testing.T) (alice, bob string) {
+	t.Helper()
+	base := t.TempDir()
+	alice, bob = UserSourceRoot(base, 1), UserSourceRoot(base, 2)
+	require.NoError(t, os.MkdirAll(filepath.Join(alice, "photos"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(bob, "docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(bob, "docs", "secret.txt"), []byte("bob"), 0644))
+	return alice, bob
+}
+
+func TestResolveSourceStaysInUserRoot(t *testing.T) {
+	alice, bob := userRoots(t)
+	require.NoError(t, os.Symlink(filepath.Join("..", "user_2", "docs"), filepath.Join(alice, "relative")))
+	require.NoError(t, os.Symlink(filepath.Join(bob, "docs"), filepath.Join(alice, "absolute")))
+	require.NoError(t, os.Symlink(filepath.Join(bob, "docs", "secret.txt"), filepath.Join(alice, "photos", "db.sqlite")))
+
+	for _, name := range []string{"../user_2/docs", "/etc", "relative", "absolute", "photos/db.sqlite"} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ResolveSource(alice, name)
+			assert.ErrorIs(t, err, ErrUnsafePath)
+		})
+	}
+
+	t.Run("own source", func(t *testing.T) {
+		dir, err := ResolveSource(alice, "photos")
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(alice, "photos"), dir)
+	})
+}
+
+func TestWalkSourceWithinSkipsEscapingLinks(t *testing.T) {
+	alice, bob := userRoots(t)
+	photos := filepath.Join(alice, "photos")
+	require.NoError(t, os.WriteFile(filepath.Join(photos, "a.jpg"), []byte("a"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(alice, "music"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(alice, "music", "b.mp3"), []byte("b"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(bob, "docs"), filepath.Join(photos, "bob")))
+	require.NoError(t, os.Symlink(filepath.Join(bob, "docs", "secret.txt"), filepath.Join(photos, "secret.txt")))
+	require.NoError(t, os.Symlink(filepath.Join("..", "music"), filepath.Join(photos, "music")))
+
+	var (
+		files   []string
+		skipped = map[string]string{}
+	)
+	err := WalkSource(photos, WalkOptions{FollowSymlinks: true, Within: alice}, func(_, rel string, _ os.FileInfo) error {
+		files = append(files, rel)
+		return nil
+	}, func(rel, reason string) {
+		skipped[rel] = reason
+	})
+	require.NoError(t, err)
+
+	sort.Strings(files)
+	assert.Equal(t, []string{"a.jpg", "music/b.mp3"}, files)
+	assert.Equal(t, "symlink outside the source root", skipped["bob"])
+	assert.Equal(t, "symlink outside the source root", skipped["secret.txt"])
+
+	// Sem Within os links são seguidos para qualquer lugar, como no agente
+	files = nil
+	require.NoError(t, WalkSource(photos, WalkOptions{FollowSymlinks: true}, func(_, rel string, _ os.FileInfo) error {
+		files = append(files, rel)
+		return nil
+	}, nil))
+	assert.Contains(t, files, "bob/secret.txt")
+}
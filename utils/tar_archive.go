@@ -0,0 +1,444 @@
+package utils
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	xattrPAXPrefix = "SCHILY.xattr."
+	sparsePAXKey   = "SAFEBOX.sparse"
+	sparseBlock    = 4096
+)
+
+// TarEntry describes a regular file written to a tar archive. A hard link
+// to a file already in the archive is described with its content and Link
+// set to the path of that file, whose data the archive holds.
+type TarEntry struct {
+	Path    string
+	Size    int64
+	SHA256  string
+	ModTime time.Time
+	Link    string
+}
+
+// TarOptions configures WriteTarArchive and TarArchiveStream
+type TarOptions struct {
+	Compression CompressionOptions
+	// OnFile is called after each regular file, hard links included, has been written
+	OnFile func(TarEntry)
+	// Walk selects the entries to archive. Symbolic links are always stored
+	// as links, so FollowSymlinks is ignored.
+	Walk WalkOptions
+}
+
+type fileID struct {
+	dev, ino uint64
+}
+
+// TarArchiveStream returns a zstd (or configured codec) compressed tar archive
+// of sourceDir as a stream, prefixed with the CompressAuto header
+func TarArchiveStream(sourceDir string, opts TarOptions) (io.ReadCloser, *CompressionStats, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(WriteTarArchive(pw, sourceDir, opts))
+	}()
+
+	stream, stats, err := CompressWithHeader(pr, opts.Compression)
+	if err != nil {
+		pr.Close()
+		return nil, nil, err
+	}
+	return &tarStream{ReadCloser: stream, pipe: pr}, stats, nil
+}
+
+// errTarStreamClosed stops the archive writer when the stream is closed early
+var errTarStreamClosed = errors.New("tar archive stream closed")
+
+// tarStream also closes the pipe the archive is written to, so a consumer
+// that stops reading early does not leave WriteTarArchive blocked forever
+type tarStream struct {
+	io.ReadCloser
+	pipe *io.PipeReader
+}
+
+func (s *tarStream) Close() error {
+	err := s.ReadCloser.Close()
+	s.pipe.CloseWithError(errTarStreamClosed)
+	return err
+}
+
+// WriteTarArchive writes an uncompressed PAX tar archive of sourceDir to w.
+// Unlike zip it keeps mode, uid/gid, nanosecond mtimes, symlink targets,
+// hard links, extended attributes and marks sparse files.
+func WriteTarArchive(w io.Writer, sourceDir string, opts TarOptions) error {
+	filter, err := newSourceFilter(sourceDir, opts.Walk)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	seen := map[fileID]string{}
+	linked := map[string]TarEntry{} // arquivos com outros hard links, pelo caminho
+
+	err = filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+
+		// Aplica os filtros do perfil; diretórios excluídos não são percorridos
+		if filter.excluded(name) || (info.IsDir() && filter.otherDevice(info)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && (!filter.included(name) || filter.tooLarge(info)) {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.Format = tar.FormatPAX
+		header.PAXRecords = map[string]string{}
+
+		hasLinks := false
+		if info.Mode().IsRegular() {
+			if id, nlink, ok := fileIdentity(info); ok && nlink > 1 {
+				if first, found := seen[id]; found {
+					header.Typeflag = tar.TypeLink
+					header.Linkname = first
+					header.Size = 0
+				} else {
+					seen[id] = name
+					hasLinks = true
+				}
+			}
+			if header.Typeflag == tar.TypeReg && isSparse(info) {
+				header.PAXRecords[sparsePAXKey] = "1"
+			}
+		}
+
+		attrs, err := readXattrs(path)
+		if err != nil {
+			return fmt.Errorf("failed to read xattrs of %s: %w", path, err)
+		}
+		for k, v := range attrs {
+			header.PAXRecords[xattrPAXPrefix+k] = v
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeLink {
+			if first, ok := linked[header.Linkname]; ok && opts.OnFile != nil {
+				first.Path = name
+				first.Link = header.Linkname
+				opts.OnFile(first)
+			}
+			return nil
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		hash := sha256.New()
+		written, err := io.Copy(io.MultiWriter(tw, hash), file)
+		if err != nil {
+			return err
+		}
+		entry := TarEntry{
+			Path:    name,
+			Size:    written,
+			SHA256:  hex.EncodeToString(hash.Sum(nil)),
+			ModTime: info.ModTime(),
+		}
+		if hasLinks {
+			linked[name] = entry
+		}
+		if opts.OnFile != nil {
+			opts.OnFile(entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// OpenTarArchive returns a tar reader over a stream produced by TarArchiveStream.
+// The returned closer releases the decompressor.
+func OpenTarArchive(src io.Reader) (*tar.Reader, io.Closer, error) {
+	dec, err := DecompressAuto(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tar.NewReader(dec), dec, nil
+}
+
+// ExtractTarArchive extracts a stream produced by TarArchiveStream into destDir,
+// applying the same path, symlink and size protections as ExtractZip.
+// Ownership is restored when the process is privileged.
+// If only is not empty, just the listed archive paths are extracted.
+func ExtractTarArchive(src io.Reader, destDir string, opts ExtractOptions, only ...string) error {
+	dec, err := DecompressAuto(src)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	return ExtractTar(dec, destDir, opts, only...)
+}
+
+// ExtractTar is ExtractTarArchive for an uncompressed tar stream without the
+// CompressAuto header, such as a restore downloaded as tar
+func ExtractTar(src io.Reader, destDir string, opts ExtractOptions, only ...string) error {
+	root, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	wanted := map[string]bool{}
+	for _, p := range only {
+		wanted[strings.TrimPrefix(filepath.ToSlash(p), "/")] = true
+	}
+
+	type dirMeta struct {
+		header *tar.Header
+		path   string
+	}
+	var (
+		tr      = tar.NewReader(src)
+		total   int64
+		entries int
+		dirs    []dirMeta
+		links   = NewLinkGuard(root)
+	)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tar archive: %w", err)
+		}
+
+		entries++
+		if opts.MaxEntries > 0 && entries > opts.MaxEntries {
+			return ErrTooManyEntries
+		}
+		name := strings.TrimSuffix(header.Name, "/")
+		if len(wanted) > 0 && !wanted[name] {
+			continue
+		}
+
+		target, err := SafeJoin(root, header.Name)
+		if err != nil {
+			return err
+		}
+		if err := CheckParents(root, target); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirMeta{header: header, path: target})
+			continue
+
+		case tar.TypeReg:
+			written, err := extractTarFile(tr, target, header, opts, remaining(opts.MaxTotalSize, total))
+			total += written
+			if err != nil {
+				return fmt.Errorf("failed to extract %s: %w", header.Name, err)
+			}
+
+		case tar.TypeSymlink:
+			if !opts.AllowSymlinks {
+				continue
+			}
+			if err := links.CheckLinkTarget(target, header.Linkname); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+
+		case tar.TypeLink:
+			linkTarget, err := SafeJoin(root, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := CheckParents(root, linkTarget); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Link(linkTarget, target); err != nil {
+				return err
+			}
+			continue
+
+		default:
+			continue // dispositivos, pipes etc. nunca são restaurados
+		}
+
+		if err := ApplyTarMetadata(target, header); err != nil {
+			return err
+		}
+	}
+
+	// Diretórios por último, pois criar arquivos altera o mtime do diretório pai
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := ApplyTarMetadata(dirs[i].path, dirs[i].header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTarFile(tr *tar.Reader, target string, header *tar.Header, opts ExtractOptions, totalLeft int64) (int64, error) {
+	if totalLeft == 0 {
+		return 0, ErrArchiveTooLarge
+	}
+	limit := opts.MaxFileSize
+	if totalLeft > 0 && (limit == 0 || totalLeft < limit) {
+		limit = totalLeft
+	}
+	if limit > 0 && header.Size > limit {
+		return 0, ErrArchiveTooLarge
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	if header.PAXRecords[sparsePAXKey] == "1" {
+		written, err = writeSparse(dst, tr, header.Size)
+	} else {
+		written, err = copyLimited(dst, tr, limit)
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target)
+	}
+	return written, err
+}
+
+// writeSparse skips all-zero blocks by seeking, leaving holes in the restored file
+func writeSparse(dst *os.File, src io.Reader, size int64) (int64, error) {
+	buf := make([]byte, sparseBlock)
+	var written int64
+	for written < size {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			if isZero(buf[:n]) {
+				if _, err := dst.Seek(int64(n), io.SeekCurrent); err != nil {
+					return written, err
+				}
+			} else if _, err := dst.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, dst.Truncate(written)
+}
+
+// ApplyTarMetadata restores the owner, extended attributes, mode and times
+// recorded in header on an extracted file, directory or symlink. Ownership
+// is only changed when the process is privileged.
+func ApplyTarMetadata(path string, header *tar.Header) error {
+	if err := restoreOwner(path, header.Uid, header.Gid); err != nil {
+		return err
+	}
+
+	attrs := map[string]string{}
+	for k, v := range header.PAXRecords {
+		if strings.HasPrefix(k, xattrPAXPrefix) {
+			attrs[strings.TrimPrefix(k, xattrPAXPrefix)] = v
+		}
+	}
+	if err := writeXattrs(path, attrs); err != nil {
+		return err
+	}
+
+	if header.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// chown limpa os bits setuid/setgid, então o modo é reaplicado depois dele
+	if err := os.Chmod(path, header.FileInfo().Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	accessTime := header.AccessTime
+	if accessTime.IsZero() {
+		accessTime = header.ModTime
+	}
+	return os.Chtimes(path, accessTime, header.ModTime)
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
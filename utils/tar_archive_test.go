@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarArchiveStreamHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), []byte("shared"), 0644))
+	require.NoError(t, os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "b")))
+
+	var (
+		mu      sync.Mutex
+		entries = map[string]TarEntry{}
+	)
+	stream, _, err := TarArchiveStream(dir, TarOptions{
+		Compression: CompressionOptions{Algorithm: CompressionNone},
+		OnFile: func(entry TarEntry) {
+			mu.Lock()
+			defer mu.Unlock()
+			entries[entry.Path] = entry
+		},
+	})
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, stream)
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	require.Len(t, entries, 2)
+	assert.Empty(t, entries["a"].Link)
+	assert.Equal(t, "a", entries["b"].Link)
+	assert.Equal(t, entries["a"].SHA256, entries["b"].SHA256)
+	assert.Equal(t, int64(len("shared")), entries["b"].Size)
+}
+
+func TestTarArchiveStreamCloseStopsWriter(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 4<<20)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "big"), data, 0644))
+
+	before := runtime.NumGoroutine()
+	stream, _, err := TarArchiveStream(dir, TarOptions{Compression: CompressionOptions{Algorithm: CompressionNone}})
+	require.NoError(t, err)
+	_, err = io.ReadFull(stream, make([]byte, 1024))
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "archive writer still running after Close")
+}
+
+func TestExtractTarPlainStream(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "docs/a.txt", Mode: 0640, Size: 5, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	dir := t.TempDir()
+	require.NoError(t, ExtractTar(bytes.NewReader(buf.Bytes()), dir, DefaultExtractOptions))
+	data, err := os.ReadFile(filepath.Join(dir, "docs", "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape.txt", Mode: 0644, Typeflag: tar.TypeReg}))
+	require.NoError(t, tw.Close())
+	assert.ErrorIs(t, ExtractTar(bytes.NewReader(buf.Bytes()), t.TempDir(), DefaultExtractOptions), ErrUnsafePath)
+}